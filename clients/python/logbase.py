@@ -0,0 +1,135 @@
+"""Minimal Logbase client, following api/openapi.yaml. Standard library only."""
+
+import hashlib
+import json
+import shutil
+import tarfile
+import urllib.error
+import urllib.parse
+import urllib.request
+
+
+class LogbaseError(Exception):
+    def __init__(self, status, message):
+        super().__init__(f"{status}: {message}")
+        self.status = status
+
+
+class GuardFailed(LogbaseError):
+    pass
+
+
+class Client:
+    def __init__(self, url="http://localhost:8080", timeout=10):
+        self.url = url.rstrip("/")
+        self.timeout = timeout
+
+    def _request(self, method, path, body=None, params=None, headers=None):
+        url = self.url + path
+        if params:
+            url += "?" + urllib.parse.urlencode(params)
+        req = urllib.request.Request(url, data=body, method=method, headers=headers or {})
+        try:
+            with urllib.request.urlopen(req, timeout=self.timeout) as resp:
+                return resp.status, resp.read()
+        except urllib.error.HTTPError as e:
+            if e.code == 404:
+                return e.code, b""
+            message = e.read().decode(errors="replace").strip()
+            raise (GuardFailed if e.code == 412 else LogbaseError)(e.code, message) from None
+
+    def _json(self, path, payload, params=None):
+        _, body = self._request("POST", path, json.dumps(payload).encode(), params,
+                                {"Content-Type": "application/json"})
+        return json.loads(body) if body else None
+
+    def get(self, key):
+        """Returns the value as bytes, or None if the key does not exist."""
+        status, body = self._request("GET", "/kv/" + urllib.parse.quote(key, safe=""))
+        return None if status == 404 else body
+
+    def put(self, key, value):
+        if isinstance(value, str):
+            value = value.encode()
+        self._request("PUT", "/kv/" + urllib.parse.quote(key, safe=""), value)
+
+    def delete(self, key):
+        self._request("DELETE", "/kv/" + urllib.parse.quote(key, safe=""))
+
+    def range(self, start=None, end=None, prefix=None, keys_only=False, limit=None, after=None):
+        """Returns {key: value} (values are None with keys_only). With limit,
+        at most that many keys; pass the largest key returned as after to
+        get the next page."""
+        params = {k: v for k, v in (("start", start), ("end", end), ("prefix", prefix), ("after", after)) if v}
+        if keys_only:
+            params["keys_only"] = "true"
+        if limit:
+            params["limit"] = str(limit)
+        _, body = self._request("GET", "/range", params=params)
+
+        result = {}
+        for line in body.decode().splitlines():
+            if keys_only:
+                result[line] = None
+            else:
+                key, _, value = line.partition("=")
+                result[key] = value
+        return result
+
+    def batch(self, items, guard_key=None, guard_value=None, return_previous=False):
+        """Writes items atomically. With return_previous, returns the values
+        from before the batch. Raises GuardFailed if the guard does not hold."""
+        params = {}
+        if guard_key is not None:
+            params["guard_key"] = guard_key
+            if guard_value is not None:
+                params["guard_value"] = guard_value
+        if return_previous:
+            params["return_previous"] = "true"
+        result = self._json("/batch", items, params)
+        return result["previous"] if result else None
+
+    def batch_delete(self, keys):
+        self._json("/batch-delete", list(keys))
+
+    def mget(self, keys, fields=None):
+        payload = {"keys": list(keys)}
+        if fields:
+            payload["fields"] = list(fields)
+        return self._json("/mget", payload)
+
+    def snapshot_read(self, keys=(), ranges=()):
+        """ranges are dicts with start/end and/or prefix. Returns the
+        response: {"seq", "keys", "ranges"}."""
+        return self._json("/snapshot-read", {"keys": list(keys), "ranges": list(ranges)})
+
+    def import_records(self, records):
+        """Streams (key, value) pairs through /import; returns the final
+        progress event."""
+        body = "".join(json.dumps({"key": k, "value": v}) + "\n" for k, v in records).encode()
+        _, resp = self._request("POST", "/import", body, headers={"Content-Type": "application/x-ndjson"})
+        events = [json.loads(line) for line in resp.decode().splitlines() if line]
+        final = events[-1] if events else {}
+        if final.get("error"):
+            raise LogbaseError(200, final["error"])
+        return final
+
+    def export(self, path):
+        """Saves the /export tar archive of fully compacted data to path and
+        returns its EXPORT.json manifest, after checking every table
+        against its SHA-256. Raises LogbaseError (409) if the data is not
+        fully compacted."""
+        url = self.url + "/export"
+        try:
+            with urllib.request.urlopen(url, timeout=self.timeout) as resp, open(path, "wb") as out:
+                shutil.copyfileobj(resp, out)
+        except urllib.error.HTTPError as e:
+            raise LogbaseError(e.code, e.read().decode(errors="replace").strip()) from None
+
+        with tarfile.open(path) as archive:
+            manifest = json.load(archive.extractfile("EXPORT.json"))
+            for table in manifest["tables"]:
+                data = archive.extractfile("tables/" + table["file"]).read()
+                if hashlib.sha256(data).hexdigest() != table["sha256"]:
+                    raise LogbaseError(200, "checksum mismatch in " + table["file"])
+        return manifest
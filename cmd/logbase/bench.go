@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/config"
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// benchCmd writes n keys to an engine in a scratch directory, then reads n
+// random ones back, and reports throughput and latency for each phase. It
+// uses the LOGBASE_* tuning settings, so it can compare them.
+func benchCmd(args []string) {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	n := fs.Int("n", 100000, "keys to write and reads to make")
+	valueSize := fs.Int("value-size", 100, "value size in bytes")
+	dir := fs.String("dir", "", "scratch directory (default: a new temp directory, removed afterwards)")
+	fs.Parse(args)
+
+	if *n <= 0 {
+		log.Fatal("bench: -n must be positive")
+	}
+	if *dir == "" {
+		tmp, err := os.MkdirTemp("", "logbase-bench-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		*dir = tmp
+	}
+	cfg.DataDir = *dir
+
+	engine, err := storage.NewEngineWithConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer engine.Close()
+
+	value := make([]byte, *valueSize)
+	for i := range value {
+		value[i] = byte('a' + i%26)
+	}
+	key := func(i int) []byte { return fmt.Appendf(nil, "bench:%010d", i) }
+
+	fmt.Printf("%-6s %10s %12s %10s %10s %10s\n", "phase", "ops", "ops/sec", "p50", "p99", "max")
+
+	benchPhase("write", *n, func(i int) error {
+		return engine.Put(key(i), value)
+	})
+	benchPhase("read", *n, func(int) error {
+		if _, ok := engine.Get(key(rand.IntN(*n))); !ok {
+			return fmt.Errorf("missing key")
+		}
+		return nil
+	})
+}
+
+func benchPhase(name string, n int, op func(i int) error) {
+	latencies := make([]time.Duration, n)
+	start := time.Now()
+	for i := range n {
+		t := time.Now()
+		if err := op(i); err != nil {
+			log.Fatalf("bench %s: op %d: %v", name, i, err)
+		}
+		latencies[i] = time.Since(t)
+	}
+	elapsed := time.Since(start)
+
+	slices.Sort(latencies)
+	fmt.Printf("%-6s %10d %12.0f %10s %10s %10s\n", name, n, float64(n)/elapsed.Seconds(),
+		latencies[n/2], latencies[n*99/100], latencies[n-1])
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/manjeet13/logbase/internal/config"
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// dumpCmd writes every live key and value as NDJSON in the format POST
+// /import reads, sorted by key. It opens the directory as a secondary, so
+// it never writes to it and can run next to the server.
+func dumpCmd(args []string) {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	dataDir := fs.String("data-dir", cfg.DataDir, "data directory to dump")
+	prefix := fs.String("prefix", "", "only dump keys with this prefix")
+	out := fs.String("out", "", "file to write (default: stdout)")
+	fs.Parse(args)
+
+	engine, err := storage.OpenSecondary(*dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer engine.Close()
+
+	entries, _, err := engine.ReadBounds(storage.IterOptions{Prefix: []byte(*prefix)}, storage.RangeOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	f := os.Stdout
+	if *out != "" {
+		if f, err = os.Create(*out); err != nil {
+			log.Fatal(err)
+		}
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, k := range keys {
+		if err := enc.Encode(map[string]string{"key": k, "value": string(entries[k])}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("dumped %d keys", len(keys))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/config"
+	"github.com/manjeet13/logbase/internal/server"
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// fetchAttempts is how many times one chunk is tried before giving up.
+const fetchAttempts = 5
+
+// fetchCmd seeds an empty data directory from a running server's
+// checkpoint. Files are fetched in chunks into <file>.part and renamed once
+// their CRC32 matches, so rerunning it after a failure resumes where it
+// stopped. MANIFEST comes last, so the directory is not usable until the
+// whole checkpoint has arrived. Chunks are fetched compressed with any of
+// the -compression codings the server is configured for.
+func fetchCmd(args []string) {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	dataDir := fs.String("data-dir", cfg.DataDir, "data directory to fill")
+	addr := fs.String("addr", "http://localhost:8080", "server to fetch from")
+	name := fs.String("checkpoint", "", "checkpoint to fetch (default: the newest)")
+	chunk := fs.Int("chunk", 1<<20, "bytes per request")
+	compression := fs.String("compression", strings.Join(server.TransferEncodings(), ","), "content codings to accept, or none")
+	fs.Parse(args)
+
+	if *chunk <= 0 || *chunk > storage.MaxChunkBytes {
+		log.Fatalf("fetch: -chunk must be between 1 and %d", storage.MaxChunkBytes)
+	}
+	if _, err := os.Stat(filepath.Join(*dataDir, "MANIFEST")); err == nil {
+		log.Fatalf("fetch: %s already has a MANIFEST", *dataDir)
+	}
+
+	if *name == "" {
+		var list struct {
+			Checkpoints []storage.CheckpointInfo `json:"checkpoints"`
+		}
+		if err := getJSON(*addr+"/admin/checkpoints", &list); err != nil {
+			log.Fatal(err)
+		}
+		if len(list.Checkpoints) == 0 {
+			log.Fatal("fetch: the server has no checkpoints")
+		}
+		*name = list.Checkpoints[len(list.Checkpoints)-1].Name
+	}
+
+	var manifest struct {
+		Files []storage.CheckpointFile `json:"files"`
+	}
+	if err := getJSON(*addr+"/admin/checkpoints/"+*name+"/files", &manifest); err != nil {
+		log.Fatal(err)
+	}
+
+	tally := &fetchTally{accept: "identity"}
+	if *compression != "" && *compression != "none" {
+		tally.accept = *compression
+	}
+	base := *addr + "/admin/checkpoints/" + *name + "/files/"
+	for _, file := range manifest.Files {
+		dst := filepath.Join(*dataDir, filepath.FromSlash(file.Name))
+		if err := fetchFile(base+file.Name, dst, file, *chunk, tally); err != nil {
+			log.Fatalf("fetch %s: %v", file.Name, err)
+		}
+		fmt.Printf("%s\t%d bytes\n", file.Name, file.Bytes)
+	}
+	fmt.Printf("fetched %s into %s\n", *name, *dataDir)
+	if tally.wire > 0 {
+		fmt.Printf("transferred %d bytes as %d (%.2fx)\n", tally.bytes, tally.wire, float64(tally.bytes)/float64(tally.wire))
+	}
+}
+
+// fetchTally is the Accept-Encoding sent for chunks and the bytes they
+// came to before and after decompression.
+type fetchTally struct {
+	accept      string
+	bytes, wire int64
+}
+
+// fetchFile fetches one file into dst, resuming from dst.part if present.
+func fetchFile(url, dst string, file storage.CheckpointFile, chunk int, tally *fetchTally) error {
+	if sum, err := fileCRC(dst); err == nil && sum == file.CRC32 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	part := dst + ".part"
+	out, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > file.Bytes {
+		if err := out.Truncate(0); err != nil {
+			return err
+		}
+		offset, _ = out.Seek(0, io.SeekStart)
+	}
+
+	for offset < file.Bytes {
+		data, err := fetchChunk(url, offset, chunk, tally)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return fmt.Errorf("short file: got %d of %d bytes", offset, file.Bytes)
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+		offset += int64(len(data))
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+
+	if sum, err := fileCRC(part); err != nil {
+		return err
+	} else if sum != file.CRC32 {
+		os.Remove(part)
+		return fmt.Errorf("checksum mismatch: got %08x, want %08x", sum, file.CRC32)
+	}
+	return os.Rename(part, dst)
+}
+
+// fetchChunk fetches and verifies one chunk, retrying with backoff.
+func fetchChunk(url string, offset int64, length int, tally *fetchTally) ([]byte, error) {
+	url += "?offset=" + strconv.FormatInt(offset, 10) + "&length=" + strconv.Itoa(length)
+
+	var err error
+	for attempt := range fetchAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<attempt) * 100 * time.Millisecond)
+		}
+
+		var data []byte
+		if data, err = getChunk(url, tally); err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+// getChunk fetches one chunk, decompressing it as the server sent it.
+// Setting Accept-Encoding turns off the transport's own gzip handling.
+func getChunk(url string, tally *fetchTally) ([]byte, error) {
+	req, err := adminRequest(http.MethodGet, url)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", tally.accept)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	wire, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	body, err := server.DecodeTransfer(resp.Header.Get("Content-Encoding"), bytes.NewReader(wire))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	want, err := strconv.ParseUint(resp.Header.Get("X-Logbase-CRC32"), 16, 32)
+	if err != nil {
+		return nil, errors.New("missing or malformed X-Logbase-CRC32")
+	}
+	if crc32.ChecksumIEEE(data) != uint32(want) {
+		return nil, errors.New("chunk checksum mismatch")
+	}
+	tally.bytes += int64(len(data))
+	tally.wire += int64(len(wire))
+	return data, nil
+}
+
+func getJSON(url string, v any) error {
+	resp, err := adminDo(http.MethodGet, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func fileCRC(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, f); err != nil {
+		return 0, err
+	}
+	return hash.Sum32(), nil
+}
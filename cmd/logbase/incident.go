@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+)
+
+// incidentCmd asks a running server for an incident bundle and saves it.
+func incidentCmd(args []string) {
+	fs := flag.NewFlagSet("incident", flag.ExitOnError)
+	addr := fs.String("addr", "http://localhost:8080", "server to collect from")
+	out := fs.String("out", "", "file to write (default: the server's bundle name)")
+	keys := fs.Bool("keys", false, "include user keys instead of redacting them")
+	fs.Parse(args)
+
+	url := *addr + "/admin/incident"
+	if *keys {
+		url += "?keys=true"
+	}
+	resp, err := adminDo(http.MethodPost, url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Fatalf("incident: %s: %s", resp.Status, msg)
+	}
+
+	if *out == "" {
+		*out = "incident.tar.gz"
+		if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+			*out = params["filename"]
+		}
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("wrote %s\n", *out)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/lincheck"
+)
+
+// lincheckCmd runs concurrent clients against a server it starts as a
+// child process, kills the process with SIGKILL and restarts it while
+// they run, and checks that the history of reads, writes and deletes is
+// linearizable.
+func lincheckCmd(args []string) {
+	fs := flag.NewFlagSet("lincheck", flag.ExitOnError)
+	clients := fs.Int("clients", 5, "concurrent clients")
+	keys := fs.Int("keys", 3, "keys the clients share")
+	duration := fs.Duration("duration", 20*time.Second, "how long the clients run")
+	crashEvery := fs.Duration("crash-every", 4*time.Second, "mean time between kills (0 = never)")
+	port := fs.String("port", "18080", "port for the server under test")
+	dataDir := fs.String("data-dir", "", "data directory (default: a new temp directory, removed afterwards)")
+	historyFile := fs.String("history", "", "write the history as JSON to this file")
+	verbose := fs.Bool("v", false, "show the server's log")
+	fs.Parse(args)
+
+	if *dataDir == "" {
+		tmp, err := os.MkdirTemp("", "logbase-lincheck-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		*dataDir = tmp
+	}
+	bin, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &lincheckServer{bin: bin, dir: *dataDir, port: *port, verbose: *verbose}
+	if err := srv.start(); err != nil {
+		log.Fatal(err)
+	}
+	defer srv.kill()
+
+	h := &lincheckHistory{start: time.Now(), base: "http://localhost:" + *port}
+	deadline := h.start.Add(*duration)
+
+	var wg sync.WaitGroup
+	for range *clients {
+		wg.Go(func() { h.client(deadline, *keys) })
+	}
+
+	crashes := 0
+	for *crashEvery > 0 {
+		pause := *crashEvery/2 + rand.N(*crashEvery)
+		if time.Now().Add(pause).After(deadline) {
+			break
+		}
+		time.Sleep(pause)
+		srv.kill()
+		crashes++
+		if err := srv.start(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	if *historyFile != "" {
+		data, err := json.MarshalIndent(h.ops, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*historyFile, data, 0644)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	failed := 0
+	for _, r := range lincheck.Check(h.ops) {
+		verdict := "linearizable"
+		if !r.Linearizable {
+			verdict = "NOT linearizable"
+			failed++
+		}
+		fmt.Printf("%-10s %6d ops  %s\n", r.Key, r.Operations, verdict)
+	}
+	fmt.Printf("%d operations (%d with unknown outcome), %d crashes\n", len(h.ops), h.unknown, crashes)
+	if failed > 0 {
+		srv.kill()
+		fmt.Printf("data directory kept at %s\n", *dataDir)
+		os.Exit(1)
+	}
+}
+
+// lincheckServer is the server under test, run as a child process.
+type lincheckServer struct {
+	bin, dir, port string
+	verbose        bool
+	cmd            *exec.Cmd
+}
+
+// start runs the server and waits until it is ready. The memtable is kept
+// small so flushes and compactions happen during the run.
+func (s *lincheckServer) start() error {
+	cmd := exec.Command(s.bin, "serve", "-data-dir", s.dir, "-port", s.port)
+	cmd.Env = append(os.Environ(), "LOGBASE_ENGINES=", "LOGBASE_ZERO_CONFIG=false")
+	if _, ok := os.LookupEnv("LOGBASE_MEMTABLE_FLUSH_BYTES"); !ok {
+		cmd.Env = append(cmd.Env, "LOGBASE_MEMTABLE_FLUSH_BYTES=4096")
+	}
+	if s.verbose {
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.cmd = cmd
+
+	for range 100 {
+		resp, err := http.Get("http://localhost:" + s.port + "/readyz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return errors.New("lincheck: the server did not become ready")
+}
+
+// kill stops the server with SIGKILL, as a crash would.
+func (s *lincheckServer) kill() {
+	if s.cmd != nil {
+		s.cmd.Process.Kill()
+		s.cmd.Wait()
+		s.cmd = nil
+	}
+}
+
+// lincheckHistory collects the clients' operations.
+type lincheckHistory struct {
+	start   time.Time
+	base    string
+	clients atomic.Int64
+
+	mu      sync.Mutex
+	ops     []lincheck.Operation
+	unknown int
+}
+
+var lincheckHTTP = &http.Client{Timeout: 2 * time.Second}
+
+func (h *lincheckHistory) now() int64 {
+	return int64(time.Since(h.start))
+}
+
+// client makes random requests until deadline. After a request whose
+// outcome is unknown it carries on as a new client, since the old one may
+// still be waiting for its request to take effect.
+func (h *lincheckHistory) client(deadline time.Time, keys int) {
+	id := int(h.clients.Add(1))
+	for n := 0; time.Now().Before(deadline); n++ {
+		op := lincheck.Operation{Client: id, Key: fmt.Sprintf("lin-%d", rand.IntN(keys))}
+		switch r := rand.IntN(10); {
+		case r < 5:
+			op.Kind = lincheck.Read
+		case r < 9:
+			op.Kind = lincheck.Write
+			op.Value = fmt.Sprintf("c%d-%d", id, n)
+		default:
+			op.Kind = lincheck.Delete
+		}
+
+		op.Call = h.now()
+		known, err := h.do(&op)
+		op.Return = h.now()
+		switch {
+		case errors.Is(err, syscall.ECONNREFUSED):
+			// The server is down and never saw the request
+			time.Sleep(20 * time.Millisecond)
+			continue
+		case err != nil && op.Kind == lincheck.Read:
+			// A failed read constrains nothing
+			continue
+		case !known:
+			op.Return = lincheck.Unknown
+			id = int(h.clients.Add(1))
+		}
+
+		h.mu.Lock()
+		h.ops = append(h.ops, op)
+		if !known {
+			h.unknown++
+		}
+		h.mu.Unlock()
+	}
+}
+
+// do sends op, filling in what a read returns. known is false if a write
+// may or may not have taken effect.
+func (h *lincheckHistory) do(op *lincheck.Operation) (known bool, err error) {
+	url := h.base + "/kv/" + op.Key
+	var req *http.Request
+	switch op.Kind {
+	case lincheck.Read:
+		req, err = http.NewRequest(http.MethodGet, url, nil)
+	case lincheck.Write:
+		req, err = http.NewRequest(http.MethodPut, url, bytes.NewReader([]byte(op.Value)))
+	default:
+		req, err = http.NewRequest(http.MethodDelete, url, nil)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := lincheckHTTP.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case op.Kind == lincheck.Read && resp.StatusCode == http.StatusOK:
+		op.Value, op.Found = string(body), true
+		return true, nil
+	case op.Kind == lincheck.Read && resp.StatusCode == http.StatusNotFound:
+		return true, nil
+	case op.Kind != lincheck.Read && resp.StatusCode == http.StatusNoContent:
+		return true, nil
+	}
+	// An error response to a write does not say whether it reached the WAL
+	return false, fmt.Errorf("%s %s: %s", req.Method, op.Key, resp.Status)
+}
@@ -0,0 +1,202 @@
+// Command logbase is the single Logbase binary: it runs the server and the
+// maintenance tools for a data directory, offline or through a running
+// server. Every command reads the same LOGBASE_* environment as the server,
+// and its flags override it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/config"
+	"github.com/manjeet13/logbase/internal/server"
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "serve":
+		serveCmd(os.Args[2:])
+	case "upgrade", "migrate":
+		upgradeCmd(os.Args[2:])
+	case "check":
+		checkCmd(os.Args[2:])
+	case "repair":
+		checkCmd(append([]string{"-repair"}, os.Args[2:]...))
+	case "checkpoints":
+		checkpointsCmd(os.Args[2:])
+	case "restore":
+		restoreCmd(os.Args[2:])
+	case "fetch":
+		fetchCmd(os.Args[2:])
+	case "incident":
+		incidentCmd(os.Args[2:])
+	case "prestop":
+		prestopCmd(os.Args[2:])
+	case "dump":
+		dumpCmd(os.Args[2:])
+	case "bench":
+		benchCmd(os.Args[2:])
+	case "sim":
+		simCmd(os.Args[2:])
+	case "lincheck":
+		lincheckCmd(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: logbase <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  serve        run the HTTP server")
+	fmt.Fprintln(os.Stderr, "  upgrade      upgrade a data directory to the current on-disk format (alias: migrate)")
+	fmt.Fprintln(os.Stderr, "  check        check a data directory for inconsistencies")
+	fmt.Fprintln(os.Stderr, "  repair       check a data directory and fix what can be fixed")
+	fmt.Fprintln(os.Stderr, "  checkpoints  list a data directory's checkpoints")
+	fmt.Fprintln(os.Stderr, "  restore      restore a data directory from a checkpoint")
+	fmt.Fprintln(os.Stderr, "  fetch        seed a data directory from a running server's checkpoint")
+	fmt.Fprintln(os.Stderr, "  incident     save an incident report bundle from a running server")
+	fmt.Fprintln(os.Stderr, "  prestop      ask a running server to prepare to stop")
+	fmt.Fprintln(os.Stderr, "  dump         write a data directory's keys and values as NDJSON")
+	fmt.Fprintln(os.Stderr, "  bench        measure write and read throughput on a scratch directory")
+	fmt.Fprintln(os.Stderr, "  sim          run deterministic simulations of a primary and a standby")
+	fmt.Fprintln(os.Stderr, "  lincheck     check a crashing server's reads and writes for linearizability")
+	os.Exit(2)
+}
+
+// adminDo sends a request to a running server's /admin API, with
+// LOGBASE_ADMIN_TOKEN as the bearer token if it is set.
+func adminDo(method, url string) (*http.Response, error) {
+	req, err := adminRequest(method, url)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// adminRequest builds a request to an admin endpoint, with the admin token
+// if one is configured.
+func adminRequest(method, url string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := config.Load().AdminToken; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+func serveCmd(args []string) {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&cfg.DataDir, "data-dir", cfg.DataDir, "data directory to serve")
+	fs.StringVar(&cfg.HTTPPort, "port", cfg.HTTPPort, "HTTP port")
+	fs.StringVar(&cfg.ReadOnly, "read-only", cfg.ReadOnly, "reject writes from startup, giving this reason")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration and exit")
+	fs.Parse(args)
+
+	if *printConfig {
+		if err := server.PrintConfig(os.Stdout, cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	server.Run(cfg)
+}
+
+func upgradeCmd(args []string) {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	dataDir := fs.String("data-dir", cfg.DataDir, "data directory to upgrade")
+	fs.Parse(args)
+
+	from, err := storage.Upgrade(*dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if from == storage.DataFormatVersion {
+		fmt.Printf("%s is already at format version %d\n", *dataDir, from)
+		return
+	}
+	fmt.Printf("upgraded %s from format version %d to %d\n", *dataDir, from, storage.DataFormatVersion)
+}
+
+func checkCmd(args []string) {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	dataDir := fs.String("data-dir", cfg.DataDir, "data directory to check")
+	repair := fs.Bool("repair", false, "fix the problems that can be fixed")
+	fs.Parse(args)
+
+	mode := storage.CheckWarn
+	if *repair {
+		mode = storage.CheckRepair
+	}
+
+	found, err := storage.CheckDataDir(*dataDir, mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	unrepaired := 0
+	for _, inc := range found {
+		fmt.Println(inc)
+		if !inc.Repaired {
+			unrepaired++
+		}
+	}
+	if len(found) == 0 {
+		fmt.Printf("%s is consistent\n", *dataDir)
+	}
+	if unrepaired > 0 {
+		os.Exit(1)
+	}
+}
+
+func checkpointsCmd(args []string) {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("checkpoints", flag.ExitOnError)
+	dataDir := fs.String("data-dir", cfg.DataDir, "data directory to list")
+	fs.Parse(args)
+
+	infos, err := storage.ListCheckpoints(*dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, info := range infos {
+		fmt.Printf("%s\t%s\t%d tables\t%d bytes\n", info.Name, info.CreatedAt.Format(time.RFC3339), info.Tables, info.Bytes)
+	}
+}
+
+func restoreCmd(args []string) {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dataDir := fs.String("data-dir", cfg.DataDir, "data directory to restore (the server must be stopped)")
+	name := fs.String("checkpoint", "", "checkpoint to restore")
+	fs.Parse(args)
+
+	if *name == "" {
+		log.Fatal("restore: -checkpoint is required")
+	}
+	if err := storage.RestoreCheckpoint(*dataDir, *name); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("restored %s from %s\n", *dataDir, *name)
+}
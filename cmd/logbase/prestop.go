@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// prestopCmd asks a running server to prepare to stop, for use as a
+// Kubernetes PreStop hook in images without curl or wget.
+func prestopCmd(args []string) {
+	fs := flag.NewFlagSet("prestop", flag.ExitOnError)
+	addr := fs.String("addr", "http://localhost:8080", "server to prepare")
+	fs.Parse(args)
+
+	resp, err := adminDo(http.MethodPost, *addr+"/admin/prestop")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("prestop: %s: %s", resp.Status, body)
+	}
+	fmt.Printf("%s", body)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// simCmd runs deterministic simulations, one per seed, and exits non-zero
+// on the first that fails. A failing seed replays exactly with -seed and
+// -trace.
+func simCmd(args []string) {
+	fs := flag.NewFlagSet("sim", flag.ExitOnError)
+	seed := fs.Uint64("seed", 1, "first seed")
+	runs := fs.Int("runs", 1, "seeds to run, from -seed up")
+	steps := fs.Int("steps", 2000, "actions per run")
+	syncFail := fs.Int("sync-fail-percent", 0, "chance of each WAL write failing")
+	trace := fs.Bool("trace", false, "print every action")
+	fs.Parse(args)
+
+	for s := *seed; s < *seed+uint64(*runs); s++ {
+		dir, err := os.MkdirTemp("", "logbase-sim-")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		opts := storage.SimulationOptions{Seed: s, Steps: *steps, Dir: dir, SyncFailPercent: *syncFail}
+		if *trace {
+			opts.Trace = os.Stdout
+		}
+		report, err := storage.Simulate(opts)
+		os.RemoveAll(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ops := make([]string, 0, len(report.Ops))
+		for name, n := range report.Ops {
+			ops = append(ops, fmt.Sprintf("%s=%d", name, n))
+		}
+		slices.Sort(ops)
+		fmt.Printf("seed %d: ok, %d steps, digest %s\n  %v\n", s, report.Steps, report.Digest[:16], ops)
+	}
+}
@@ -0,0 +1,274 @@
+// Package admission limits how many requests of each operation class run
+// concurrently, queueing the excess for a bounded time.
+package admission
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+type Class int
+
+const (
+	PointRead Class = iota
+	Scan
+	Write
+	Admin
+	numClasses
+)
+
+func (c Class) String() string {
+	switch c {
+	case PointRead:
+		return "read"
+	case Scan:
+		return "scan"
+	case Write:
+		return "write"
+	case Admin:
+		return "admin"
+	}
+	return "unknown"
+}
+
+var (
+	ErrQueueFull = errors.New("admission queue full")
+	ErrTimeout   = errors.New("admission queue timeout")
+)
+
+var (
+	waitSeconds = metrics.NewHistogramVec("logbase_admission_wait_seconds",
+		"Time requests spent queued before admission.", metrics.DefaultBuckets, "class", "priority")
+	queued = metrics.NewGaugeVec("logbase_admission_queued",
+		"Requests currently waiting for admission.", "class")
+	active = metrics.NewGaugeVec("logbase_admission_active",
+		"Requests currently admitted.", "class")
+	rejected = metrics.NewCounterVec("logbase_admission_rejected_total",
+		"Requests rejected by admission control.", "class", "reason")
+)
+
+// Limits configures one class. A zero Concurrency disables limiting.
+type Limits struct {
+	Concurrency int
+	MaxQueue    int
+	Timeout     time.Duration
+}
+
+// Priority orders queued requests within a class. Bulk requests wait behind
+// interactive ones and are the first to be shed when a queue is full.
+type Priority int
+
+const (
+	Interactive Priority = iota
+	Bulk
+)
+
+func (p Priority) String() string {
+	if p == Bulk {
+		return "bulk"
+	}
+	return "interactive"
+}
+
+// ParsePriority maps a client-supplied priority name to a Priority,
+// defaulting to Interactive.
+func ParsePriority(s string) Priority {
+	switch s {
+	case "bulk", "background", "low":
+		return Bulk
+	}
+	return Interactive
+}
+
+var errShed = errors.New("admission shed for interactive traffic")
+
+type Controller struct {
+	classes [numClasses]*class
+}
+
+func NewController(limits map[Class]Limits) *Controller {
+	c := &Controller{}
+	for i := range c.classes {
+		c.classes[i] = &class{name: Class(i).String(), limits: limits[Class(i)]}
+	}
+	return c
+}
+
+// Acquire blocks until a slot for cls is available, the queue timeout
+// expires, or ctx is done. The returned func must be called to release the slot.
+func (c *Controller) Acquire(ctx context.Context, cls Class, prio Priority) (func(), error) {
+	return c.classes[cls].acquire(ctx, prio)
+}
+
+// InteractiveWaiting reports whether any interactive request is queued.
+func (c *Controller) InteractiveWaiting() bool {
+	for _, cls := range c.classes {
+		if cls.interactiveWaiting() {
+			return true
+		}
+	}
+	return false
+}
+
+// YieldToInteractive lets background work (such as compaction) step aside
+// while interactive requests are queued, for at most max.
+func (c *Controller) YieldToInteractive(max time.Duration) {
+	deadline := time.Now().Add(max)
+	for c.InteractiveWaiting() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type waiter struct {
+	prio  Priority
+	ready chan struct{}
+	// granted and err are set under the class lock before ready is closed.
+	granted bool
+	err     error
+}
+
+type class struct {
+	name   string
+	limits Limits
+
+	mu      sync.Mutex
+	running int
+	waiters []*waiter
+}
+
+func (c *class) acquire(ctx context.Context, prio Priority) (func(), error) {
+	if c.limits.Concurrency <= 0 {
+		return func() {}, nil
+	}
+
+	c.mu.Lock()
+	if c.running < c.limits.Concurrency && len(c.waiters) == 0 {
+		c.running++
+		c.mu.Unlock()
+		waitSeconds.With(c.name, prio.String()).Observe(0)
+		return c.admitted(), nil
+	}
+	if len(c.waiters) >= c.limits.MaxQueue && !(prio == Interactive && c.shedBulk()) {
+		c.mu.Unlock()
+		rejected.With(c.name, "queue_full").Inc()
+		return nil, ErrQueueFull
+	}
+	w := &waiter{prio: prio, ready: make(chan struct{})}
+	c.waiters = append(c.waiters, w)
+	c.mu.Unlock()
+
+	queued.With(c.name).Add(1)
+	defer queued.With(c.name).Add(-1)
+
+	start := time.Now()
+	timer := time.NewTimer(c.limits.Timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		err = w.err
+	case <-timer.C:
+		err = ErrTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		c.mu.Lock()
+		granted := w.granted
+		if !granted {
+			c.remove(w)
+		}
+		c.mu.Unlock()
+
+		// A slot handed over concurrently with the timeout is passed on.
+		if granted {
+			c.release()
+		}
+		rejected.With(c.name, reason(err)).Inc()
+		return nil, err
+	}
+
+	waitSeconds.With(c.name, prio.String()).Observe(time.Since(start).Seconds())
+	return c.admitted(), nil
+}
+
+func reason(err error) string {
+	switch err {
+	case errShed:
+		return "shed"
+	case ErrTimeout:
+		return "timeout"
+	}
+	return "canceled"
+}
+
+func (c *class) admitted() func() {
+	gauge := active.With(c.name)
+	gauge.Add(1)
+	return func() {
+		gauge.Add(-1)
+		c.release()
+	}
+}
+
+// shedBulk drops the most recently queued bulk waiter to make room for an
+// interactive request. It reports whether one was dropped.
+func (c *class) shedBulk() bool {
+	for i := len(c.waiters) - 1; i >= 0; i-- {
+		if w := c.waiters[i]; w.prio == Bulk {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			w.err = errShed
+			close(w.ready)
+			return true
+		}
+	}
+	return false
+}
+
+func (c *class) interactiveWaiting() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.waiters {
+		if w.prio == Interactive {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *class) remove(w *waiter) {
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// release hands the slot to the highest-priority waiter, or frees it.
+func (c *class) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.waiters) == 0 {
+		c.running--
+		return
+	}
+
+	next := 0
+	for i, w := range c.waiters {
+		if w.prio < c.waiters[next].prio {
+			next = i
+		}
+	}
+	w := c.waiters[next]
+	c.waiters = append(c.waiters[:next], c.waiters[next+1:]...)
+	w.granted = true
+	close(w.ready)
+}
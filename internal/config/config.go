@@ -3,24 +3,219 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
+// Config is the runtime configuration. Each field is read from the
+// environment variable named by its env tag; see Settings.
 type Config struct {
-	HTTPPort              string
-	DataDir               string
-	MemTableFlushSize     int
-	MaxSSTablesBeforeComp int
+	HTTPPort                 string  `env:"LOGBASE_HTTP_PORT"`
+	DataDir                  string  `env:"LOGBASE_DATA_DIR"`
+	MemTableFlushSize        int     `env:"LOGBASE_MEMTABLE_FLUSH_BYTES"`
+	MemTableFilter           bool    `env:"LOGBASE_MEMTABLE_FILTER"`
+	MaxSSTablesBeforeComp    int     `env:"LOGBASE_MAX_SSTABLES"`
+	GarbageRewritePercent    int     `env:"LOGBASE_GARBAGE_REWRITE_PERCENT"`
+	CompactionParallelism    int     `env:"LOGBASE_COMPACTION_PARALLELISM"`
+	CompactionTargetFileSize int64   `env:"LOGBASE_COMPACTION_TARGET_FILE_BYTES"`
+	IndexInterval            int     `env:"LOGBASE_INDEX_INTERVAL"`
+	IndexAdaptive            bool    `env:"LOGBASE_INDEX_ADAPTIVE"`
+	SSTableBlockSize         int     `env:"LOGBASE_SSTABLE_BLOCK_BYTES"`
+	SSTableCompression       string  `env:"LOGBASE_SSTABLE_COMPRESSION"`
+	BlockCacheBytes          int64   `env:"LOGBASE_BLOCK_CACHE_BYTES"`
+	BloomFPR                 float64 `env:"LOGBASE_BLOOM_FPR"`
+	MaxOpenTables            int     `env:"LOGBASE_MAX_OPEN_TABLES"`
+	IOErrorThreshold         int     `env:"LOGBASE_IO_ERROR_THRESHOLD"`
+	StartupCheck             string  `env:"LOGBASE_STARTUP_CHECK"`
+	StatsIntervalSec         int     `env:"LOGBASE_STATS_INTERVAL_SEC"`
+	CheckpointIntervalSec    int     `env:"LOGBASE_CHECKPOINT_INTERVAL_SEC"`
+	CheckpointRetain         int     `env:"LOGBASE_CHECKPOINT_RETAIN"`
+	TransferBytesPerSec      int64   `env:"LOGBASE_TRANSFER_BYTES_PER_SEC"`
+	TransferCompression      string  `env:"LOGBASE_TRANSFER_COMPRESSION"`
+	TransferCompressionLevel int     `env:"LOGBASE_TRANSFER_COMPRESSION_LEVEL"`
+	Standby                  bool    `env:"LOGBASE_STANDBY"`
+	StandbyCatchUpMs         int     `env:"LOGBASE_STANDBY_CATCHUP_MS"`
+	QuotaBytes               int64   `env:"LOGBASE_QUOTA_BYTES"`
+	QuotaWarnPercent         int     `env:"LOGBASE_QUOTA_WARN_PERCENT"`
+	QuotaWebhookURL          string  `env:"LOGBASE_QUOTA_WEBHOOK_URL" secret:"true"`
+	LogLevel                 string  `env:"LOGBASE_LOG_LEVEL"`
+	MetricsNamespaces        string  `env:"LOGBASE_METRICS_NAMESPACES"`
+	MetricsMaxNamespaces     int     `env:"LOGBASE_METRICS_MAX_NAMESPACES"`
+	SLOAvailability          float64 `env:"LOGBASE_SLO_AVAILABILITY"`
+	SLOLatency               float64 `env:"LOGBASE_SLO_LATENCY"`
+	SLOLatencyMs             int     `env:"LOGBASE_SLO_LATENCY_MS"`
+	TraceSampleRate          float64 `env:"LOGBASE_TRACE_SAMPLE_RATE"`
+	TraceSampleErrors        bool    `env:"LOGBASE_TRACE_SAMPLE_ERRORS"`
+	TraceSampleRoutes        string  `env:"LOGBASE_TRACE_SAMPLE_ROUTES"`
+	TraceSlowMs              int     `env:"LOGBASE_TRACE_SLOW_MS"`
+	ShutdownDelayMs          int     `env:"LOGBASE_SHUTDOWN_DELAY_MS"`
+	// ReadOnly, if set, is the reason writes are rejected from startup;
+	// see /admin/read-only.
+	ReadOnly string `env:"LOGBASE_READ_ONLY"`
+
+	// Admission control: concurrent requests per operation class (0 = unlimited).
+	AdmitReads       int `env:"LOGBASE_ADMIT_READS"`
+	AdmitScans       int `env:"LOGBASE_ADMIT_SCANS"`
+	AdmitWrites      int `env:"LOGBASE_ADMIT_WRITES"`
+	AdmitAdmin       int `env:"LOGBASE_ADMIT_ADMIN"`
+	AdmitQueueLength int `env:"LOGBASE_ADMIT_QUEUE"`
+	AdmitTimeoutMs   int `env:"LOGBASE_ADMIT_TIMEOUT_MS"`
+
+	// FaultInjection enables /admin/faults for resilience testing.
+	FaultInjection bool `env:"LOGBASE_FAULT_INJECTION"`
+
+	// ZeroConfig is the container mode: the data directory defaults to
+	// /data, an empty one is initialized, and /admin/* and /debug/* require
+	// an admin token, generated and stored in the data directory if not
+	// given.
+	ZeroConfig bool `env:"LOGBASE_ZERO_CONFIG"`
+	// AdminToken, if set, is required as a bearer token on /admin/* and
+	// /debug/*.
+	AdminToken string `env:"LOGBASE_ADMIN_TOKEN" secret:"true"`
+	// Engines lists several engines for one process to host, each with
+	// its own data directory and port; see HostedEngines.
+	Engines string `env:"LOGBASE_ENGINES"`
+	// TempDir is where temporary files go, for read-only root filesystems.
+	// In zero-config mode it defaults to a tmp directory in the data
+	// directory.
+	TempDir string `env:"LOGBASE_TEMP_DIR"`
+	// HotKeys lists keys, and prefixes ending in "*", to keep in memory;
+	// see HotKeyPatterns. HotMaxBytes caps what they may hold.
+	HotKeys     string `env:"LOGBASE_HOT_KEYS"`
+	HotMaxBytes int64  `env:"LOGBASE_HOT_MAX_BYTES"`
+	// AccessTracking records when each key was last read and written,
+	// sampling AccessSampleRate of reads; the records are saved every
+	// AccessSaveIntervalSec.
+	AccessTracking        bool    `env:"LOGBASE_ACCESS_TRACKING"`
+	AccessSampleRate      float64 `env:"LOGBASE_ACCESS_SAMPLE_RATE"`
+	AccessSaveIntervalSec int     `env:"LOGBASE_ACCESS_SAVE_INTERVAL_SEC"`
+	// ColdDir enables cold tiering: every TieringIntervalSec, tables with
+	// at least ColdPercent of their keys untouched for ColdAgeDays move
+	// there. It needs AccessTracking.
+	ColdDir            string `env:"LOGBASE_COLD_DIR"`
+	ColdAgeDays        int    `env:"LOGBASE_COLD_AGE_DAYS"`
+	ColdPercent        int    `env:"LOGBASE_COLD_PERCENT"`
+	TieringIntervalSec int    `env:"LOGBASE_TIERING_INTERVAL_SEC"`
+	// ExpiryIntervalSec is how often keys written with a TTL are checked
+	// for expiry; 0 never deletes them.
+	ExpiryIntervalSec int `env:"LOGBASE_EXPIRY_INTERVAL_SEC"`
+	// LockProfileRate times one in this many acquisitions of the engine,
+	// WAL and memtable locks for /debug/locks; 0 turns it off.
+	LockProfileRate int `env:"LOGBASE_LOCK_PROFILE_RATE"`
+	// WALSync fsyncs the WAL before each write returns. A synced write
+	// waits up to GroupCommitWindowUs, or until GroupCommitSize writes
+	// join it, to share its fsync with others.
+	WALSync             bool `env:"LOGBASE_WAL_SYNC"`
+	GroupCommitWindowUs int  `env:"LOGBASE_GROUP_COMMIT_WINDOW_US"`
+	GroupCommitSize     int  `env:"LOGBASE_GROUP_COMMIT_SIZE"`
+	// PrefixSyncPolicies gives key prefixes their own sync policy, as
+	// prefix=policy pairs; see storage.ParsePrefixSyncPolicies.
+	PrefixSyncPolicies string `env:"LOGBASE_PREFIX_SYNC"`
+	// ValueTransformer names a compiled-in value transformer to apply to
+	// every value; see storage.RegisterValueTransformer.
+	ValueTransformer string `env:"LOGBASE_VALUE_TRANSFORMER"`
 }
 
 func Load() *Config {
+	zeroConfig := getEnvAsBool("LOGBASE_ZERO_CONFIG", false)
+	dataDir := getEnv("LOGBASE_DATA_DIR", "data")
+	checkpointInterval := 0
+	if zeroConfig {
+		dataDir = getEnv("LOGBASE_DATA_DIR", "/data")
+		checkpointInterval = 24 * 60 * 60
+	}
+
 	return &Config{
-		HTTPPort:              getEnv("LOGBASE_HTTP_PORT", "8080"),
-		DataDir:               getEnv("LOGBASE_DATA_DIR", "data"),
-		MemTableFlushSize:     getEnvAsInt("LOGBASE_MEMTABLE_FLUSH_BYTES", 1024*1024),
-		MaxSSTablesBeforeComp: getEnvAsInt("LOGBASE_MAX_SSTABLES", 4),
+		HTTPPort:                 getEnv("LOGBASE_HTTP_PORT", "8080"),
+		DataDir:                  dataDir,
+		MemTableFlushSize:        getEnvAsInt("LOGBASE_MEMTABLE_FLUSH_BYTES", 1024*1024),
+		MemTableFilter:           getEnvAsBool("LOGBASE_MEMTABLE_FILTER", false),
+		MaxSSTablesBeforeComp:    getEnvAsInt("LOGBASE_MAX_SSTABLES", 4),
+		GarbageRewritePercent:    getEnvAsInt("LOGBASE_GARBAGE_REWRITE_PERCENT", 50),
+		CompactionParallelism:    getEnvAsInt("LOGBASE_COMPACTION_PARALLELISM", 1),
+		CompactionTargetFileSize: int64(getEnvAsInt("LOGBASE_COMPACTION_TARGET_FILE_BYTES", 64*1024*1024)),
+		IndexInterval:            getEnvAsInt("LOGBASE_INDEX_INTERVAL", 128),
+		IndexAdaptive:            getEnvAsBool("LOGBASE_INDEX_ADAPTIVE", true),
+		SSTableBlockSize:         getEnvAsInt("LOGBASE_SSTABLE_BLOCK_BYTES", 4096),
+		SSTableCompression:       getEnv("LOGBASE_SSTABLE_COMPRESSION", "none"),
+		BlockCacheBytes:          int64(getEnvAsInt("LOGBASE_BLOCK_CACHE_BYTES", 8*1024*1024)),
+		BloomFPR:                 getEnvAsFloat("LOGBASE_BLOOM_FPR", 0.01),
+		MaxOpenTables:            getEnvAsInt("LOGBASE_MAX_OPEN_TABLES", 256),
+		IOErrorThreshold:         getEnvAsInt("LOGBASE_IO_ERROR_THRESHOLD", 3),
+		StartupCheck:             getEnv("LOGBASE_STARTUP_CHECK", "warn"),
+		StatsIntervalSec:         getEnvAsInt("LOGBASE_STATS_INTERVAL_SEC", 300),
+		CheckpointIntervalSec:    getEnvAsInt("LOGBASE_CHECKPOINT_INTERVAL_SEC", checkpointInterval),
+		CheckpointRetain:         getEnvAsInt("LOGBASE_CHECKPOINT_RETAIN", 7),
+		TransferBytesPerSec:      int64(getEnvAsInt("LOGBASE_TRANSFER_BYTES_PER_SEC", 0)),
+		TransferCompression:      getEnv("LOGBASE_TRANSFER_COMPRESSION", "gzip"),
+		TransferCompressionLevel: getEnvAsInt("LOGBASE_TRANSFER_COMPRESSION_LEVEL", 1),
+		Standby:                  getEnvAsBool("LOGBASE_STANDBY", false),
+		StandbyCatchUpMs:         getEnvAsInt("LOGBASE_STANDBY_CATCHUP_MS", 1000),
+		QuotaBytes:               int64(getEnvAsInt("LOGBASE_QUOTA_BYTES", 0)),
+		QuotaWarnPercent:         getEnvAsInt("LOGBASE_QUOTA_WARN_PERCENT", 80),
+		QuotaWebhookURL:          getEnv("LOGBASE_QUOTA_WEBHOOK_URL", ""),
+		LogLevel:                 getEnv("LOGBASE_LOG_LEVEL", "info"),
+		MetricsNamespaces:        getEnv("LOGBASE_METRICS_NAMESPACES", ""),
+		MetricsMaxNamespaces:     getEnvAsInt("LOGBASE_METRICS_MAX_NAMESPACES", 100),
+		SLOAvailability:          getEnvAsFloat("LOGBASE_SLO_AVAILABILITY", 0),
+		SLOLatency:               getEnvAsFloat("LOGBASE_SLO_LATENCY", 99),
+		SLOLatencyMs:             getEnvAsInt("LOGBASE_SLO_LATENCY_MS", 0),
+		TraceSampleRate:          getEnvAsFloat("LOGBASE_TRACE_SAMPLE_RATE", 0),
+		TraceSampleErrors:        getEnvAsBool("LOGBASE_TRACE_SAMPLE_ERRORS", true),
+		TraceSampleRoutes:        getEnv("LOGBASE_TRACE_SAMPLE_ROUTES", ""),
+		TraceSlowMs:              getEnvAsInt("LOGBASE_TRACE_SLOW_MS", 500),
+		ShutdownDelayMs:          getEnvAsInt("LOGBASE_SHUTDOWN_DELAY_MS", 0),
+		ReadOnly:                 getEnv("LOGBASE_READ_ONLY", ""),
+
+		AdmitReads:       getEnvAsInt("LOGBASE_ADMIT_READS", 256),
+		AdmitScans:       getEnvAsInt("LOGBASE_ADMIT_SCANS", 8),
+		AdmitWrites:      getEnvAsInt("LOGBASE_ADMIT_WRITES", 64),
+		AdmitAdmin:       getEnvAsInt("LOGBASE_ADMIT_ADMIN", 4),
+		AdmitQueueLength: getEnvAsInt("LOGBASE_ADMIT_QUEUE", 1024),
+		AdmitTimeoutMs:   getEnvAsInt("LOGBASE_ADMIT_TIMEOUT_MS", 1000),
+
+		FaultInjection: getEnvAsBool("LOGBASE_FAULT_INJECTION", false),
+
+		ZeroConfig: zeroConfig,
+		AdminToken: getEnv("LOGBASE_ADMIN_TOKEN", ""),
+		Engines:    getEnv("LOGBASE_ENGINES", ""),
+		TempDir:    getEnv("LOGBASE_TEMP_DIR", ""),
+
+		HotKeys:     getEnv("LOGBASE_HOT_KEYS", ""),
+		HotMaxBytes: int64(getEnvAsInt("LOGBASE_HOT_MAX_BYTES", 16*1024*1024)),
+
+		AccessTracking:        getEnvAsBool("LOGBASE_ACCESS_TRACKING", false),
+		AccessSampleRate:      getEnvAsFloat("LOGBASE_ACCESS_SAMPLE_RATE", 1),
+		AccessSaveIntervalSec: getEnvAsInt("LOGBASE_ACCESS_SAVE_INTERVAL_SEC", 300),
+
+		ColdDir:            getEnv("LOGBASE_COLD_DIR", ""),
+		ColdAgeDays:        getEnvAsInt("LOGBASE_COLD_AGE_DAYS", 30),
+		ColdPercent:        getEnvAsInt("LOGBASE_COLD_PERCENT", 80),
+		TieringIntervalSec: getEnvAsInt("LOGBASE_TIERING_INTERVAL_SEC", 3600),
+
+		ExpiryIntervalSec: getEnvAsInt("LOGBASE_EXPIRY_INTERVAL_SEC", 60),
+
+		LockProfileRate: getEnvAsInt("LOGBASE_LOCK_PROFILE_RATE", 0),
+
+		WALSync:             getEnvAsBool("LOGBASE_WAL_SYNC", false),
+		GroupCommitWindowUs: getEnvAsInt("LOGBASE_GROUP_COMMIT_WINDOW_US", 0),
+		GroupCommitSize:     getEnvAsInt("LOGBASE_GROUP_COMMIT_SIZE", 0),
+		PrefixSyncPolicies:  getEnv("LOGBASE_PREFIX_SYNC", ""),
+
+		ValueTransformer: getEnv("LOGBASE_VALUE_TRANSFORMER", ""),
 	}
 }
 
+// HotKeyPatterns returns the patterns listed in HotKeys.
+func (c *Config) HotKeyPatterns() []string {
+	var patterns []string
+	for _, p := range strings.Split(c.HotKeys, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
 func getEnv(key, defaultVal string) string {
 	if val, ok := os.LookupEnv(key); ok {
 		return val
@@ -36,3 +231,21 @@ func getEnvAsInt(key string, defaultVal int) int {
 	}
 	return defaultVal
 }
+
+func getEnvAsFloat(key string, defaultVal float64) float64 {
+	if val, ok := os.LookupEnv(key); ok {
+		if parsed, err := strconv.ParseFloat(val, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultVal
+}
+
+func getEnvAsBool(key string, defaultVal bool) bool {
+	if val, ok := os.LookupEnv(key); ok {
+		if parsed, err := strconv.ParseBool(val); err == nil {
+			return parsed
+		}
+	}
+	return defaultVal
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// HostedEngine is one of the engines a process hosts; see Config.Engines.
+type HostedEngine struct {
+	Name   string
+	Config *Config
+}
+
+// HostedEngines returns the engines to run. With Engines empty that is c
+// alone. Otherwise Engines is a comma-separated list of "name=dir:port",
+// and each engine gets a copy of c with its own data directory and port,
+// and its own subdirectory of ColdDir.
+func (c *Config) HostedEngines() ([]HostedEngine, error) {
+	if strings.TrimSpace(c.Engines) == "" {
+		return []HostedEngine{{Config: c}}, nil
+	}
+
+	var engines []HostedEngine
+	names := make(map[string]bool)
+	dirs := make(map[string]bool)
+	ports := make(map[string]bool)
+	for _, item := range strings.Split(c.Engines, ",") {
+		name, rest, ok := strings.Cut(strings.TrimSpace(item), "=")
+		i := strings.LastIndex(rest, ":")
+		if !ok || i <= 0 {
+			return nil, fmt.Errorf("LOGBASE_ENGINES: %q is not name=dir:port", item)
+		}
+		dir, port := rest[:i], rest[i+1:]
+
+		if !validEngineName(name) {
+			return nil, fmt.Errorf("LOGBASE_ENGINES: engine name %q must be letters, digits, - or _", name)
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("LOGBASE_ENGINES: %s: bad port %q", name, port)
+		}
+		clean := filepath.Clean(dir)
+		switch {
+		case names[name]:
+			return nil, fmt.Errorf("LOGBASE_ENGINES: engine %s is listed twice", name)
+		case dirs[clean]:
+			return nil, fmt.Errorf("LOGBASE_ENGINES: %s: data directory %s is used by another engine", name, dir)
+		case ports[port]:
+			return nil, fmt.Errorf("LOGBASE_ENGINES: %s: port %s is used by another engine", name, port)
+		}
+		names[name], dirs[clean], ports[port] = true, true, true
+
+		engine := *c
+		engine.DataDir = dir
+		engine.HTTPPort = port
+		if c.ColdDir != "" {
+			engine.ColdDir = filepath.Join(c.ColdDir, name)
+		}
+		engines = append(engines, HostedEngine{Name: name, Config: &engine})
+	}
+	return engines, nil
+}
+
+func validEngineName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
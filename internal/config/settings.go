@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Redacted replaces the value of a secret setting that is set.
+const Redacted = "<redacted>"
+
+// Settings is the effective configuration keyed by environment variable,
+// with secrets redacted, for showing operators what a process runs with.
+func (c *Config) Settings() map[string]any {
+	settings := make(map[string]any)
+	v := reflect.ValueOf(c).Elem()
+	for i, f := range reflect.VisibleFields(v.Type()) {
+		value := v.Field(i).Interface()
+		if f.Tag.Get("secret") == "true" && !v.Field(i).IsZero() {
+			value = Redacted
+		}
+		settings[f.Tag.Get("env")] = value
+	}
+	return settings
+}
+
+// EnvProblems finds LOGBASE_* environment variables that have no effect:
+// unknown ones, usually typos, and ones whose value does not parse, for
+// which the default is used instead.
+func EnvProblems() (unknown, invalid []string) {
+	unknown, invalid = []string{}, []string{}
+	fields := make(map[string]reflect.Kind)
+	for _, f := range reflect.VisibleFields(reflect.TypeFor[Config]()) {
+		fields[f.Tag.Get("env")] = f.Type.Kind()
+	}
+
+	for _, kv := range os.Environ() {
+		name, val, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, "LOGBASE_") {
+			continue
+		}
+		kind, ok := fields[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		var err error
+		switch kind {
+		case reflect.Int, reflect.Int64:
+			_, err = strconv.Atoi(val)
+		case reflect.Float64:
+			_, err = strconv.ParseFloat(val, 64)
+		case reflect.Bool:
+			_, err = strconv.ParseBool(val)
+		}
+		if err != nil {
+			invalid = append(invalid, name)
+		}
+	}
+	slices.Sort(unknown)
+	slices.Sort(invalid)
+	return unknown, invalid
+}
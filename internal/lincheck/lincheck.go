@@ -0,0 +1,231 @@
+// Package lincheck checks that a history of key-value operations is
+// linearizable: that every operation can be given a single point between
+// its call and its return such that, taken in that order, each read sees
+// the latest write. Keys are independent registers, so each key's
+// operations are checked on their own.
+//
+// The search is the Wing and Gong algorithm with Lowe's memoization of
+// (linearized set, state) pairs, as used by checkers such as porcupine.
+package lincheck
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Kind is the kind of an operation.
+type Kind int
+
+const (
+	Read Kind = iota
+	Write
+	Delete
+)
+
+// Unknown is the Return of an operation whose outcome is not known, such
+// as a write whose connection broke: it may take effect at any point after
+// its call, or never.
+const Unknown = math.MaxInt64
+
+// Operation is one client request and its response. Times are in any
+// unit, as long as they come from one clock.
+type Operation struct {
+	Client int    `json:"client"`
+	Kind   Kind   `json:"kind"`
+	Key    string `json:"key"`
+	// Value is the value written, or the value read.
+	Value string `json:"value,omitempty"`
+	// Found reports whether a read found the key.
+	Found  bool  `json:"found,omitempty"`
+	Call   int64 `json:"call"`
+	Return int64 `json:"return"`
+}
+
+// Result is the outcome of checking one key.
+type Result struct {
+	Key          string `json:"key"`
+	Operations   int    `json:"operations"`
+	Linearizable bool   `json:"linearizable"`
+}
+
+// Check checks every key in history and returns the results sorted by key.
+func Check(history []Operation) []Result {
+	byKey := make(map[string][]Operation)
+	for _, op := range history {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+
+	results := make([]Result, 0, len(byKey))
+	for key, ops := range byKey {
+		results = append(results, Result{Key: key, Operations: len(ops), Linearizable: CheckKey(ops)})
+	}
+	slices.SortFunc(results, func(a, b Result) int { return strings.Compare(a.Key, b.Key) })
+	return results
+}
+
+// state is a register's value: "" for absent, or "=" and the value.
+type state string
+
+const absent state = ""
+
+// step applies op to s, reporting false if op cannot happen in state s.
+func step(s state, op Operation) (bool, state) {
+	switch op.Kind {
+	case Write:
+		return true, state("=" + op.Value)
+	case Delete:
+		return true, absent
+	default:
+		if !op.Found {
+			return s == absent, s
+		}
+		return s == state("="+op.Value), s
+	}
+}
+
+// event is a call or return in the history, in a doubly linked list in
+// time order.
+type event struct {
+	op         int
+	call       bool
+	match      *event // a call's return
+	prev, next *event
+}
+
+// CheckKey reports whether the operations on one key are linearizable,
+// starting from an absent key.
+func CheckKey(ops []Operation) bool {
+	head := buildEvents(ops)
+
+	type frame struct {
+		call  *event
+		state state
+	}
+	var stack []frame
+	linearized := newBitset(len(ops))
+	seen := make(map[string]bool)
+	current := absent
+
+	e := head.next
+	for head.next != nil {
+		if e == nil {
+			// Only unknown operations are left; they need not take effect
+			return true
+		}
+		if e.call {
+			ok, next := step(current, ops[e.op])
+			if ok {
+				linearized.set(e.op)
+				key := linearized.key(string(next))
+				if !seen[key] {
+					seen[key] = true
+					stack = append(stack, frame{e, current})
+					current = next
+					lift(e)
+					e = head.next
+					continue
+				}
+				linearized.clear(e.op)
+			}
+			e = e.next
+			continue
+		}
+
+		// A return whose call is not linearized: backtrack
+		if len(stack) == 0 {
+			return false
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		current = top.state
+		linearized.clear(top.call.op)
+		unlift(top.call)
+		e = top.call.next
+	}
+	return true
+}
+
+// buildEvents returns the head of the list of ops' calls and returns in
+// time order. At equal times calls come first, so operations that touch
+// are taken as concurrent. Unknown operations have no return event.
+func buildEvents(ops []Operation) *event {
+	type timed struct {
+		time int64
+		ev   *event
+	}
+	events := make([]timed, 0, 2*len(ops))
+	for i, op := range ops {
+		call := &event{op: i, call: true}
+		events = append(events, timed{op.Call, call})
+		if op.Return != Unknown {
+			ret := &event{op: i}
+			call.match = ret
+			events = append(events, timed{op.Return, ret})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].time != events[j].time {
+			return events[i].time < events[j].time
+		}
+		return events[i].ev.call && !events[j].ev.call
+	})
+
+	head := &event{}
+	prev := head
+	for _, t := range events {
+		t.ev.prev = prev
+		prev.next = t.ev
+		prev = t.ev
+	}
+	return head
+}
+
+// lift removes a call and its return from the list.
+func lift(call *event) {
+	unlink(call)
+	if call.match != nil {
+		unlink(call.match)
+	}
+}
+
+// unlift puts back what lift removed.
+func unlift(call *event) {
+	if call.match != nil {
+		relink(call.match)
+	}
+	relink(call)
+}
+
+func unlink(e *event) {
+	e.prev.next = e.next
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+}
+
+func relink(e *event) {
+	e.prev.next = e
+	if e.next != nil {
+		e.next.prev = e
+	}
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset { return make(bitset, (n+63)/64) }
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << (i % 64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << (i % 64) }
+
+// key identifies the set together with a state, for memoization.
+func (b bitset) key(s string) string {
+	buf := make([]byte, 0, 8*len(b)+len(s))
+	for _, w := range b {
+		for i := range 8 {
+			buf = append(buf, byte(w>>(8*i)))
+		}
+	}
+	return string(append(buf, s...))
+}
@@ -0,0 +1,175 @@
+// Package logging provides per-subsystem log levels that can be changed at
+// runtime, with sampling for debug output.
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+var ErrUnknownLevel = errors.New("unknown log level")
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownLevel, s)
+}
+
+// Logger logs for one subsystem. Messages below its level are dropped; with
+// a sample rate of n, only one in n debug messages is written.
+type Logger struct {
+	name   string
+	level  atomic.Int32
+	sample atomic.Int64
+	count  atomic.Int64
+}
+
+var (
+	mu      sync.Mutex
+	loggers = make(map[string]*Logger)
+
+	defaultLevel atomic.Int32
+)
+
+func init() {
+	defaultLevel.Store(int32(Info))
+}
+
+// For returns the subsystem's logger, creating it at the default level.
+func For(name string) *Logger {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if l, ok := loggers[name]; ok {
+		return l
+	}
+	l := &Logger{name: name}
+	l.level.Store(defaultLevel.Load())
+	l.sample.Store(1)
+	loggers[name] = l
+	return l
+}
+
+// SetDefaultLevel sets the level of every subsystem, including ones not
+// yet created.
+func SetDefaultLevel(level Level) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	defaultLevel.Store(int32(level))
+	for _, l := range loggers {
+		l.level.Store(int32(level))
+	}
+}
+
+// Lookup returns the named subsystem's logger, if it exists.
+func Lookup(name string) (*Logger, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	l, ok := loggers[name]
+	return l, ok
+}
+
+// Settings describes a subsystem's logging configuration.
+type Settings struct {
+	Level  string `json:"level"`
+	Sample int64  `json:"sample"`
+}
+
+// All returns every subsystem's settings, keyed by name.
+func All() map[string]Settings {
+	mu.Lock()
+	names := make([]string, 0, len(loggers))
+	for name := range loggers {
+		names = append(names, name)
+	}
+	mu.Unlock()
+	sort.Strings(names)
+
+	all := make(map[string]Settings, len(names))
+	for _, name := range names {
+		all[name] = For(name).Settings()
+	}
+	return all
+}
+
+func (l *Logger) Settings() Settings {
+	return Settings{Level: l.Level().String(), Sample: l.sample.Load()}
+}
+
+func (l *Logger) Level() Level {
+	return Level(l.level.Load())
+}
+
+func (l *Logger) SetLevel(level Level) {
+	l.level.Store(int32(level))
+}
+
+// SetSample writes one in n debug messages; n <= 1 writes them all.
+func (l *Logger) SetSample(n int64) {
+	l.sample.Store(max(n, 1))
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+func (l *Logger) Debugf(format string, args ...any) {
+	if !l.Enabled(Debug) {
+		return
+	}
+	if n := l.sample.Load(); n > 1 && l.count.Add(1)%n != 1 {
+		return
+	}
+	l.output(Debug, format, args)
+}
+
+func (l *Logger) Infof(format string, args ...any) {
+	if l.Enabled(Info) {
+		l.output(Info, format, args)
+	}
+}
+
+func (l *Logger) Warnf(format string, args ...any) {
+	if l.Enabled(Warn) {
+		l.output(Warn, format, args)
+	}
+}
+
+func (l *Logger) Errorf(format string, args ...any) {
+	if l.Enabled(Error) {
+		l.output(Error, format, args)
+	}
+}
+
+func (l *Logger) output(level Level, format string, args []any) {
+	log.Output(3, fmt.Sprintf("%s %s: %s", strings.ToUpper(level.String()), l.name, fmt.Sprintf(format, args...)))
+}
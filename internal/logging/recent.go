@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"sync"
+)
+
+// recent keeps the last lines written through the standard logger,
+// for incident reports.
+var recent = &lineRing{}
+
+// lineRing is an io.Writer that keeps the last max lines written to it.
+type lineRing struct {
+	mu    sync.Mutex
+	max   int
+	lines [][]byte
+	next  int
+}
+
+func (r *lineRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.max == 0 {
+		return len(p), nil
+	}
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		line = bytes.Clone(line)
+		if len(r.lines) < r.max {
+			r.lines = append(r.lines, line)
+		} else {
+			r.lines[r.next] = line
+		}
+		r.next = (r.next + 1) % r.max
+	}
+	return len(p), nil
+}
+
+// KeepRecent starts keeping the last n lines the standard logger writes,
+// in addition to writing them where it already does.
+func KeepRecent(n int) {
+	recent.mu.Lock()
+	recent.max, recent.lines, recent.next = n, nil, 0
+	recent.mu.Unlock()
+
+	log.SetOutput(io.MultiWriter(log.Writer(), recent))
+}
+
+// Recent returns the kept log lines, oldest first.
+func Recent() []byte {
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+
+	var buf bytes.Buffer
+	n := len(recent.lines)
+	for i := range n {
+		buf.Write(recent.lines[(recent.next+i)%n])
+	}
+	return buf.Bytes()
+}
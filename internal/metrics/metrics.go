@@ -0,0 +1,299 @@
+// Package metrics is a small dependency-free metrics registry that renders
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are latency buckets in seconds.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Default is the registry served by Handler.
+var Default = NewRegistry()
+
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type metric interface {
+	// write renders the series; openMetrics selects the OpenMetrics
+	// format, which can carry exemplars.
+	write(w io.Writer, name, labels string, openMetrics bool)
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	newFn  func() metric
+
+	mu     sync.Mutex
+	series map[string]metric
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, newFn func() metric) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		return f
+	}
+	f := &family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		newFn:  newFn,
+		series: make(map[string]metric),
+	}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(values ...string) metric {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	var b strings.Builder
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", f.labels[i], v)
+	}
+	key := b.String()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.series[key]
+	if !ok {
+		m = f.newFn()
+		f.series[key] = m
+	}
+	return m
+}
+
+// WriteText renders every registered metric in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) {
+	r.write(w, false)
+}
+
+// WriteOpenMetrics renders every registered metric in the OpenMetrics text
+// format, including histogram exemplars.
+func (r *Registry) WriteOpenMetrics(w io.Writer) {
+	r.write(w, true)
+	fmt.Fprintln(w, "# EOF")
+}
+
+func (r *Registry) write(w io.Writer, openMetrics bool) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		r.mu.Lock()
+		f := r.families[name]
+		r.mu.Unlock()
+
+		f.mu.Lock()
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		series := make([]metric, len(keys))
+		for i, k := range keys {
+			series[i] = f.series[k]
+		}
+		f.mu.Unlock()
+
+		if len(series) == 0 {
+			continue
+		}
+		// OpenMetrics names a counter family without its _total suffix.
+		meta := f.name
+		if openMetrics && f.kind == "counter" {
+			meta = strings.TrimSuffix(f.name, "_total")
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", meta, f.help, meta, f.kind)
+		for i, m := range series {
+			m.write(w, f.name, keys[i], openMetrics)
+		}
+	}
+}
+
+// Handler serves the Default registry, in OpenMetrics if the scraper asks
+// for it.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+			Default.WriteOpenMetrics(w)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		Default.WriteText(w)
+	})
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Add(n uint64)  { c.v.Add(n) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+func (c *Counter) write(w io.Writer, name, labels string, _ bool) {
+	fmt.Fprintf(w, "%s%s %d\n", name, braces(labels), c.Value())
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v atomic.Int64
+}
+
+func (g *Gauge) Set(n int64)  { g.v.Store(n) }
+func (g *Gauge) Add(n int64)  { g.v.Add(n) }
+func (g *Gauge) Value() int64 { return g.v.Load() }
+
+func (g *Gauge) write(w io.Writer, name, labels string, _ bool) {
+	fmt.Fprintf(w, "%s%s %d\n", name, braces(labels), g.Value())
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sumBits atomic.Uint64
+	// exemplars holds the latest exemplar per bucket, +Inf last.
+	exemplars []atomic.Pointer[Exemplar]
+}
+
+// Exemplar links one observation to the trace that produced it, so a
+// dashboard can jump from a slow bucket to an example request.
+type Exemplar struct {
+	TraceID string
+	Value   float64
+	Time    time.Time
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets:   buckets,
+		counts:    make([]atomic.Uint64, len(buckets)),
+		exemplars: make([]atomic.Pointer[Exemplar], len(buckets)+1),
+	}
+}
+
+// ObserveWithExemplar observes v and records it as the exemplar for its
+// bucket.
+func (h *Histogram) ObserveWithExemplar(v float64, traceID string) {
+	h.Observe(v)
+
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.exemplars[i].Store(&Exemplar{TraceID: traceID, Value: v, Time: time.Now()})
+}
+
+func (h *Histogram) Observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i].Add(1)
+		}
+	}
+	h.count.Add(1)
+	for {
+		old := h.sumBits.Load()
+		sum := math.Float64frombits(old) + v
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(sum)) {
+			return
+		}
+	}
+}
+
+func (h *Histogram) write(w io.Writer, name, labels string, openMetrics bool) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d%s\n", name, labels, sep, upper, h.counts[i].Load(), h.exemplar(i, openMetrics))
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d%s\n", name, labels, sep, h.count.Load(), h.exemplar(len(h.buckets), openMetrics))
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, braces(labels), math.Float64frombits(h.sumBits.Load()))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces(labels), h.count.Load())
+}
+
+// exemplar renders bucket i's exemplar suffix, if it has one.
+func (h *Histogram) exemplar(i int, openMetrics bool) string {
+	e := h.exemplars[i].Load()
+	if !openMetrics || e == nil {
+		return ""
+	}
+	return fmt.Sprintf(" # {trace_id=%q} %g %.3f", e.TraceID, e.Value, float64(e.Time.UnixMilli())/1000)
+}
+
+func NewCounter(name, help string) *Counter {
+	return NewCounterVec(name, help).With()
+}
+
+func NewGauge(name, help string) *Gauge {
+	return NewGaugeVec(name, help).With()
+}
+
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return NewHistogramVec(name, help, buckets).With()
+}
+
+type CounterVec struct{ f *family }
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{Default.register(name, help, "counter", labels, func() metric { return &Counter{} })}
+}
+
+func (v *CounterVec) With(values ...string) *Counter { return v.f.with(values...).(*Counter) }
+
+type GaugeVec struct{ f *family }
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{Default.register(name, help, "gauge", labels, func() metric { return &Gauge{} })}
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge { return v.f.with(values...).(*Gauge) }
+
+type HistogramVec struct{ f *family }
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{Default.register(name, help, "histogram", labels, func() metric { return newHistogram(buckets) })}
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram { return v.f.with(values...).(*Histogram) }
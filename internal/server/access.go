@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// saveAccess persists the engine's access records every interval.
+func saveAccess(engine *storage.Engine, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := engine.SaveAccess(); err != nil {
+			log.Printf("saving access records: %v", err)
+		}
+	}
+}
+
+// tierCold moves cold tables to the cold tier every interval.
+func tierCold(engine *storage.Engine, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := engine.TierCold(); err != nil {
+			log.Printf("cold tiering: %v", err)
+		}
+	}
+}
+
+// untouchedHandler lists keys neither read nor written for a while on GET
+// /admin/access/untouched?days=N[&prefix=p][&limit=n], least recently
+// used first. It needs LOGBASE_ACCESS_TRACKING.
+func untouchedHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		days, err := strconv.ParseFloat(query.Get("days"), 64)
+		if err != nil || days < 0 {
+			http.Error(w, "days must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		limit := 1000
+		if s := query.Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		age := time.Duration(days * 24 * float64(time.Hour))
+		keys, err := engine.UntouchedKeys(query.Get("prefix"), age, limit)
+		if errors.Is(err, storage.ErrAccessTracking) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, map[string]any{"keys": keys})
+	}
+}
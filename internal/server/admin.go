@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+const maxSampleSize = 10000
+
+// sampleHandler returns a uniform random sample of live keys.
+func sampleHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := 100
+		if s := r.URL.Query().Get("n"); s != "" {
+			parsed, err := strconv.Atoi(s)
+			if err != nil || parsed <= 0 || parsed > maxSampleSize {
+				http.Error(w, "n must be between 1 and 10000", http.StatusBadRequest)
+				return
+			}
+			n = parsed
+		}
+
+		keys, err := engine.SampleKeys(n)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		sort.Strings(keys)
+
+		writeJSON(w, map[string]any{"keys": keys})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// bloomHandler reports bloom filter effectiveness per table and overall.
+func bloomHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		tables, total := engine.BloomStats()
+		writeJSON(w, map[string]any{"tables": tables, "total": total})
+	}
+}
+
+// blockCacheHandler reports the size and hit rate of the process's SSTable
+// block cache.
+func blockCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, storage.BlockCache())
+}
+
+// ioHandler reports the process's disk I/O by subsystem; see
+// storage.IOStats.
+func ioHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	subsystems, foreground, background := storage.IOStats()
+	writeJSON(w, map[string]any{"subsystems": subsystems, "foreground": foreground, "background": background})
+}
+
+// locksHandler reports the sampled wait times of the engine's internal
+// locks; see storage.LockProfile.
+func locksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]any{"locks": storage.LockProfile()})
+}
+
+// manifestHandler reports the current version set: manifest, sequence
+// numbers, tables and WAL position.
+func manifestHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, engine.Version())
+	}
+}
+
+// compactionPlanHandler reports what the compactor would do now, without
+// doing it.
+func compactionPlanHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, engine.PlanCompaction())
+	}
+}
+
+type copyRangeRequest struct {
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	SrcPrefix string `json:"src_prefix"`
+	DstPrefix string `json:"dst_prefix"`
+}
+
+// copyRangeHandler copies a key range, optionally renaming a key prefix.
+// With only src_prefix, the range defaults to every key with that prefix.
+func copyRangeHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req copyRangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Start == "" && req.End == "" && req.SrcPrefix != "" {
+			req.Start, req.End = req.SrcPrefix, req.SrcPrefix+"\xff"
+		}
+		if req.Start == "" || req.End == "" {
+			http.Error(w, "start and end (or src_prefix) required", http.StatusBadRequest)
+			return
+		}
+		if req.SrcPrefix == req.DstPrefix {
+			http.Error(w, "src_prefix and dst_prefix must differ", http.StatusBadRequest)
+			return
+		}
+
+		copied, err := engine.CopyRange([]byte(req.Start), []byte(req.End), storage.CopyRangeOptions{
+			SrcPrefix: []byte(req.SrcPrefix),
+			DstPrefix: []byte(req.DstPrefix),
+		})
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		writeJSON(w, map[string]int{"copied": copied})
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/admission"
+	"github.com/manjeet13/logbase/internal/config"
+)
+
+func newAdmissionController(cfg *config.Config) *admission.Controller {
+	limits := func(concurrency int) admission.Limits {
+		return admission.Limits{
+			Concurrency: concurrency,
+			MaxQueue:    cfg.AdmitQueueLength,
+			Timeout:     time.Duration(cfg.AdmitTimeoutMs) * time.Millisecond,
+		}
+	}
+
+	return admission.NewController(map[admission.Class]admission.Limits{
+		admission.PointRead: limits(cfg.AdmitReads),
+		admission.Scan:      limits(cfg.AdmitScans),
+		admission.Write:     limits(cfg.AdmitWrites),
+		admission.Admin:     limits(cfg.AdmitAdmin),
+	})
+}
+
+// classOf returns a classifier that always reports cls.
+func classOf(cls admission.Class) func(*http.Request) admission.Class {
+	return func(*http.Request) admission.Class { return cls }
+}
+
+// kvClass classifies /kv requests by method.
+func kvClass(r *http.Request) admission.Class {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return admission.PointRead
+	}
+	return admission.Write
+}
+
+// priorityHeader lets clients mark requests as "interactive" (default) or
+// "bulk"; bulk requests queue behind interactive ones.
+const priorityHeader = "X-Logbase-Priority"
+
+// compactionYieldLimit bounds how long one compaction step waits for queued
+// interactive requests to be admitted.
+const compactionYieldLimit = 50 * time.Millisecond
+
+// admit wraps next so it only runs once the request's class has capacity.
+func admit(ctrl *admission.Controller, classify func(*http.Request) admission.Class, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		prio := admission.ParsePriority(r.Header.Get(priorityHeader))
+		release, err := ctrl.Acquire(r.Context(), classify(r), prio)
+		if err != nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+
+		next(w, r)
+	}
+}
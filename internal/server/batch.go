@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// batchOptions reads the options shared by /batch and /batch-delete:
+// ?guard_key=k applies the batch only if k holds ?guard_value (or, without
+// guard_value, does not exist); ?return_previous=true returns each key's
+// value from before the batch.
+func batchOptions(r *http.Request) storage.BatchOptions {
+	query := r.URL.Query()
+
+	var opts storage.BatchOptions
+	if guardKey := query.Get("guard_key"); guardKey != "" {
+		opts.Guard = &storage.Guard{Key: []byte(guardKey)}
+		if query.Has("guard_value") {
+			opts.Guard.Value = []byte(query.Get("guard_value"))
+		}
+	}
+	opts.ReturnPrevious = query.Get("return_previous") == "true"
+	return opts
+}
+
+// writeBatchResult reports a batch's outcome. names maps each touched key
+// to the name the client used for it; with ReturnPrevious every name is
+// listed, with null for keys that did not exist.
+func writeBatchResult(w http.ResponseWriter, opts storage.BatchOptions, names map[string]string, result storage.BatchResult, err error) {
+	if errors.Is(err, storage.ErrGuardFailed) {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	writeSeq(w.Header(), result.Seq)
+	if !opts.ReturnPrevious {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	previous := make(map[string]*string, len(names))
+	for key, name := range names {
+		previous[name] = nil
+		if v, ok := result.Previous[key]; ok {
+			s := string(v)
+			previous[name] = &s
+		}
+	}
+	writeJSON(w, map[string]any{"previous": previous})
+}
+
+// batchOp is one operation of a mixed /batch: {"op": "put", "key": k,
+// "value": v} or {"op": "delete", "key": k}.
+type batchOp struct {
+	Op    string  `json:"op"`
+	Key   string  `json:"key"`
+	Value *string `json:"value"`
+}
+
+// writeOps applies a JSON array of batchOps as one atomic batch.
+func writeOps(engine *storage.Engine, w http.ResponseWriter, r *http.Request, body json.RawMessage) {
+	var ops []batchOp
+	if err := json.Unmarshal(body, &ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var batch storage.Batch
+	names := make(map[string]string, len(ops))
+	for i, op := range ops {
+		if op.Key == "" {
+			http.Error(w, fmt.Sprintf("operation %d: empty key", i), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case op.Op == "put" && op.Value != nil:
+			batch.Put([]byte(op.Key), []byte(*op.Value))
+		case op.Op == "put":
+			http.Error(w, fmt.Sprintf("operation %d: put without a value", i), http.StatusBadRequest)
+			return
+		case op.Op == "delete":
+			batch.Delete([]byte(op.Key))
+		default:
+			http.Error(w, fmt.Sprintf("operation %d: op must be put or delete", i), http.StatusBadRequest)
+			return
+		}
+		names[op.Key] = op.Key
+	}
+
+	opts := batchOptions(r)
+	result, err := engine.WriteWithOptions(&batch, opts)
+	writeBatchResult(w, opts, names, result, err)
+}
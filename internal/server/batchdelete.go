@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+const maxBatchDeleteKeys = 10000
+
+// batchDeleteHandler deletes a JSON array of keys in one atomic batch. With
+// ?encoding=base64 the keys are base64 encoded, for keys that are not
+// valid UTF-8. See batchOptions for the other query parameters.
+func batchDeleteHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var names []string
+		if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(names) > maxBatchDeleteKeys {
+			http.Error(w, "too many keys", http.StatusBadRequest)
+			return
+		}
+
+		encoded := r.URL.Query().Get("encoding") == "base64"
+		keys := make([][]byte, 0, len(names))
+		byKey := make(map[string]string, len(names))
+		for _, name := range names {
+			key := []byte(name)
+			if encoded {
+				var err error
+				if key, err = base64.StdEncoding.DecodeString(name); err != nil {
+					http.Error(w, "invalid base64 key: "+name, http.StatusBadRequest)
+					return
+				}
+			}
+			if len(key) == 0 {
+				http.Error(w, "empty key", http.StatusBadRequest)
+				return
+			}
+			keys = append(keys, key)
+			byKey[string(key)] = name
+		}
+
+		opts := batchOptions(r)
+		result, err := engine.BatchDeleteWithOptions(keys, opts)
+		writeBatchResult(w, opts, byKey, result, err)
+	}
+}
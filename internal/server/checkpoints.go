@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// takeCheckpoints checkpoints the engine every interval, keeping the newest
+// retain checkpoints.
+func takeCheckpoints(engine *storage.Engine, interval time.Duration, retain int) {
+	for range time.Tick(interval) {
+		if _, err := engine.Checkpoint(); err != nil {
+			log.Printf("checkpoint: %v", err)
+			continue
+		}
+		if err := engine.PruneCheckpoints(retain); err != nil {
+			log.Printf("checkpoint prune: %v", err)
+		}
+	}
+}
+
+// checkpointsHandler lists checkpoints on GET and takes one on POST.
+func checkpointsHandler(engine *storage.Engine, retain int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			infos, err := engine.Checkpoints()
+			if err != nil {
+				writeStorageError(w, err)
+				return
+			}
+			writeJSON(w, map[string]any{"checkpoints": infos})
+
+		case http.MethodPost:
+			info, err := engine.Checkpoint()
+			if err != nil {
+				writeStorageError(w, err)
+				return
+			}
+			if err := engine.PruneCheckpoints(retain); err != nil {
+				log.Printf("checkpoint prune: %v", err)
+			}
+			writeJSON(w, info)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// crcHeader carries the CRC32 (IEEE, hex) of a checkpoint file chunk.
+const crcHeader = "X-Logbase-CRC32"
+
+// checkpointFilesHandler serves a checkpoint's files to a node bootstrapping
+// from it. GET /admin/checkpoints/{name}/files lists the files with their
+// sizes and CRC32s; GET /admin/checkpoints/{name}/files/{file}?offset=&length=
+// returns one chunk, with its CRC32 in X-Logbase-CRC32. Fetching by offset
+// lets a client resume after a failure instead of starting over. Chunks
+// are compressed as transfers negotiates; the CRC32 is of the chunk before
+// compression.
+func checkpointFilesHandler(engine *storage.Engine, transfers *transferCompressor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name, rest, _ := strings.Cut(r.URL.Path[len("/admin/checkpoints/"):], "/")
+		if rest == "files" {
+			files, err := engine.CheckpointFiles(name)
+			if err != nil {
+				writeCheckpointError(w, err)
+				return
+			}
+			writeJSON(w, map[string]any{"checkpoint": name, "files": files})
+			return
+		}
+
+		file, ok := strings.CutPrefix(rest, "files/")
+		if !ok || file == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		offset, length := int64(0), storage.MaxChunkBytes
+		if s := r.URL.Query().Get("offset"); s != "" {
+			parsed, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || parsed < 0 {
+				http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+			offset = parsed
+		}
+		if s := r.URL.Query().Get("length"); s != "" {
+			parsed, err := strconv.Atoi(s)
+			if err != nil || parsed <= 0 || parsed > storage.MaxChunkBytes {
+				http.Error(w, fmt.Sprintf("length must be between 1 and %d", storage.MaxChunkBytes), http.StatusBadRequest)
+				return
+			}
+			length = parsed
+		}
+
+		chunk, err := engine.ReadCheckpointChunk(name, file, offset, length)
+		if err != nil {
+			writeCheckpointError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(crcHeader, fmt.Sprintf("%08x", crc32.ChecksumIEEE(chunk)))
+		transfers.write(w, r, chunk)
+	}
+}
+
+func writeCheckpointError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrNoCheckpoint) || errors.Is(err, storage.ErrNoCheckpointFile) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeStorageError(w, err)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/manjeet13/logbase/internal/config"
+)
+
+// resolveConfig fills in the settings whose defaults depend on others.
+func resolveConfig(cfg *config.Config) {
+	if cfg.TempDir == "" && cfg.ZeroConfig {
+		cfg.TempDir = filepath.Join(cfg.DataDir, "tmp")
+	}
+}
+
+// configReport is the effective configuration, secrets redacted, with the
+// LOGBASE_* environment variables that were ignored.
+func configReport(cfg *config.Config) map[string]any {
+	unknown, invalid := config.EnvProblems()
+	return map[string]any{
+		"settings":    cfg.Settings(),
+		"unknown_env": unknown,
+		"invalid_env": invalid,
+	}
+}
+
+// PrintConfig writes the configuration Run would use with cfg, as
+// /admin/config reports it. An admin token that zero-config mode would
+// generate or load from the data directory is not known yet, so it shows
+// as empty.
+func PrintConfig(w io.Writer, cfg *config.Config) error {
+	resolveConfig(cfg)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(configReport(cfg))
+}
+
+// configHandler serves the running configuration.
+func configHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.Encode(configReport(cfg))
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// experimentSpec is the body of PUT /admin/experiments: the durability to
+// try on percent of writes for duration_sec.
+type experimentSpec struct {
+	Percent       float64 `json:"percent"`
+	DurationSec   int     `json:"duration_sec"`
+	Sync          bool    `json:"sync"`
+	GroupWindowUs int     `json:"group_window_us"`
+	GroupSize     int     `json:"group_size"`
+}
+
+// experimentsHandler reports the last durability experiment on GET
+// /admin/experiments, starts one on PUT and stops it early on DELETE.
+func experimentsHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var spec experimentSpec
+			if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err := engine.StartExperiment(storage.Experiment{
+				Durability: storage.Durability{
+					Sync:        spec.Sync,
+					GroupWindow: time.Duration(spec.GroupWindowUs) * time.Microsecond,
+					GroupSize:   spec.GroupSize,
+				},
+				Percent:  spec.Percent,
+				Duration: time.Duration(spec.DurationSec) * time.Second,
+			})
+			switch {
+			case errors.Is(err, storage.ErrInvalidExperiment):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			case errors.Is(err, storage.ErrExperimentRunning):
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+		case http.MethodDelete:
+			engine.StopExperiment()
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		report, ok := engine.ExperimentReport()
+		if !ok {
+			http.Error(w, "no experiment has run", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, report)
+	}
+}
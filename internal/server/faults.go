@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+type faultSpec struct {
+	LatencyMs int     `json:"latency_ms"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// faultsHandler configures fault injection. GET /admin/faults/ lists the
+// active faults; PUT /admin/faults/{point} sets one and DELETE clears it.
+func faultsHandler(faults *storage.FaultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		point := storage.FaultPoint(r.URL.Path[len("/admin/faults/"):])
+
+		if point == "" {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			active := make(map[storage.FaultPoint]faultSpec)
+			for p, f := range faults.Faults() {
+				active[p] = faultSpec{LatencyMs: int(f.Latency / time.Millisecond), ErrorRate: f.ErrorRate}
+			}
+			writeJSON(w, active)
+			return
+		}
+
+		if !slices.Contains(storage.FaultPoints, point) {
+			http.Error(w, "unknown fault point", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			var spec faultSpec
+			if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if spec.LatencyMs < 0 || spec.ErrorRate < 0 || spec.ErrorRate > 1 {
+				http.Error(w, "latency_ms must be >= 0 and error_rate between 0 and 1", http.StatusBadRequest)
+				return
+			}
+			faults.Set(point, storage.Fault{
+				Latency:   time.Duration(spec.LatencyMs) * time.Millisecond,
+				ErrorRate: spec.ErrorRate,
+			})
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodDelete:
+			faults.Clear(point)
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// readyzHandler reports whether the engine can serve traffic. A read-only
+// engine is still ready (it serves reads); an unavailable one is not, and
+// neither is a server that is stopping.
+func readyzHandler(engine *storage.Engine, stopping *atomic.Bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		state, cause := engine.Health()
+
+		status := map[string]string{"state": state.String()}
+		if cause != nil {
+			status["cause"] = cause.Error()
+		}
+		// Still ready: reads are served
+		if mode, ok := engine.ReadOnly(); ok {
+			status["maintenance"] = mode.Reason
+		}
+		if stopping.Load() {
+			status["state"] = "stopping"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if state == storage.Unavailable || stopping.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// available rejects requests outright once the engine is unavailable.
+func available(engine *storage.Engine, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if state, cause := engine.Health(); state == storage.Unavailable {
+			http.Error(w, storage.ErrUnavailable.Error()+": "+cause.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeStorageError maps engine errors to HTTP statuses.
+func writeStorageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrReadOnly) || errors.Is(err, storage.ErrUnavailable) ||
+		errors.Is(err, storage.ErrFenced) || errors.Is(err, storage.ErrSecondary) ||
+		errors.Is(err, storage.ErrMaintenance) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, storage.ErrQuotaExceeded) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	if errors.Is(err, storage.ErrSeqMismatch) {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	if errors.Is(err, storage.ErrReservedKey) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
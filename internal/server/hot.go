@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// hotHandler reports the hot set on GET /admin/hot.
+func hotHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, engine.HotSet())
+	}
+}
+
+// hotPatternHandler pins a key, or a prefix ending in "*", on PUT
+// /admin/hot/{pattern} and unpins it on DELETE. Pins made here last until
+// the server stops; LOGBASE_HOT_KEYS sets the ones applied at start.
+func hotPatternHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pattern := strings.TrimPrefix(r.URL.Path, "/admin/hot/")
+
+		var err error
+		switch r.Method {
+		case http.MethodPut:
+			err = engine.PinHot(pattern)
+		case http.MethodDelete:
+			err = engine.UnpinHot(pattern)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch {
+		case errors.Is(err, storage.ErrHotSetTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, storage.ErrInvalidHotPattern):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			writeStorageError(w, err)
+		default:
+			writeJSON(w, engine.HotSet())
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// otherNamespace labels requests whose namespace is not tracked.
+const otherNamespace = "(other)"
+
+// requestMetrics records per-route request counts and latencies, labelled
+// by namespace when namespaces are tracked.
+type requestMetrics struct {
+	total    *metrics.CounterVec
+	duration *metrics.HistogramVec
+	// namespaces is nil when requests are not labelled by namespace.
+	namespaces *namespaceGuard
+	// slos is nil when no SLO is configured.
+	slos   *sloTracker
+	tracer *tracer
+}
+
+func newRequestMetrics(namespaces *namespaceGuard, slos *sloTracker, tracer *tracer) *requestMetrics {
+	labels := []string{"route"}
+	if namespaces != nil {
+		labels = append(labels, "namespace")
+	}
+	return &requestMetrics{
+		total: metrics.NewCounterVec("logbase_http_requests_total",
+			"HTTP requests by route and status code.", append(labels, "code")...),
+		duration: metrics.NewHistogramVec("logbase_http_request_duration_seconds",
+			"HTTP request latency by route.", metrics.DefaultBuckets, labels...),
+		namespaces: namespaces,
+		slos:       slos,
+		tracer:     tracer,
+	}
+}
+
+// instrument records and traces every request the mux serves.
+func (m *requestMetrics) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		traceID, reason := m.tracer.start(w, r, route)
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(sw, r)
+		elapsed := time.Since(start)
+
+		labels := []string{route}
+		if m.namespaces != nil {
+			labels = append(labels, m.namespaces.label(requestNamespace(r)))
+		}
+		sampled := m.tracer.finish(span{
+			TraceID:    traceID,
+			Start:      start,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Route:      route,
+			Status:     sw.status,
+			DurationMs: float64(elapsed.Microseconds()) / 1000,
+			Reason:     reason,
+		})
+		if sampled {
+			m.duration.With(labels...).ObserveWithExemplar(elapsed.Seconds(), traceID)
+		} else {
+			m.duration.With(labels...).Observe(elapsed.Seconds())
+		}
+		m.total.With(append(labels, strconv.Itoa(sw.status))...).Inc()
+		m.slos.record(route, sw.status, elapsed)
+	})
+}
+
+// requestNamespace is the namespace of the key a request addresses, by the
+// same rule /admin/stats groups keys, or "" if it addresses no single key
+// or prefix.
+func requestNamespace(r *http.Request) string {
+	if key, ok := strings.CutPrefix(r.URL.Path, "/kv/"); ok {
+		return storage.KeyPrefix(key)
+	}
+	if r.URL.Path == "/range" {
+		q := r.URL.Query()
+		if prefix := q.Get("prefix"); prefix != "" {
+			return storage.KeyPrefix(prefix)
+		}
+		return storage.KeyPrefix(q.Get("start"))
+	}
+	return ""
+}
+
+// namespaceGuard bounds the namespace label's cardinality. Namespaces on
+// the allowlist are always tracked; without an allowlist the first max
+// namespaces seen are. Everything else is counted as "(other)".
+type namespaceGuard struct {
+	allow map[string]bool
+	max   int
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// newNamespaceGuard parses LOGBASE_METRICS_NAMESPACES: "" disables
+// namespace labels, "*" tracks any namespace, anything else is a
+// comma-separated allowlist.
+func newNamespaceGuard(spec string, max int) *namespaceGuard {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil
+	}
+
+	g := &namespaceGuard{max: max, seen: make(map[string]bool)}
+	if spec != "*" {
+		g.allow = make(map[string]bool)
+		for _, ns := range strings.Split(spec, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				g.allow[ns] = true
+			}
+		}
+	}
+	return g
+}
+
+func (g *namespaceGuard) label(ns string) string {
+	if ns == "" {
+		return ""
+	}
+	if g.allow != nil {
+		if g.allow[ns] {
+			return ns
+		}
+		return otherNamespace
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.seen[ns] {
+		return ns
+	}
+	if len(g.seen) >= g.max {
+		return otherNamespace
+	}
+	g.seen[ns] = true
+	return ns
+}
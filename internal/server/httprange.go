@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+var errInvalidRange = errors.New("invalid range")
+
+// byteRange is a single parsed "bytes=" range in the engine's (off, n) form:
+// a negative off is a suffix range and a negative n reads to the end.
+type byteRange struct {
+	off int64
+	n   int64
+}
+
+// parseByteRange parses a Range header value. Only a single range is
+// supported; multi-range requests are reported as invalid so the caller can
+// fall back to serving the whole value.
+func parseByteRange(header string) (byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return byteRange{}, errInvalidRange
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return byteRange{}, errInvalidRange
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return byteRange{}, errInvalidRange
+		}
+		return byteRange{off: -suffix, n: -1}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, errInvalidRange
+	}
+	if last == "" {
+		return byteRange{off: start, n: -1}, nil
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return byteRange{}, errInvalidRange
+	}
+	return byteRange{off: start, n: end - start + 1}, nil
+}
+
+// serveRange writes a 206 response carrying the requested slice of key's value.
+func serveRange(w http.ResponseWriter, r *http.Request, engine *storage.Engine, key []byte, br byteRange) {
+	val, size, ok := engine.GetRange(key, br.off, br.n)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	start := br.off
+	if start < 0 {
+		start = max(size+start, 0)
+	}
+	if start >= size {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, "range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+int64(len(val))-1, size))
+	w.Header().Set("Content-Length", strconv.Itoa(len(val)))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(val)
+}
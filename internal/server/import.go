@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// importBatchSize is the number of keys each streamed sub-batch writes.
+const importBatchSize = 1000
+
+var errBadImport = errors.New("bad import")
+
+type importRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// progressEvent is one line of a streamed write's NDJSON response.
+type progressEvent struct {
+	Applied int    `json:"applied"`
+	Done    bool   `json:"done,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// importer applies a stream of writes in atomic sub-batches of
+// importBatchSize keys, reporting progress after each. Until the first
+// sub-batch is written, errors get a plain HTTP error status; after that
+// the response has started and they are reported in the final event.
+type importer struct {
+	engine  *storage.Engine
+	w       http.ResponseWriter
+	batch   map[string][]byte
+	applied int
+	started bool
+}
+
+func newImporter(engine *storage.Engine, w http.ResponseWriter) *importer {
+	// Keep reading the body after progress has been sent
+	http.NewResponseController(w).EnableFullDuplex()
+	return &importer{engine: engine, w: w, batch: make(map[string][]byte)}
+}
+
+func (im *importer) add(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty key", errBadImport)
+	}
+	im.batch[key] = value
+	if len(im.batch) >= importBatchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+func (im *importer) flush() error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	if err := im.engine.BatchPut(im.batch); err != nil {
+		return err
+	}
+	im.applied += len(im.batch)
+	im.batch = make(map[string][]byte)
+	im.emit(progressEvent{Applied: im.applied})
+	return nil
+}
+
+func (im *importer) emit(ev progressEvent) {
+	if !im.started {
+		im.started = true
+		im.w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	json.NewEncoder(im.w).Encode(ev)
+	http.NewResponseController(im.w).Flush()
+}
+
+// finish writes what is left and ends the response.
+func (im *importer) finish(err error) {
+	if err == nil {
+		err = im.flush()
+	}
+
+	if err != nil && !im.started {
+		var syntax *json.SyntaxError
+		if errors.Is(err, errBadImport) || errors.As(err, &syntax) || errors.Is(err, io.ErrUnexpectedEOF) {
+			http.Error(im.w, err.Error(), http.StatusBadRequest)
+		} else {
+			writeStorageError(im.w, err)
+		}
+		return
+	}
+
+	ev := progressEvent{Applied: im.applied, Done: err == nil}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	im.emit(ev)
+}
+
+// importHandler streams newline-delimited {"key": ..., "value": ...}
+// records into the store in sub-batches, so bodies of any size are
+// accepted without buffering them. Each sub-batch is atomic; the import as
+// a whole is not.
+func importHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		im := newImporter(engine, w)
+		dec := json.NewDecoder(r.Body)
+		for {
+			var rec importRecord
+			err := dec.Decode(&rec)
+			if err == io.EOF {
+				break
+			}
+			if err == nil {
+				err = im.add(rec.Key, []byte(rec.Value))
+			}
+			if err != nil {
+				im.finish(err)
+				return
+			}
+		}
+		im.finish(nil)
+	}
+}
+
+// splitBatch streams a /batch JSON object into sub-batches rather than
+// decoding it whole.
+func splitBatch(engine *storage.Engine, w http.ResponseWriter, r *http.Request) {
+	im := newImporter(engine, w)
+	im.finish(decodeObject(json.NewDecoder(r.Body), im.add))
+}
+
+// decodeObject calls fn for each member of a JSON object of strings as it
+// is decoded.
+func decodeObject(dec *json.Decoder, fn func(key string, value []byte) error) error {
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("%w: expected a JSON object", errBadImport)
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+
+		var value string
+		if err := dec.Decode(&value); err != nil {
+			return fmt.Errorf("%w: value of %q: %v", errBadImport, key, err)
+		}
+		if err := fn(key, []byte(value)); err != nil {
+			return err
+		}
+	}
+
+	_, err := dec.Token()
+	return err
+}
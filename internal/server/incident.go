@@ -0,0 +1,202 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/config"
+	"github.com/manjeet13/logbase/internal/logging"
+	"github.com/manjeet13/logbase/internal/metrics"
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+const (
+	// incidentLogLines is how many recent log lines the server keeps for
+	// incident bundles.
+	incidentLogLines = 2000
+	// incidentDirName holds bundles under the data directory; only the
+	// newest maxIncidentBundles are kept.
+	incidentDirName    = "incidents"
+	maxIncidentBundles = 10
+	redacted           = "<redacted>"
+)
+
+// kvPath matches key paths in logs and traced requests.
+var kvPath = regexp.MustCompile(`/kv/[^\s?"]+`)
+
+// incidentReporter gathers what a bug report needs from a running server.
+type incidentReporter struct {
+	engine  *storage.Engine
+	cfg     *config.Config
+	tracer  *tracer
+	slos    *sloTracker
+	started time.Time
+}
+
+// bundle builds a gzipped tarball of the server's state. Unless keys is
+// set, user keys are redacted to their namespace; values are never read.
+func (ir *incidentReporter) bundle(keys bool) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	addJSON := func(name string, v any) error {
+		var data bytes.Buffer
+		enc := json.NewEncoder(&data)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return add(name, data.Bytes())
+	}
+
+	state, cause := ir.engine.Health()
+	info := map[string]any{
+		"time":       now.UTC(),
+		"started":    ir.started.UTC(),
+		"uptime_sec": int64(now.Sub(ir.started).Seconds()),
+		"go_version": runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"goroutines": runtime.NumGoroutine(),
+		"pid":        os.Getpid(),
+		"health":     state.String(),
+		"keys":       keys,
+	}
+	if cause != nil {
+		info["health_cause"] = cause.Error()
+	}
+
+	version := ir.engine.Version()
+	spans := ir.tracer.spans("")
+	slices.SortStableFunc(spans, func(a, b span) int {
+		switch {
+		case a.DurationMs > b.DurationMs:
+			return -1
+		case a.DurationMs < b.DurationMs:
+			return 1
+		}
+		return 0
+	})
+	logs := logging.Recent()
+	if !keys {
+		for i := range version.Tables {
+			version.Tables[i].MinKey = scrubKey(version.Tables[i].MinKey)
+			version.Tables[i].MaxKey = scrubKey(version.Tables[i].MaxKey)
+		}
+		for i := range spans {
+			spans[i].Path = scrubPath(spans[i].Path)
+		}
+		logs = kvPath.ReplaceAll(logs, []byte("/kv/"+redacted))
+	}
+
+	var goroutines, metricsText bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&goroutines, 2)
+	metrics.Default.WriteText(&metricsText)
+
+	steps := []func() error{
+		func() error { return addJSON("info.json", info) },
+		func() error { return addJSON("config.json", configReport(ir.cfg)) },
+		func() error { return addJSON("manifest.json", version) },
+		func() error { return addJSON("stats.json", ir.engine.Stats()) },
+		func() error { return addJSON("slo.json", ir.slos.evaluate()) },
+		func() error { return addJSON("requests.json", spans) },
+		func() error { return add("log.txt", logs) },
+		func() error { return add("goroutines.txt", goroutines.Bytes()) },
+		func() error { return add("metrics.txt", metricsText.Bytes()) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// persist saves a bundle under the data directory and prunes old ones.
+func (ir *incidentReporter) persist(name string, data []byte) error {
+	dir := filepath.Join(ir.cfg.DataDir, incidentDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		return err
+	}
+
+	old, _ := filepath.Glob(filepath.Join(dir, "incident-*.tar.gz"))
+	slices.Sort(old)
+	for len(old) > maxIncidentBundles {
+		os.Remove(old[0])
+		old = old[1:]
+	}
+	return nil
+}
+
+// scrubKey keeps a key's namespace and redacts the rest.
+func scrubKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	prefix := storage.KeyPrefix(key)
+	if !strings.HasPrefix(key, prefix) {
+		return redacted
+	}
+	return key[:len(prefix)+1] + redacted
+}
+
+func scrubPath(path string) string {
+	return kvPath.ReplaceAllString(path, "/kv/"+redacted)
+}
+
+// incidentHandler builds an incident bundle on POST, saves a copy under
+// the data directory and returns it. ?keys=true keeps user keys in it.
+func incidentHandler(ir *incidentReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		data, err := ir.bundle(r.URL.Query().Get("keys") == "true")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		name := "incident-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+		if err := ir.persist(name, data); err != nil {
+			log.Printf("incident bundle: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Write(data)
+	}
+}
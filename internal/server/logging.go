@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/logging"
+)
+
+var httpLog = logging.For("http")
+
+type logSpec struct {
+	Level  *string `json:"level"`
+	Sample *int64  `json:"sample"`
+}
+
+// statusWriter remembers the status code written to it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for
+// flushing and full-duplex streaming.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logRequests logs each request at debug level on the http subsystem.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !httpLog.Enabled(logging.Debug) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		httpLog.Debugf("%s %s %d %s", r.Method, r.URL.Path, sw.status, time.Since(start))
+	})
+}
+
+// logHandler configures logging. GET /admin/log/ lists every subsystem's
+// level and debug sample rate; PUT /admin/log/{subsystem} changes them.
+func logHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[len("/admin/log/"):]
+
+	if name == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, logging.All())
+		return
+	}
+
+	logger, ok := logging.Lookup(name)
+	if !ok {
+		http.Error(w, "unknown subsystem", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, logger.Settings())
+
+	case http.MethodPut:
+		var spec logSpec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if spec.Level != nil {
+			level, err := logging.ParseLevel(*spec.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			logger.SetLevel(level)
+		}
+		if spec.Sample != nil {
+			logger.SetSample(*spec.Sample)
+		}
+		writeJSON(w, logger.Settings())
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// metaHeaderPrefix marks request headers that are stored with a value and
+// echoed back on GET.
+const metaHeaderPrefix = "X-Logbase-Meta-"
+
+func readMetadata(h http.Header) storage.Metadata {
+	meta := storage.Metadata{ContentType: h.Get("Content-Type")}
+	for name, values := range h {
+		if suffix, ok := strings.CutPrefix(name, metaHeaderPrefix); ok && suffix != "" {
+			if meta.Headers == nil {
+				meta.Headers = make(map[string]string)
+			}
+			meta.Headers[suffix] = values[0]
+		}
+	}
+	return meta
+}
+
+func writeMetadata(h http.Header, meta storage.Metadata) {
+	if meta.ContentType != "" {
+		h.Set("Content-Type", meta.ContentType)
+	}
+	for name, value := range meta.Headers {
+		h.Set(metaHeaderPrefix+name, value)
+	}
+}
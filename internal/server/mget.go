@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+const maxMGetKeys = 1000
+
+type mgetRequest struct {
+	Keys   []string `json:"keys"`
+	Fields []string `json:"fields"`
+}
+
+// mgetHandler fetches many keys in one call. When fields are given, each
+// value is decoded as a JSON document and only those (dot-separated) paths
+// are returned, keyed by path; values that are not JSON objects are skipped.
+// Keys that do not exist are omitted from the response.
+func mgetHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req mgetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Keys) > maxMGetKeys {
+			http.Error(w, "too many keys", http.StatusBadRequest)
+			return
+		}
+
+		result := make(map[string]any, len(req.Keys))
+		for _, key := range req.Keys {
+			val, ok := engine.Get([]byte(key))
+			if !ok {
+				continue
+			}
+
+			if len(req.Fields) == 0 {
+				result[key] = rawValue(val)
+				continue
+			}
+
+			var doc map[string]any
+			if err := json.Unmarshal(val, &doc); err != nil {
+				continue
+			}
+			result[key] = project(doc, req.Fields)
+		}
+
+		writeJSON(w, result)
+	}
+}
+
+// rawValue embeds JSON values as-is and everything else as a string.
+func rawValue(val []byte) any {
+	if json.Valid(val) {
+		return json.RawMessage(bytes.Clone(val))
+	}
+	return string(val)
+}
+
+func project(doc map[string]any, fields []string) map[string]any {
+	out := make(map[string]any, len(fields))
+	for _, field := range fields {
+		var cur any = doc
+		for _, part := range strings.Split(field, ".") {
+			obj, ok := cur.(map[string]any)
+			if !ok {
+				cur = nil
+				break
+			}
+			cur = obj[part]
+		}
+		if cur != nil {
+			out[field] = cur
+		}
+	}
+	return out
+}
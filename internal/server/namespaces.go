@@ -0,0 +1,338 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/manjeet13/logbase/internal/admission"
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// namespaces are data directories attached to a running server at runtime,
+// each served under /ns/{name}/ with the same key-value API as the server's
+// own engine. Detaching one closes its engine, so an archived dataset can
+// be opened for a while and released again without a restart. Attachments
+// do not survive a restart.
+type namespaces struct {
+	// own is the server's own engine, whose directory cannot be attached;
+	// ownTransformer names its value transformer.
+	own            *storage.Engine
+	ownTransformer string
+	ctrl           *admission.Controller
+
+	mu       sync.Mutex
+	attached map[string]*attachment
+}
+
+// attachment is one attached data directory. Requests hold mu for reading
+// while they use the engine, so detaching waits for them before closing it.
+type attachment struct {
+	dir         string
+	readOnly    bool
+	transformer string
+	durability  string
+	engine      *storage.Engine
+	handler     http.Handler
+
+	mu sync.RWMutex
+}
+
+func newNamespaces(own *storage.Engine, ownTransformer string, ctrl *admission.Controller) *namespaces {
+	return &namespaces{own: own, ownTransformer: ownTransformer, ctrl: ctrl, attached: make(map[string]*attachment)}
+}
+
+// attach opens dir, which must already hold a data directory, and serves
+// it as name. A read-only attachment opens it as a secondary, so nothing
+// in it is written. transformer, if set, names the registered value
+// transformer its values go through, and durability, if set, is the sync
+// policy of its writes, which go to its own WAL.
+func (ns *namespaces) attach(name, dir string, readOnly bool, transformer, durability string) error {
+	if _, err := os.Stat(filepath.Join(dir, "MANIFEST")); err != nil {
+		return fmt.Errorf("%w: %s has no MANIFEST", errNotDataDir, dir)
+	}
+	var t storage.ValueTransformer
+	if transformer != "" {
+		var err error
+		if t, err = storage.LookupValueTransformer(transformer); err != nil {
+			return fmt.Errorf("%w: %v", errBadTransformer, err)
+		}
+	}
+	var policies []storage.PrefixSyncPolicy
+	if durability != "" {
+		d, err := storage.ParseDurability(durability)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadDurability, err)
+		}
+		policies = []storage.PrefixSyncPolicy{{Durability: d, Policy: durability}}
+	}
+	clean, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if own, err := filepath.Abs(ns.own.DataDir()); err == nil && own == clean {
+		return fmt.Errorf("%w: %s is the server's own data directory", errAttached, dir)
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if _, ok := ns.attached[name]; ok {
+		return fmt.Errorf("%w: namespace %s", errAttached, name)
+	}
+	for other, a := range ns.attached {
+		if a.dir == clean {
+			return fmt.Errorf("%w: %s as %s", errAttached, dir, other)
+		}
+	}
+
+	var engine *storage.Engine
+	if readOnly {
+		engine, err = storage.OpenSecondary(clean)
+	} else {
+		engine, err = storage.NewEngine(clean)
+	}
+	if err != nil {
+		return err
+	}
+	if t != nil {
+		engine.SetValueTransformer(t)
+	}
+	engine.SetPrefixSyncPolicies(policies)
+	if mode, ok := ns.own.ReadOnly(); ok {
+		engine.SetReadOnly(mode.Reason)
+	}
+
+	mux := http.NewServeMux()
+	dataRoutes(mux, engine, ns.ctrl)
+	ns.attached[name] = &attachment{
+		dir:         clean,
+		readOnly:    readOnly,
+		transformer: transformer,
+		durability:  durability,
+		engine:      engine,
+		handler:     http.StripPrefix("/ns/"+name, mux),
+	}
+	log.Printf("namespace %s: attached %s (read-only: %t)", name, clean, readOnly)
+	return nil
+}
+
+// detach stops serving name and closes its engine once the requests using
+// it have finished.
+func (ns *namespaces) detach(name string) error {
+	ns.mu.Lock()
+	a, ok := ns.attached[name]
+	delete(ns.attached, name)
+	ns.mu.Unlock()
+	if !ok {
+		return errNotAttached
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.engine.Close(); err != nil {
+		return err
+	}
+	log.Printf("namespace %s: detached %s", name, a.dir)
+	return nil
+}
+
+// clone copies the namespace from, or the server's own data with from
+// empty, into the new data directory dir, sharing its tables, and attaches
+// the copy as name with the same value transformer.
+func (ns *namespaces) clone(name, from, dir string) error {
+	engine, transformer := ns.own, ns.ownTransformer
+	if from != "" {
+		ns.mu.Lock()
+		a, ok := ns.attached[from]
+		if ok {
+			a.mu.RLock()
+		}
+		ns.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("%w: %s", errNotAttached, from)
+		}
+		defer a.mu.RUnlock()
+		engine, transformer = a.engine, a.transformer
+	}
+
+	ns.mu.Lock()
+	_, taken := ns.attached[name]
+	ns.mu.Unlock()
+	if taken {
+		return fmt.Errorf("%w: namespace %s", errAttached, name)
+	}
+
+	if err := engine.Clone(dir); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%w: %v", errCloneTarget, err)
+		}
+		return err
+	}
+	if err := ns.attach(name, dir, false, transformer, ""); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	log.Printf("namespace %s: cloned from %s", name, cmp.Or(from, "the server's own data"))
+	return nil
+}
+
+// closeAll detaches every namespace, for shutdown.
+func (ns *namespaces) closeAll() {
+	ns.mu.Lock()
+	names := slices.Collect(maps.Keys(ns.attached))
+	ns.mu.Unlock()
+
+	for _, name := range names {
+		if err := ns.detach(name); err != nil {
+			log.Printf("namespace %s: %v", name, err)
+		}
+	}
+}
+
+var (
+	errNotAttached = errors.New("namespace not attached")
+	errAttached    = errors.New("already attached")
+	errNotDataDir  = errors.New("not a data directory")
+	// errBadTransformer is an attachment naming an unregistered transformer.
+	errBadTransformer = errors.New("bad value transformer")
+	errBadDurability  = errors.New("bad sync policy")
+	errCloneTarget    = errors.New("clone target exists")
+)
+
+// engines returns the attached namespaces' engines.
+func (ns *namespaces) engines() []*storage.Engine {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	engines := make([]*storage.Engine, 0, len(ns.attached))
+	for _, a := range ns.attached {
+		engines = append(engines, a.engine)
+	}
+	return engines
+}
+
+// ServeHTTP routes /ns/{name}/... to the attached namespace.
+func (ns *namespaces) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/ns/"), "/")
+
+	ns.mu.Lock()
+	a, ok := ns.attached[name]
+	if ok {
+		a.mu.RLock()
+	}
+	ns.mu.Unlock()
+	if !ok {
+		http.Error(w, errNotAttached.Error(), http.StatusNotFound)
+		return
+	}
+	defer a.mu.RUnlock()
+
+	a.handler.ServeHTTP(w, r)
+}
+
+// listHandler lists the attached namespaces.
+func (ns *namespaces) listHandler(w http.ResponseWriter, _ *http.Request) {
+	type entry struct {
+		Name        string `json:"name"`
+		Dir         string `json:"dir"`
+		ReadOnly    bool   `json:"read_only"`
+		Transformer string `json:"value_transformer,omitempty"`
+		Durability  string `json:"durability,omitempty"`
+	}
+
+	ns.mu.Lock()
+	list := make([]entry, 0, len(ns.attached))
+	for name, a := range ns.attached {
+		list = append(list, entry{Name: name, Dir: a.dir, ReadOnly: a.readOnly, Transformer: a.transformer, Durability: a.durability})
+	}
+	ns.mu.Unlock()
+
+	slices.SortFunc(list, func(a, b entry) int { return strings.Compare(a.Name, b.Name) })
+	writeJSON(w, map[string]any{"namespaces": list})
+}
+
+// adminHandler attaches a namespace on PUT /admin/namespaces/{name}?dir=...
+// [&read_only=true][&value_transformer=name][&durability=policy], clones one into a new
+// directory and attaches that on POST /admin/namespaces/{name}?dir=...
+// [&from=namespace], and detaches it on DELETE.
+func (ns *namespaces) adminHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/admin/namespaces/")
+	if !validNamespace(name) {
+		http.Error(w, "namespace names are letters, digits, - and _", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		dir := r.URL.Query().Get("dir")
+		if dir == "" {
+			http.Error(w, "dir required", http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+		err := ns.attach(name, dir, query.Get("read_only") == "true", query.Get("value_transformer"), query.Get("durability"))
+		switch {
+		case errors.Is(err, errAttached):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, errNotDataDir), errors.Is(err, errBadTransformer), errors.Is(err, errBadDurability):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodPost:
+		query := r.URL.Query()
+		dir := query.Get("dir")
+		if dir == "" {
+			http.Error(w, "dir required", http.StatusBadRequest)
+			return
+		}
+		err := ns.clone(name, query.Get("from"), dir)
+		switch {
+		case errors.Is(err, errAttached), errors.Is(err, errCloneTarget):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, errNotAttached):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodDelete:
+		err := ns.detach(name)
+		if errors.Is(err, errNotAttached) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func validNamespace(name string) bool {
+	return name != "" && !strings.ContainsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	})
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// followPrimary keeps a standby caught up with the primary writing its data
+// directory, until the standby is promoted.
+func followPrimary(engine *storage.Engine, interval time.Duration) {
+	for range time.Tick(interval) {
+		err := engine.TryCatchUp()
+		if errors.Is(err, storage.ErrNotSecondary) {
+			return
+		}
+		if err != nil {
+			log.Printf("standby catch-up: %v", err)
+		}
+	}
+}
+
+// promoteHandler makes a standby the primary on POST. The old primary is
+// fenced and fails its next flush or MANIFEST update with ErrFenced.
+func promoteHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := engine.Promote()
+		if errors.Is(err, storage.ErrNotSecondary) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		v := engine.Version()
+		log.Printf("promoted to primary at epoch %d", v.Manifest.Epoch)
+		writeJSON(w, map[string]any{"epoch": v.Manifest.Epoch, "last_seq": v.LastSeq})
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+const quotaWebhookTimeout = 5 * time.Second
+
+// quotaWebhook posts soft quota warnings to url in the background so the
+// write that crossed the threshold is not held up.
+func quotaWebhook(url string) func(storage.QuotaUsage) {
+	client := &http.Client{Timeout: quotaWebhookTimeout}
+
+	return func(usage storage.QuotaUsage) {
+		body, _ := json.Marshal(map[string]any{
+			"event":       "quota_warning",
+			"used_bytes":  usage.UsedBytes,
+			"limit_bytes": usage.LimitBytes,
+		})
+
+		go func() {
+			resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				log.Printf("quota webhook: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+}
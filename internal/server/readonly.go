@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// readOnlyStatus is the body of every /admin/read-only response.
+type readOnlyStatus struct {
+	ReadOnly bool `json:"read_only"`
+	storage.ReadOnlyMode
+}
+
+// readOnlyHandler reports on GET /admin/read-only whether writes are
+// rejected for maintenance, turns that on for the server's engine and
+// every attached namespace on PUT with {"reason": "..."}, and off again on
+// DELETE. Namespaces attached meanwhile start out the same way. Reads are
+// served throughout; writes get 503 with the reason.
+func readOnlyHandler(engine *storage.Engine, ns *namespaces) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body struct {
+				Reason string `json:"reason"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			reason := strings.TrimSpace(body.Reason)
+			if reason == "" {
+				http.Error(w, "reason required", http.StatusBadRequest)
+				return
+			}
+			for _, e := range append(ns.engines(), engine) {
+				e.SetReadOnly(reason)
+			}
+			log.Printf("read-only for maintenance: %s", reason)
+		case http.MethodDelete:
+			for _, e := range append(ns.engines(), engine) {
+				e.ClearReadOnly()
+			}
+			log.Println("read-only mode cleared; accepting writes")
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		mode, ok := engine.ReadOnly()
+		writeJSON(w, readOnlyStatus{ReadOnly: ok, ReadOnlyMode: mode})
+	}
+}
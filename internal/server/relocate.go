@@ -0,0 +1,40 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// relocateHandler moves the engine's data directory on POST
+// /admin/relocate?dir=path, answering once the engine has switched over.
+// LOGBASE_DATA_DIR must be updated before the next restart.
+func relocateHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		dir := r.URL.Query().Get("dir")
+		if dir == "" {
+			http.Error(w, "dir is required", http.StatusBadRequest)
+			return
+		}
+
+		old := engine.DataDir()
+		err := engine.Relocate(dir)
+		if errors.Is(err, storage.ErrRelocateTarget) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		log.Printf("relocated data directory from %s to %s; set LOGBASE_DATA_DIR before restarting", old, dir)
+		writeJSON(w, map[string]any{"data_dir": engine.DataDir(), "previous": old})
+	}
+}
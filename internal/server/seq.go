@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Write responses carry the sequence number the write was applied at, and
+// GET responses the one of the write that stored the value, in seqHeader.
+// A PUT or DELETE with ifSeqHeader applies only if the key's value still
+// comes from that write, or with 0 only if the key does not exist;
+// otherwise it fails with 412 Precondition Failed.
+const (
+	seqHeader   = "X-Logbase-Seq"
+	ifSeqHeader = "If-Seq-Match"
+)
+
+// writeSeq sets seqHeader, unless seq is 0: a value written before
+// sequence numbers were stored.
+func writeSeq(h http.Header, seq uint64) {
+	if seq != 0 {
+		h.Set(seqHeader, strconv.FormatUint(seq, 10))
+	}
+}
+
+// readIfSeq parses ifSeqHeader, returning nil if it is absent.
+func readIfSeq(h http.Header) (*uint64, error) {
+	value := h.Get(ifSeqHeader)
+	if value == "" {
+		return nil, nil
+	}
+	seq, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &seq, nil
+}
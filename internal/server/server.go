@@ -0,0 +1,440 @@
+// Package server is the Logbase HTTP server, run by cmd/server and by
+// "logbase serve".
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/admission"
+	"github.com/manjeet13/logbase/internal/config"
+	"github.com/manjeet13/logbase/internal/logging"
+	"github.com/manjeet13/logbase/internal/metrics"
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// Run serves cfg's data directory over HTTP until the process exits, or,
+// with LOGBASE_ENGINES set, each listed engine on its own port.
+func Run(cfg *config.Config) {
+	started := time.Now()
+	logging.KeepRecent(incidentLogLines)
+
+	level, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetDefaultLevel(level)
+
+	resolveConfig(cfg)
+	unknown, invalid := config.EnvProblems()
+	for _, name := range unknown {
+		log.Printf("config: ignoring unknown %s", name)
+	}
+	for _, name := range invalid {
+		log.Printf("config: ignoring unparsable %s; using the default", name)
+	}
+	if cfg.TempDir != "" {
+		useTempDir(cfg.TempDir)
+	}
+
+	engines, err := cfg.HostedEngines()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Engines are opened one at a time, since opening one applies its
+	// settings to the storage package.
+	instances := make([]*instance, len(engines))
+	for i, e := range engines {
+		instances[i] = newInstance(e.Name, e.Config, started)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(instances))
+	for i, inst := range instances {
+		wg.Go(func() { errs[i] = inst.serve() })
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// instance is one engine and the HTTP server in front of it.
+type instance struct {
+	name     string
+	cfg      *config.Config
+	engine   *storage.Engine
+	server   *http.Server
+	stopping atomic.Bool
+	// namespaces are the data directories attached at runtime.
+	namespaces *namespaces
+}
+
+// newInstance opens cfg's engine and builds its server. name is empty
+// unless the process hosts several engines.
+func newInstance(name string, cfg *config.Config, started time.Time) *instance {
+	if cfg.ZeroConfig {
+		checkVolume(cfg.DataDir)
+	}
+
+	inst := &instance{name: name, cfg: cfg}
+	engine, err := storage.NewEngineWithConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.ZeroConfig {
+		if cfg.AdminToken, err = zeroConfigToken(cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, inc := range engine.Inconsistencies() {
+		log.Printf("startup check: %s", inc)
+	}
+	if cfg.ReadOnly != "" {
+		engine.SetReadOnly(cfg.ReadOnly)
+		log.Printf("read-only for maintenance: %s", cfg.ReadOnly)
+	}
+
+	ctrl := newAdmissionController(cfg)
+	engine.SetCompactionYield(func() { ctrl.YieldToInteractive(compactionYieldLimit) })
+	if cfg.QuotaWebhookURL != "" {
+		engine.SetQuotaWarning(quotaWebhook(cfg.QuotaWebhookURL))
+	}
+	if cfg.StatsIntervalSec > 0 {
+		go refreshStats(engine, time.Duration(cfg.StatsIntervalSec)*time.Second)
+	}
+	if cfg.AccessTracking && cfg.AccessSaveIntervalSec > 0 {
+		go saveAccess(engine, time.Duration(cfg.AccessSaveIntervalSec)*time.Second)
+	}
+	if cfg.ColdDir != "" && cfg.TieringIntervalSec > 0 {
+		go tierCold(engine, time.Duration(cfg.TieringIntervalSec)*time.Second)
+	}
+	if cfg.ExpiryIntervalSec > 0 {
+		go purgeExpired(engine, time.Duration(cfg.ExpiryIntervalSec)*time.Second)
+	}
+	if cfg.Standby {
+		log.Println("Standby: following " + cfg.DataDir + " until promoted")
+		go followPrimary(engine, time.Duration(cfg.StandbyCatchUpMs)*time.Millisecond)
+	}
+	if cfg.CheckpointIntervalSec > 0 {
+		go takeCheckpoints(engine, time.Duration(cfg.CheckpointIntervalSec)*time.Second, cfg.CheckpointRetain)
+	}
+
+	slos := newSLOTracker(cfg.SLOAvailability, cfg.SLOLatency, time.Duration(cfg.SLOLatencyMs)*time.Millisecond)
+	if slos != nil {
+		go evaluateSLOs(slos, sloEvaluateInterval)
+	}
+
+	tracer, err := newTracer(cfg.TraceSampleRate, cfg.TraceSampleErrors, time.Duration(cfg.TraceSlowMs)*time.Millisecond, cfg.TraceSampleRoutes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transfers, err := newTransferCompressor(cfg.TransferCompression, cfg.TransferCompressionLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	inst.engine = engine
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/readyz", readyzHandler(engine, &inst.stopping))
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/debug/locks", admit(ctrl, classOf(admission.Admin), locksHandler))
+	dataRoutes(mux, engine, ctrl)
+	inst.namespaces = newNamespaces(engine, cfg.ValueTransformer, ctrl)
+	mux.Handle("/ns/", inst.namespaces)
+	mux.HandleFunc("/admin/sample", admit(ctrl, classOf(admission.Admin), available(engine, sampleHandler(engine))))
+	mux.HandleFunc("/admin/copy-range", admit(ctrl, classOf(admission.Admin), available(engine, copyRangeHandler(engine))))
+	mux.HandleFunc("/admin/namespaces", admit(ctrl, classOf(admission.Admin), inst.namespaces.listHandler))
+	mux.HandleFunc("/admin/namespaces/", admit(ctrl, classOf(admission.Admin), inst.namespaces.adminHandler))
+	mux.HandleFunc("/admin/config", admit(ctrl, classOf(admission.Admin), configHandler(cfg)))
+	mux.HandleFunc("/admin/manifest", admit(ctrl, classOf(admission.Admin), manifestHandler(engine)))
+	mux.HandleFunc("/admin/compaction/plan", admit(ctrl, classOf(admission.Admin), compactionPlanHandler(engine)))
+	mux.HandleFunc("/admin/experiments", admit(ctrl, classOf(admission.Admin), experimentsHandler(engine)))
+	mux.HandleFunc("/admin/log/", admit(ctrl, classOf(admission.Admin), logHandler))
+	mux.HandleFunc("/admin/bloom", admit(ctrl, classOf(admission.Admin), bloomHandler(engine)))
+	mux.HandleFunc("/admin/block-cache", admit(ctrl, classOf(admission.Admin), blockCacheHandler))
+	mux.HandleFunc("/admin/io", admit(ctrl, classOf(admission.Admin), ioHandler))
+	mux.HandleFunc("/admin/read-only", admit(ctrl, classOf(admission.Admin), readOnlyHandler(engine, inst.namespaces)))
+	mux.HandleFunc("/admin/hot", admit(ctrl, classOf(admission.Admin), hotHandler(engine)))
+	mux.HandleFunc("/admin/hot/", admit(ctrl, classOf(admission.Admin), available(engine, hotPatternHandler(engine))))
+	incidents := &incidentReporter{engine: engine, cfg: cfg, tracer: tracer, slos: slos, started: started}
+	mux.HandleFunc("/admin/incident", admit(ctrl, classOf(admission.Admin), incidentHandler(incidents)))
+	mux.HandleFunc("/admin/traces", admit(ctrl, classOf(admission.Admin), tracesHandler(tracer)))
+	mux.HandleFunc("/admin/slo", admit(ctrl, classOf(admission.Admin), sloHandler(slos)))
+	mux.HandleFunc("/admin/stats", admit(ctrl, classOf(admission.Admin), available(engine, statsHandler(engine))))
+	mux.HandleFunc("/admin/access/untouched", admit(ctrl, classOf(admission.Admin), available(engine, untouchedHandler(engine))))
+	mux.HandleFunc("/admin/checkpoints", admit(ctrl, classOf(admission.Admin), available(engine, checkpointsHandler(engine, cfg.CheckpointRetain))))
+	mux.HandleFunc("/admin/relocate", admit(ctrl, classOf(admission.Admin), available(engine, relocateHandler(engine))))
+	mux.HandleFunc("/admin/promote", admit(ctrl, classOf(admission.Admin), available(engine, promoteHandler(engine))))
+	mux.HandleFunc("/admin/prestop", admit(ctrl, classOf(admission.Admin), prestopHandler(engine, &inst.stopping)))
+	mux.HandleFunc("/admin/checkpoints/", admit(ctrl, classOf(admission.Admin), available(engine, checkpointFilesHandler(engine, transfers))))
+	mux.HandleFunc("/admin/transfers", admit(ctrl, classOf(admission.Admin), transfersHandler(transfers)))
+
+	if cfg.FaultInjection {
+		log.Println("Fault injection enabled")
+		mux.HandleFunc("/admin/faults/", admit(ctrl, classOf(admission.Admin), faultsHandler(engine.EnableFaultInjection())))
+	}
+
+	reqMetrics := newRequestMetrics(newNamespaceGuard(cfg.MetricsNamespaces, cfg.MetricsMaxNamespaces), slos, tracer)
+	inst.server = &http.Server{
+		Addr:    ":" + cfg.HTTPPort,
+		Handler: logRequests(requireAdminToken(cfg.AdminToken, reqMetrics.instrument(mux))),
+	}
+	return inst
+}
+
+// serve runs the server until the process is signalled, then closes the
+// engine.
+func (inst *instance) serve() error {
+	label := "Logbase"
+	if inst.name != "" {
+		label = "Logbase engine " + inst.name
+	}
+
+	stopped := stopOnSignal(inst.server, &inst.stopping, time.Duration(inst.cfg.ShutdownDelayMs)*time.Millisecond)
+	log.Println(label + " listening on :" + inst.cfg.HTTPPort)
+	if err := inst.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-stopped
+
+	inst.namespaces.closeAll()
+	if err := inst.engine.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", inst.engine.DataDir(), err)
+	}
+	log.Println(label + " stopped")
+	return nil
+}
+
+// dataRoutes registers the key-value API for engine on mux.
+func dataRoutes(mux *http.ServeMux, engine *storage.Engine, ctrl *admission.Controller) {
+	mux.HandleFunc("/kv/", admit(ctrl, kvClass, available(engine, kvHandler(engine))))
+	mux.HandleFunc("/range", admit(ctrl, classOf(admission.Scan), available(engine, rangeHandler(engine))))
+	mux.HandleFunc("/batch", admit(ctrl, classOf(admission.Write), available(engine, batchHandler(engine))))
+	mux.HandleFunc("/import", admit(ctrl, classOf(admission.Write), available(engine, importHandler(engine))))
+	mux.HandleFunc("/batch-delete", admit(ctrl, classOf(admission.Write), available(engine, batchDeleteHandler(engine))))
+	mux.HandleFunc("/snapshot-read", admit(ctrl, classOf(admission.Scan), available(engine, snapshotReadHandler(engine))))
+	mux.HandleFunc("/mget", admit(ctrl, classOf(admission.PointRead), available(engine, mgetHandler(engine))))
+	mux.HandleFunc("/export", admit(ctrl, classOf(admission.Scan), available(engine, exportHandler(engine))))
+}
+
+func healthHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func kvHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path[len("/kv/"):]
+		if key == "" {
+			http.Error(w, "missing key", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			if header := r.Header.Get("Range"); header != "" {
+				if br, err := parseByteRange(header); err == nil {
+					serveRange(w, r, engine, []byte(key), br)
+					return
+				}
+			}
+
+			val, meta, seq, ok := engine.GetVersioned([]byte(key))
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			writeMetadata(w.Header(), meta)
+			writeSeq(w.Header(), seq)
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Write(val)
+
+		case http.MethodPut, http.MethodDelete:
+			ifSeq, err := readIfSeq(r.Header)
+			if err != nil {
+				http.Error(w, "invalid "+ifSeqHeader+" header", http.StatusBadRequest)
+				return
+			}
+
+			var seq uint64
+			if r.Method == http.MethodPut {
+				var value []byte
+				if value, err = io.ReadAll(r.Body); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				var ttl time.Duration
+				if ttl, err = readTTL(r); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				opts := storage.WriteOptions{Meta: readMetadata(r.Header), IfSeq: ifSeq, TTL: ttl}
+				seq, err = engine.PutWithOptions([]byte(key), value, opts)
+				if errors.Is(err, storage.ErrMetadataTooLarge) {
+					http.Error(w, err.Error(), http.StatusRequestHeaderFieldsTooLarge)
+					return
+				}
+			} else {
+				seq, err = engine.DeleteWithOptions([]byte(key), storage.WriteOptions{IfSeq: ifSeq})
+			}
+			if err != nil {
+				writeStorageError(w, err)
+				return
+			}
+			writeSeq(w.Header(), seq)
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Sending "X-Logbase-Debug: plan" with a range request returns the read plan
+// in X-Logbase-Plan, for troubleshooting slow scans. A range cut short by
+// its limit names the key to continue after in the X-Logbase-Next trailer.
+const (
+	debugHeader = "X-Logbase-Debug"
+	planHeader  = "X-Logbase-Plan"
+	nextTrailer = "X-Logbase-Next"
+)
+
+func rangeHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := r.URL.Query().Get("start")
+		end := r.URL.Query().Get("end")
+		prefix := r.URL.Query().Get("prefix")
+
+		if prefix == "" && (start == "" || end == "") {
+			http.Error(w, "start and end, or prefix, required", http.StatusBadRequest)
+			return
+		}
+
+		// With a prefix, start and end are optional extra bounds.
+		bounds := storage.IterOptions{Prefix: []byte(prefix)}
+		if start != "" {
+			bounds.LowerBound = []byte(start)
+		}
+		if end != "" {
+			bounds.UpperBound = append([]byte(end), 0)
+		}
+
+		// after continues a range cut short by limit
+		if after := r.URL.Query().Get("after"); after != "" {
+			if next := append([]byte(after), 0); bytes.Compare(next, bounds.LowerBound) > 0 {
+				bounds.LowerBound = next
+			}
+		}
+		limit := 0
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		opts := storage.RangeOptions{KeysOnly: r.URL.Query().Get("keys_only") == "true"}
+		it, err := engine.NewBoundsIterator(bounds, opts)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		defer it.Close()
+		if r.Header.Get(debugHeader) == "plan" {
+			w.Header().Set(planHeader, it.Plan().String())
+		}
+		w.Header().Set("Trailer", nextTrailer)
+		writeRange(w, it, limit, opts.KeysOnly)
+	}
+}
+
+// writeRange streams the iterator's keys in order as "key=value" (or
+// "key") lines, stopping after limit keys if limit is positive. If keys
+// remain, the last key written goes in the nextTrailer trailer. The status
+// has been sent by the time a read fails, so the response is aborted
+// instead, leaving the client a truncated chunked body.
+func writeRange(w http.ResponseWriter, it *storage.Iterator, limit int, keysOnly bool) {
+	var last []byte
+	for n := 0; it.Next(); n++ {
+		if limit > 0 && n == limit {
+			w.Header().Set(nextTrailer, url.QueryEscape(string(last)))
+			return
+		}
+		w.Write(it.Key())
+		if !keysOnly {
+			w.Write([]byte("="))
+			w.Write(it.Value())
+		}
+		w.Write([]byte("\n"))
+		last = append(last[:0], it.Key()...)
+	}
+	if err := it.Err(); err != nil {
+		if last == nil {
+			writeStorageError(w, err)
+			return
+		}
+		log.Printf("range read failed part way: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// batchHandler writes a JSON object of keys to values in one atomic batch,
+// or a JSON array of put and delete operations (see batchOp). See
+// batchOptions for the query parameters it accepts. With ?split=true an
+// object is streamed in sub-batches instead, as for /import.
+func batchHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("split") == "true" {
+			if opts := batchOptions(r); opts.Guard != nil || opts.ReturnPrevious {
+				http.Error(w, "split cannot be combined with guard_key or return_previous", http.StatusBadRequest)
+				return
+			}
+			splitBatch(engine, w, r)
+			return
+		}
+
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body[0] == '[' {
+			writeOps(engine, w, r, body)
+			return
+		}
+
+		var data map[string]string
+		if err := json.Unmarshal(body, &data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries := make(map[string][]byte)
+		names := make(map[string]string, len(data))
+		for k, v := range data {
+			entries[k] = []byte(v)
+			names[k] = k
+		}
+
+		opts := batchOptions(r)
+		result, err := engine.BatchPutWithOptions(entries, opts)
+		writeBatchResult(w, opts, names, result, err)
+	}
+}
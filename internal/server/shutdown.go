@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// shutdownTimeout bounds how long in-flight requests get to finish once the
+// listener is closed.
+const shutdownTimeout = 30 * time.Second
+
+// prestopHandler prepares the server to be stopped on POST, for a
+// Kubernetes PreStop hook: /readyz starts failing, so the pod is taken out
+// of its Service, and the engine flushes, syncs its WAL and pauses
+// compaction. Requests are still served until the process is signalled.
+func prestopHandler(engine *storage.Engine, stopping *atomic.Bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		stopping.Store(true)
+		if err := engine.PrepareStop(); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		v := engine.Version()
+		log.Printf("prestop: flushed through seq %d; compaction paused", v.LastSeq)
+		writeJSON(w, map[string]any{"last_seq": v.LastSeq})
+	}
+}
+
+// stopOnSignal shuts server down gracefully on SIGTERM or SIGINT. /readyz
+// fails for delay first, so load balancers stop sending traffic before the
+// listener closes; then in-flight requests get shutdownTimeout to finish.
+// done is closed once the server has stopped.
+func stopOnSignal(server *http.Server, stopping *atomic.Bool, delay time.Duration) (done <-chan struct{}) {
+	stopped := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		sig := <-sigs
+		stopping.Store(true)
+		log.Printf("%s: not ready; closing the listener in %s", sig, delay)
+		time.Sleep(delay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+		close(stopped)
+	}()
+	return stopped
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/logging"
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+var sloLog = logging.For("slo")
+
+var sloFiring = metrics.NewGaugeVec("logbase_slo_alert_firing",
+	"1 while an SLO's burn-rate alert is firing.", "slo", "severity")
+
+const (
+	// sloBucketCount per-minute buckets cover the longest alert window.
+	sloBucketCount = 360
+	// sloEvaluateInterval is how often alert states are refreshed.
+	sloEvaluateInterval = 15 * time.Second
+)
+
+// sloAlert is a multiwindow burn-rate alert: it fires while the error
+// budget burns faster than Burn times the sustainable rate over both the
+// long and the short window. The short window makes it stop soon after the
+// problem does.
+type sloAlert struct {
+	Severity string
+	Long     time.Duration
+	Short    time.Duration
+	Burn     float64
+}
+
+// sloAlerts are the usual page and ticket thresholds for a 30-day budget:
+// paging spends 2% of it in an hour, ticketing 5% in six hours.
+var sloAlerts = []sloAlert{
+	{Severity: "page", Long: time.Hour, Short: 5 * time.Minute, Burn: 14.4},
+	{Severity: "ticket", Long: 6 * time.Hour, Short: 30 * time.Minute, Burn: 6},
+}
+
+// slo is one objective: the fraction of requests that must be good.
+type slo struct {
+	name      string
+	objective float64 // percent
+	bad       func(status int, d time.Duration) bool
+
+	buckets [sloBucketCount]sloBucket
+	firing  map[string]bool
+}
+
+type sloBucket struct {
+	minute int64
+	total  uint64
+	bad    uint64
+}
+
+// sloTracker evaluates the configured SLOs over data-path requests.
+type sloTracker struct {
+	mu   sync.Mutex
+	slos []*slo
+}
+
+// newSLOTracker sets up the availability objective (non-5xx responses) and
+// the latency objective (responses within latency), in percent. Objectives
+// outside (0, 100) are skipped; it returns nil if neither is set.
+func newSLOTracker(availability, latencyObjective float64, latency time.Duration) *sloTracker {
+	t := &sloTracker{}
+	if availability > 0 && availability < 100 {
+		t.slos = append(t.slos, &slo{
+			name:      "availability",
+			objective: availability,
+			bad:       func(status int, _ time.Duration) bool { return status >= 500 },
+		})
+	}
+	if latencyObjective > 0 && latencyObjective < 100 && latency > 0 {
+		t.slos = append(t.slos, &slo{
+			name:      "latency",
+			objective: latencyObjective,
+			bad:       func(_ int, d time.Duration) bool { return d > latency },
+		})
+	}
+	if len(t.slos) == 0 {
+		return nil
+	}
+	for _, s := range t.slos {
+		s.firing = make(map[string]bool)
+	}
+	return t
+}
+
+// sloRoute reports whether requests to a route count toward the SLOs:
+// data-path requests do, admin and monitoring requests do not.
+func sloRoute(route string) bool {
+	switch route {
+	case "unmatched", "/health", "/readyz", "/metrics":
+		return false
+	}
+	return !strings.HasPrefix(route, "/admin/")
+}
+
+func (t *sloTracker) record(route string, status int, d time.Duration) {
+	if t == nil || !sloRoute(route) {
+		return
+	}
+	minute := time.Now().Unix() / 60
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.slos {
+		b := &s.buckets[minute%sloBucketCount]
+		if b.minute != minute {
+			*b = sloBucket{minute: minute}
+		}
+		b.total++
+		if s.bad(status, d) {
+			b.bad++
+		}
+	}
+}
+
+// sloWindow is an SLO's record over one window.
+type sloWindow struct {
+	Requests uint64  `json:"requests"`
+	Bad      uint64  `json:"bad"`
+	BurnRate float64 `json:"burn_rate"`
+}
+
+// window sums the buckets of the last d, including the current minute.
+func (s *slo) window(now int64, d time.Duration) sloWindow {
+	minutes := int64(d / time.Minute)
+	var w sloWindow
+	for _, b := range s.buckets {
+		if b.minute > now-minutes && b.minute <= now {
+			w.Requests += b.total
+			w.Bad += b.bad
+		}
+	}
+	if w.Requests > 0 {
+		budget := 1 - s.objective/100
+		w.BurnRate = float64(w.Bad) / float64(w.Requests) / budget
+	}
+	return w
+}
+
+// sloStatus is one SLO as reported by /admin/slo.
+type sloStatus struct {
+	Name      string               `json:"name"`
+	Objective float64              `json:"objective"`
+	Windows   map[string]sloWindow `json:"windows"`
+	Firing    map[string]bool      `json:"firing"`
+}
+
+// evaluate computes every window and updates the alert states, logging
+// alerts as they start and stop firing.
+func (t *sloTracker) evaluate() []sloStatus {
+	if t == nil {
+		return []sloStatus{}
+	}
+	now := time.Now().Unix() / 60
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	statuses := make([]sloStatus, 0, len(t.slos))
+	for _, s := range t.slos {
+		st := sloStatus{Name: s.name, Objective: s.objective, Windows: make(map[string]sloWindow), Firing: make(map[string]bool)}
+		for _, a := range sloAlerts {
+			long, short := s.window(now, a.Long), s.window(now, a.Short)
+			st.Windows[formatWindow(a.Long)] = long
+			st.Windows[formatWindow(a.Short)] = short
+
+			firing := long.BurnRate > a.Burn && short.BurnRate > a.Burn
+			if firing != s.firing[a.Severity] {
+				if firing {
+					sloLog.Warnf("%s SLO %s alert firing: burn rate %.1f over %s", s.name, a.Severity, long.BurnRate, formatWindow(a.Long))
+				} else {
+					sloLog.Infof("%s SLO %s alert resolved", s.name, a.Severity)
+				}
+				s.firing[a.Severity] = firing
+			}
+			st.Firing[a.Severity] = firing
+
+			value := int64(0)
+			if firing {
+				value = 1
+			}
+			sloFiring.With(s.name, a.Severity).Set(value)
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+func formatWindow(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return strings.TrimSuffix(d.String(), "0m0s")
+	}
+	return strings.TrimSuffix(d.String(), "0s")
+}
+
+// evaluateSLOs keeps the alert gauges current between /admin/slo calls.
+func evaluateSLOs(t *sloTracker, interval time.Duration) {
+	for range time.Tick(interval) {
+		t.evaluate()
+	}
+}
+
+// sloHandler reports each SLO's windows, burn rates and alert states.
+func sloHandler(t *sloTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, map[string]any{"slos": t.evaluate()})
+	}
+}
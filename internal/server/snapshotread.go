@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+const maxSnapshotRanges = 100
+
+type snapshotReadRange struct {
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	Prefix string `json:"prefix"`
+}
+
+type snapshotReadRequest struct {
+	Keys   []string            `json:"keys"`
+	Ranges []snapshotReadRange `json:"ranges"`
+}
+
+type snapshotReadResponse struct {
+	Seq    uint64           `json:"seq"`
+	Keys   map[string]any   `json:"keys"`
+	Ranges []map[string]any `json:"ranges"`
+}
+
+// snapshotReadHandler reads keys and ranges all at one snapshot, so the
+// results are consistent with each other. Missing keys map to null; each
+// range is returned in request order, as by /range (start and end are
+// inclusive; a prefix may replace or narrow them).
+func snapshotReadHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req snapshotReadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Keys) > maxMGetKeys || len(req.Ranges) > maxSnapshotRanges {
+			http.Error(w, "too many keys or ranges", http.StatusBadRequest)
+			return
+		}
+
+		bounds := make([]storage.IterOptions, len(req.Ranges))
+		for i, rg := range req.Ranges {
+			if rg.Prefix == "" && (rg.Start == "" || rg.End == "") {
+				http.Error(w, "each range needs start and end, or prefix", http.StatusBadRequest)
+				return
+			}
+			bounds[i].Prefix = []byte(rg.Prefix)
+			if rg.Start != "" {
+				bounds[i].LowerBound = []byte(rg.Start)
+			}
+			if rg.End != "" {
+				bounds[i].UpperBound = append([]byte(rg.End), 0)
+			}
+		}
+
+		snap := engine.NewSnapshot(storage.SnapshotOptions{})
+		defer snap.Release()
+
+		resp := snapshotReadResponse{
+			Seq:    snap.Seq(),
+			Keys:   make(map[string]any, len(req.Keys)),
+			Ranges: make([]map[string]any, 0, len(bounds)),
+		}
+		for _, key := range req.Keys {
+			resp.Keys[key] = nil
+			if val, ok := snap.Get([]byte(key)); ok {
+				resp.Keys[key] = rawValue(val)
+			}
+		}
+		for _, b := range bounds {
+			result, err := snap.ReadBounds(b, storage.RangeOptions{})
+			if err != nil {
+				writeStorageError(w, err)
+				return
+			}
+			values := make(map[string]any, len(result))
+			for k, v := range result {
+				values[k] = rawValue(v)
+			}
+			resp.Ranges = append(resp.Ranges, values)
+		}
+
+		writeJSON(w, resp)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// refreshStats recomputes the engine's statistics every interval.
+func refreshStats(engine *storage.Engine, interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := engine.RefreshStats(); err != nil {
+			log.Printf("stats refresh: %v", err)
+		}
+	}
+}
+
+// statsHandler serves the latest statistics on GET and recomputes them
+// on POST.
+func statsHandler(engine *storage.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			stats := engine.Stats()
+			if stats == nil {
+				http.Error(w, "statistics not computed yet", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, stats)
+
+		case http.MethodPost:
+			stats, err := engine.RefreshStats()
+			if err != nil {
+				writeStorageError(w, err)
+				return
+			}
+			writeJSON(w, stats)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
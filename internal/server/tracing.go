@@ -0,0 +1,178 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/logging"
+)
+
+var traceLog = logging.For("trace")
+
+// maxRecentSpans is how many sampled requests /admin/traces keeps.
+const maxRecentSpans = 256
+
+// traceparentHeader carries W3C trace context in and out.
+const traceparentHeader = "traceparent"
+
+// span is one sampled request.
+type span struct {
+	TraceID    string    `json:"trace_id"`
+	Start      time.Time `json:"start"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Route      string    `json:"route"`
+	Status     int       `json:"status"`
+	DurationMs float64   `json:"duration_ms"`
+	// Reason is why the request was sampled: "parent", "rate", "error"
+	// or "slow".
+	Reason string `json:"reason"`
+}
+
+// tracer decides which requests are traced. A request is sampled if its
+// caller sampled it, at the route's sample rate, or, when errors is set,
+// whenever it fails with a 5xx, or when it takes longer than slow. Sampled requests are logged on the trace
+// subsystem, kept for /admin/traces and attached to the latency histogram
+// as exemplars.
+type tracer struct {
+	rate   float64
+	routes map[string]float64
+	errors bool
+	slow   time.Duration
+
+	mu     sync.Mutex
+	recent []span
+	next   int
+}
+
+// newTracer parses per-route overrides given as "route=rate,...", where
+// route is a mux pattern such as /kv/ or /range.
+func newTracer(rate float64, errors bool, slow time.Duration, routes string) (*tracer, error) {
+	t := &tracer{rate: rate, errors: errors, slow: slow, routes: make(map[string]float64)}
+	for _, spec := range strings.Split(routes, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		route, value, ok := strings.Cut(spec, "=")
+		r, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil || r < 0 || r > 1 {
+			return nil, fmt.Errorf("trace sample route %q: want route=rate with rate in [0, 1]", spec)
+		}
+		t.routes[route] = r
+	}
+	return t, nil
+}
+
+// start reads or creates the request's trace context and makes the head
+// sampling decision. It returns the trace ID and why the request is
+// sampled, or "" if it is not (yet).
+func (t *tracer) start(w http.ResponseWriter, r *http.Request, route string) (string, string) {
+	traceID, parentSampled := parseTraceparent(r.Header.Get(traceparentHeader))
+	if traceID == "" {
+		traceID = randomHex(16)
+	}
+
+	reason := ""
+	rate, ok := t.routes[route]
+	if !ok {
+		rate = t.rate
+	}
+	switch {
+	case parentSampled:
+		reason = "parent"
+	case rate > 0 && mathrand.Float64() < rate:
+		reason = "rate"
+	}
+
+	flags := "00"
+	if reason != "" {
+		flags = "01"
+	}
+	w.Header().Set(traceparentHeader, "00-"+traceID+"-"+randomHex(8)+"-"+flags)
+	return traceID, reason
+}
+
+// finish completes a request's sampling decision and records it if it is
+// sampled, reporting whether it was.
+func (t *tracer) finish(s span) bool {
+	switch {
+	case s.Reason != "":
+	case t.errors && s.Status >= 500:
+		s.Reason = "error"
+	case t.slow > 0 && s.DurationMs >= float64(t.slow.Microseconds())/1000:
+		s.Reason = "slow"
+	}
+	if s.Reason == "" {
+		return false
+	}
+
+	traceLog.Infof("%s %s %s %d %.1fms (%s)", s.TraceID, s.Method, s.Path, s.Status, s.DurationMs, s.Reason)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.recent) < maxRecentSpans {
+		t.recent = append(t.recent, s)
+	} else {
+		t.recent[t.next] = s
+	}
+	t.next = (t.next + 1) % maxRecentSpans
+	return true
+}
+
+// spans returns the kept spans newest first, optionally only one trace's.
+func (t *tracer) spans(traceID string) []span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := []span{}
+	n := len(t.recent)
+	for i := range n {
+		s := t.recent[((t.next-1-i)%n+n)%n]
+		if traceID == "" || s.TraceID == traceID {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// parseTraceparent returns the trace ID and sampled flag of a W3C
+// traceparent header, or "" if it is missing or malformed.
+func parseTraceparent(header string) (string, bool) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil || parts[1] == strings.Repeat("0", 32) {
+		return "", false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return "", false
+	}
+	return parts[1], flags&1 == 1
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// tracesHandler lists recently sampled requests, newest first;
+// ?trace_id= selects one trace, as linked from a histogram exemplar.
+func tracesHandler(t *tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, map[string]any{"spans": t.spans(r.URL.Query().Get("trace_id"))})
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+// TransferEncoding is an HTTP content coding for checkpoint chunks sent to
+// other nodes. Encode compresses at a codec-specific level and fails for
+// a level the codec does not have.
+type TransferEncoding struct {
+	Encode func(w io.Writer, level int) (io.WriteCloser, error)
+	Decode func(r io.Reader) (io.ReadCloser, error)
+}
+
+// transferEncodings is the compiled-in content coding registry.
+var transferEncodings = struct {
+	sync.RWMutex
+	byName map[string]TransferEncoding
+}{
+	byName: map[string]TransferEncoding{
+		"gzip": {
+			Encode: func(w io.Writer, level int) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, level) },
+			Decode: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		},
+		"deflate": {
+			Encode: func(w io.Writer, level int) (io.WriteCloser, error) { return flate.NewWriter(w, level) },
+			Decode: func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+		},
+	},
+}
+
+// RegisterTransferEncoding makes enc available as the content coding name,
+// to LOGBASE_TRANSFER_COMPRESSION and to logbase fetch. It is meant to be
+// called from an init function compiled into both ends, for example with
+// "zstd", which the standard library does not have; a name registered
+// twice panics.
+func RegisterTransferEncoding(name string, enc TransferEncoding) {
+	transferEncodings.Lock()
+	defer transferEncodings.Unlock()
+
+	if _, ok := transferEncodings.byName[name]; ok || name == "identity" {
+		panic("server: transfer encoding registered twice: " + name)
+	}
+	transferEncodings.byName[name] = enc
+}
+
+func lookupTransferEncoding(name string) (TransferEncoding, bool) {
+	transferEncodings.RLock()
+	defer transferEncodings.RUnlock()
+
+	enc, ok := transferEncodings.byName[name]
+	return enc, ok
+}
+
+// TransferEncodings lists the registered content codings, for a client's
+// Accept-Encoding.
+func TransferEncodings() []string {
+	transferEncodings.RLock()
+	defer transferEncodings.RUnlock()
+
+	names := make([]string, 0, len(transferEncodings.byName))
+	for name := range transferEncodings.byName {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// DecodeTransfer wraps a response body sent with the content coding
+// encoding; an empty encoding or identity leaves it as it is.
+func DecodeTransfer(encoding string, body io.Reader) (io.ReadCloser, error) {
+	if encoding == "" || encoding == "identity" {
+		return io.NopCloser(body), nil
+	}
+	enc, ok := lookupTransferEncoding(encoding)
+	if !ok {
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+	return enc.Decode(body)
+}
+
+var (
+	transferBytes     = metrics.NewCounterVec("logbase_transfer_bytes_total", "Checkpoint bytes served to other nodes, before compression, by encoding.", "encoding")
+	transferWireBytes = metrics.NewCounterVec("logbase_transfer_wire_bytes_total", "Checkpoint bytes served to other nodes as sent, by encoding.", "encoding")
+	transferChunks    = metrics.NewCounterVec("logbase_transfer_chunks_total", "Checkpoint chunks served to other nodes, by encoding.", "encoding")
+)
+
+// transferCompressor picks the content coding for each checkpoint chunk:
+// the first of the configured ones the client accepts. A chunk that does
+// not get smaller is sent as it is.
+type transferCompressor struct {
+	prefer []string
+	level  int
+
+	mu    sync.Mutex
+	stats map[string]*transferStats
+}
+
+// transferStats is what was served with one content coding. Ratio is
+// bytes over wire bytes.
+type transferStats struct {
+	Chunks    uint64  `json:"chunks"`
+	Bytes     uint64  `json:"bytes"`
+	WireBytes uint64  `json:"wire_bytes"`
+	Ratio     float64 `json:"ratio"`
+}
+
+// newTransferCompressor parses LOGBASE_TRANSFER_COMPRESSION, a
+// comma-separated list of content codings in order of preference, and
+// checks that each has the level.
+func newTransferCompressor(names string, level int) (*transferCompressor, error) {
+	c := &transferCompressor{level: level, stats: make(map[string]*transferStats)}
+	for name := range strings.SplitSeq(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "none" || name == "identity" {
+			continue
+		}
+		enc, ok := lookupTransferEncoding(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown transfer compression %q (registered: %v)", name, TransferEncodings())
+		}
+		if _, err := enc.Encode(io.Discard, level); err != nil {
+			return nil, fmt.Errorf("transfer compression %s: level %d: %w", name, level, err)
+		}
+		c.prefer = append(c.prefer, name)
+	}
+	return c, nil
+}
+
+// write sends chunk to w in the best content coding r accepts.
+func (c *transferCompressor) write(w http.ResponseWriter, r *http.Request, chunk []byte) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	encoding, body := "identity", chunk
+	if name := c.negotiate(r.Header.Get("Accept-Encoding")); name != "" {
+		if compressed, err := c.compress(name, chunk); err == nil && len(compressed) < len(chunk) {
+			encoding, body = name, compressed
+			w.Header().Set("Content-Encoding", name)
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+	c.record(encoding, len(chunk), len(body))
+}
+
+// negotiate returns the first preferred content coding accept allows, or
+// "" for none.
+func (c *transferCompressor) negotiate(accept string) string {
+	accepted := make(map[string]bool)
+	for part := range strings.SplitSeq(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	for _, name := range c.prefer {
+		if ok, listed := accepted[name]; ok || !listed && accepted["*"] {
+			return name
+		}
+	}
+	return ""
+}
+
+func (c *transferCompressor) compress(name string, chunk []byte) ([]byte, error) {
+	enc, _ := lookupTransferEncoding(name)
+	var buf bytes.Buffer
+	zw, err := enc.Encode(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(chunk); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *transferCompressor) record(encoding string, raw, wire int) {
+	transferChunks.With(encoding).Inc()
+	transferBytes.With(encoding).Add(uint64(raw))
+	transferWireBytes.With(encoding).Add(uint64(wire))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats[encoding]
+	if s == nil {
+		s = &transferStats{}
+		c.stats[encoding] = s
+	}
+	s.Chunks++
+	s.Bytes += uint64(raw)
+	s.WireBytes += uint64(wire)
+}
+
+// transfersHandler reports, on GET /admin/transfers, the checkpoint bytes
+// served since startup by content coding and overall, with how much
+// compression saved.
+func transfersHandler(c *transferCompressor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		c.mu.Lock()
+		var total transferStats
+		byEncoding := make(map[string]transferStats, len(c.stats))
+		for name, s := range c.stats {
+			byEncoding[name] = s.withRatio()
+			total.Chunks += s.Chunks
+			total.Bytes += s.Bytes
+			total.WireBytes += s.WireBytes
+		}
+		c.mu.Unlock()
+
+		writeJSON(w, map[string]any{
+			"compression": c.prefer,
+			"level":       c.level,
+			"total":       total.withRatio(),
+			"encodings":   byEncoding,
+		})
+	}
+}
+
+func (s transferStats) withRatio() transferStats {
+	if s.WireBytes > 0 {
+		s.Ratio = float64(s.Bytes) / float64(s.WireBytes)
+	}
+	return s
+}
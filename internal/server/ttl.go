@@ -0,0 +1,36 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/storage"
+)
+
+// purgeExpired deletes keys whose TTL has run out every interval.
+func purgeExpired(engine *storage.Engine, interval time.Duration) {
+	for range time.Tick(interval) {
+		n, err := engine.PurgeExpired()
+		if err != nil {
+			log.Printf("purging expired keys: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d expired keys", n)
+		}
+	}
+}
+
+// readTTL parses a PUT's ttl query parameter, a Go duration such as "90s"
+// or "24h". It returns 0 if the parameter is absent.
+func readTTL(r *http.Request) (time.Duration, error) {
+	s := r.URL.Query().Get("ttl")
+	if s == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl <= 0 {
+		return 0, errors.New("ttl must be a positive duration, such as 90s")
+	}
+	return ttl, nil
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/manjeet13/logbase/internal/config"
+)
+
+// adminTokenFile holds the admin token generated in zero-config mode, so
+// the same token works after a restart.
+const adminTokenFile = "admin-token"
+
+// checkVolume reports, in zero-config mode, whether the data directory is
+// new and whether it looks like a mounted volume. Data in a directory that
+// is not a mount point is lost with the container.
+func checkVolume(dataDir string) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+	empty := true
+	for _, e := range entries {
+		if e.Name() != "lost+found" {
+			empty = false
+			break
+		}
+	}
+
+	switch mounted, known := isMountPoint(dataDir); {
+	case !known:
+		// Not Linux, or /proc is unavailable; nothing to say.
+	case mounted && empty:
+		log.Println("Zero-config: initializing the empty volume at " + dataDir)
+	case !mounted:
+		log.Println("Zero-config: WARNING: " + dataDir + " is not a mounted volume; its data is lost when the container is removed")
+	}
+}
+
+// isMountPoint reports whether dir is a mount point, according to
+// /proc/self/mountinfo. known is false if that cannot be determined.
+func isMountPoint(dir string) (mounted, known bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return false, false
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false, false
+	}
+	defer f.Close()
+
+	// Each line is "id parent major:minor root mountpoint options ...",
+	// with spaces in paths escaped as \040.
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) > 4 && strings.ReplaceAll(fields[4], `\040`, " ") == abs {
+			return true, true
+		}
+	}
+	return false, sc.Err() == nil
+}
+
+// zeroConfigToken returns the admin token for zero-config mode: the
+// configured one, else the one saved in the data directory, else a new one,
+// which is saved and printed once.
+func zeroConfigToken(cfg *config.Config) (string, error) {
+	if cfg.AdminToken != "" {
+		return cfg.AdminToken, nil
+	}
+
+	path := filepath.Join(cfg.DataDir, adminTokenFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	log.Printf("Zero-config: generated admin token %s (saved to %s)", token, path)
+	return token, nil
+}
+
+// requireAdminToken rejects requests to /admin/* and /debug/* that do not
+// carry token as "Authorization: Bearer <token>". An empty token allows
+// everything.
+func requireAdminToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") || strings.HasPrefix(r.URL.Path, "/debug/") {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="logbase admin"`)
+				http.Error(w, "admin token required", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// useTempDir points os.TempDir, and so everything that makes temporary
+// files, at dir.
+func useTempDir(dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("TMPDIR", dir)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdminToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := requireAdminToken("secret", ok)
+
+	cases := []struct {
+		path          string
+		authorization string
+		want          int
+	}{
+		{"/admin/config", "", http.StatusUnauthorized},
+		{"/admin/config", "Bearer wrong", http.StatusUnauthorized},
+		{"/admin/config", "Bearer secret", http.StatusOK},
+		{"/debug/locks", "", http.StatusUnauthorized},
+		{"/debug/locks", "Bearer secret", http.StatusOK},
+		{"/health", "", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("GET %s with %q: %d, want %d", c.path, c.authorization, rec.Code, c.want)
+		}
+	}
+}
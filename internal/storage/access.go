@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const accessName = "ACCESS"
+
+// ErrAccessTracking is returned by UntouchedKeys when tracking is off.
+var ErrAccessTracking = errors.New("access tracking is not enabled")
+
+// AccessOptions configure access tracking; see EnableAccessTracking.
+type AccessOptions struct {
+	// SampleRate is the fraction of point reads recorded, in (0, 1]. A
+	// lower rate costs less on hot keys at the price of a key read only
+	// now and then looking colder than it is. Writes are always recorded.
+	SampleRate float64
+}
+
+// KeyAccess is when a key was last read and written. A zero time means
+// not since tracking started.
+type KeyAccess struct {
+	Key       string    `json:"key"`
+	LastRead  time.Time `json:"last_read,omitzero"`
+	LastWrite time.Time `json:"last_write,omitzero"`
+}
+
+// accessTracker holds the last read and write time of every key touched
+// since tracking started, in Unix seconds.
+type accessTracker struct {
+	sampleRate float64
+
+	mu sync.Mutex
+	// since is when tracking started in this data directory.
+	since int64
+	keys  map[string]*accessTimes
+	dirty bool
+}
+
+type accessTimes struct {
+	Read  int64 `json:"r,omitempty"`
+	Write int64 `json:"w,omitempty"`
+}
+
+// accessFile is the persisted form of an accessTracker.
+type accessFile struct {
+	Since int64                   `json:"since"`
+	Keys  map[string]*accessTimes `json:"keys"`
+}
+
+// EnableAccessTracking starts recording when each key is read and written,
+// picking up what was persisted in the data directory's ACCESS file. Only
+// point reads count: scans, such as statistics refreshes, would otherwise
+// make every key look recently used. Deleted keys are forgotten. Records
+// are kept in memory and persisted by SaveAccess and Close.
+func (e *Engine) EnableAccessTracking(opts AccessOptions) error {
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+
+	t := &accessTracker{sampleRate: opts.SampleRate, keys: make(map[string]*accessTimes)}
+	data, err := os.ReadFile(filepath.Join(e.DataDir(), accessName))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		t.since = clock().Unix()
+		t.dirty = true
+	case err != nil:
+		return err
+	default:
+		var f accessFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return err
+		}
+		t.since = f.Since
+		if f.Keys != nil {
+			t.keys = f.Keys
+		}
+	}
+
+	e.access.Store(t)
+	return nil
+}
+
+// SaveAccess persists the access records, if tracking is enabled and they
+// changed since they were last saved. A secondary never writes them.
+func (e *Engine) SaveAccess() error {
+	t := e.access.Load()
+	if t == nil || e.secondary {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return nil
+	}
+	data, err := json.Marshal(accessFile{Since: t.since, Keys: t.keys})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(e.DataDir(), accessName), data); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+// UntouchedKeys returns the live keys, with the given prefix, that have
+// been neither read nor written for at least age, least recently used
+// first, up to limit of them (0 means no limit). A key with no record
+// counts as last touched when tracking started. It fails with
+// ErrAccessTracking if tracking is off.
+func (e *Engine) UntouchedKeys(prefix string, age time.Duration, limit int) ([]KeyAccess, error) {
+	t := e.access.Load()
+	if t == nil {
+		return nil, ErrAccessTracking
+	}
+
+	live, _, err := e.ReadBounds(IterOptions{Prefix: []byte(prefix)}, RangeOptions{KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	cutoff := clock().Add(-age).Unix()
+
+	type candidate struct {
+		KeyAccess
+		last int64
+	}
+	var found []candidate
+
+	t.mu.Lock()
+	for k := range live {
+		c := candidate{KeyAccess: KeyAccess{Key: k}, last: t.since}
+		if times := t.keys[k]; times != nil {
+			c.LastRead, c.LastWrite = unixTime(times.Read), unixTime(times.Write)
+			c.last = max(c.last, times.Read, times.Write)
+		}
+		if c.last <= cutoff {
+			found = append(found, c)
+		}
+	}
+	t.mu.Unlock()
+
+	slices.SortFunc(found, func(a, b candidate) int {
+		return cmp.Or(cmp.Compare(a.last, b.last), strings.Compare(a.Key, b.Key))
+	})
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+
+	keys := make([]KeyAccess, len(found))
+	for i, c := range found {
+		keys[i] = c.KeyAccess
+	}
+	return keys, nil
+}
+
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+// recordRead notes a point read of key, which exists, if it is sampled.
+func (e *Engine) recordRead(key []byte) {
+	t := e.access.Load()
+	if t == nil || (t.sampleRate < 1 && rand.Float64() >= t.sampleRate) {
+		return
+	}
+	now := clock().Unix()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	times := t.keys[string(key)]
+	if times == nil {
+		times = &accessTimes{}
+		t.keys[string(key)] = times
+	}
+	if times.Read != now {
+		times.Read = now
+		t.dirty = true
+	}
+}
+
+// recordWrites notes writes that were applied; deleted keys are forgotten.
+func (e *Engine) recordWrites(records ...WALRecord) {
+	t := e.access.Load()
+	if t == nil {
+		return
+	}
+	now := clock().Unix()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range records {
+		k := string(r.Key)
+		if r.Type == DeleteRecord {
+			delete(t.keys, k)
+		} else if times := t.keys[k]; times != nil {
+			times.Write = now
+		} else {
+			t.keys[k] = &accessTimes{Write: now}
+		}
+	}
+	t.dirty = true
+}
@@ -0,0 +1,69 @@
+package storage
+
+// Batch is a sequence of puts and deletes for Write to apply as one. Later
+// operations on a key override earlier ones. The zero value is an empty
+// batch.
+type Batch struct {
+	records []WALRecord
+}
+
+// Put adds a put of key to the batch.
+func (b *Batch) Put(key, value []byte) {
+	b.records = append(b.records, WALRecord{Type: PutRecord, Key: key, Value: value})
+}
+
+// Delete adds a delete of key to the batch.
+func (b *Batch) Delete(key []byte) {
+	b.records = append(b.records, WALRecord{Type: DeleteRecord, Key: key})
+}
+
+// Len is the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.records)
+}
+
+// Write applies batch atomically: its operations go to the WAL as one
+// record group, so a crash part way through never replays some of them,
+// and are published to readers together, like BatchPut.
+func (e *Engine) Write(batch *Batch) error {
+	_, err := e.WriteWithOptions(batch, BatchOptions{})
+	return err
+}
+
+// WriteWithOptions is Write with options; see BatchPutWithOptions.
+func (e *Engine) WriteWithOptions(batch *Batch, opts BatchOptions) (result BatchResult, err error) {
+	if err := checkKeys(recordKeys(batch.records)); err != nil {
+		return BatchResult{}, err
+	}
+	route := e.routeFor(opts.Durability, recordKeys(batch.records))
+	err = e.commit(route, func() error {
+		result, err = e.write(batch, opts, route)
+		return err
+	})
+	return result, err
+}
+
+// write is WriteWithOptions short of making the batch durable. It logs to
+// route's WAL.
+func (e *Engine) write(batch *Batch, opts BatchOptions, route writeRoute) (BatchResult, error) {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if err := e.checkGuard(opts.Guard); err != nil {
+		return BatchResult{}, err
+	}
+
+	var result BatchResult
+	if opts.ReturnPrevious {
+		result.Previous = make(map[string][]byte, len(batch.records))
+		for _, r := range batch.records {
+			if entry, ok := e.getEntry(r.Key); ok {
+				result.Previous[string(r.Key)] = entry.Value
+			}
+		}
+	}
+
+	err := e.applyRecordsLocked(batch.records, route)
+	result.Seq = e.lastSeq
+	return result, err
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReturnPreviousNotARead(t *testing.T) {
+	e := openTestEngine(t)
+	if err := e.EnableAccessTracking(AccessOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.BatchPut(map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}); err != nil {
+		t.Fatal(err)
+	}
+
+	var batch Batch
+	batch.Put([]byte("b"), []byte("20"))
+	writes := map[string]func() (BatchResult, error){
+		"BatchPutWithOptions": func() (BatchResult, error) {
+			return e.BatchPutWithOptions(map[string][]byte{"a": []byte("10")}, BatchOptions{ReturnPrevious: true})
+		},
+		"WriteWithOptions": func() (BatchResult, error) {
+			return e.WriteWithOptions(&batch, BatchOptions{ReturnPrevious: true})
+		},
+		"BatchDeleteWithOptions": func() (BatchResult, error) {
+			return e.BatchDeleteWithOptions([][]byte{[]byte("c")}, BatchOptions{ReturnPrevious: true})
+		},
+	}
+	want := map[string]string{"BatchPutWithOptions": "1", "WriteWithOptions": "2", "BatchDeleteWithOptions": "3"}
+	for name, write := range writes {
+		result, err := write()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Previous) != 1 {
+			t.Errorf("%s returned %q", name, result.Previous)
+		}
+		for _, v := range result.Previous {
+			if !bytes.Equal(v, []byte(want[name])) {
+				t.Errorf("%s returned previous value %q, want %q", name, v, want[name])
+			}
+		}
+	}
+
+	// Fetching the previous values is part of the write, not a read of
+	// the keys
+	tracker := e.access.Load()
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	for key, times := range tracker.keys {
+		if times.Read != 0 {
+			t.Errorf("%q recorded as read", key)
+		}
+	}
+}
+
+func TestGuardNotARead(t *testing.T) {
+	e := openTestEngine(t)
+	if err := e.EnableAccessTracking(AccessOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Put([]byte("guard"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.BatchPutIf(Guard{Key: []byte("guard"), Value: []byte("v")}, map[string][]byte{"k": []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.BatchPutIf(Guard{Key: []byte("guard"), Value: []byte("w")}, map[string][]byte{"k": []byte("2")}); !errors.Is(err, ErrGuardFailed) {
+		t.Fatalf("failed guard: %v, want %v", err, ErrGuardFailed)
+	}
+
+	tracker := e.access.Load()
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if times := tracker.keys["guard"]; times == nil || times.Read != 0 {
+		t.Errorf("guard key access %+v, want a write and no read", times)
+	}
+}
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Version 6 tables store their records in blocks of about sstableBlockSize
+// bytes, each compressed on its own:
+//
+//	codec(1) | storedLen(4) | rawLen(4) | crc32(4) | stored
+//
+// stored is rawLen bytes of records, in the version 5 record format,
+// compressed with codec; the CRC32 covers stored. A record never spans two
+// blocks, so one larger than a block gets a block of its own. The index
+// block then holds one index entry per block, its first key and offset,
+// so a point lookup reads and decompresses exactly one block.
+const blockHeaderSize = 13
+
+// Block codec IDs, as stored in each block's header. Snappy and zstd are
+// reserved for builds that register them; see RegisterBlockCodec.
+const (
+	BlockCodecNone   byte = 0
+	BlockCodecFlate  byte = 1
+	BlockCodecSnappy byte = 2
+	BlockCodecZstd   byte = 3
+)
+
+var errBadBlock = errors.New("corrupt table block")
+
+// sstableBlockSize is the uncompressed size at which a table block is
+// closed, and blockCompression the codec new blocks are compressed with.
+var (
+	sstableBlockSize      = 4096
+	blockCompression byte = BlockCodecNone
+)
+
+// BlockCodec compresses SSTable blocks. Decompress is given the block's
+// uncompressed size. Both must be safe for concurrent use.
+type BlockCodec interface {
+	Compress(raw []byte) ([]byte, error)
+	Decompress(stored []byte, rawLen int) ([]byte, error)
+}
+
+// blockCodecs is the compiled-in codec registry, by ID and by name.
+var blockCodecs = struct {
+	sync.RWMutex
+	byID   map[byte]BlockCodec
+	byName map[string]byte
+}{
+	byID:   map[byte]BlockCodec{BlockCodecFlate: flateCodec{}},
+	byName: map[string]byte{"none": BlockCodecNone, "flate": BlockCodecFlate},
+}
+
+// RegisterBlockCodec makes c available as name, to
+// LOGBASE_SSTABLE_COMPRESSION, and for reading blocks stored with id. It
+// is meant to be called from an init function compiled into the server,
+// with BlockCodecSnappy or BlockCodecZstd for those formats; an ID or
+// name registered twice panics. A directory with tables in a codec can
+// only be read by binaries that register it.
+func RegisterBlockCodec(name string, id byte, c BlockCodec) {
+	blockCodecs.Lock()
+	defer blockCodecs.Unlock()
+
+	if _, ok := blockCodecs.byName[name]; ok || id == BlockCodecNone || blockCodecs.byID[id] != nil {
+		panic(fmt.Sprintf("storage: block codec registered twice: %s (%d)", name, id))
+	}
+	blockCodecs.byID[id] = c
+	blockCodecs.byName[name] = id
+}
+
+// SetBlockCompression compresses blocks of tables written from now on
+// with the codec registered as name; "none" stores them as they are.
+func SetBlockCompression(name string) error {
+	blockCodecs.RLock()
+	defer blockCodecs.RUnlock()
+
+	id, ok := blockCodecs.byName[name]
+	if !ok {
+		names := make([]string, 0, len(blockCodecs.byName))
+		for n := range blockCodecs.byName {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown block compression %q (registered: %v)", name, names)
+	}
+	blockCompression = id
+	return nil
+}
+
+func lookupBlockCodec(id byte) (BlockCodec, bool) {
+	blockCodecs.RLock()
+	defer blockCodecs.RUnlock()
+
+	c, ok := blockCodecs.byID[id]
+	return c, ok
+}
+
+// flateCodec is DEFLATE at its fastest level, the only compressor in the
+// standard library.
+type flateCodec struct{}
+
+func (flateCodec) Compress(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decompress(stored []byte, rawLen int) ([]byte, error) {
+	raw := make([]byte, rawLen)
+	r := flate.NewReader(bytes.NewReader(stored))
+	defer r.Close()
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// blockWriter cuts a table's records into blocks, indexing each by its
+// first key.
+type blockWriter struct {
+	w     *bufio.Writer
+	codec byte
+	// block holds the records of the block being built; size is the data
+	// written before it.
+	block bytes.Buffer
+	size  int64
+	index []IndexEntry
+}
+
+func (bw *blockWriter) add(key string, e Entry) error {
+	if bw.block.Len() == 0 {
+		bw.index = append(bw.index, IndexEntry{Key: key, Offset: bw.size})
+	}
+	writeEntry(&bw.block, []byte(key), e)
+	if bw.block.Len() >= sstableBlockSize {
+		return bw.finish()
+	}
+	return nil
+}
+
+// finish writes out the block being built, compressed unless that does
+// not make it smaller.
+func (bw *blockWriter) finish() error {
+	if bw.block.Len() == 0 {
+		return nil
+	}
+	raw := bw.block.Bytes()
+	codec, stored := BlockCodecNone, raw
+	if c, ok := lookupBlockCodec(bw.codec); ok {
+		compressed, err := c.Compress(raw)
+		if err != nil {
+			return err
+		}
+		if len(compressed) < len(raw) {
+			codec, stored = bw.codec, compressed
+		}
+	}
+
+	header := make([]byte, 0, blockHeaderSize)
+	header = append(header, codec)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(stored)))
+	header = binary.BigEndian.AppendUint32(header, uint32(len(raw)))
+	header = binary.BigEndian.AppendUint32(header, crc32.ChecksumIEEE(stored))
+	bw.w.Write(header)
+	if _, err := bw.w.Write(stored); err != nil {
+		return err
+	}
+	bw.size += int64(len(header) + len(stored))
+	bw.block.Reset()
+	return nil
+}
+
+// readBlock reads the next block from r and returns its records and the
+// bytes it took up. io.EOF is returned only at the end of the data.
+func readBlock(r io.Reader) ([]byte, int64, error) {
+	header := make([]byte, blockHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+	codec := header[0]
+	storedLen := binary.BigEndian.Uint32(header[1:5])
+	rawLen := int(binary.BigEndian.Uint32(header[5:9]))
+	sum := binary.BigEndian.Uint32(header[9:13])
+
+	stored := make([]byte, storedLen)
+	if _, err := io.ReadFull(r, stored); err != nil {
+		return nil, 0, noEOF(err)
+	}
+	if crc32.ChecksumIEEE(stored) != sum {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", errBadBlock)
+	}
+	size := int64(blockHeaderSize) + int64(storedLen)
+
+	if codec == BlockCodecNone {
+		if len(stored) != rawLen {
+			return nil, 0, fmt.Errorf("%w: %d bytes, header says %d", errBadBlock, len(stored), rawLen)
+		}
+		return stored, size, nil
+	}
+	c, ok := lookupBlockCodec(codec)
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: codec %d is not registered", errBadBlock, codec)
+	}
+	raw, err := c.Decompress(stored, rawLen)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errBadBlock, err)
+	}
+	return raw, size, nil
+}
+
+// blockReader reads the records of consecutive blocks as one stream.
+type blockReader struct {
+	src  io.Reader
+	path string
+	// off is the data offset of the next block; records is what is left
+	// of the current one.
+	off     int64
+	records []byte
+}
+
+func (r *blockReader) Read(p []byte) (int, error) {
+	for len(r.records) == 0 {
+		records, size, err := readBlock(r.src)
+		if err != nil {
+			if err == io.EOF {
+				return 0, err
+			}
+			return 0, fmt.Errorf("%s: block at %d: %w", filepath.Base(r.path), r.off, err)
+		}
+		r.records, r.off = records, r.off+size
+	}
+	n := copy(p, r.records)
+	r.records = r.records[n:]
+	return n, nil
+}
+
+// recordReader returns a reader of the table's records from data offset
+// off, which in a block table must start a block, counting its reads for
+// sub.
+func (s *SSTable) recordReader(section *io.SectionReader, off int64, limiter *rateLimiter, readAhead int, sub ioSubsystem) (*bufio.Reader, error) {
+	if _, err := section.Seek(off, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReaderSize(limiter.reader(sub.reader(section)), max(readAhead, minReadAhead))
+	if s.version < sstableV6 {
+		return reader, nil
+	}
+	return bufio.NewReader(&blockReader{src: reader, path: s.Path, off: off}), nil
+}
+
+// readBlockAt returns the records of the block at data offset off, or
+// io.EOF if the table has no data there, counting the read for sub.
+func (s *SSTable) readBlockAt(file *os.File, off int64, limiter *rateLimiter, sub ioSubsystem) ([]byte, error) {
+	section := io.NewSectionReader(file, off, max(s.dataSize-off, 0))
+	records, _, err := readBlock(limiter.reader(sub.reader(section)))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: block at %d: %w", filepath.Base(s.Path), off, err)
+	}
+	return records, err
+}
+
+// loadBlockIndex rebuilds a block table's index by reading every block.
+// Like the record scan of older tables, it stops at the first damage.
+func (s *SSTable) loadBlockIndex(section *io.SectionReader) {
+	src := bufio.NewReader(section)
+	var off int64
+	count := 0
+	for {
+		block, size, err := readBlock(src)
+		if err != nil {
+			break
+		}
+		records := bufio.NewReader(bytes.NewReader(block))
+		for i := 0; ; i++ {
+			k, _, err := readEntry(records, s.version)
+			if err != nil {
+				break
+			}
+			if i == 0 {
+				s.Index = append(s.Index, IndexEntry{Key: string(k), Offset: off})
+			}
+			if count == 0 {
+				s.minKey = string(k)
+			}
+			s.maxKey = string(k)
+			count++
+		}
+		off += size
+	}
+	s.entries = int64(count)
+}
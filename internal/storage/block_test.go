@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// writeTestBlocks cuts entries into blocks with codec and returns the
+// encoded blocks and their index.
+func writeTestBlocks(t *testing.T, entries []keyedEntry, codec byte) ([]byte, []IndexEntry) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	blocks := &blockWriter{w: w, codec: codec}
+	for _, e := range entries {
+		if err := blocks.add(e.key, e.entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := blocks.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), blocks.index
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	for name, codec := range map[string]byte{"none": BlockCodecNone, "flate": BlockCodecFlate} {
+		t.Run(name, func(t *testing.T) {
+			withBlocks(t, 256, codec)
+			entries := tableTestEntries(sstableVersion)
+			data, index := writeTestBlocks(t, entries, codec)
+
+			r := bytes.NewReader(data)
+			var off int64
+			var got []keyedEntry
+			for i := 0; ; i++ {
+				records, size, err := readBlock(r)
+				if err == io.EOF {
+					if i != len(index) {
+						t.Errorf("read %d blocks, index has %d", i, len(index))
+					}
+					break
+				}
+				if err != nil {
+					t.Fatalf("block %d: %v", i, err)
+				}
+				if i >= len(index) || index[i].Offset != off {
+					t.Fatalf("block %d at %d is not in the index %v", i, off, index)
+				}
+				off += size
+
+				reader := bufio.NewReader(bytes.NewReader(records))
+				for j := 0; ; j++ {
+					k, e, err := readEntry(reader, sstableV6)
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					if j == 0 && string(k) != index[i].Key {
+						t.Errorf("block %d starts with %q, index says %q", i, k, index[i].Key)
+					}
+					got = append(got, keyedEntry{string(k), e})
+				}
+			}
+
+			if len(got) != len(entries) {
+				t.Fatalf("read %d entries, want %d", len(got), len(entries))
+			}
+			for i, e := range entries {
+				if want := wantEntry(e.entry, sstableV6); got[i].key != e.key || !sameEntry(got[i].entry, want) {
+					t.Errorf("entry %d = %q %+v, want %q %+v", i, got[i].key, got[i].entry, e.key, want)
+				}
+			}
+			if codec == BlockCodecFlate && data[0] != BlockCodecFlate {
+				t.Errorf("first block stored with codec %d, want flate", data[0])
+			}
+		})
+	}
+}
+
+func TestBlockDamage(t *testing.T) {
+	withBlocks(t, 256, BlockCodecFlate)
+	data, _ := writeTestBlocks(t, tableTestEntries(sstableVersion), BlockCodecFlate)
+
+	damaged := bytes.Clone(data)
+	damaged[blockHeaderSize] ^= 0xff
+	if _, _, err := readBlock(bytes.NewReader(damaged)); !errors.Is(err, errBadBlock) {
+		t.Errorf("checksum mismatch: %v, want %v", err, errBadBlock)
+	}
+
+	if _, _, err := readBlock(bytes.NewReader(data[:blockHeaderSize+1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("torn block: %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// Nothing registers zstd in this package's tests
+	unknown := bytes.Clone(data)
+	unknown[0] = BlockCodecZstd
+	if _, _, err := readBlock(bytes.NewReader(unknown)); !errors.Is(err, errBadBlock) {
+		t.Errorf("unregistered codec: %v, want %v", err, errBadBlock)
+	}
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+var (
+	blockCacheHits = metrics.NewCounter("logbase_block_cache_hits_total",
+		"Point lookups answered from a cached SSTable block.")
+	blockCacheMisses = metrics.NewCounter("logbase_block_cache_misses_total",
+		"Point lookups that read an SSTable block from disk.")
+	blockCacheEvictions = metrics.NewCounter("logbase_block_cache_evictions_total",
+		"SSTable blocks evicted from the block cache to make room.")
+	blockCacheBytes = metrics.NewGauge("logbase_block_cache_bytes",
+		"Uncompressed bytes of SSTable blocks in the block cache.")
+)
+
+// blockCache holds the decompressed blocks point lookups read, least
+// recently used first out, for every table in the process. Scans do not
+// fill it, so a large range read does not push out the blocks hot keys
+// live in.
+var blockCache = &lruBlockCache{entries: make(map[blockKey]*list.Element)}
+
+// BlockCacheStats describes the block cache. Hits and Misses count point
+// lookups in block tables since the process started.
+type BlockCacheStats struct {
+	CapacityBytes int64   `json:"capacity_bytes"`
+	Bytes         int64   `json:"bytes"`
+	Blocks        int     `json:"blocks"`
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	Evictions     uint64  `json:"evictions"`
+	HitRate       float64 `json:"hit_rate"`
+}
+
+type blockKey struct {
+	table *SSTable
+	off   int64
+}
+
+type cachedBlock struct {
+	key     blockKey
+	records []byte
+}
+
+type lruBlockCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	// order has the most recently used block at the front.
+	order   list.List
+	entries map[blockKey]*list.Element
+}
+
+// SetBlockCacheSize bounds the block cache to bytes of uncompressed
+// blocks, evicting as needed; 0 turns it off.
+func SetBlockCacheSize(bytes int64) {
+	blockCache.mu.Lock()
+	defer blockCache.mu.Unlock()
+
+	blockCache.capacity = max(bytes, 0)
+	blockCache.evict()
+}
+
+// BlockCache reports the block cache's size and hit rate.
+func BlockCache() BlockCacheStats {
+	blockCache.mu.Lock()
+	stats := BlockCacheStats{
+		CapacityBytes: blockCache.capacity,
+		Bytes:         blockCache.size,
+		Blocks:        len(blockCache.entries),
+	}
+	blockCache.mu.Unlock()
+
+	stats.Hits, stats.Misses = blockCacheHits.Value(), blockCacheMisses.Value()
+	stats.Evictions = blockCacheEvictions.Value()
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+// get returns the cached records of the table's block at off. They must
+// not be modified.
+func (c *lruBlockCache) get(s *SSTable, off int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[blockKey{s, off}]
+	if !ok {
+		blockCacheMisses.Inc()
+		return nil, false
+	}
+	blockCacheHits.Inc()
+	c.order.MoveToFront(el)
+	return el.Value.(*cachedBlock).records, true
+}
+
+// put caches the table's block at off, unless it is larger than the cache.
+func (c *lruBlockCache) put(s *SSTable, off int64, records []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := blockKey{s, off}
+	if int64(len(records)) > c.capacity {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cachedBlock{key: key, records: records})
+	c.size += int64(len(records))
+	c.evict()
+}
+
+// drop forgets a table's blocks, once its file is gone.
+func (c *lruBlockCache) drop(s *SSTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.entries {
+		if key.table == s {
+			c.remove(el)
+		}
+	}
+	blockCacheBytes.Set(c.size)
+}
+
+// evict removes the least recently used blocks until the cache fits its
+// capacity. c.mu must be held.
+func (c *lruBlockCache) evict() {
+	for c.size > c.capacity {
+		c.remove(c.order.Back())
+		blockCacheEvictions.Inc()
+	}
+	blockCacheBytes.Set(c.size)
+}
+
+func (c *lruBlockCache) remove(el *list.Element) {
+	b := c.order.Remove(el).(*cachedBlock)
+	delete(c.entries, b.key)
+	c.size -= int64(len(b.records))
+}
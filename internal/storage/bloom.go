@@ -1,14 +1,28 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"hash/fnv"
+	"math"
 	"os"
+	"path/filepath"
 )
 
+// bloomFPR is the false-positive rate table bloom filters are sized for.
+var bloomFPR = 0.01
+
 type BloomFilter struct {
 	bits []byte
 	k    int // number of hash functions
+
+	// keys and fpr are what a sized filter was sized for, or zero.
+	keys int
+	fpr  float64
 }
 
 func NewBloomFilter(size int, k int) *BloomFilter {
@@ -18,6 +32,20 @@ func NewBloomFilter(size int, k int) *BloomFilter {
 	}
 }
 
+// NewSizedBloomFilter returns a filter for keys keys with a false-positive
+// rate of about fpr: -keys*ln(fpr)/ln(2)^2 bits, rounded up to whole
+// bytes, and ln(2) hashes per bit per key.
+func NewSizedBloomFilter(keys int, fpr float64) *BloomFilter {
+	n := float64(max(keys, 1))
+	bits := math.Ceil(-n * math.Log(fpr) / (math.Ln2 * math.Ln2))
+	size := max(int(bits+7)/8, 8)
+	k := int(math.Round(float64(size*8) / n * math.Ln2))
+
+	b := NewBloomFilter(size, min(max(k, 1), 30))
+	b.keys, b.fpr = keys, fpr
+	return b
+}
+
 func (b *BloomFilter) Add(key []byte) {
 	for i := 0; i < b.k; i++ {
 		idx := b.hash(key, i) % (uint64(len(b.bits)) * 8)
@@ -46,26 +74,161 @@ func (b *BloomFilter) hash(key []byte, seed int) uint64 {
 	return h.Sum64()
 }
 
+// A .bloom file is bloomMagic, bloomVersion, the hash count, the key count
+// and target false-positive rate it was sized for, the number of bits and
+// the bits, then a CRC32 of everything before it. Files from before the
+// format are gob-encoded and still load.
+const (
+	bloomMagic   = "LBBLOOMF"
+	bloomVersion = 1
+)
+
+var errBadBloomFilter = errors.New("malformed bloom filter")
+
+// Save writes the filter to path, replacing any file there whole.
 func (b *BloomFilter) Save(path string) error {
-	file, err := os.Create(path)
+	return writeFileAtomic(path, b.encode())
+}
+
+func (b *BloomFilter) encode() []byte {
+	buf := make([]byte, 0, len(bloomMagic)+36+len(b.bits)+4)
+	buf = append(buf, bloomMagic...)
+	buf = binary.BigEndian.AppendUint32(buf, bloomVersion)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(b.k))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(b.keys))
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(b.fpr))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(b.bits))*8)
+	buf = append(buf, b.bits...)
+	return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+// LoadBloomFilter reads the filter at path, in either format.
+func LoadBloomFilter(path string) (*BloomFilter, error) {
+	b, _, err := loadBloomFilter(path)
+	return b, err
+}
+
+// loadBloomFilter is LoadBloomFilter that also reports whether the file is
+// in the gob format.
+func loadBloomFilter(path string) (b *BloomFilter, legacy bool, err error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, false, err
+	}
+	if !bytes.HasPrefix(data, []byte(bloomMagic)) {
+		b, err := decodeLegacyBloomFilter(data)
+		return b, true, err
+	}
+	b, err = decodeBloomFilter(data)
+	return b, false, err
+}
+
+func decodeBloomFilter(data []byte) (*BloomFilter, error) {
+	const header = len(bloomMagic) + 32
+	if len(data) < header+4 {
+		return nil, errBadBloomFilter
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", errBadBloomFilter)
+	}
+
+	d := cacheDecoder{buf: body[len(bloomMagic):]}
+	if v := d.uint32(); v != bloomVersion {
+		return nil, fmt.Errorf("%w: unknown version %d", errBadBloomFilter, v)
+	}
+	b := &BloomFilter{k: int(d.uint32())}
+	b.keys = int(d.int64())
+	b.fpr = math.Float64frombits(uint64(d.int64()))
+	bits := d.int64()
+	if bits%8 != 0 || bits/8 != int64(len(d.buf)) || b.k <= 0 {
+		return nil, errBadBloomFilter
+	}
+	b.bits = bytes.Clone(d.buf)
+	return b, nil
+}
+
+// bloomFile is a filter as the gob format saved it.
+type bloomFile struct {
+	Bits []byte
+	K    int
+	Keys int
+	FPR  float64
+}
+
+func decodeLegacyBloomFilter(data []byte) (*BloomFilter, error) {
+	var bf bloomFile
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&bf); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadBloomFilter, err)
+	}
+	if bf.K <= 0 || len(bf.Bits) == 0 {
+		return nil, errBadBloomFilter
+	}
+	return &BloomFilter{bits: bf.Bits, k: bf.K, keys: bf.Keys, fpr: bf.FPR}, nil
+}
+
+// readBloom reads the table's bloom filter: a version 7 table's own, or
+// the .bloom file of an older one, reporting whether that is in the gob
+// format. The table's footer must be loaded.
+func (s *SSTable) readBloom() (b *BloomFilter, legacy bool, err error) {
+	if s.version < sstableV7 {
+		return loadBloomFilter(s.Path + ".bloom")
+	}
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, false, err
 	}
 	defer file.Close()
 
-	encoder := gob.NewEncoder(file)
-	return encoder.Encode(b)
+	b, err = s.loadBloomBlock(file)
+	return b, false, err
 }
 
-func LoadBloomFilter(path string) (*BloomFilter, error) {
-	file, err := os.Open(path)
+// loadBloom loads the table's bloom filter when its directory is opened.
+// A gob-format .bloom file is rewritten in the current format, and a
+// missing or damaged filter is rebuilt from the table's keys, so reads of
+// the table are not left to scan it. A rebuilt filter is saved for a table
+// that keeps it in a .bloom file; one embedded in the table stays in
+// memory until compaction rewrites the table.
+func (s *SSTable) loadBloom() {
+	path, name := s.Path+".bloom", filepath.Base(s.Path)
+	b, legacy, err := s.readBloom()
+	if err == nil {
+		s.Bloom = b
+		if legacy {
+			if err := b.Save(path); err != nil {
+				cacheLog.Warnf("%s: converting bloom filter: %v", name, err)
+			}
+		}
+		return
+	}
+
+	cacheLog.Warnf("%s: bloom filter: %v; rebuilding it", name, err)
+	if b, err = s.buildBloom(); err != nil {
+		cacheLog.Warnf("%s: rebuilding bloom filter: %v", name, err)
+		return
+	}
+	s.Bloom = b
+	if s.version >= sstableV7 {
+		return
+	}
+	if err := b.Save(path); err != nil {
+		cacheLog.Warnf("%s: saving rebuilt bloom filter: %v", name, err)
+	}
+}
+
+// buildBloom builds a filter of the table's keys, tombstones included, as
+// writing the table does. The index must be loaded.
+func (s *SSTable) buildBloom() (*BloomFilter, error) {
+	it, err := s.newIterator(IterOptions{}, rangeScan{io: ioCompaction, keysOnly: true})
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	defer it.Close()
 
-	var bf BloomFilter
-	decoder := gob.NewDecoder(file)
-	err = decoder.Decode(&bf)
-	return &bf, err
+	b := NewSizedBloomFilter(int(s.entries), bloomFPR)
+	for it.Next() {
+		b.Add(it.Key())
+	}
+	return b, it.Err()
 }
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"path/filepath"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+var (
+	bloomChecks = metrics.NewCounter("logbase_bloom_checks_total",
+		"Point lookups that consulted an SSTable bloom filter.")
+	bloomNegatives = metrics.NewCounter("logbase_bloom_negatives_total",
+		"Bloom filter checks that ruled a table out.")
+	bloomFalsePositives = metrics.NewCounter("logbase_bloom_false_positives_total",
+		"Bloom filter checks that passed for a key the table did not hold.")
+)
+
+// BloomStats describes how well a table's bloom filter avoids reads.
+type BloomStats struct {
+	Table          string `json:"table,omitempty"`
+	Checks         uint64 `json:"checks"`
+	Negatives      uint64 `json:"negatives"`
+	FalsePositives uint64 `json:"false_positives"`
+	// FalsePositiveRate is the fraction of lookups for absent keys that
+	// the filter failed to rule out.
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+}
+
+func (b *BloomStats) add(o BloomStats) {
+	b.Checks += o.Checks
+	b.Negatives += o.Negatives
+	b.FalsePositives += o.FalsePositives
+}
+
+func (b *BloomStats) computeRate() {
+	if absent := b.Negatives + b.FalsePositives; absent > 0 {
+		b.FalsePositiveRate = float64(b.FalsePositives) / float64(absent)
+	}
+}
+
+// mightContain consults the table's bloom filter, recording the outcome.
+func (s *SSTable) mightContain(key []byte) bool {
+	if s.Bloom == nil {
+		return true
+	}
+
+	s.bloomChecks.Add(1)
+	bloomChecks.Inc()
+	if !s.Bloom.MightContain(key) {
+		s.bloomNegatives.Add(1)
+		bloomNegatives.Inc()
+		return false
+	}
+	return true
+}
+
+// bloomMiss records that a lookup the filter let through found nothing.
+func (s *SSTable) bloomMiss() {
+	if s.Bloom == nil {
+		return
+	}
+	s.bloomFalsePositives.Add(1)
+	bloomFalsePositives.Inc()
+}
+
+// BloomStats returns bloom filter statistics for each live table, oldest
+// first, and their total. Counts start from zero when a table is opened.
+func (e *Engine) BloomStats() ([]BloomStats, BloomStats) {
+	v := e.view()
+
+	tables := make([]BloomStats, 0, len(v.tables))
+	var total BloomStats
+	for _, t := range v.tables {
+		s := BloomStats{
+			Table:          filepath.Base(t.Path),
+			Checks:         t.bloomChecks.Load(),
+			Negatives:      t.bloomNegatives.Load(),
+			FalsePositives: t.bloomFalsePositives.Load(),
+		}
+		s.computeRate()
+		tables = append(tables, s)
+		total.add(s)
+	}
+	total.computeRate()
+
+	return tables, total
+}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// CheckMode selects what the startup consistency check does with the
+// problems it finds.
+type CheckMode int
+
+const (
+	// CheckWarn reports problems and opens the directory anyway.
+	CheckWarn CheckMode = iota
+	// CheckStrict refuses to open a directory with any problem.
+	CheckStrict
+	// CheckRepair fixes what it can: leftovers are deleted, damaged tables
+	// are set aside and a torn WAL tail is cut off.
+	CheckRepair
+)
+
+// ParseCheckMode maps "warn", "strict" and "repair" to a CheckMode.
+func ParseCheckMode(s string) (CheckMode, error) {
+	switch s {
+	case "warn", "":
+		return CheckWarn, nil
+	case "strict":
+		return CheckStrict, nil
+	case "repair":
+		return CheckRepair, nil
+	}
+	return CheckWarn, fmt.Errorf("unknown check mode %q", s)
+}
+
+// startupCheck is the mode NewEngine checks the data directory with.
+var startupCheck = CheckWarn
+
+var ErrInconsistent = errors.New("data directory is inconsistent")
+
+// Inconsistency is one problem found in a data directory.
+type Inconsistency struct {
+	Path     string `json:"path"`
+	Problem  string `json:"problem"`
+	Repaired bool   `json:"repaired"`
+}
+
+func (i Inconsistency) String() string {
+	s := i.Path + ": " + i.Problem
+	if i.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// CheckDataDir cross-checks dataDir's MANIFEST, SSTables and WAL segments.
+// In strict mode any problem is returned as an ErrInconsistent error; in
+// repair mode problems are fixed where possible and marked Repaired.
+func CheckDataDir(dataDir string, mode CheckMode) ([]Inconsistency, error) {
+	m, err := readManifest(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &checker{mode: mode}
+	c.checkLeftovers(dataDir)
+	c.checkTables(dataDir, m)
+	for _, dir := range walDirs(filepath.Join(dataDir, walDirName)) {
+		c.checkWAL(dir)
+	}
+
+	if mode == CheckStrict && len(c.found) > 0 {
+		return c.found, fmt.Errorf("%w: %d problems, first: %s", ErrInconsistent, len(c.found), c.found[0])
+	}
+	return c.found, nil
+}
+
+// Inconsistencies returns the problems found when the engine was opened.
+func (e *Engine) Inconsistencies() []Inconsistency {
+	return e.inconsistencies
+}
+
+type checker struct {
+	mode  CheckMode
+	found []Inconsistency
+}
+
+// report records a problem, applying fix in repair mode. A nil fix means
+// the problem cannot be repaired automatically.
+func (c *checker) report(path, problem string, fix func() error) {
+	inc := Inconsistency{Path: path, Problem: problem}
+	if c.mode == CheckRepair && fix != nil {
+		if err := fix(); err != nil {
+			inc.Problem += fmt.Sprintf("; repair failed: %v", err)
+		} else {
+			inc.Repaired = true
+		}
+	}
+	c.found = append(c.found, inc)
+}
+
+// checkLeftovers finds temporary files from interrupted writes.
+func (c *checker) checkLeftovers(dataDir string) {
+	for _, dir := range append([]string{dataDir}, walDirs(filepath.Join(dataDir, walDirName))...) {
+		tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+		for _, path := range tmps {
+			c.report(path, "leftover temporary file", func() error {
+				return os.Remove(path)
+			})
+		}
+	}
+
+	blooms, _ := filepath.Glob(filepath.Join(dataDir, "sst_*.dat.bloom"))
+	for _, path := range blooms {
+		if _, err := os.Stat(strings.TrimSuffix(path, ".bloom")); errors.Is(err, os.ErrNotExist) {
+			c.report(path, "bloom filter without a table", func() error {
+				return os.Remove(path)
+			})
+		}
+	}
+}
+
+// checkTables verifies every live table has a valid footer in the format
+// the MANIFEST declares, and the bloom filter the MANIFEST's features
+// imply. A table the MANIFEST lists must exist; files it does not list are
+// leftovers the engine removes when it opens the directory.
+func (c *checker) checkTables(dataDir string, m *Manifest) {
+	live, _ := liveTables(dataDir, m)
+	for _, rec := range live {
+		path := filepath.Join(dataDir, rec.File)
+		if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+			c.report(path, fmt.Sprintf("table listed in %s is missing", manifestName), func() error {
+				return unlist(dataDir, m, path)
+			})
+			continue
+		}
+		table := &SSTable{Path: path}
+		file, _, err := table.open()
+		if err != nil {
+			c.report(path, fmt.Sprintf("unreadable table: %v", err), quarantine(dataDir, m, path))
+			continue
+		}
+		file.Close()
+
+		if m != nil && m.FormatVersion >= dataFormatV2 && table.version < sstableV2 {
+			c.report(path, "table has no footer; it was probably cut short by a crash", quarantine(dataDir, m, path))
+			continue
+		}
+
+		if m != nil && m.hasFeature(FeatureBloomFilter) {
+			if _, _, err := table.readBloom(); errors.Is(err, os.ErrNotExist) {
+				c.report(path, "missing bloom filter; it is rebuilt when the table is loaded", nil)
+			} else if err != nil {
+				c.report(path, fmt.Sprintf("%v; it is rebuilt when the table is loaded", err), nil)
+			}
+		}
+	}
+}
+
+// quarantine renames a damaged table out of the way, and drops it from
+// the MANIFEST's table list, so it is no longer loaded but can still be
+// inspected.
+func quarantine(dataDir string, m *Manifest, path string) func() error {
+	return func() error {
+		os.Rename(path+".bloom", path+".bloom.corrupt")
+		if err := os.Rename(path, path+".corrupt"); err != nil {
+			return err
+		}
+		return unlist(dataDir, m, path)
+	}
+}
+
+// unlist drops the table at path from m's table list, if it has one.
+func unlist(dataDir string, m *Manifest, path string) error {
+	if _, ok := m.listedTables(); !ok {
+		return nil
+	}
+	m.Tables = slices.DeleteFunc(slices.Clone(m.Tables), func(t ManifestTable) bool {
+		return t.File == filepath.Base(path)
+	})
+	return writeManifest(dataDir, m)
+}
+
+// checkWAL verifies segments are contiguous and readable. Only the tail of
+// the log may end in a torn record, which repair cuts off; segments after
+// it may exist but must be empty, as when a crashed engine was restarted.
+// Segments a replay set aside as *.corrupt do not count as missing.
+func (c *checker) checkWAL(dir string) {
+	paths := segmentPaths(dir)
+
+	valid := make([]int64, len(paths))
+	errs := make([]error, len(paths))
+	records := make([]int, len(paths))
+	for i, path := range paths {
+		valid[i], records[i], errs[i] = validSegmentSize(path)
+	}
+
+	for i, path := range paths {
+		if i > 0 && extractID(path) != extractID(paths[i-1])+1 && !setAsideBetween(dir, paths[i-1], path) {
+			c.report(path, fmt.Sprintf("missing WAL segments before this one (previous is %s)",
+				filepath.Base(paths[i-1])), nil)
+		}
+
+		if errs[i] == nil {
+			continue
+		}
+		if isWALDamage(errs[i]) && isTail(records[i+1:]) {
+			c.report(path, fmt.Sprintf("torn or corrupt record at offset %d: %v", valid[i], errs[i]), func() error {
+				return os.Truncate(path, valid[i])
+			})
+			continue
+		}
+		c.report(path, fmt.Sprintf("unreadable WAL segment at offset %d: %v", valid[i], errs[i]), nil)
+	}
+}
+
+// setAsideBetween reports whether every segment missing between prev and
+// next was set aside by a replay that stopped at damage.
+func setAsideBetween(dir, prev, next string) bool {
+	for id := extractID(prev) + 1; id < extractID(next); id++ {
+		if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("wal_%06d.log.corrupt", id))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// isTail reports whether the segments that follow hold no records.
+func isTail(records []int) bool {
+	for _, n := range records {
+		if n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validSegmentSize returns the length of the segment's readable prefix, its
+// header and every complete record, and the number of those records.
+func validSegmentSize(path string) (int64, int, error) {
+	records, valid, err := readSegment(path, ioWAL)
+	return valid, len(records), err
+}
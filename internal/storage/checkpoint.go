@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// checkpointDirName holds checkpoints under the data directory.
+const checkpointDirName = "checkpoints"
+
+var ErrNoCheckpoint = errors.New("no such checkpoint")
+
+// CheckpointInfo describes a checkpoint on disk.
+type CheckpointInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Tables    int       `json:"tables"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// Checkpoint saves the engine's current state under checkpoints/ in the
+// data directory. SSTables are immutable, so they are hard linked rather
+// than copied; the WAL is copied and a MANIFEST listing the tables is
+// written. Writers are paused while it runs so the tables and WAL agree,
+// and frozen memtables are flushed first so no WAL segment is truncated
+// under it.
+func (e *Engine) Checkpoint() (CheckpointInfo, error) {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if e.secondary {
+		return CheckpointInfo{}, ErrSecondary
+	}
+	if err := e.waitForFlushes(); err != nil {
+		return CheckpointInfo{}, err
+	}
+
+	now := clock().UTC()
+	name := "ckpt-" + now.Format("20060102T150405.000Z")
+	dir := filepath.Join(e.DataDir(), checkpointDirName, name)
+	tmp := dir + ".tmp"
+
+	if err := e.writeCheckpoint(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return CheckpointInfo{}, err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		return CheckpointInfo{}, err
+	}
+
+	return checkpointInfo(dir)
+}
+
+// Clone writes the engine's current state to dir as a new data directory,
+// like Checkpoint. The tables are hard linked, so the clone is instant and
+// shares them with the engine until compaction on either side replaces
+// its own links with new tables; dir must therefore be on the same
+// filesystem. dir must not exist.
+func (e *Engine) Clone(dir string) error {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if e.secondary {
+		return ErrSecondary
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%w: %s", os.ErrExist, dir)
+	}
+	if err := e.waitForFlushes(); err != nil {
+		return err
+	}
+
+	tmp := dir + ".tmp"
+	if err := e.writeCheckpoint(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return nil
+}
+
+func (e *Engine) writeCheckpoint(dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, walDirName), 0755); err != nil {
+		return err
+	}
+
+	// Compaction may replace the tables meanwhile; pinned, their files stay
+	tables := e.tables()
+	pinTables(tables)
+	defer unpinTables(tables)
+
+	for _, t := range tables {
+		if err := linkTableFile(t.Path, filepath.Join(dir, filepath.Base(t.Path))); err != nil {
+			return err
+		}
+		err := os.Link(t.Path+".bloom", filepath.Join(dir, filepath.Base(t.Path)+".bloom"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err := copySegments(filepath.Join(e.DataDir(), walDirName), filepath.Join(dir, walDirName)); err != nil {
+		return err
+	}
+
+	// The MANIFEST lists the tables linked, whatever compaction did since
+	e.manifestMu.Lock()
+	m := *e.manifest
+	e.manifestMu.Unlock()
+	m.Tables = tableRecords(tables)
+	return writeManifest(dir, &m)
+}
+
+// Checkpoints lists the data directory's checkpoints, oldest first.
+func (e *Engine) Checkpoints() ([]CheckpointInfo, error) {
+	return ListCheckpoints(e.DataDir())
+}
+
+// ListCheckpoints lists dataDir's checkpoints, oldest first.
+func ListCheckpoints(dataDir string) ([]CheckpointInfo, error) {
+	dirs, _ := filepath.Glob(filepath.Join(dataDir, checkpointDirName, "ckpt-*"))
+	sort.Strings(dirs)
+
+	infos := make([]CheckpointInfo, 0, len(dirs))
+	for _, dir := range dirs {
+		if strings.HasSuffix(dir, ".tmp") {
+			continue
+		}
+		info, err := checkpointInfo(dir)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// PruneCheckpoints deletes all but the newest keep checkpoints.
+func (e *Engine) PruneCheckpoints(keep int) error {
+	infos, err := e.Checkpoints()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(infos)-keep; i++ {
+		if err := os.RemoveAll(filepath.Join(e.DataDir(), checkpointDirName, infos[i].Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkpointInfo(dir string) (CheckpointInfo, error) {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return CheckpointInfo{}, err
+	}
+	info := CheckpointInfo{Name: filepath.Base(dir), CreatedAt: stat.ModTime().UTC()}
+
+	tables, _ := filepath.Glob(filepath.Join(dir, "sst_*.dat"))
+	info.Tables = len(tables)
+
+	err = filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			info.Bytes += fi.Size()
+		}
+		return err
+	})
+	return info, err
+}
+
+// RestoreCheckpoint replaces dataDir's tables, WAL and MANIFEST with those
+// of the named checkpoint. No engine may have dataDir open.
+func RestoreCheckpoint(dataDir, name string) error {
+	src := filepath.Join(dataDir, checkpointDirName, name)
+	if _, err := os.Stat(filepath.Join(src, manifestName)); err != nil {
+		return fmt.Errorf("%w: %s", ErrNoCheckpoint, name)
+	}
+
+	// Drop the current state. The index cache is rebuilt on open.
+	if err := os.Remove(filepath.Join(dataDir, indexCacheName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	current, _ := filepath.Glob(filepath.Join(dataDir, "sst_*"))
+	current = append(current, allSegmentPaths(filepath.Join(dataDir, walDirName))...)
+	for _, path := range current {
+		if err := removeTableFile(path); err != nil {
+			return err
+		}
+	}
+
+	// Link the checkpoint's tables back and copy the mutable files
+	files, _ := filepath.Glob(filepath.Join(src, "sst_*"))
+	for _, path := range files {
+		if err := os.Link(path, filepath.Join(dataDir, filepath.Base(path))); err != nil {
+			return err
+		}
+	}
+	walDir := filepath.Join(dataDir, walDirName)
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		return err
+	}
+	if err := copySegments(filepath.Join(src, walDirName), walDir); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(filepath.Join(src, manifestName))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dataDir, manifestName), data)
+}
+
+// copyFile copies src to dst and syncs dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
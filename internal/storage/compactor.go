@@ -0,0 +1,129 @@
+package storage
+
+import "sync"
+
+// compactor runs compactions on a goroutine of its own, so a flush does
+// not wait for a merge. Flushes queue
+// a request; requests made while one is already queued are folded into it,
+// since one compaction covers every table there is when it starts.
+type compactor struct {
+	requests chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	// manual is set in a simulation, which runs compactions itself.
+	manual bool
+
+	mu   sync.Mutex
+	idle *sync.Cond
+	// pending counts queued and running requests.
+	pending int
+	// err is the outcome of the last compaction.
+	err error
+}
+
+// startCompactor starts the compaction goroutine; in a simulation the
+// simulation runs queued compactions itself.
+func (e *Engine) startCompactor() {
+	c := &e.compactor
+	c.requests = make(chan struct{}, 1)
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	c.idle = sync.NewCond(&c.mu)
+	if simulating.Load() != nil {
+		c.manual = true
+		close(c.done)
+		return
+	}
+	go e.runCompactions()
+}
+
+func (e *Engine) runCompactions() {
+	c := &e.compactor
+	defer close(c.done)
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-c.requests:
+		}
+		e.compactOnce()
+	}
+}
+
+// compactOnce serves one request taken from the queue.
+func (e *Engine) compactOnce() error {
+	c := &e.compactor
+	err := e.breaker.recordWrite(e.maybeCompact())
+	if err != nil {
+		compactionLog.Errorf("compaction: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	c.pending--
+	if c.pending == 0 {
+		c.idle.Broadcast()
+	}
+	return err
+}
+
+// runQueuedCompaction serves a queued request on the caller's goroutine,
+// for a simulation. It reports whether one was queued.
+func (e *Engine) runQueuedCompaction() (bool, error) {
+	select {
+	case <-e.compactor.requests:
+		return true, e.compactOnce()
+	default:
+		return false, nil
+	}
+}
+
+// requestCompaction queues a check for compaction work.
+func (e *Engine) requestCompaction() {
+	c := &e.compactor
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case c.requests <- struct{}{}:
+		c.pending++
+	default:
+	}
+}
+
+// WaitForCompactions blocks until no compaction is queued or running, and
+// returns the error of the last one.
+func (e *Engine) WaitForCompactions() error {
+	c := &e.compactor
+	if c.manual {
+		for {
+			if ran, _ := e.runQueuedCompaction(); !ran {
+				break
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.pending > 0 {
+		c.idle.Wait()
+	}
+	return c.err
+}
+
+// stopCompactor stops the compaction goroutine once any compaction in
+// progress has finished. Requests still queued are dropped.
+func (e *Engine) stopCompactor() {
+	c := &e.compactor
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+
+		c.mu.Lock()
+		c.pending = 0
+		c.idle.Broadcast()
+		c.mu.Unlock()
+	})
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Compaction actions a CompactionPlan may name.
+const (
+	CompactionNone    = "none"
+	CompactionMerge   = "merge"
+	CompactionRewrite = "rewrite"
+)
+
+// CompactionPlan is what the compactor would do if it ran now.
+type CompactionPlan struct {
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+	// Paused is set while compaction is held back, in which case the plan
+	// is what it would do once resumed.
+	Paused bool `json:"paused,omitempty"`
+	Tables int  `json:"tables"`
+	// Inputs are the tables the action reads and replaces; Kept are the
+	// tables a merge leaves as they are because their keys overlap no
+	// other table.
+	Inputs []TableInfo `json:"inputs"`
+	Kept   []TableInfo `json:"kept,omitempty"`
+	// InputBytes is the size of the inputs; EstOutputBytes estimates the
+	// size of what replaces them, from each input's garbage estimate.
+	InputBytes      int64 `json:"input_bytes"`
+	EstOutputBytes  int64 `json:"est_output_bytes"`
+	EstOutputTables int   `json:"est_output_tables"`
+}
+
+// PlanCompaction reports the compaction or rewrite the tables call for
+// right now, and why, without running it. It applies the same tests as the
+// compactor, so tuning the thresholds can be checked before any table is
+// touched. Cold tiering, which runs only when asked for, is not planned.
+func (e *Engine) PlanCompaction() CompactionPlan {
+	tables := e.tables()
+	plan := CompactionPlan{
+		Action: CompactionNone,
+		Paused: e.compactionPaused.Load(),
+		Tables: len(tables),
+		Inputs: []TableInfo{},
+	}
+	if e.secondary {
+		plan.Reason = "a secondary does not compact"
+		return plan
+	}
+
+	if reason := mergeReason(tables); reason != "" {
+		inputs, kept := compactionInputs(tables)
+		for _, t := range kept {
+			plan.Kept = append(plan.Kept, tableInfo(t))
+		}
+		if len(inputs) > 0 {
+			plan.Action, plan.Reason = CompactionMerge, reason
+			plan.addInputs(inputs...)
+			plan.EstOutputTables = int(max((plan.EstOutputBytes+compactionTargetFileSize-1)/compactionTargetFileSize, 1))
+			return plan
+		}
+		plan.Kept = nil
+	}
+
+	if i := rewriteCandidate(tables); i >= 0 {
+		t := tables[i]
+		plan.Action = CompactionRewrite
+		plan.Reason = fmt.Sprintf("%s is an estimated %.0f%% garbage, at or over %.0f%%",
+			filepath.Base(t.Path), t.garbageRatio()*100, garbageRewriteRatio*100)
+		plan.addInputs(t)
+		plan.EstOutputTables = 1
+		return plan
+	}
+
+	plan.Reason = fmt.Sprintf("%d of %d tables and no table %.0f%% garbage",
+		len(tables), MaxSSTables, garbageRewriteRatio*100)
+	return plan
+}
+
+// addInputs adds tables to the plan's inputs, estimating that their
+// garbage is dropped.
+func (p *CompactionPlan) addInputs(tables ...*SSTable) {
+	for _, t := range tables {
+		p.Inputs = append(p.Inputs, tableInfo(t))
+		p.InputBytes += t.dataSize
+		p.EstOutputBytes += int64(float64(t.dataSize) * (1 - min(t.garbageRatio(), 1)))
+	}
+}
+
+// mergeReason says why the tables call for a full compaction, or is empty
+// if they do not.
+func mergeReason(tables []*SSTable) string {
+	if len(tables) < MaxSSTables {
+		return ""
+	}
+	return fmt.Sprintf("%d tables, at or over the limit of %d", len(tables), MaxSSTables)
+}
+
+// rewriteCandidate returns the index of the first table with enough
+// garbage to be rewritten on its own, or -1.
+func rewriteCandidate(tables []*SSTable) int {
+	for i, t := range tables {
+		if t.garbageRatio() >= garbageRewriteRatio {
+			return i
+		}
+	}
+	return -1
+}
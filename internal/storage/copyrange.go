@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+)
+
+// copyBatchSize is the number of keys CopyRange writes per batch.
+const copyBatchSize = 1000
+
+// CopyRangeOptions select and rename the keys CopyRange copies.
+type CopyRangeOptions struct {
+	// SrcPrefix, if set, restricts the copy to keys with this prefix. It
+	// is replaced by DstPrefix in the copied keys.
+	SrcPrefix []byte
+	DstPrefix []byte
+}
+
+// CopyRange copies the live keys in [start, end], with their metadata, as
+// of a snapshot taken when it starts, so it does not observe its own
+// writes. Each batch of copyBatchSize keys is written atomically; the copy
+// as a whole is not. It returns the number of keys copied.
+func (e *Engine) CopyRange(start, end []byte, opts CopyRangeOptions) (int, error) {
+	snap := e.NewSnapshot(SnapshotOptions{})
+	defer snap.Release()
+
+	bounds := closedRange(start, end)
+	bounds.Prefix = opts.SrcPrefix
+	entries, _, err := e.readEntriesIn(snap.view, bounds, RangeOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	copied := 0
+	for len(keys) > 0 {
+		n := min(len(keys), copyBatchSize)
+
+		records := make([]WALRecord, 0, n)
+		for _, k := range keys[:n] {
+			entry := entries[k]
+			dst := append(bytes.Clone(opts.DstPrefix), k[len(opts.SrcPrefix):]...)
+
+			r := WALRecord{Type: PutRecord, Key: dst, Value: entry.Value}
+			if len(entry.Meta) > 0 {
+				r.Type, r.Meta = PutMetaRecord, entry.Meta
+			}
+			records = append(records, r)
+		}
+
+		if err := e.applyRecords(records); err != nil {
+			return copied, err
+		}
+		copied += n
+		keys = keys[n:]
+	}
+
+	return copied, nil
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"iter"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrefixSyncPolicy makes writes of keys under Prefix as durable as
+// Durability, so data that needs an fsync per write can share an engine
+// with bulk data that does not. Each policy's writes go to a WAL of its
+// own, with its own segments and group commit, so fsyncing them neither
+// waits for nor pays for the bulk writes logged elsewhere. An empty Prefix
+// covers every key and so replaces the engine's default, on the engine's
+// own WAL.
+type PrefixSyncPolicy struct {
+	Prefix     string     `json:"prefix"`
+	Durability Durability `json:"-"`
+	// Policy is Durability as ParseDurability reads it.
+	Policy string `json:"policy"`
+}
+
+// ParseDurability reads a sync policy: "async" hands writes to the
+// operating system, and "sync[:window[:size]]" fsyncs them, sharing each
+// fsync with the writes that arrive within window (a Go duration) or
+// until size of them have.
+func ParseDurability(s string) (Durability, error) {
+	fields := strings.Split(s, ":")
+	switch {
+	case s == "async":
+		return Durability{}, nil
+	case fields[0] != "sync" || len(fields) > 3:
+		return Durability{}, fmt.Errorf("unknown sync policy %q; want async or sync[:window[:size]]", s)
+	}
+
+	d := Durability{Sync: true}
+	if len(fields) > 1 {
+		window, err := time.ParseDuration(fields[1])
+		if err != nil || window < 0 {
+			return Durability{}, fmt.Errorf("sync policy %q: bad group commit window", s)
+		}
+		d.GroupWindow = window
+	}
+	if len(fields) > 2 {
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || size < 0 {
+			return Durability{}, fmt.Errorf("sync policy %q: bad group commit size", s)
+		}
+		d.GroupSize = size
+	}
+	return d, nil
+}
+
+// ParsePrefixSyncPolicies reads LOGBASE_PREFIX_SYNC, a comma-separated
+// list of prefix=policy.
+func ParsePrefixSyncPolicies(s string) ([]PrefixSyncPolicy, error) {
+	var policies []PrefixSyncPolicy
+	for part := range strings.SplitSeq(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		prefix, policy, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("prefix sync policy %q is not prefix=policy", part)
+		}
+		d, err := ParseDurability(policy)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, PrefixSyncPolicy{Prefix: prefix, Durability: d, Policy: policy})
+	}
+	return policies, nil
+}
+
+// SetPrefixSyncPolicies replaces the engine's prefix sync policies. A
+// write that sets its own Durability keeps it, though it is still logged
+// to its policy's WAL, and one they cover takes no part in durability
+// experiments. The longest matching prefix wins. A policy's WAL is opened
+// by the first write routed to it, and kept, like any left by a removed
+// policy, until the engine closes.
+func (e *Engine) SetPrefixSyncPolicies(policies []PrefixSyncPolicy) {
+	if len(policies) == 0 {
+		e.prefixSync.Store(nil)
+		return
+	}
+	policies = slices.Clone(policies)
+	slices.SortStableFunc(policies, func(a, b PrefixSyncPolicy) int {
+		return cmp.Compare(len(b.Prefix), len(a.Prefix))
+	})
+	e.prefixSync.Store(&policies)
+}
+
+// PrefixSyncPolicies returns the engine's prefix sync policies, longest
+// prefix first.
+func (e *Engine) PrefixSyncPolicies() []PrefixSyncPolicy {
+	if policies := e.prefixSync.Load(); policies != nil {
+		return slices.Clone(*policies)
+	}
+	return nil
+}
+
+// routeFor returns where to log a write of keys and how durable to make
+// it. The strictest policy that covers any of keys wins, with keys no
+// policy covers counted at the default on the engine's own WAL; a batch
+// spanning policies is logged whole to the winner's WAL, so it still
+// replays all or nothing. d, if set, overrides the winner's durability but
+// not its WAL. The durability is nil, the default or an experiment's, if
+// no policy covers any of keys.
+func (e *Engine) routeFor(d *Durability, keys iter.Seq[[]byte]) writeRoute {
+	policies := e.prefixSync.Load()
+	if policies == nil {
+		return writeRoute{durability: d}
+	}
+
+	var strictest *PrefixSyncPolicy
+	uncovered := false
+	for key := range keys {
+		i := slices.IndexFunc(*policies, func(p PrefixSyncPolicy) bool {
+			return bytes.HasPrefix(key, []byte(p.Prefix))
+		})
+		if i < 0 {
+			uncovered = true
+			continue
+		}
+		if policy := &(*policies)[i]; strictest == nil || stricter(policy.Durability, strictest.Durability) {
+			strictest = policy
+		}
+	}
+	if strictest == nil {
+		return writeRoute{durability: d}
+	}
+	if uncovered && stricter(defaultDurability, strictest.Durability) {
+		return writeRoute{durability: cmp.Or(d, &defaultDurability)}
+	}
+	return writeRoute{prefix: strictest.Prefix, durability: cmp.Or(d, &strictest.Durability)}
+}
+
+// stricter reports whether a makes a write durable sooner than b: it syncs
+// and b does not, or both sync and a waits less for others to join.
+func stricter(a, b Durability) bool {
+	if a.Sync != b.Sync {
+		return a.Sync
+	}
+	return a.Sync && a.GroupWindow < b.GroupWindow
+}
+
+// recordKeys yields the keys of records, for routeFor.
+func recordKeys(records []WALRecord) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		for _, r := range records {
+			if !yield(r.Key) {
+				return
+			}
+		}
+	}
+}
+
+// entryKeys yields the keys of entries, for routeFor.
+func entryKeys[V any](entries map[string]V) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		for k := range entries {
+			if !yield([]byte(k)) {
+				return
+			}
+		}
+	}
+}
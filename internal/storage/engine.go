@@ -1,10 +1,15 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/manjeet13/logbase/internal/config"
 )
@@ -12,26 +17,192 @@ import (
 var MemTableFlushThreshold int // 1MB (small for testing)
 var maxSSTables int
 
+// walDirName is the directory under the data dir holding WAL segments.
+const walDirName = "wal.log"
+
 type Engine struct {
-	wal       *WAL
-	memtable  *MemTable
+	// mu guards the memtable and sstables references; readers take a
+	// consistent view of both under the read lock.
+	mu       profiledRWMutex
+	wal      *WAL
+	memtable *MemTable
+	// immutable are full memtables waiting to be flushed, oldest first.
+	immutable []*frozenMemTable
 	sstables  []*SSTable
-	dataDir   string
-	nextTable int
+	// tablesGen counts changes to sstables; see hotSet.
+	tablesGen uint64
+	// dataDir is the data directory; see Relocate.
+	dataDir atomic.Pointer[string]
+	// nextFile is the next table file number; see newFileNumber.
+	nextFile atomic.Uint64
+
+	// writeMu serializes writers so WAL order, sequence numbers and
+	// memtable order agree.
+	writeMu profiledMutex
+	lastSeq uint64
+	// groupCommit shares WAL fsyncs between concurrent synced writes;
+	// experiment is the durability experiment last started, if any.
+	groupCommit groupCommit
+	experiment  atomic.Pointer[experiment]
+	// prefixWALs are the WALs of prefix sync policies, by prefix; see
+	// SetPrefixSyncPolicies. Added under writeMu and mu.
+	prefixWALs map[string]*prefixWAL
+	// visibleSeq is the newest sequence number readers may observe. It is
+	// advanced only after every entry up to it is in the memtable.
+	visibleSeq atomic.Uint64
+
+	compactionYield atomic.Pointer[func()]
+	breaker         circuitBreaker
+	faults          *FaultInjector
+	quotaWarn       func(QuotaUsage)
+	quotaWarned     bool
+	// compactionPaused holds compaction back; see PauseCompaction.
+	compactionPaused atomic.Bool
+	// compactor runs compactions off the write path.
+	compactor compactor
+	// flusher writes frozen memtables off the write path.
+	flusher flusher
+	// tail feeds committed writes to TailWAL subscribers.
+	tail tailLog
+	// hot holds the keys pinned with PinHot.
+	hot hotSet
+	// transformer, if set, encodes values as they are stored and decodes
+	// them as they are read; see SetValueTransformer.
+	transformer atomic.Pointer[ValueTransformer]
+	// access, if set, records when keys were last used; see
+	// EnableAccessTracking.
+	access atomic.Pointer[accessTracker]
+	// tiering, if set, lets TierCold move cold tables; tierRequested asks
+	// the compaction goroutine to.
+	tiering       atomic.Pointer[TierOptions]
+	tierRequested atomic.Bool
+	// transferLimiter throttles checkpoint transfers to other nodes.
+	transferLimiter *rateLimiter
+	// tableFiles keeps the files of recently read tables open.
+	tableFiles *tableFiles
+
+	txnIDs atomic.Uint64
+	locks  *lockManager
+
+	manifestMu sync.Mutex
+	manifest   *Manifest
+	// manifestSeen is the MANIFEST as of the last checkFence.
+	manifestSeen os.FileInfo
+
+	stats statsState
+
+	// inconsistencies are the problems the startup check found.
+	inconsistencies []Inconsistency
+
+	// secondary engines follow another process's data directory and
+	// never write to it.
+	secondary bool
+	// fenced is set once a newer primary has taken over; see Promote.
+	fenced atomic.Bool
+	// maintenance, if set, rejects writes; see SetReadOnly.
+	maintenance atomic.Pointer[ReadOnlyMode]
+	// prefixSync, if set, holds the sync policies of key prefixes,
+	// longest first; see SetPrefixSyncPolicies.
+	prefixSync atomic.Pointer[[]PrefixSyncPolicy]
 }
 
 func NewEngineWithConfig(cfg *config.Config) (*Engine, error) {
 	// wire config values into package-level vars
 	MemTableFlushThreshold = cfg.MemTableFlushSize
 	maxSSTables = cfg.MaxSSTablesBeforeComp
+	garbageRewriteRatio = float64(cfg.GarbageRewritePercent) / 100
+	compactionParallelism = cfg.CompactionParallelism
+	compactionTargetFileSize = cfg.CompactionTargetFileSize
+	indexInterval = cfg.IndexInterval
+	adaptiveIndex = cfg.IndexAdaptive
+	ioErrorThreshold = cfg.IOErrorThreshold
+	mode, err := ParseCheckMode(cfg.StartupCheck)
+	if err != nil {
+		return nil, err
+	}
+	startupCheck = mode
+	quotaBytes = cfg.QuotaBytes
+	quotaWarnPercent = cfg.QuotaWarnPercent
+	transferBytesPerSec = cfg.TransferBytesPerSec
+	quotaLimit.Set(quotaBytes)
+	hotSetMaxBytes = cfg.HotMaxBytes
+	maxOpenTables = cfg.MaxOpenTables
+	memtableFilter = cfg.MemTableFilter
+	SetLockProfileRate(cfg.LockProfileRate)
+	sstableBlockSize = cfg.SSTableBlockSize
+	if cfg.BloomFPR <= 0 || cfg.BloomFPR >= 1 {
+		return nil, fmt.Errorf("bloom false-positive rate %v is not between 0 and 1", cfg.BloomFPR)
+	}
+	bloomFPR = cfg.BloomFPR
+	if err := SetBlockCompression(cfg.SSTableCompression); err != nil {
+		return nil, err
+	}
+	SetBlockCacheSize(cfg.BlockCacheBytes)
+	defaultDurability = Durability{
+		Sync:        cfg.WALSync,
+		GroupWindow: time.Duration(cfg.GroupCommitWindowUs) * time.Microsecond,
+		GroupSize:   cfg.GroupCommitSize,
+	}
 
-	return NewEngine(cfg.DataDir)
+	open := NewEngine
+	if cfg.Standby {
+		open = OpenSecondary
+	}
+	engine, err := open(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ValueTransformer != "" {
+		t, err := LookupValueTransformer(cfg.ValueTransformer)
+		if err != nil {
+			engine.Close()
+			return nil, fmt.Errorf("LOGBASE_VALUE_TRANSFORMER: %w", err)
+		}
+		engine.SetValueTransformer(t)
+	}
+	if cfg.AccessTracking {
+		if err := engine.EnableAccessTracking(AccessOptions{SampleRate: cfg.AccessSampleRate}); err != nil {
+			engine.Close()
+			return nil, fmt.Errorf("access tracking: %w", err)
+		}
+	}
+	if cfg.ColdDir != "" {
+		opts := TierOptions{
+			Dir:          cfg.ColdDir,
+			MinAge:       time.Duration(cfg.ColdAgeDays) * 24 * time.Hour,
+			ColdFraction: float64(cfg.ColdPercent) / 100,
+		}
+		if err := engine.EnableTiering(opts); err != nil {
+			engine.Close()
+			return nil, fmt.Errorf("LOGBASE_COLD_DIR: %w", err)
+		}
+	}
+	for _, pattern := range cfg.HotKeyPatterns() {
+		if err := engine.PinHot(pattern); err != nil {
+			engine.Close()
+			return nil, fmt.Errorf("LOGBASE_HOT_KEYS: %w", err)
+		}
+	}
+	policies, err := ParsePrefixSyncPolicies(cfg.PrefixSyncPolicies)
+	if err != nil {
+		engine.Close()
+		return nil, fmt.Errorf("LOGBASE_PREFIX_SYNC: %w", err)
+	}
+	engine.SetPrefixSyncPolicies(policies)
+	return engine, nil
 }
 
 func NewEngine(dataDir string) (*Engine, error) {
 	os.MkdirAll(dataDir, 0755)
 
-	wal, err := OpenWAL(filepath.Join(dataDir, "wal.log"))
+	if _, err := Upgrade(dataDir); err != nil {
+		return nil, err
+	}
+	inconsistencies, err := CheckDataDir(dataDir, startupCheck)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(dataDir)
 	if err != nil {
 		return nil, err
 	}
@@ -39,188 +210,1030 @@ func NewEngine(dataDir string) (*Engine, error) {
 	memtable := NewMemTable()
 
 	engine := &Engine{
-		wal:      wal,
+		mu:       profiledRWMutex{stats: engineLock, readStats: engineReadLock},
+		writeMu:  profiledMutex{stats: writerLock},
 		memtable: memtable,
-		dataDir:  dataDir,
+		manifest: manifest,
+		locks:    newLockManager(),
+		tail:     tailLog{retention: defaultTailRetention},
+
+		transferLimiter: newRateLimiter(transferBytesPerSec),
+		tableFiles:      newTableFiles(maxOpenTables),
+		inconsistencies: inconsistencies,
+	}
+	engine.dataDir.Store(&dataDir)
+
+	// New segments are in the checksummed format, with sequence numbers
+	// and batch records. Tables written from now on, by compaction too,
+	// flag their tombstones, store their records in blocks and embed their
+	// bloom filters.
+	if err := engine.requireFeature(FeatureWALChecksum); err != nil {
+		return nil, err
+	}
+	if err := engine.requireFeature(FeatureSeqNo); err != nil {
+		return nil, err
+	}
+	if err := engine.requireFeature(FeatureWALBatch); err != nil {
+		return nil, err
+	}
+	if err := engine.requireFeature(FeatureTombstoneFlag); err != nil {
+		return nil, err
+	}
+	if err := engine.requireFeature(FeatureBlocks); err != nil {
+		return nil, err
+	}
+	if err := engine.requireFeature(FeatureEmbeddedBloom); err != nil {
+		return nil, err
+	}
+	wal, err := OpenWAL(filepath.Join(dataDir, walDirName))
+	if err != nil {
+		return nil, err
+	}
+	engine.wal = wal
+	if engine.prefixWALs, err = openPrefixWALs(filepath.Join(dataDir, walDirName), nil); err != nil {
+		return nil, err
+	}
+
+	if err := engine.loadSSTables(); err != nil {
+		return nil, err
 	}
 
-	engine.loadSSTables()
+	// Stale or unreadable statistics are replaced on the next refresh
+	engine.stats.current, _ = loadStats(dataDir)
 
-	records, err := wal.Replay()
+	records, err := replayWALs(wal, engine.prefixWALs)
 	if err != nil {
 		return nil, err
 	}
 
+	// Sequence numbers carry on from the newest write, which is in the
+	// WAL or, once flushed, recorded in the MANIFEST
+	engine.lastSeq = engine.manifest.LastSeq
 	for _, r := range records {
-		if r.Type == PutRecord {
-			memtable.Put(r.Key, r.Value)
-		} else {
-			memtable.Delete(r.Key)
+		seq := replaySeq(r, engine.lastSeq)
+		switch r.Type {
+		case PutRecord:
+			memtable.PutEntry(r.Key, seq, Entry{Value: r.Value})
+		case PutMetaRecord:
+			memtable.PutEntry(r.Key, seq, Entry{Value: r.Value, Meta: r.Meta})
+		default:
+			memtable.Delete(r.Key, seq)
 		}
+		engine.lastSeq = max(engine.lastSeq, seq)
 	}
+	engine.visibleSeq.Store(engine.lastSeq)
+	engine.startCompactor()
+	engine.startFlusher()
 
 	return engine, nil
 }
 
+// replaySeq returns the sequence number a replayed record was applied at.
+// Records from before sequence numbers were logged take the next one.
+func replaySeq(r WALRecord, last uint64) uint64 {
+	if r.Seq != 0 {
+		return r.Seq
+	}
+	return last + 1
+}
+
 func (e *Engine) Put(key, value []byte) error {
-	if err := e.wal.AppendPut(key, value); err != nil {
+	_, err := e.PutWithOptions(key, value, WriteOptions{})
+	return err
+}
+
+// PutWithMetadata stores value together with meta. An empty meta behaves
+// exactly like Put.
+func (e *Engine) PutWithMetadata(key, value []byte, meta Metadata) error {
+	_, err := e.PutWithOptions(key, value, WriteOptions{Meta: meta})
+	return err
+}
+
+// ErrSeqMismatch means a key was not at the sequence number a write
+// expected; see WriteOptions.IfSeq.
+var ErrSeqMismatch = errors.New("sequence number does not match")
+
+// WriteOptions adjust a single-key write.
+type WriteOptions struct {
+	// Meta is stored with a put's value. Deletes ignore it.
+	Meta Metadata
+	// IfSeq, if set, is the sequence number of the write the key's current
+	// value must come from, as returned by GetVersioned; 0 means the key
+	// must not exist. Values older than stored sequence numbers never
+	// match. The check and the write happen under the writer lock.
+	IfSeq *uint64
+	// TTL, if positive, makes a put expire: PurgeExpired deletes the value
+	// once TTL has passed, unless it was overwritten meanwhile. Deletes
+	// ignore it.
+	TTL time.Duration
+	// Durability, if set, is how durable the write is made before it
+	// returns; nil means the engine's default, or a running experiment's.
+	Durability *Durability
+}
+
+// PutWithOptions is Put with options. It returns the sequence number the
+// write was applied at.
+func (e *Engine) PutWithOptions(key, value []byte, opts WriteOptions) (seq uint64, err error) {
+	if err := checkKeys(slices.Values([][]byte{key})); err != nil {
+		return 0, err
+	}
+	route := e.routeFor(opts.Durability, slices.Values([][]byte{key}))
+	err = e.commit(route, func() error {
+		seq, err = e.put(key, value, opts, route)
 		return err
+	})
+	return seq, err
+}
+
+// put is PutWithOptions short of making the write durable. It logs to
+// route's WAL.
+func (e *Engine) put(key, value []byte, opts WriteOptions, route writeRoute) (uint64, error) {
+	encoded, err := opts.Meta.Encode()
+	if err != nil {
+		return 0, err
+	}
+	if opts.TTL > 0 {
+		return e.putExpiring(key, value, encoded, opts, route)
+	}
+	stored, err := e.encodeValue(key, value)
+	if err != nil {
+		return 0, err
 	}
 
-	e.memtable.Put(key, value)
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if err := e.checkWrite(); err != nil {
+		return 0, err
+	}
+	if err := e.checkSeq(key, opts.IfSeq); err != nil {
+		return 0, err
+	}
+	if err := e.checkQuota(len(key) + len(stored) + len(encoded)); err != nil {
+		return 0, err
+	}
 
-	if e.memtable.Size() >= MemTableFlushThreshold {
-		return e.flushMemTable()
+	wal, err := e.walFor(route)
+	if err != nil {
+		return 0, err
 	}
 
+	// The sequence number is only used up once the write is logged
+	r := WALRecord{Type: PutRecord, Seq: e.lastSeq + 1, Key: key, Value: value}
+	if encoded == nil {
+		err = wal.AppendPut(r.Seq, key, stored)
+	} else {
+		r.Type, r.Meta = PutMetaRecord, encoded
+		err = wal.AppendPutMeta(r.Seq, key, stored, encoded)
+	}
+	if err := e.breaker.recordWrite(err); err != nil {
+		return 0, err
+	}
+
+	seq := e.nextSeq()
+	e.memtable.PutEntry(key, seq, Entry{Value: stored, Meta: encoded})
+	e.publish()
+	e.tail.publish(r)
+	e.recordWrites(r)
+
+	return seq, e.maybeFlush()
+}
+
+// checkSeq fails with ErrSeqMismatch unless want is nil or key's current
+// value matches it, as described for WriteOptions.IfSeq. Callers hold
+// writeMu.
+func (e *Engine) checkSeq(key []byte, want *uint64) error {
+	if want == nil {
+		return nil
+	}
+
+	entry, ok := e.getEntry(key)
+	switch {
+	case !ok && *want == 0:
+		return nil
+	case !ok:
+		return fmt.Errorf("%w: %q does not exist", ErrSeqMismatch, key)
+	case *want == 0:
+		return fmt.Errorf("%w: %q exists", ErrSeqMismatch, key)
+	case entry.Seq != *want:
+		return fmt.Errorf("%w: %q is at %d, not %d", ErrSeqMismatch, key, entry.Seq, *want)
+	}
+	return nil
+}
+
+// checkWrite fails writes on secondaries, fenced engines, an engine
+// read-only for maintenance and a degraded engine.
+func (e *Engine) checkWrite() error {
+	if e.secondary {
+		return ErrSecondary
+	}
+	if err := e.checkFence(); err != nil {
+		return err
+	}
+	if err := e.checkMaintenance(); err != nil {
+		return err
+	}
+	return e.breaker.checkWrite()
+}
+
+// nextSeq allocates a sequence number. Callers hold writeMu.
+func (e *Engine) nextSeq() uint64 {
+	e.lastSeq++
+	return e.lastSeq
+}
+
+// publish makes every allocated sequence number visible to readers.
+// Callers hold writeMu.
+func (e *Engine) publish() {
+	e.visibleSeq.Store(e.lastSeq)
+}
+
+// maybeFlush freezes the memtable once it is over the threshold, for the
+// flusher to write out. If too many are already waiting, the write waits
+// for them. Callers hold writeMu.
+func (e *Engine) maybeFlush() error {
+	if e.memtable.Size() < MemTableFlushThreshold {
+		return nil
+	}
+	if err := e.breaker.recordWrite(e.freezeMemTable()); err != nil {
+		return err
+	}
+	if e.frozenCount() > maxFrozenMemTables {
+		return e.waitForFlushes()
+	}
 	return nil
 }
 
+// readView is a consistent set of memtables, tables and sequence number
+// that a read is evaluated against.
+type readView struct {
+	memtable  *MemTable
+	immutable []*frozenMemTable
+	tables    []*SSTable
+	seq       uint64
+	// gen is the table generation of tables.
+	gen uint64
+	// limiter, if set, throttles SSTable reads made through this view.
+	limiter *rateLimiter
+	// noCache keeps point lookups through this view out of the block
+	// cache.
+	noCache bool
+}
+
+func (e *Engine) view() readView {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return readView{memtable: e.memtable, immutable: e.immutable, tables: e.sstables, seq: e.visibleSeq.Load(), gen: e.tablesGen}
+}
+
+// memtableGet looks key up in the active memtable, then in the frozen ones
+// from newest to oldest. ok is false if none of them has it.
+func (v readView) memtableGet(key []byte) (entry Entry, deleted, ok bool) {
+	if entry, deleted, ok := v.memtable.GetAt(key, v.seq); ok {
+		return entry, deleted, true
+	}
+	for i := len(v.immutable) - 1; i >= 0; i-- {
+		if entry, deleted, ok := v.immutable[i].mem.GetAt(key, v.seq); ok {
+			return entry, deleted, true
+		}
+	}
+	return Entry{}, false, false
+}
+
 func (e *Engine) Get(key []byte) ([]byte, bool) {
-	if val, ok := e.memtable.Get(key); ok {
-		return val, true
+	entry, ok := e.getEntry(key)
+	if ok {
+		e.recordRead(key)
 	}
+	return entry.Value, ok
+}
 
-	for i := len(e.sstables) - 1; i >= 0; i-- {
-		table := e.sstables[i]
+// GetWithMetadata is Get that also returns the metadata stored with the value.
+func (e *Engine) GetWithMetadata(key []byte) ([]byte, Metadata, bool) {
+	value, meta, _, ok := e.GetVersioned(key)
+	return value, meta, ok
+}
 
-		if table.Bloom != nil && !table.Bloom.MightContain(key) {
-			continue // definitely not here
+// GetVersioned is GetWithMetadata that also returns the sequence number of
+// the write that stored the value, for use with WriteOptions.IfSeq. It is
+// 0 for a value written before sequence numbers were stored.
+func (e *Engine) GetVersioned(key []byte) ([]byte, Metadata, uint64, bool) {
+	entry, ok := e.getEntry(key)
+	if !ok {
+		return nil, Metadata{}, 0, false
+	}
+	e.recordRead(key)
+	meta, _ := DecodeMetadata(entry.Meta)
+	return entry.Value, meta, entry.Seq, true
+}
+
+func (e *Engine) getEntry(key []byte) (Entry, bool) {
+	return e.getEntryIn(e.view(), key)
+}
+
+// getEntryIn looks key up in v and decodes its value. A value that fails
+// to decode is logged and reported as missing.
+func (e *Engine) getEntryIn(v readView, key []byte) (Entry, bool) {
+	entry, ok := e.getStoredIn(v, key)
+	if !ok {
+		return Entry{}, false
+	}
+	entry, err := e.decodeEntry(key, entry)
+	if err != nil {
+		transformLog.Errorf("%v", err)
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// getStoredIn is getEntryIn returning the value as stored.
+func (e *Engine) getStoredIn(v readView, key []byte) (Entry, bool) {
+	if entry, deleted, ok := v.memtableGet(key); ok {
+		return entry, !deleted
+	}
+	if entry, found, ok := e.hot.get(key, v.gen); ok {
+		return entry, found && !entry.Deleted
+	}
+
+	for i := len(v.tables) - 1; i >= 0; i-- {
+		table := v.tables[i]
+
+		if !table.mightContain(key) {
+			continue
 		}
 
-		if val, ok, _ := table.Get(key); ok {
-			return val, true
+		entry, ok, err := table.getEntry(key, rangeScan{limiter: v.limiter, files: e.tableFiles, noCache: v.noCache})
+		if err == nil && !ok {
+			table.bloomMiss()
+		}
+		if err == nil {
+			err = e.faults.inject(FaultSSTableRead)
+			ok = ok && err == nil
+		}
+		e.breaker.recordRead(err)
+		if ok {
+			table.countTierRead()
+			return entry, !entry.Deleted
 		}
 	}
 
-	return nil, false
+	return Entry{}, false
+}
+
+// GetRange returns n bytes of the value for key starting at off, along with
+// the value's total size. See SSTable.GetRange for the off/n conventions.
+func (e *Engine) GetRange(key []byte, off, n int64) ([]byte, int64, bool) {
+	val, size, ok := e.getRange(key, off, n)
+	if ok {
+		e.recordRead(key)
+	}
+	return val, size, ok
+}
+
+// getRange is GetRange without recording the read.
+func (e *Engine) getRange(key []byte, off, n int64) ([]byte, int64, bool) {
+	if e.transformer.Load() != nil {
+		// Offsets are into the decoded value, so all of it is read
+		entry, ok := e.getEntry(key)
+		if !ok {
+			return nil, 0, false
+		}
+		size := int64(len(entry.Value))
+		off, n = clampRange(off, n, size)
+		return entry.Value[off : off+n], size, true
+	}
+
+	v := e.view()
+	sstables := v.tables
+
+	if entry, deleted, ok := v.memtableGet(key); ok {
+		if deleted {
+			return nil, 0, false
+		}
+		val := entry.Value
+		size := int64(len(val))
+		off, n = clampRange(off, n, size)
+		return val[off : off+n], size, true
+	}
+
+	for i := len(sstables) - 1; i >= 0; i-- {
+		table := sstables[i]
+
+		if !table.mightContain(key) {
+			continue
+		}
+
+		val, size, deleted, ok, err := table.getRange(key, off, n)
+		if err == nil && !ok {
+			table.bloomMiss()
+		}
+		if err == nil {
+			err = e.faults.inject(FaultSSTableRead)
+			ok = ok && err == nil
+		}
+		e.breaker.recordRead(err)
+		if ok {
+			table.countTierRead()
+			return val, size, !deleted
+		}
+	}
+
+	return nil, 0, false
 }
 
 func (e *Engine) Delete(key []byte) error {
-	// 1️⃣ Write delete to WAL
-	if err := e.wal.AppendDelete(key); err != nil {
+	_, err := e.DeleteWithOptions(key, WriteOptions{})
+	return err
+}
+
+// DeleteWithOptions is Delete with options. It returns the sequence number
+// the delete was applied at.
+func (e *Engine) DeleteWithOptions(key []byte, opts WriteOptions) (seq uint64, err error) {
+	if err := checkKeys(slices.Values([][]byte{key})); err != nil {
+		return 0, err
+	}
+	route := e.routeFor(opts.Durability, slices.Values([][]byte{key}))
+	err = e.commit(route, func() error {
+		seq, err = e.deleteKey(key, opts, route)
 		return err
+	})
+	return seq, err
+}
+
+// deleteKey is DeleteWithOptions short of making the delete durable. It
+// logs to route's WAL.
+func (e *Engine) deleteKey(key []byte, opts WriteOptions, route writeRoute) (uint64, error) {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if err := e.checkWrite(); err != nil {
+		return 0, err
+	}
+	if err := e.checkSeq(key, opts.IfSeq); err != nil {
+		return 0, err
+	}
+
+	wal, err := e.walFor(route)
+	if err != nil {
+		return 0, err
+	}
+
+	// 1️⃣ Write delete to WAL
+	r := WALRecord{Type: DeleteRecord, Seq: e.lastSeq + 1, Key: key}
+	if err := e.breaker.recordWrite(wal.AppendDelete(r.Seq, key)); err != nil {
+		return 0, err
 	}
 
 	// 2️⃣ Insert tombstone into MemTable
-	e.memtable.Delete(key)
+	seq := e.nextSeq()
+	e.memtable.Delete(key, seq)
+	e.publish()
+	e.tail.publish(r)
+	e.recordWrites(r)
 
 	// 3️⃣ Flush if needed
-	if e.memtable.Size() >= MemTableFlushThreshold {
-		return e.flushMemTable()
+	return seq, e.maybeFlush()
+}
+
+// BatchPut applies entries atomically with respect to readers: the batch's
+// sequence numbers are published only once every entry is in the memtable,
+// so a reader sees either none or all of it.
+func (e *Engine) BatchPut(entries map[string][]byte) error {
+	if err := checkKeys(entryKeys(entries)); err != nil {
+		return err
+	}
+	route := e.routeFor(nil, entryKeys(entries))
+	return e.commit(route, func() error {
+		e.writeMu.Lock()
+		defer e.writeMu.Unlock()
+
+		return e.batchPut(entries, route)
+	})
+}
+
+// Guard is a condition on one key that a conditional batch checks before
+// applying.
+type Guard struct {
+	Key []byte
+	// Value is the value Key must currently hold; nil means Key must not
+	// exist.
+	Value []byte
+}
+
+var ErrGuardFailed = errors.New("batch guard does not hold")
+
+// BatchPutIf is BatchPut that applies entries only if guard holds.
+func (e *Engine) BatchPutIf(guard Guard, entries map[string][]byte) error {
+	_, err := e.BatchPutWithOptions(entries, BatchOptions{Guard: &guard})
+	return err
+}
+
+// BatchOptions adjust a batch write.
+type BatchOptions struct {
+	// Guard, if set, must hold for the batch to apply. The check and the
+	// write happen under the writer lock, so no other write can change the
+	// guard key in between.
+	Guard *Guard
+	// ReturnPrevious asks for each touched key's value before the batch.
+	ReturnPrevious bool
+	// Durability is as for WriteOptions.Durability.
+	Durability *Durability
+}
+
+// BatchResult is the outcome of a batch write.
+type BatchResult struct {
+	// Previous holds, with ReturnPrevious, the prior value of every key
+	// that existed; keys that did not exist are absent.
+	Previous map[string][]byte
+	// Seq is the sequence number of the batch's last write.
+	Seq uint64
+}
+
+// BatchPutWithOptions is BatchPut with options.
+func (e *Engine) BatchPutWithOptions(entries map[string][]byte, opts BatchOptions) (result BatchResult, err error) {
+	if err := checkKeys(entryKeys(entries)); err != nil {
+		return BatchResult{}, err
+	}
+	route := e.routeFor(opts.Durability, entryKeys(entries))
+	err = e.commit(route, func() error {
+		result, err = e.batchPutWithOptions(entries, opts, route)
+		return err
+	})
+	return result, err
+}
+
+// batchPutWithOptions is BatchPutWithOptions short of making the batch
+// durable. It logs to route's WAL.
+func (e *Engine) batchPutWithOptions(entries map[string][]byte, opts BatchOptions, route writeRoute) (BatchResult, error) {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if err := e.checkGuard(opts.Guard); err != nil {
+		return BatchResult{}, err
 	}
 
+	var result BatchResult
+	if opts.ReturnPrevious {
+		result.Previous = make(map[string][]byte, len(entries))
+		for k := range entries {
+			if entry, ok := e.getEntry([]byte(k)); ok {
+				result.Previous[k] = entry.Value
+			}
+		}
+	}
+
+	err := e.batchPut(entries, route)
+	result.Seq = e.lastSeq
+	return result, err
+}
+
+// checkGuard fails unless g is nil or holds. Callers hold writeMu.
+func (e *Engine) checkGuard(g *Guard) error {
+	if g == nil {
+		return nil
+	}
+
+	current, ok := e.getEntry(g.Key)
+	if g.Value == nil && ok {
+		return fmt.Errorf("%w: %q exists", ErrGuardFailed, g.Key)
+	}
+	if g.Value != nil && (!ok || !bytes.Equal(current.Value, g.Value)) {
+		return fmt.Errorf("%w: %q does not hold the expected value", ErrGuardFailed, g.Key)
+	}
 	return nil
 }
 
-func (e *Engine) BatchPut(entries map[string][]byte) error {
-	// 1️⃣ Append all entries to WAL
-	if err := e.wal.AppendBatch(entries); err != nil {
+// batchPut is BatchPut for callers that hold writeMu. It logs to route's
+// WAL.
+func (e *Engine) batchPut(entries map[string][]byte, route writeRoute) error {
+	if err := e.checkWrite(); err != nil {
+		return err
+	}
+	size := 0
+	for k, v := range entries {
+		size += len(k) + len(v)
+	}
+	if err := e.checkQuota(size); err != nil {
 		return err
 	}
 
-	// 2️⃣ Apply to MemTable
+	records := make([]WALRecord, 0, len(entries))
 	for k, v := range entries {
-		e.memtable.Put([]byte(k), v)
+		seq := e.lastSeq + uint64(len(records)) + 1
+		records = append(records, WALRecord{Type: PutRecord, Seq: seq, Key: []byte(k), Value: v})
+	}
+
+	stored, err := e.encodeRecords(records)
+	if err != nil {
+		return err
+	}
+	wal, err := e.walFor(route)
+	if err != nil {
+		return err
+	}
+
+	// 1️⃣ Append all entries to WAL
+	if err := e.breaker.recordWrite(wal.AppendRecords(stored)); err != nil {
+		return err
 	}
 
+	// 2️⃣ Apply to MemTable, then publish the whole batch at once
+	for _, r := range stored {
+		e.memtable.PutEntry(r.Key, e.nextSeq(), Entry{Value: r.Value})
+	}
+	e.publish()
+	e.tail.publish(records...)
+	e.recordWrites(records...)
+
 	// 3️⃣ Flush if needed
-	if e.memtable.Size() >= MemTableFlushThreshold {
-		return e.flushMemTable()
+	return e.maybeFlush()
+}
+
+// BatchDelete deletes keys atomically with respect to readers, like
+// BatchPut.
+func (e *Engine) BatchDelete(keys [][]byte) error {
+	_, err := e.BatchDeleteWithOptions(keys, BatchOptions{})
+	return err
+}
+
+// BatchDeleteWithOptions is BatchDelete with options; see
+// BatchPutWithOptions.
+func (e *Engine) BatchDeleteWithOptions(keys [][]byte, opts BatchOptions) (result BatchResult, err error) {
+	if err := checkKeys(slices.Values(keys)); err != nil {
+		return BatchResult{}, err
 	}
+	route := e.routeFor(opts.Durability, slices.Values(keys))
+	err = e.commit(route, func() error {
+		result, err = e.batchDelete(keys, opts, route)
+		return err
+	})
+	return result, err
+}
 
-	return nil
+// batchDelete is BatchDeleteWithOptions short of making the batch durable.
+// It logs to route's WAL.
+func (e *Engine) batchDelete(keys [][]byte, opts BatchOptions, route writeRoute) (BatchResult, error) {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if err := e.checkGuard(opts.Guard); err != nil {
+		return BatchResult{}, err
+	}
+
+	var result BatchResult
+	if opts.ReturnPrevious {
+		result.Previous = make(map[string][]byte, len(keys))
+	}
+
+	records := make([]WALRecord, len(keys))
+	for i, k := range keys {
+		records[i] = WALRecord{Type: DeleteRecord, Key: k}
+		if result.Previous != nil {
+			if entry, ok := e.getEntry(k); ok {
+				result.Previous[string(k)] = entry.Value
+			}
+		}
+	}
+
+	err := e.applyRecordsLocked(records, route)
+	result.Seq = e.lastSeq
+	return result, err
 }
 
-func (e *Engine) flushMemTable() error {
-	snapshot := e.memtable.Snapshot()
-	if len(snapshot) == 0 {
-		return nil
+// applyRecords writes a mix of puts and deletes to the WAL and memtable,
+// publishing them to readers together. It is the write path of
+// transactions and CopyRange, so their keys are checked here.
+func (e *Engine) applyRecords(records []WALRecord) error {
+	if err := checkKeys(recordKeys(records)); err != nil {
+		return err
+	}
+	route := e.routeFor(nil, recordKeys(records))
+	return e.commit(route, func() error {
+		e.writeMu.Lock()
+		defer e.writeMu.Unlock()
+
+		return e.applyRecordsLocked(records, route)
+	})
+}
+
+// applyRecordsLocked is applyRecords for callers that hold writeMu, logging
+// to route's WAL. The records' sequence numbers are assigned here.
+func (e *Engine) applyRecordsLocked(records []WALRecord, route writeRoute) error {
+	if err := e.checkWrite(); err != nil {
+		return err
+	}
+	if err := e.checkQuota(recordsSize(records)); err != nil {
+		return err
 	}
 
-	path := fmt.Sprintf("%s/sst_%06d.dat", e.dataDir, e.nextTable)
-	table, err := WriteSSTable(path, snapshot)
+	committed := make([]WALRecord, len(records))
+	for i, r := range records {
+		r.Seq = e.lastSeq + uint64(i) + 1
+		committed[i] = r
+	}
+	stored, err := e.encodeRecords(committed)
+	if err != nil {
+		return err
+	}
+	wal, err := e.walFor(route)
 	if err != nil {
 		return err
 	}
+	if err := e.breaker.recordWrite(wal.AppendRecords(stored)); err != nil {
+		return err
+	}
 
-	e.sstables = append(e.sstables, table)
-	e.nextTable++
-	e.memtable = NewMemTable()
+	for _, r := range stored {
+		seq := e.nextSeq()
+		if r.Type == DeleteRecord {
+			e.memtable.Delete(r.Key, seq)
+		} else {
+			e.memtable.PutEntry(r.Key, seq, Entry{Value: r.Value, Meta: r.Meta})
+		}
+	}
+	e.publish()
+	e.tail.publish(committed...)
+	e.recordWrites(committed...)
 
-	if err := e.wal.Rotate(); err != nil {
+	return e.maybeFlush()
+}
+
+// flushMemTable freezes the memtable and waits until it and any frozen
+// before it are written to SSTables. Callers hold writeMu.
+func (e *Engine) flushMemTable() error {
+	if err := e.freezeMemTable(); err != nil {
 		return err
 	}
-	e.wal.Truncate(e.wal.segment - 1)
-	if err := e.maybeCompact(); err != nil {
-		return err
+	return e.waitForFlushes()
+}
+
+// loadSSTables opens the tables the MANIFEST lists and removes table files
+// it does not, which a crash left behind. A directory whose MANIFEST lists
+// no tables yet has every table file loaded, and the list started.
+func (e *Engine) loadSSTables() error {
+	live, files := liveTables(e.DataDir(), e.manifest)
+	e.initFileNumbers(files)
+	if _, listed := e.manifest.listedTables(); listed {
+		removeUnlisted(files, live)
+	}
+
+	cache := readIndexCache(e.DataDir())
+	cached := 0
+
+	for _, rec := range live {
+		f := filepath.Join(e.DataDir(), rec.File)
+		table := &SSTable{Path: f, level: rec.Level, maxSeq: rec.MaxSeq}
+		table.detectTier()
+		c, ok := cache[filepath.Base(f)]
+		if table.loadFromCache(c, ok) {
+			cached++
+		} else {
+			if err := table.LoadIndex(); err != nil {
+				cacheLog.Warnf("%s: loading index: %v", filepath.Base(f), err)
+			} else {
+				table.loadBloom()
+			}
+			cacheLog.Debugf("%s: loaded %d index entries for %d keys", filepath.Base(f), len(table.Index), table.entries)
+		}
+		e.sstables = append(e.sstables, table)
+	}
+
+	if len(live) > 0 {
+		cacheLog.Infof("loaded %d of %d tables from %s", cached, len(live), indexCacheName)
+	}
+	if cached != len(live) || len(cache) != len(live) {
+		e.saveIndexCache()
 	}
 
+	if _, listed := e.manifest.listedTables(); !listed {
+		return e.recordTables()
+	}
 	return nil
 }
 
-func (e *Engine) loadSSTables() {
-	files, _ := filepath.Glob(filepath.Join(e.dataDir, "sst_*.dat"))
-	sort.Strings(files)
+// liveTables lists dir's live tables, oldest to newest: those m lists or,
+// if it lists none, every table file in file number order. files are all
+// the table files in dir.
+func liveTables(dir string, m *Manifest) (live []ManifestTable, files []string) {
+	files, _ = filepath.Glob(filepath.Join(dir, "sst_*.dat"))
+	if listed, ok := m.listedTables(); ok {
+		return listed, files
+	}
 
+	sortTables(files)
+	live = make([]ManifestTable, len(files))
+	for i, f := range files {
+		live[i] = ManifestTable{File: filepath.Base(f)}
+	}
+	return live, files
+}
+
+// removeUnlisted removes the table files that are not live: the outputs of
+// a flush or compaction cut short, or the inputs of one that finished.
+func removeUnlisted(files []string, live []ManifestTable) {
+	listed := make(map[string]bool, len(live))
+	for _, t := range live {
+		listed[t.File] = true
+	}
 	for _, f := range files {
-		bf, _ := LoadBloomFilter(f + ".bloom")
-		table := &SSTable{
-			Path:  f,
-			Bloom: bf,
+		if !listed[filepath.Base(f)] {
+			compactionLog.Warnf("removing %s: not in %s", filepath.Base(f), manifestName)
+			(&SSTable{Path: f}).remove()
 		}
-		table.LoadIndex()
-		e.sstables = append(e.sstables, table)
-		e.nextTable++
+	}
+}
+
+// saveIndexCache records the current tables in the index cache. A
+// secondary never writes to the data directory.
+func (e *Engine) saveIndexCache() {
+	if e.secondary {
+		return
+	}
+	if err := writeIndexCache(e.DataDir(), e.tables()); err != nil {
+		cacheLog.Warnf("writing %s: %v", indexCacheName, err)
 	}
 }
 
 func (e *Engine) ReadKeyRange(start, end []byte) (map[string][]byte, error) {
-	result := make(map[string][]byte)
+	return e.readKeyRangeIn(e.view(), start, end)
+}
 
-	// 1. MemTable
-	for k, v := range e.memtable.Range(start, end) {
-		result[k] = v
+func (e *Engine) readKeyRangeIn(rv readView, start, end []byte) (map[string][]byte, error) {
+	result, _, err := e.readRangeIn(rv, closedRange(start, end), RangeOptions{})
+	return result, err
+}
+
+func (e *Engine) readRangeIn(rv readView, bounds IterOptions, opts RangeOptions) (map[string][]byte, RangePlan, error) {
+	entries, plan, err := e.readEntriesIn(rv, bounds, opts)
+	if err != nil {
+		return nil, plan, err
 	}
 
-	// 2. SSTables (newest → oldest)
-	for i := len(e.sstables) - 1; i >= 0; i-- {
-		data, err := e.sstables[i].Range(start, end)
-		if err != nil {
-			return nil, err
+	result := make(map[string][]byte, len(entries))
+	for k, entry := range entries {
+		if opts.KeysOnly {
+			result[k] = nil
+		} else {
+			result[k] = entry.Value
 		}
-		for k, v := range data {
-			if _, exists := result[k]; !exists {
-				result[k] = v
+	}
+	return result, plan, nil
+}
+
+// readEntriesIn returns the live entries within bounds, with metadata and
+// decoded values.
+func (e *Engine) readEntriesIn(rv readView, bounds IterOptions, opts RangeOptions) (map[string]Entry, RangePlan, error) {
+	plan := planRange(rv.tables, bounds, opts)
+	merged, err := e.mergeRange(rv, bounds, plan, opts)
+	if err != nil {
+		return nil, plan, err
+	}
+	defer merged.Close()
+
+	result := make(map[string]Entry)
+	for merged.Next() {
+		result[string(merged.Key())] = merged.Entry()
+	}
+	if err := e.breaker.recordRead(merged.Err()); err != nil {
+		return nil, plan, err
+	}
+
+	if !opts.KeysOnly && e.transformer.Load() != nil {
+		for k, entry := range result {
+			decoded, err := e.decodeEntry([]byte(k), entry)
+			if err != nil {
+				return nil, plan, err
 			}
+			result[k] = decoded
 		}
 	}
+	return result, plan, nil
+}
 
-	// 3. Remove tombstones
-	for k, v := range result {
-		if len(v) == 0 {
-			delete(result, k)
-		}
+// mergeRange opens the sources of a read within bounds over rv, as
+// planned, and merges them into the live entries, with stored values. The
+// expiry index is left out, whatever the bounds.
+func (e *Engine) mergeRange(rv readView, bounds IterOptions, plan RangePlan, opts RangeOptions) (*mergingIterator, error) {
+	if state, cause := e.breaker.status(); state == Unavailable {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, cause)
 	}
 
-	return result, nil
+	// The memtable is newest, then frozen memtables and tables newest →
+	// oldest, as planned
+	sources := []iterator{newSliceIterator(rv.memtable.entriesAt(bounds, rv.seq))}
+	for i := len(rv.immutable) - 1; i >= 0; i-- {
+		sources = append(sources, newSliceIterator(rv.immutable[i].mem.entriesAt(bounds, rv.seq)))
+	}
+	for _, tp := range plan.Tables {
+		if tp.Skip {
+			continue
+		}
+
+		it, err := tp.table.newIterator(bounds, rangeScan{
+			limiter:   rv.limiter,
+			readAhead: tp.ReadAhead,
+			keysOnly:  opts.KeysOnly,
+			files:     e.tableFiles,
+		})
+		if err == nil {
+			if err = e.faults.inject(FaultSSTableRead); err != nil {
+				it.Close()
+			}
+		}
+		if err := e.breaker.recordRead(err); err != nil {
+			closeIterators(sources)
+			return nil, err
+		}
+		sources = append(sources, it)
+	}
+	merged := newMergingIterator(sources, true)
+	merged.hidePrefix = []byte(expiryPrefix)
+	return merged, nil
 }
 
 const MaxSSTables = 4
 
+// SetCompactionYield installs a hook that compaction calls between input
+// tables, letting the caller hold background work back while latency
+// sensitive requests are waiting.
+func (e *Engine) SetCompactionYield(fn func()) {
+	e.compactionYield.Store(&fn)
+}
+
+// yieldCompaction calls the hook installed by SetCompactionYield.
+func (e *Engine) yieldCompaction() {
+	if fn := e.compactionYield.Load(); fn != nil {
+		(*fn)()
+	}
+	if s := simulating.Load(); s != nil {
+		s.yield()
+	}
+}
+
+// tables returns the current tables, oldest to newest.
+func (e *Engine) tables() []*SSTable {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.sstables
+}
+
+// maybeCompact runs the compaction or rewrite the tables call for, if any.
+// It runs on the compactor goroutine, while flushes go on appending
+// tables; nothing else removes them.
 func (e *Engine) maybeCompact() error {
-	if len(e.sstables) < MaxSSTables {
+	if e.compactionPaused.Load() {
 		return nil
 	}
-	return e.compactAll()
+	if mergeReason(e.tables()) != "" {
+		if err := e.compactAll(); err != nil {
+			return err
+		}
+	}
+
+	if e.tierRequested.Swap(false) {
+		if err := e.tierCold(); err != nil {
+			return err
+		}
+	}
+
+	if i := rewriteCandidate(e.tables()); i >= 0 {
+		return e.rewriteTable(i)
+	}
+	return nil
 }
 
 func (e *Engine) Close() error {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	defer e.stopCompactor()
+	defer e.stopFlusher()
+	defer e.tail.closeAll()
+	defer e.tableFiles.closeAll()
+
+	if e.secondary {
+		return nil
+	}
+	if err := e.checkEpoch(); err != nil {
+		// A newer primary owns the files; leave them alone.
+		e.wal.Close()
+		closePrefixWALs(e.prefixWALs)
+		return err
+	}
+
 	//Flush remaining MemTable
-	if e.memtable.Size() > 0 {
-		if err := e.flushMemTable(); err != nil {
-			return err
-		}
+	if err := e.flushMemTable(); err != nil {
+		return err
+	}
+	if err := e.WaitForCompactions(); err != nil {
+		compactionLog.Warnf("closing after a failed compaction: %v", err)
+	}
+
+	e.saveIndexCache()
+	if err := e.SaveAccess(); err != nil {
+		return err
+	}
+	if err := e.releaseFileNumbers(); err != nil {
+		return err
 	}
 
 	//Close WAL
@@ -229,6 +1242,9 @@ func (e *Engine) Close() error {
 			return err
 		}
 	}
+	if err := closePrefixWALs(e.prefixWALs); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+// ErrInvalidExperiment is returned by StartExperiment for a percent outside
+// (0, 100], a duration that is not positive, or a negative group setting.
+var ErrInvalidExperiment = errors.New("invalid experiment")
+
+// ErrExperimentRunning is returned by StartExperiment while another
+// experiment is running.
+var ErrExperimentRunning = errors.New("an experiment is already running")
+
+// experimentBuckets bound write latencies, in seconds, for the experiment
+// histograms and the percentiles estimated from them.
+var experimentBuckets = []float64{
+	10e-6, 25e-6, 50e-6, 100e-6, 250e-6, 500e-6,
+	1e-3, 2.5e-3, 5e-3, 10e-3, 25e-3, 50e-3, 100e-3, 250e-3, 500e-3, 1,
+}
+
+var experimentLatency = metrics.NewHistogramVec("logbase_experiment_write_seconds",
+	"Latency of writes during a durability experiment, by arm.", experimentBuckets, "arm")
+
+// Experiment runs a different Durability on a share of writes for a
+// while, so its cost can be measured against the default on the same
+// traffic. Writes that set their own Durability take no part.
+type Experiment struct {
+	Durability Durability
+	// Percent of writes, in (0, 100], use Durability.
+	Percent  float64
+	Duration time.Duration
+}
+
+// ExperimentReport compares an experiment's arms: Control ran the default
+// durability, Treatment the experiment's.
+type ExperimentReport struct {
+	Percent   float64   `json:"percent"`
+	Started   time.Time `json:"started"`
+	Ends      time.Time `json:"ends"`
+	Active    bool      `json:"active"`
+	Control   ArmReport `json:"control"`
+	Treatment ArmReport `json:"treatment"`
+}
+
+// ArmReport is one arm's writes, throughput since the start, and latency.
+// Percentiles are the upper bounds of the histogram buckets they fall in,
+// capped at the maximum.
+type ArmReport struct {
+	Sync          bool    `json:"sync"`
+	GroupWindowUs int64   `json:"group_window_us"`
+	GroupSize     int     `json:"group_size"`
+	Writes        uint64  `json:"writes"`
+	Errors        uint64  `json:"errors"`
+	WritesPerSec  float64 `json:"writes_per_sec"`
+	MeanMs        float64 `json:"mean_ms"`
+	P50Ms         float64 `json:"p50_ms"`
+	P99Ms         float64 `json:"p99_ms"`
+	MaxMs         float64 `json:"max_ms"`
+}
+
+type experiment struct {
+	Experiment
+	started time.Time
+	// ends is when the experiment ends, in Unix nanoseconds; stopping it
+	// brings it forward.
+	ends    atomic.Int64
+	control experimentArm
+	treated experimentArm
+}
+
+type experimentArm struct {
+	durability Durability
+	latency    *metrics.Histogram
+
+	writes   atomic.Uint64
+	errors   atomic.Uint64
+	nanos    atomic.Int64
+	maxNanos atomic.Int64
+	buckets  [17]atomic.Uint64 // one per experimentBuckets bound, then +Inf
+}
+
+// StartExperiment starts x, replacing the report of any experiment that
+// has ended.
+func (e *Engine) StartExperiment(x Experiment) error {
+	switch {
+	case x.Percent <= 0 || x.Percent > 100:
+		return fmt.Errorf("%w: percent %v is not in (0, 100]", ErrInvalidExperiment, x.Percent)
+	case x.Duration <= 0:
+		return fmt.Errorf("%w: duration %v is not positive", ErrInvalidExperiment, x.Duration)
+	case x.Durability.GroupWindow < 0 || x.Durability.GroupSize < 0:
+		return fmt.Errorf("%w: negative group window or size", ErrInvalidExperiment)
+	}
+
+	exp := &experiment{Experiment: x, started: time.Now()}
+	exp.ends.Store(exp.started.Add(x.Duration).UnixNano())
+	exp.control.durability, exp.control.latency = defaultDurability, experimentLatency.With("control")
+	exp.treated.durability, exp.treated.latency = x.Durability, experimentLatency.With("treatment")
+	for {
+		old := e.experiment.Load()
+		if old != nil && old.active(exp.started) {
+			return ErrExperimentRunning
+		}
+		if e.experiment.CompareAndSwap(old, exp) {
+			return nil
+		}
+	}
+}
+
+// StopExperiment ends the running experiment early. Its report stays
+// available until the next one starts.
+func (e *Engine) StopExperiment() {
+	if exp := e.experiment.Load(); exp != nil {
+		now := time.Now().UnixNano()
+		for {
+			ends := exp.ends.Load()
+			if ends <= now || exp.ends.CompareAndSwap(ends, now) {
+				break
+			}
+		}
+	}
+}
+
+// ExperimentReport reports the last experiment started; ok is false if
+// none was.
+func (e *Engine) ExperimentReport() (report ExperimentReport, ok bool) {
+	exp := e.experiment.Load()
+	if exp == nil {
+		return ExperimentReport{}, false
+	}
+	now := time.Now()
+	ends := time.Unix(0, exp.ends.Load())
+	elapsed := now.Sub(exp.started)
+	if now.After(ends) {
+		elapsed = ends.Sub(exp.started)
+	}
+	return ExperimentReport{
+		Percent:   exp.Percent,
+		Started:   exp.started.UTC(),
+		Ends:      ends.UTC(),
+		Active:    exp.active(now),
+		Control:   exp.control.report(elapsed),
+		Treatment: exp.treated.report(elapsed),
+	}, true
+}
+
+func (x *experiment) active(now time.Time) bool {
+	return now.UnixNano() < x.ends.Load()
+}
+
+// commit runs write, then makes it as durable as route asks. A nil
+// durability means the default or, for the share of writes a running
+// experiment takes, the experiment's; the write is then timed for its arm.
+func (e *Engine) commit(route writeRoute, write func() error) error {
+	if route.durability != nil {
+		return e.durable(route, *route.durability, write())
+	}
+
+	exp := e.experiment.Load()
+	start := time.Now()
+	if exp == nil || !exp.active(start) {
+		return e.durable(route, defaultDurability, write())
+	}
+
+	arm := &exp.control
+	if rand.Float64()*100 < exp.Percent {
+		arm = &exp.treated
+	}
+	err := e.durable(route, arm.durability, write())
+	arm.record(time.Since(start), err)
+	return err
+}
+
+// durable syncs the WAL of a write on route that succeeded as d asks.
+func (e *Engine) durable(route writeRoute, d Durability, err error) error {
+	if err != nil {
+		return err
+	}
+	return e.syncWrites(route, d)
+}
+
+func (a *experimentArm) record(d time.Duration, err error) {
+	a.writes.Add(1)
+	if err != nil {
+		a.errors.Add(1)
+	}
+	a.nanos.Add(int64(d))
+	for {
+		old := a.maxNanos.Load()
+		if int64(d) <= old || a.maxNanos.CompareAndSwap(old, int64(d)) {
+			break
+		}
+	}
+	i := 0
+	for i < len(experimentBuckets) && d.Seconds() > experimentBuckets[i] {
+		i++
+	}
+	a.buckets[i].Add(1)
+	a.latency.Observe(d.Seconds())
+}
+
+func (a *experimentArm) report(elapsed time.Duration) ArmReport {
+	r := ArmReport{
+		Sync:          a.durability.Sync,
+		GroupWindowUs: a.durability.GroupWindow.Microseconds(),
+		GroupSize:     a.durability.GroupSize,
+		Writes:        a.writes.Load(),
+		Errors:        a.errors.Load(),
+		MaxMs:         millis(a.maxNanos.Load()),
+	}
+	if r.Writes == 0 {
+		return r
+	}
+	if elapsed > 0 {
+		r.WritesPerSec = float64(r.Writes) / elapsed.Seconds()
+	}
+	r.MeanMs = millis(a.nanos.Load()) / float64(r.Writes)
+	r.P50Ms = a.percentile(r.Writes, 0.5)
+	r.P99Ms = a.percentile(r.Writes, 0.99)
+	return r
+}
+
+// percentile estimates the q'th latency percentile in milliseconds, as the
+// bound of the bucket it falls in, or the maximum if that is lower.
+func (a *experimentArm) percentile(total uint64, q float64) float64 {
+	want := uint64(q * float64(total))
+	maxMs := millis(a.maxNanos.Load())
+	var seen uint64
+	for i := range experimentBuckets {
+		seen += a.buckets[i].Load()
+		if seen > want {
+			return min(experimentBuckets[i]*1000, maxMs)
+		}
+	}
+	return maxMs
+}
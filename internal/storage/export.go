@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ErrNotCompacted is returned by ExportTables when the tables do not hold
+// every key exactly once, so their files cannot stand in for the data.
+var ErrNotCompacted = errors.New("tables are not fully compacted")
+
+// ExportTables pins the tables for an export that copies their files
+// instead of merging their keys. That is only safe when nothing is left in
+// a MemTable and no two tables' key ranges overlap, as after a full
+// compaction: every key is then in exactly one table. A primary flushes
+// its MemTable first; a secondary must have nothing left to flush.
+// Otherwise ErrNotCompacted says what is in the way, and a merged read
+// such as NewBoundsIterator is needed.
+//
+// The set lists the non-empty tables in key order, and seq is the
+// sequence number they hold the data as of. Values are as stored, so they
+// are still encoded if a value transformer is installed, and tables may
+// hold tombstones for keys no other table has. Release the set with
+// ReleaseTables.
+func (e *Engine) ExportTables() (set *TableSet, seq uint64, err error) {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if !e.secondary {
+		if err := e.flushMemTable(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	e.mu.RLock()
+	unflushed := e.memtable.Size()
+	for _, f := range e.immutable {
+		unflushed += f.mem.Size()
+	}
+	tables := slices.DeleteFunc(slices.Clone(e.sstables), func(t *SSTable) bool { return t.entries == 0 })
+	seq = e.visibleSeq.Load()
+	if unflushed == 0 {
+		pinTables(tables)
+	}
+	e.mu.RUnlock()
+	if unflushed > 0 {
+		return nil, 0, fmt.Errorf("%w: %d bytes are not flushed yet", ErrNotCompacted, unflushed)
+	}
+
+	slices.SortFunc(tables, func(a, b *SSTable) int { return strings.Compare(a.minKey, b.minKey) })
+	for i := 1; i < len(tables); i++ {
+		if prev := tables[i-1]; prev.maxKey >= tables[i].minKey {
+			unpinTables(tables)
+			return nil, 0, fmt.Errorf("%w: %s and %s share keys", ErrNotCompacted,
+				filepath.Base(prev.Path), filepath.Base(tables[i].Path))
+		}
+	}
+
+	set = &TableSet{tables: tables, Tables: make([]TableInfo, 0, len(tables))}
+	for _, t := range tables {
+		set.Tables = append(set.Tables, tableInfo(t))
+	}
+	return set, seq, nil
+}
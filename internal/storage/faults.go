@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// FaultPoint names a place in the engine where faults can be injected.
+type FaultPoint string
+
+const (
+	FaultWALSync     FaultPoint = "wal_sync"
+	FaultSSTableRead FaultPoint = "sstable_read"
+	FaultCompaction  FaultPoint = "compaction"
+)
+
+var FaultPoints = []FaultPoint{FaultWALSync, FaultSSTableRead, FaultCompaction}
+
+var ErrInjectedFault = errors.New("injected fault")
+
+// Fault is the misbehaviour applied at a fault point: a fixed delay and
+// a probability of failing the operation.
+type Fault struct {
+	Latency   time.Duration
+	ErrorRate float64
+}
+
+// FaultInjector adds artificial latency and errors for resilience testing.
+// A nil injector injects nothing.
+type FaultInjector struct {
+	mu     sync.RWMutex
+	faults map[FaultPoint]Fault
+}
+
+func (f *FaultInjector) Set(point FaultPoint, fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[point] = fault
+}
+
+func (f *FaultInjector) Clear(point FaultPoint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.faults, point)
+}
+
+func (f *FaultInjector) Faults() map[FaultPoint]Fault {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	out := make(map[FaultPoint]Fault, len(f.faults))
+	for p, fault := range f.faults {
+		out[p] = fault
+	}
+	return out
+}
+
+// inject applies the fault configured for point. Injected errors look like
+// disk errors so they exercise the same handling as real ones.
+func (f *FaultInjector) inject(point FaultPoint) error {
+	if f == nil {
+		return nil
+	}
+
+	f.mu.RLock()
+	fault, ok := f.faults[point]
+	f.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	if fault.Latency > 0 {
+		time.Sleep(fault.Latency)
+	}
+	if fault.ErrorRate > 0 && rand.Float64() < fault.ErrorRate {
+		return &fs.PathError{Op: string(point), Path: "fault-injection", Err: ErrInjectedFault}
+	}
+	return nil
+}
+
+// EnableFaultInjection turns on fault injection for the engine and returns
+// the injector used to configure it.
+func (e *Engine) EnableFaultInjection() *FaultInjector {
+	f := &FaultInjector{faults: make(map[FaultPoint]Fault)}
+	e.faults = f
+	if e.wal != nil {
+		for _, wal := range e.allWALs() {
+			wal.faults = f
+		}
+	}
+	return f
+}
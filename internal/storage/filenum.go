@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fileNumberBatch is how many file numbers each MANIFEST update reserves,
+// so most new tables need no MANIFEST write.
+const fileNumberBatch = 64
+
+// newFileNumber returns a file number never used before in the data
+// directory. Numbers are reserved in the MANIFEST before they are handed
+// out, so they stay unique across restarts; numbers reserved but unused
+// when the process stops are skipped.
+func (e *Engine) newFileNumber() (uint64, error) {
+	n := e.nextFile.Add(1) - 1
+
+	e.manifestMu.Lock()
+	defer e.manifestMu.Unlock()
+
+	if n < e.manifest.NextFile {
+		return n, nil
+	}
+
+	next := *e.manifest
+	next.NextFile = n + fileNumberBatch
+	if err := e.commitManifest(&next); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// releaseFileNumbers gives back reserved numbers that were never used.
+// It must only be called once nothing else allocates, as at Close.
+func (e *Engine) releaseFileNumbers() error {
+	e.manifestMu.Lock()
+	defer e.manifestMu.Unlock()
+
+	n := e.nextFile.Load()
+	if e.manifest == nil || e.manifest.NextFile <= n {
+		return nil
+	}
+
+	next := *e.manifest
+	next.NextFile = n
+	return e.commitManifest(&next)
+}
+
+// tablePath returns the path for a new table file with a fresh number.
+func (e *Engine) tablePath(kind string) (string, error) {
+	n, err := e.newFileNumber()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(e.DataDir(), fmt.Sprintf("sst_%s%06d.dat", kind, n)), nil
+}
+
+// tableNumber parses the file number from a table path. Flushes write
+// sst_N.dat. Compactions write sst_compacted_N.cM.dat, taking the number of
+// their newest input, and a rewrite keeps the number of the table it
+// replaces (sst_N.rM.dat), so both keep their place before later flushes.
+func tableNumber(path string) uint64 {
+	name := strings.TrimPrefix(filepath.Base(path), "sst_")
+	name = strings.TrimPrefix(name, "compacted_")
+	digits, _, _ := strings.Cut(name, ".")
+	n, _ := strconv.ParseUint(digits, 10, 64)
+	return n
+}
+
+// sortTables orders table paths oldest to newest by file number.
+func sortTables(paths []string) {
+	sort.Slice(paths, func(i, j int) bool {
+		ni, nj := tableNumber(paths[i]), tableNumber(paths[j])
+		if ni != nj {
+			return ni < nj
+		}
+		return paths[i] < paths[j]
+	})
+}
+
+// initFileNumbers starts numbering after both the MANIFEST's reservation
+// and every table on disk, which covers directories written before file
+// numbers were recorded.
+func (e *Engine) initFileNumbers(paths []string) {
+	next := uint64(0)
+	if e.manifest != nil {
+		next = e.manifest.NextFile
+	}
+	for _, p := range paths {
+		next = max(next, tableNumber(p)+1)
+	}
+	e.nextFile.Store(next)
+}
@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// maxFrozenMemTables is how many frozen memtables may wait to be flushed
+// before writes wait for the flusher.
+const maxFrozenMemTables = 2
+
+// frozenMemTable is a full memtable waiting to be written to an SSTable.
+// Reads still consult it until the table replaces it.
+type frozenMemTable struct {
+	mem *MemTable
+	// segment is the WAL segment started when it was frozen; its records
+	// are all in older segments. prefixSegments are the same for the
+	// prefix WALs.
+	segment        int
+	prefixSegments map[*WAL]int
+}
+
+// flusher writes frozen memtables to SSTables on a goroutine of its own,
+// oldest first, so the write that fills the memtable only swaps in a new
+// one. Like the compactor, queued requests are folded together.
+type flusher struct {
+	requests chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu   sync.Mutex
+	idle *sync.Cond
+	// rounds counts finished flush rounds, so waiters can tell whether a
+	// round started after their request.
+	rounds uint64
+	// err is the outcome of the last round.
+	err error
+}
+
+// startFlusher starts the flush goroutine; in a simulation the simulation
+// runs queued flushes itself.
+func (e *Engine) startFlusher() {
+	f := &e.flusher
+	f.requests = make(chan struct{}, 1)
+	f.stop = make(chan struct{})
+	f.done = make(chan struct{})
+	f.idle = sync.NewCond(&f.mu)
+	if simulating.Load() != nil {
+		close(f.done)
+		return
+	}
+
+	go func() {
+		defer close(f.done)
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-f.requests:
+			}
+			e.flushRound()
+		}
+	}()
+}
+
+// stopFlusher stops the flush goroutine once a round in progress is done.
+func (e *Engine) stopFlusher() {
+	f := &e.flusher
+	f.stopOnce.Do(func() {
+		close(f.stop)
+		<-f.done
+	})
+}
+
+// freezeMemTable queues the memtable for flushing and starts a new one,
+// along with new segments in every WAL for it. Callers hold writeMu.
+func (e *Engine) freezeMemTable() error {
+	if e.memtable.Size() == 0 {
+		return nil
+	}
+	if err := e.wal.Rotate(); err != nil {
+		return err
+	}
+	prefixSegments := make(map[*WAL]int, len(e.prefixWALs))
+	for _, p := range e.prefixWALs {
+		if err := p.wal.Rotate(); err != nil {
+			return err
+		}
+		prefixSegments[p.wal] = p.wal.segment
+	}
+
+	e.mu.Lock()
+	e.immutable = append(slices.Clip(e.immutable), &frozenMemTable{mem: e.memtable, segment: e.wal.segment, prefixSegments: prefixSegments})
+	e.memtable = NewMemTable()
+	e.mu.Unlock()
+
+	select {
+	case e.flusher.requests <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// frozenCount returns how many memtables wait to be flushed.
+func (e *Engine) frozenCount() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return len(e.immutable)
+}
+
+// waitForFlushes waits until every frozen memtable is flushed, or a flush
+// fails. Callers hold writeMu, so nothing new is frozen meanwhile and the
+// flusher is not stopped. Once it is, or in a simulation, the flushes run
+// on the caller's goroutine.
+func (e *Engine) waitForFlushes() error {
+	f := &e.flusher
+	select {
+	case <-f.done:
+		return e.flushRound()
+	default:
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for e.frozenCount() > 0 {
+		round := f.rounds
+		select {
+		case f.requests <- struct{}{}:
+		default:
+		}
+		for f.rounds == round {
+			f.idle.Wait()
+		}
+		if f.err != nil {
+			return f.err
+		}
+	}
+	return nil
+}
+
+// flushRound flushes frozen memtables, oldest first, until none are left
+// or one fails.
+func (e *Engine) flushRound() error {
+	var err error
+	for {
+		e.mu.RLock()
+		var frozen *frozenMemTable
+		if len(e.immutable) > 0 {
+			frozen = e.immutable[0]
+		}
+		e.mu.RUnlock()
+		if frozen == nil {
+			break
+		}
+		if err = e.breaker.recordWrite(e.flushFrozen(frozen)); err != nil {
+			compactionLog.Errorf("flush: %v", err)
+			break
+		}
+	}
+
+	f := &e.flusher
+	f.mu.Lock()
+	f.rounds++
+	f.err = err
+	f.idle.Broadcast()
+	f.mu.Unlock()
+	return err
+}
+
+// flushFrozen writes the oldest frozen memtable to a new SSTable, which
+// replaces it for readers.
+func (e *Engine) flushFrozen(frozen *frozenMemTable) error {
+	snapshot := frozen.mem.sorted()
+	if err := e.checkEpoch(); err != nil {
+		return err
+	}
+
+	if err := e.requireFeature(FeatureBloomFilter); err != nil {
+		return err
+	}
+	if err := e.requireFeature(FeatureIndexBlock); err != nil {
+		return err
+	}
+
+	// The WAL records are truncated below, so the MANIFEST keeps their
+	// sequence numbers from being handed out again after a restart
+	var last uint64
+	for _, e := range snapshot {
+		last = max(last, e.entry.Seq)
+	}
+	if err := e.raiseLastSeq(last); err != nil {
+		return err
+	}
+
+	path, err := e.tablePath("")
+	if err != nil {
+		return err
+	}
+	table, err := writeSortedSSTable(path, snapshot, ioFlush)
+	if err != nil {
+		return err
+	}
+	if err := e.replaceTables(nil, []*SSTable{table}); err != nil {
+		table.remove()
+		return err
+	}
+
+	e.mu.Lock()
+	e.sstables = append(e.sstables, table)
+	e.immutable = e.immutable[1:]
+	e.tablesGen++
+	hotStale := e.hot.flushed(snapshot, e.tablesGen)
+	older := e.sstables[:len(e.sstables)-1]
+	e.mu.Unlock()
+
+	if hotStale {
+		if err := e.refreshHot(); err != nil {
+			cacheLog.Warnf("hot set: %v", err)
+		}
+	}
+
+	e.trackGarbage(older, table, snapshot)
+	compactionLog.Debugf("flushed %d entries to %s", len(snapshot), filepath.Base(path))
+
+	e.wal.Truncate(frozen.segment - 1)
+	for wal, segment := range frozen.prefixSegments {
+		wal.Truncate(segment - 1)
+	}
+	e.requestCompaction()
+	return nil
+}
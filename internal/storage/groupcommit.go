@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+var groupCommitSize = metrics.NewHistogram("logbase_group_commit_writes",
+	"Writes sharing each WAL fsync.", []float64{1, 2, 4, 8, 16, 32, 64, 128})
+
+// Durability says how far a write is made durable before it returns. The
+// zero value hands the WAL record to the operating system, which survives
+// a process crash but not a power failure.
+type Durability struct {
+	// Sync fsyncs the WAL before the write returns.
+	Sync bool
+	// GroupWindow is how long a synced write waits for other writes to
+	// share its fsync (group commit); 0 syncs at once, sharing the fsync
+	// only with writes already waiting. GroupSize, if set, ends the wait
+	// as soon as that many writes share it.
+	GroupWindow time.Duration
+	GroupSize   int
+}
+
+// defaultDurability applies to writes that do not set their own; see
+// WriteOptions.Durability.
+var defaultDurability Durability
+
+// groupCommit lets synced writes share fsyncs. The first write to need one
+// leads a group: it waits out its window, closes the group and syncs, and
+// every write that joined meanwhile returns with the leader's result.
+// Writes join only after they are logged, so the one fsync covers them all.
+// A write that joins with a shorter window than the leader's moves the
+// sync up, so it never waits longer than its own window. Each WAL has its
+// own group commit.
+type groupCommit struct {
+	mu      sync.Mutex
+	current *syncGroup
+}
+
+type syncGroup struct {
+	size, limit int
+	// full is closed once size reaches the leader's GroupSize, limit.
+	full chan struct{}
+	// deadline is when the leader syncs. A write that joins needing the
+	// sync sooner moves it up and wakes the leader through sooner.
+	deadline time.Time
+	sooner   chan struct{}
+	// done is closed once the group's fsync has finished, with err set.
+	done chan struct{}
+	err  error
+}
+
+// syncWrites makes the writes logged so far to route's WAL as durable as d
+// asks, sharing the fsync with concurrent writes to that WAL. It must be
+// called without writeMu, so other writes can join the group meanwhile.
+func (e *Engine) syncWrites(route writeRoute, d Durability) error {
+	if !d.Sync {
+		return nil
+	}
+	_, g := e.syncTarget(route)
+
+	g.mu.Lock()
+	deadline := time.Now().Add(d.GroupWindow)
+	group, leader := g.current, false
+	if group == nil {
+		group = &syncGroup{
+			limit:    d.GroupSize,
+			full:     make(chan struct{}),
+			deadline: deadline,
+			sooner:   make(chan struct{}, 1),
+			done:     make(chan struct{}),
+		}
+		g.current, leader = group, true
+	}
+	group.size++
+	if group.size == group.limit {
+		close(group.full)
+	}
+	if deadline.Before(group.deadline) {
+		group.deadline = deadline
+		select {
+		case group.sooner <- struct{}{}:
+		default:
+		}
+	}
+	g.mu.Unlock()
+
+	if !leader {
+		<-group.done
+		return group.err
+	}
+
+	for wait := d.GroupWindow; wait > 0; {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			wait = 0
+		case <-group.full:
+			timer.Stop()
+			wait = 0
+		case <-group.sooner:
+			timer.Stop()
+			g.mu.Lock()
+			wait = time.Until(group.deadline)
+			g.mu.Unlock()
+		}
+	}
+	g.mu.Lock()
+	g.current = nil
+	size := group.size
+	g.mu.Unlock()
+
+	wal, _ := e.syncTarget(route)
+	group.err = e.breaker.recordWrite(wal.Sync())
+	groupCommitSize.Observe(float64(size))
+	close(group.done)
+	return group.err
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"syscall"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+// HealthState describes how much of the engine is still usable after
+// persistent disk errors.
+type HealthState int32
+
+const (
+	Healthy HealthState = iota
+	// ReadOnly is entered after repeated WAL or flush failures: writes are
+	// rejected because they can no longer be made durable.
+	ReadOnly
+	// Unavailable is entered after repeated SSTable read failures: reads
+	// can no longer be trusted to return correct data.
+	Unavailable
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case ReadOnly:
+		return "read-only"
+	case Unavailable:
+		return "unavailable"
+	}
+	return "healthy"
+}
+
+var (
+	ErrReadOnly    = errors.New("storage is in degraded read-only mode")
+	ErrUnavailable = errors.New("storage is unavailable")
+)
+
+// ioErrorThreshold is the number of consecutive I/O errors on one path
+// that trips the breaker.
+var ioErrorThreshold = 3
+
+var (
+	healthState = metrics.NewGauge("logbase_storage_health_state",
+		"Storage health: 0 healthy, 1 read-only, 2 unavailable.")
+	ioErrors = metrics.NewCounterVec("logbase_storage_io_errors_total",
+		"Disk I/O errors seen by the storage engine.", "op")
+)
+
+// circuitBreaker degrades the engine once I/O errors stop being transient,
+// so callers get a clear error instead of sporadic failures.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	writeErrs int
+	readErrs  int
+	state     HealthState
+	cause     error
+}
+
+func (b *circuitBreaker) status() (HealthState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.cause
+}
+
+// checkWrite returns the error a write should fail with, if any.
+func (b *circuitBreaker) checkWrite() error {
+	state, cause := b.status()
+	switch state {
+	case ReadOnly:
+		return fmt.Errorf("%w: %v", ErrReadOnly, cause)
+	case Unavailable:
+		return fmt.Errorf("%w: %v", ErrUnavailable, cause)
+	}
+	return nil
+}
+
+// recordWrite notes the outcome of a WAL append or flush and passes err through.
+func (b *circuitBreaker) recordWrite(err error) error {
+	b.record(err, &b.writeErrs, ReadOnly, "write")
+	return err
+}
+
+// recordRead notes the outcome of an SSTable read and passes err through.
+func (b *circuitBreaker) recordRead(err error) error {
+	b.record(err, &b.readErrs, Unavailable, "read")
+	return err
+}
+
+func (b *circuitBreaker) record(err error, count *int, trip HealthState, op string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil || !isIOError(err) {
+		*count = 0
+		return
+	}
+
+	ioErrors.With(op).Inc()
+	*count++
+	if *count >= ioErrorThreshold && b.state < trip {
+		b.state = trip
+		b.cause = err
+		healthState.Set(int64(trip))
+	}
+}
+
+func isIOError(err error) bool {
+	var pathErr *fs.PathError
+	var errno syscall.Errno
+	return errors.As(err, &pathErr) ||
+		errors.As(err, &errno) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Health reports the engine's current state and the error that degraded it.
+func (e *Engine) Health() (HealthState, error) {
+	return e.breaker.status()
+}
@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// hotSetMaxBytes caps the keys and values the hot set holds; 0 means no cap.
+var hotSetMaxBytes int64
+
+// ErrHotSetTooLarge is returned by PinHot when the pinned keys would hold
+// more than LOGBASE_HOT_MAX_BYTES in memory.
+var ErrHotSetTooLarge = errors.New("hot set too large")
+
+// ErrInvalidHotPattern is returned for an empty pattern or a bare "*".
+var ErrInvalidHotPattern = errors.New("invalid hot set pattern")
+
+// hotSet keeps pinned keys, and every key under pinned prefixes, in memory.
+// It mirrors what the SSTables of one table generation hold for them, so a
+// read that misses the memtable is answered without touching a table. A
+// covered key absent from entries is known not to be in any table, and a
+// tombstone is held as a Deleted entry, as in a table.
+//
+// Flushes update it in place; compactions and rewrites, which do not change
+// what the tables hold, only re-stamp it. Anything else, or a failed
+// update, leaves it stale until refreshHot rebuilds it; reads then fall
+// through to the tables.
+type hotSet struct {
+	mu       sync.RWMutex
+	keys     map[string]bool
+	prefixes []string
+	// version counts pattern changes, so a rebuild for old patterns is
+	// not installed.
+	version uint64
+
+	valid   bool
+	gen     uint64
+	entries map[string]Entry
+	bytes   int64
+}
+
+// HotSetInfo describes the hot set.
+type HotSetInfo struct {
+	Patterns []string `json:"patterns"`
+	Keys     int      `json:"keys"`
+	Bytes    int64    `json:"bytes"`
+	// Current is false while the hot set is being rebuilt; reads of
+	// pinned keys go to the SSTables meanwhile.
+	Current bool `json:"current"`
+}
+
+// parseHotPattern splits a pattern into an exact key or, if it ends in
+// "*", a prefix.
+func parseHotPattern(pattern string) (key string, prefix bool, err error) {
+	if pattern == "" || pattern == "*" {
+		return "", false, fmt.Errorf("%w %q", ErrInvalidHotPattern, pattern)
+	}
+	if p, ok := strings.CutSuffix(pattern, "*"); ok {
+		return p, true, nil
+	}
+	return pattern, false, nil
+}
+
+// covers reports whether key is pinned. h.mu must be held.
+func (h *hotSet) covers(key string) bool {
+	if h.keys[key] {
+		return true
+	}
+	for _, p := range h.prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// get looks key up for a view of table generation gen. ok is false if the
+// hot set cannot answer, and the tables must be read.
+func (h *hotSet) get(key []byte, gen uint64) (entry Entry, found, ok bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if !h.valid || h.gen != gen || !h.covers(string(key)) {
+		return Entry{}, false, false
+	}
+	entry, found = h.entries[string(key)]
+	return entry, found, true
+}
+
+// flushed applies a flushed memtable snapshot, which became table
+// generation gen. e.mu must be held for writing. It reports whether the
+// hot set went stale.
+func (h *hotSet) flushed(snapshot []keyedEntry, gen uint64) (stale bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.keys) == 0 && len(h.prefixes) == 0 {
+		h.gen = gen
+		return false
+	}
+	if !h.valid || h.gen != gen-1 {
+		h.valid = false
+		return true
+	}
+	if len(h.prefixes) == 0 && len(h.keys) < len(snapshot) {
+		for k := range h.keys {
+			i, ok := slices.BinarySearchFunc(snapshot, k, func(e keyedEntry, k string) int {
+				return strings.Compare(e.key, k)
+			})
+			if ok {
+				h.set(k, snapshot[i].entry)
+			}
+		}
+	} else {
+		for _, e := range snapshot {
+			if h.covers(e.key) {
+				h.set(e.key, e.entry)
+			}
+		}
+	}
+	h.gen = gen
+	return false
+}
+
+func (h *hotSet) set(key string, entry Entry) {
+	if old, ok := h.entries[key]; ok {
+		h.bytes -= entrySize(key, old)
+	}
+	h.entries[key] = entry
+	h.bytes += entrySize(key, entry)
+}
+
+func entrySize(key string, entry Entry) int64 {
+	return int64(len(key) + len(entry.Value) + len(entry.Meta))
+}
+
+// restamp moves the hot set to table generation gen, whose tables hold
+// the same data as the previous one. e.mu must be held for writing.
+func (h *hotSet) restamp(gen uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.valid && h.gen == gen-1 {
+		h.gen = gen
+	} else {
+		h.valid = false
+	}
+}
+
+// patterns returns the pinned keys and prefixes and their version.
+func (h *hotSet) patterns() (keys, prefixes []string, version uint64) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for k := range h.keys {
+		keys = append(keys, k)
+	}
+	return keys, slices.Clone(h.prefixes), h.version
+}
+
+// install replaces the entries with ones built for table generation gen and
+// pattern version. It reports false if the patterns changed meanwhile.
+func (h *hotSet) install(entries map[string]Entry, size int64, gen, version uint64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.version != version {
+		return false
+	}
+	h.entries, h.bytes = entries, size
+	h.gen, h.valid = gen, true
+	return true
+}
+
+// PinHot keeps the key, or with a trailing "*" every key with the prefix,
+// in memory, so reads of it never touch an SSTable. It returns once the
+// pinned keys are loaded.
+func (e *Engine) PinHot(pattern string) error {
+	key, prefix, err := parseHotPattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	h := &e.hot
+	h.mu.Lock()
+	if prefix && !slices.Contains(h.prefixes, key) {
+		h.prefixes = append(h.prefixes, key)
+	} else if !prefix {
+		if h.keys == nil {
+			h.keys = make(map[string]bool)
+		}
+		h.keys[key] = true
+	}
+	h.version++
+	h.valid = false
+	h.mu.Unlock()
+
+	if err := e.refreshHot(); err != nil {
+		e.UnpinHot(pattern)
+		return err
+	}
+	return nil
+}
+
+// UnpinHot releases a pattern given to PinHot.
+func (e *Engine) UnpinHot(pattern string) error {
+	key, prefix, err := parseHotPattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	h := &e.hot
+	h.mu.Lock()
+	if prefix {
+		h.prefixes = slices.DeleteFunc(h.prefixes, func(p string) bool { return p == key })
+	} else {
+		delete(h.keys, key)
+	}
+	h.version++
+	h.valid = false
+	h.mu.Unlock()
+
+	return e.refreshHot()
+}
+
+// HotSet describes the pinned patterns and what they hold.
+func (e *Engine) HotSet() HotSetInfo {
+	e.mu.RLock()
+	gen := e.tablesGen
+	e.mu.RUnlock()
+
+	h := &e.hot
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	info := HotSetInfo{Patterns: []string{}, Current: h.valid && h.gen == gen}
+	for k := range h.keys {
+		info.Patterns = append(info.Patterns, k)
+	}
+	for _, p := range h.prefixes {
+		info.Patterns = append(info.Patterns, p+"*")
+	}
+	slices.Sort(info.Patterns)
+	if info.Current {
+		info.Keys, info.Bytes = len(h.entries), h.bytes
+	}
+	return info
+}
+
+// hotRefreshAttempts bounds how often refreshHot rebuilds when tables
+// keep changing under it.
+const hotRefreshAttempts = 3
+
+// refreshHot rebuilds the hot set from the current tables. If the tables
+// change while it reads them, it tries again; after hotRefreshAttempts it
+// gives up and leaves the hot set stale, for the next flush to retry.
+func (e *Engine) refreshHot() error {
+	keys, prefixes, version := e.hot.patterns()
+
+	for range hotRefreshAttempts {
+		e.mu.RLock()
+		tables, gen := e.sstables, e.tablesGen
+		pinTables(tables)
+		e.mu.RUnlock()
+
+		entries, size, err := readHot(tables, keys, prefixes)
+		unpinTables(tables)
+		if err != nil {
+			return err
+		}
+		if hotSetMaxBytes > 0 && size > hotSetMaxBytes {
+			return fmt.Errorf("%w: %d bytes, limit %d", ErrHotSetTooLarge, size, hotSetMaxBytes)
+		}
+
+		e.mu.RLock()
+		installed := false
+		if e.tablesGen == gen {
+			installed = e.hot.install(entries, size, gen, version)
+		}
+		e.mu.RUnlock()
+		if installed {
+			return nil
+		}
+		if _, _, v := e.hot.patterns(); v != version {
+			// A newer PinHot or UnpinHot rebuilds for its own patterns
+			return nil
+		}
+	}
+
+	cacheLog.Warnf("hot set: tables kept changing; rebuilding after the next flush")
+	return nil
+}
+
+// readHot reads the pinned keys and prefixes from tables, ordered oldest
+// to newest.
+func readHot(tables []*SSTable, keys, prefixes []string) (map[string]Entry, int64, error) {
+	entries := make(map[string]Entry)
+
+	for _, key := range keys {
+		for i := len(tables) - 1; i >= 0; i-- {
+			if !tables[i].mightContain([]byte(key)) {
+				continue
+			}
+			entry, ok, err := tables[i].getEntry([]byte(key), rangeScan{})
+			if err != nil {
+				return nil, 0, err
+			}
+			if ok {
+				entries[key] = entry
+				break
+			}
+		}
+	}
+
+	// Newer tables overwrite older ones
+	for _, t := range tables {
+		for _, p := range prefixes {
+			it, err := t.newIterator(IterOptions{Prefix: []byte(p)}, rangeScan{})
+			if err != nil {
+				return nil, 0, err
+			}
+			for it.Next() {
+				entries[string(it.Key())] = it.Entry()
+			}
+			if err := it.Err(); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	var size int64
+	for k, entry := range entries {
+		size += entrySize(k, entry)
+	}
+	return entries, size, nil
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// indexCacheName is the sidecar file holding every table's in-memory
+// structures, so opening a directory need not re-read each table.
+const indexCacheName = "INDEXCACHE"
+
+const (
+	indexCacheMagic   = "LBIXCACH"
+	indexCacheVersion = 2
+)
+
+var errBadIndexCache = errors.New("malformed index cache")
+
+// cachedTable is what the cache remembers about one table. The file's
+// size and modification time identify it; a mismatch means the entry is
+// stale and the table is loaded from disk.
+type cachedTable struct {
+	size    int64
+	modTime int64
+
+	version  uint32
+	dataSize int64
+	entries  int64
+	minKey   string
+	maxKey   string
+	interval int
+	index    []IndexEntry
+
+	bloomK    int
+	bloomBits []byte
+}
+
+// fileStamp returns the size and modification time identifying path.
+func fileStamp(path string) (int64, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return info.Size(), info.ModTime().UnixNano(), nil
+}
+
+// readIndexCache loads dataDir's index cache keyed by table file name. A
+// missing or damaged cache is treated as empty.
+func readIndexCache(dataDir string) map[string]cachedTable {
+	data, err := os.ReadFile(filepath.Join(dataDir, indexCacheName))
+	if err != nil {
+		return nil
+	}
+
+	cache, err := decodeIndexCache(data)
+	if err != nil {
+		cacheLog.Warnf("ignoring %s: %v", indexCacheName, err)
+		return nil
+	}
+	return cache
+}
+
+func decodeIndexCache(data []byte) (map[string]cachedTable, error) {
+	if len(data) < len(indexCacheMagic)+8 || string(data[:len(indexCacheMagic)]) != indexCacheMagic {
+		return nil, errBadIndexCache
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, errBadIndexCache
+	}
+
+	d := cacheDecoder{buf: body[len(indexCacheMagic):]}
+	if d.uint32() != indexCacheVersion {
+		return nil, errBadIndexCache
+	}
+
+	n := d.uint32()
+	cache := make(map[string]cachedTable, n)
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		name := string(d.blob())
+		t := cachedTable{
+			size:     d.int64(),
+			modTime:  d.int64(),
+			version:  d.uint32(),
+			dataSize: d.int64(),
+			entries:  d.int64(),
+			minKey:   string(d.blob()),
+			maxKey:   string(d.blob()),
+			interval: int(d.uint32()),
+		}
+		for j := d.uint32(); j > 0 && d.err == nil; j-- {
+			t.index = append(t.index, IndexEntry{Key: string(d.blob()), Offset: d.int64()})
+		}
+		t.bloomK = int(d.uint32())
+		t.bloomBits = bytes.Clone(d.blob())
+		cache[name] = t
+	}
+
+	if d.err != nil || len(d.buf) != 0 {
+		return nil, errBadIndexCache
+	}
+	return cache, nil
+}
+
+// writeIndexCache records tables' in-memory structures in dataDir.
+func writeIndexCache(dataDir string, tables []*SSTable) error {
+	buf := []byte(indexCacheMagic)
+	buf = binary.BigEndian.AppendUint32(buf, indexCacheVersion)
+
+	var body bytes.Buffer
+	count := uint32(0)
+	for _, t := range tables {
+		size, modTime, err := fileStamp(t.Path)
+		if err != nil {
+			continue
+		}
+
+		var b []byte
+		b = appendBlob(b, []byte(filepath.Base(t.Path)))
+		b = binary.BigEndian.AppendUint64(b, uint64(size))
+		b = binary.BigEndian.AppendUint64(b, uint64(modTime))
+		b = binary.BigEndian.AppendUint32(b, t.version)
+		b = binary.BigEndian.AppendUint64(b, uint64(t.dataSize))
+		b = binary.BigEndian.AppendUint64(b, uint64(t.entries))
+		b = appendBlob(b, []byte(t.minKey))
+		b = appendBlob(b, []byte(t.maxKey))
+		b = binary.BigEndian.AppendUint32(b, uint32(t.indexInterval))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(t.Index)))
+		for _, ie := range t.Index {
+			b = appendBlob(b, []byte(ie.Key))
+			b = binary.BigEndian.AppendUint64(b, uint64(ie.Offset))
+		}
+		if t.Bloom != nil {
+			b = binary.BigEndian.AppendUint32(b, uint32(t.Bloom.k))
+			b = appendBlob(b, t.Bloom.bits)
+		} else {
+			b = binary.BigEndian.AppendUint32(b, 0)
+			b = appendBlob(b, nil)
+		}
+
+		body.Write(b)
+		count++
+	}
+
+	buf = binary.BigEndian.AppendUint32(buf, count)
+	buf = append(buf, body.Bytes()...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+	return writeFileAtomic(filepath.Join(dataDir, indexCacheName), buf)
+}
+
+// loadFromCache fills in the table from its cache entry if the entry
+// still describes the file on disk.
+func (s *SSTable) loadFromCache(c cachedTable, ok bool) bool {
+	if !ok {
+		return false
+	}
+	size, modTime, err := fileStamp(s.Path)
+	if err != nil || size != c.size || modTime != c.modTime {
+		return false
+	}
+
+	s.version = c.version
+	s.dataSize = c.dataSize
+	s.entries = c.entries
+	s.minKey, s.maxKey = c.minKey, c.maxKey
+	s.indexInterval = c.interval
+	s.Index = c.index
+	if c.bloomK > 0 {
+		s.Bloom = &BloomFilter{bits: c.bloomBits, k: c.bloomK}
+	}
+	return true
+}
+
+func appendBlob(b, v []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+	return append(b, v...)
+}
+
+// cacheDecoder reads big-endian fields from buf, remembering the first
+// short read.
+type cacheDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *cacheDecoder) next(n int) []byte {
+	if d.err != nil || len(d.buf) < n {
+		d.err = errBadIndexCache
+		return make([]byte, 8)
+	}
+	v := d.buf[:n]
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *cacheDecoder) uint32() uint32 { return binary.BigEndian.Uint32(d.next(4)) }
+func (d *cacheDecoder) int64() int64   { return int64(binary.BigEndian.Uint64(d.next(8))) }
+
+// blob reads a length-prefixed value. It aliases buf.
+func (d *cacheDecoder) blob() []byte {
+	n := d.uint32()
+	if d.err != nil || uint64(len(d.buf)) < uint64(n) {
+		d.err = errBadIndexCache
+		return nil
+	}
+	return d.next(int(n))
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"io"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+// ioSubsystem is what a disk read or write is done for. Reads serve
+// clients and the WAL holds them up, so both are foreground; the rest is
+// background work that competes with them for the disk.
+type ioSubsystem int
+
+const (
+	// ioReads is point lookups and range scans of tables.
+	ioReads ioSubsystem = iota
+	// ioWAL is WAL appends and fsyncs, and replay at startup.
+	ioWAL
+	// ioFlush is MemTables written out as tables.
+	ioFlush
+	// ioCompaction is tables read and written by compaction, rewrites,
+	// format migration and cold tiering.
+	ioCompaction
+	// ioReplication is checkpoint files served to other nodes and a
+	// standby reading the primary's WAL.
+	ioReplication
+
+	ioSubsystems = 5
+)
+
+var ioSubsystemNames = [ioSubsystems]string{"reads", "wal", "flush", "compaction", "replication"}
+
+func (sub ioSubsystem) String() string { return ioSubsystemNames[sub] }
+
+func (sub ioSubsystem) foreground() bool { return sub == ioReads || sub == ioWAL }
+
+var (
+	ioBytesVec = metrics.NewCounterVec("logbase_io_bytes_total",
+		"Bytes read from and written to disk, by subsystem and operation.", "subsystem", "op")
+	ioOpsVec = metrics.NewCounterVec("logbase_io_ops_total",
+		"Disk reads, writes and fsyncs, by subsystem and operation.", "subsystem", "op")
+)
+
+// ioCounters are one subsystem's counters, looked up once.
+type ioCounters struct {
+	readBytes, readOps   *metrics.Counter
+	writeBytes, writeOps *metrics.Counter
+	syncs                *metrics.Counter
+}
+
+var ioUsage = func() (usage [ioSubsystems]ioCounters) {
+	for sub := range ioSubsystem(ioSubsystems) {
+		name := sub.String()
+		usage[sub] = ioCounters{
+			readBytes:  ioBytesVec.With(name, "read"),
+			readOps:    ioOpsVec.With(name, "read"),
+			writeBytes: ioBytesVec.With(name, "write"),
+			writeOps:   ioOpsVec.With(name, "write"),
+			syncs:      ioOpsVec.With(name, "sync"),
+		}
+	}
+	return usage
+}()
+
+// IOUsage is the disk I/O one subsystem has done since the process
+// started. Ops count the reads and writes that reached the file, after any
+// buffering, so they approximate system calls rather than device IOPS.
+type IOUsage struct {
+	Subsystem  string `json:"subsystem"`
+	Foreground bool   `json:"foreground"`
+	ReadBytes  uint64 `json:"read_bytes"`
+	ReadOps    uint64 `json:"read_ops"`
+	WriteBytes uint64 `json:"write_bytes"`
+	WriteOps   uint64 `json:"write_ops"`
+	Syncs      uint64 `json:"syncs"`
+}
+
+func (u *IOUsage) add(o IOUsage) {
+	u.ReadBytes += o.ReadBytes
+	u.ReadOps += o.ReadOps
+	u.WriteBytes += o.WriteBytes
+	u.WriteOps += o.WriteOps
+	u.Syncs += o.Syncs
+}
+
+// IOStats reports the disk I/O of every engine in the process by
+// subsystem, with the foreground and background totals.
+func IOStats() (subsystems []IOUsage, foreground, background IOUsage) {
+	foreground = IOUsage{Subsystem: "foreground", Foreground: true}
+	background = IOUsage{Subsystem: "background"}
+	for sub := range ioSubsystem(ioSubsystems) {
+		c := &ioUsage[sub]
+		u := IOUsage{
+			Subsystem:  sub.String(),
+			Foreground: sub.foreground(),
+			ReadBytes:  c.readBytes.Value(),
+			ReadOps:    c.readOps.Value(),
+			WriteBytes: c.writeBytes.Value(),
+			WriteOps:   c.writeOps.Value(),
+			Syncs:      c.syncs.Value(),
+		}
+		subsystems = append(subsystems, u)
+		if u.Foreground {
+			foreground.add(u)
+		} else {
+			background.add(u)
+		}
+	}
+	return subsystems, foreground, background
+}
+
+// reader wraps r, which must read straight from a file, so its reads are
+// counted for sub.
+func (sub ioSubsystem) reader(r io.Reader) io.Reader {
+	return &countingReader{r: r, c: &ioUsage[sub]}
+}
+
+// writer wraps w, which must write straight to a file, so its writes are
+// counted for sub.
+func (sub ioSubsystem) writer(w io.Writer) io.Writer {
+	return &countingWriter{w: w, c: &ioUsage[sub]}
+}
+
+// synced counts an fsync for sub.
+func (sub ioSubsystem) synced() {
+	ioUsage[sub].syncs.Inc()
+}
+
+type countingReader struct {
+	r io.Reader
+	c *ioCounters
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.c.readOps.Inc()
+		r.c.readBytes.Add(uint64(n))
+	}
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	c *ioCounters
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.c.writeOps.Inc()
+	w.c.writeBytes.Add(uint64(n))
+	return n, err
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sort"
+)
+
+// IterOptions bound an iteration to keys in [LowerBound, UpperBound); a nil
+// bound is open. With Prefix set, only keys starting with it are visited,
+// which narrows the bounds further. Sorted sources stop reading as soon as
+// they pass the upper bound instead of scanning to the end.
+type IterOptions struct {
+	LowerBound []byte
+	UpperBound []byte
+	Prefix     []byte
+}
+
+// closedRange returns the bounds covering [start, end]: the smallest key
+// after end is end followed by a zero byte.
+func closedRange(start, end []byte) IterOptions {
+	return IterOptions{LowerBound: start, UpperBound: append(bytes.Clone(end), 0)}
+}
+
+// prefixSuccessor returns the smallest key greater than every key with the
+// prefix, or nil if there is none (the prefix is all 0xff bytes).
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			succ := bytes.Clone(prefix[:i+1])
+			succ[i]++
+			return succ
+		}
+	}
+	return nil
+}
+
+// normalize folds Prefix into the bounds.
+func (o IterOptions) normalize() IterOptions {
+	if len(o.Prefix) == 0 {
+		return o
+	}
+	if bytes.Compare(o.Prefix, o.LowerBound) > 0 {
+		o.LowerBound = o.Prefix
+	}
+	if succ := prefixSuccessor(o.Prefix); succ != nil && (o.UpperBound == nil || bytes.Compare(succ, o.UpperBound) < 0) {
+		o.UpperBound = succ
+	}
+	return o
+}
+
+// before reports whether key sorts below the lower bound.
+func (o IterOptions) before(key string) bool {
+	return key < string(o.LowerBound)
+}
+
+// past reports whether key is at or beyond the upper bound, so no later
+// key in sorted order can match.
+func (o IterOptions) past(key string) bool {
+	return o.UpperBound != nil && key >= string(o.UpperBound)
+}
+
+// contains reports whether key is within the bounds and has the prefix.
+func (o IterOptions) contains(key string) bool {
+	return !o.before(key) && !o.past(key) && bytes.HasPrefix([]byte(key), o.Prefix)
+}
+
+// tableIterator walks a table's entries in key order within bounds. It
+// releases the table file as soon as it reaches the end of the bounds, so
+// callers that stop early hold no file open beyond the engine's cache.
+type tableIterator struct {
+	bounds   IterOptions
+	keysOnly bool
+	version  uint32
+
+	file   *tableFile
+	reader *bufio.Reader
+
+	key   []byte
+	entry Entry
+	err   error
+}
+
+func (s *SSTable) newIterator(opts IterOptions, scan rangeScan) (*tableIterator, error) {
+	file, section, err := s.openShared(scan.files)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip straight to the indexed key at or before the lower bound
+	opts = opts.normalize()
+	reader, err := s.recordReader(section, s.seekOffset(opts.LowerBound), scan.limiter, scan.readAhead, scan.io)
+	if err != nil {
+		file.release()
+		return nil, err
+	}
+
+	return &tableIterator{
+		bounds:   opts,
+		keysOnly: scan.keysOnly,
+		version:  s.version,
+		file:     file,
+		reader:   reader,
+	}, nil
+}
+
+// seekOffset returns the data offset of the last sparse index entry at or
+// before key, where a scan for key can start.
+func (s *SSTable) seekOffset(key []byte) int64 {
+	i := sort.Search(len(s.Index), func(i int) bool { return s.Index[i].Key > string(key) })
+	if i == 0 {
+		return 0
+	}
+	return s.Index[i-1].Offset
+}
+
+// Next advances to the next entry in bounds, reporting false at the end
+// or on error. Tombstones are returned as entries marked Deleted.
+func (it *tableIterator) Next() bool {
+	for it.file != nil {
+		var err error
+		if it.keysOnly {
+			it.key, it.entry, err = readEntryKey(it.reader, it.version)
+		} else {
+			it.key, it.entry, err = readEntry(it.reader, it.version)
+		}
+		if err != nil {
+			if err != io.EOF {
+				it.err = err
+			}
+			it.Close()
+			return false
+		}
+
+		k := string(it.key)
+		if it.bounds.before(k) {
+			continue
+		}
+		if it.bounds.past(k) {
+			// sorted order lets us stop early
+			it.Close()
+			return false
+		}
+		if !bytes.HasPrefix(it.key, it.bounds.Prefix) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (it *tableIterator) Key() []byte  { return it.key }
+func (it *tableIterator) Entry() Entry { return it.entry }
+func (it *tableIterator) Err() error   { return it.err }
+
+// Close releases the table file. It is safe to call more than once.
+func (it *tableIterator) Close() error {
+	if it.file == nil {
+		return nil
+	}
+	err := it.file.release()
+	it.file, it.reader = nil, nil
+	return err
+}
+
+// Iterator streams the live keys of a range in order, as of the moment it
+// was created. Table files are read as it advances rather than up front;
+// the MemTables' share of the range is copied when it is created. Its
+// tables are pinned so compaction cannot delete them until Close.
+type Iterator struct {
+	engine   *Engine
+	merged   *mergingIterator
+	tables   []*SSTable
+	plan     RangePlan
+	keysOnly bool
+
+	key    []byte
+	value  []byte
+	err    error
+	closed bool
+}
+
+// NewIterator returns an iterator over the keys in [start, end]. A nil end
+// leaves the range open above.
+func (e *Engine) NewIterator(start, end []byte) (*Iterator, error) {
+	bounds := IterOptions{LowerBound: start}
+	if end != nil {
+		bounds = closedRange(start, end)
+	}
+	return e.NewBoundsIterator(bounds, RangeOptions{})
+}
+
+// NewBoundsIterator is NewIterator for keys within bounds, with options as
+// for ReadBounds.
+func (e *Engine) NewBoundsIterator(bounds IterOptions, opts RangeOptions) (*Iterator, error) {
+	e.mu.RLock()
+	rv := readView{memtable: e.memtable, immutable: e.immutable, tables: e.sstables, seq: e.visibleSeq.Load(), gen: e.tablesGen}
+	pinTables(rv.tables)
+	e.mu.RUnlock()
+
+	return e.newIteratorIn(rv, bounds, opts)
+}
+
+// NewIterator is Engine.NewIterator as of the snapshot. The iterator pins
+// the tables itself, so it may outlive the snapshot.
+func (s *Snapshot) NewIterator(start, end []byte) (*Iterator, error) {
+	bounds := IterOptions{LowerBound: start}
+	if end != nil {
+		bounds = closedRange(start, end)
+	}
+	pinTables(s.view.tables)
+	return s.engine.newIteratorIn(s.view, bounds, RangeOptions{})
+}
+
+// newIteratorIn takes over the pins of rv's tables.
+func (e *Engine) newIteratorIn(rv readView, bounds IterOptions, opts RangeOptions) (*Iterator, error) {
+	plan := planRange(rv.tables, bounds, opts)
+	merged, err := e.mergeRange(rv, bounds, plan, opts)
+	if err != nil {
+		unpinTables(rv.tables)
+		return nil, err
+	}
+	return &Iterator{engine: e, merged: merged, tables: rv.tables, plan: plan, keysOnly: opts.KeysOnly}, nil
+}
+
+// Plan returns the read plan the iterator follows.
+func (it *Iterator) Plan() RangePlan { return it.plan }
+
+// Next advances to the next key, reporting whether there is one. Once it
+// returns false, Err tells whether the iteration ended early.
+func (it *Iterator) Next() bool {
+	if it.closed || it.err != nil {
+		return false
+	}
+	if !it.merged.Next() {
+		it.err = it.engine.breaker.recordRead(it.merged.Err())
+		return false
+	}
+	it.key, it.value = it.merged.Key(), nil
+	if it.keysOnly {
+		return true
+	}
+	entry, err := it.engine.decodeEntry(it.key, it.merged.Entry())
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.value = entry.Value
+	return true
+}
+
+// Key returns the current key. It is only valid until the next call to
+// Next.
+func (it *Iterator) Key() []byte { return it.key }
+
+// Value returns the current value, or nil for a keys-only iteration. It is
+// only valid until the next call to Next.
+func (it *Iterator) Value() []byte { return it.value }
+
+// Err returns the error that ended the iteration, if any.
+func (it *Iterator) Err() error { return it.err }
+
+// Close releases the iterator's files and tables. It is safe to call more
+// than once.
+func (it *Iterator) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	err := it.merged.Close()
+	unpinTables(it.tables)
+	return err
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDeadlock    = errors.New("transaction deadlock detected")
+	ErrLockTimeout = errors.New("timed out waiting for key lock")
+)
+
+// lockManager hands out exclusive per-key locks to pessimistic
+// transactions. A wait-for graph detects deadlocks as soon as a wait would
+// close a cycle; the lock timeout bounds everything else.
+type lockManager struct {
+	mu       sync.Mutex
+	owners   map[string]uint64
+	released map[string]chan struct{}
+	waitsFor map[uint64]uint64
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		owners:   make(map[string]uint64),
+		released: make(map[string]chan struct{}),
+		waitsFor: make(map[uint64]uint64),
+	}
+}
+
+func (lm *lockManager) lock(txn uint64, key string, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	lm.mu.Lock()
+	for {
+		owner, held := lm.owners[key]
+		if !held || owner == txn {
+			lm.owners[key] = txn
+			delete(lm.waitsFor, txn)
+			lm.mu.Unlock()
+			return nil
+		}
+		if lm.wouldDeadlock(txn, owner) {
+			delete(lm.waitsFor, txn)
+			lm.mu.Unlock()
+			return ErrDeadlock
+		}
+
+		lm.waitsFor[txn] = owner
+		ch, ok := lm.released[key]
+		if !ok {
+			ch = make(chan struct{})
+			lm.released[key] = ch
+		}
+		lm.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-timer.C:
+			lm.mu.Lock()
+			delete(lm.waitsFor, txn)
+			lm.mu.Unlock()
+			return ErrLockTimeout
+		}
+		lm.mu.Lock()
+	}
+}
+
+// wouldDeadlock reports whether txn waiting on owner closes a cycle.
+func (lm *lockManager) wouldDeadlock(txn, owner uint64) bool {
+	for cur, steps := owner, 0; steps <= len(lm.waitsFor); steps++ {
+		if cur == txn {
+			return true
+		}
+		next, ok := lm.waitsFor[cur]
+		if !ok {
+			return false
+		}
+		cur = next
+	}
+	return false
+}
+
+func (lm *lockManager) unlockAll(txn uint64, keys []string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for _, key := range keys {
+		if lm.owners[key] != txn {
+			continue
+		}
+		delete(lm.owners, key)
+		if ch, ok := lm.released[key]; ok {
+			close(ch)
+			delete(lm.released, key)
+		}
+	}
+}
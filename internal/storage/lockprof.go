@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+// lockProfileRate samples one in this many acquisitions of each profiled
+// lock; 0 turns lock profiling off.
+var lockProfileRate atomic.Int64
+
+var lockWait = metrics.NewHistogramVec("logbase_lock_wait_seconds",
+	"Time sampled acquisitions of internal locks waited, by lock.",
+	[]float64{1e-6, 1e-5, 1e-4, 1e-3, 1e-2, 0.1, 1}, "lock")
+
+// SetLockProfileRate samples one in rate acquisitions of the engine, WAL
+// and memtable locks for LockProfile; 0 turns sampling off.
+func SetLockProfileRate(rate int) {
+	lockProfileRate.Store(int64(max(rate, 0)))
+}
+
+// LockStats summarizes the sampled acquisitions of one lock, across every
+// engine in the process. Contended acquisitions are those that found the
+// lock held and had to wait.
+type LockStats struct {
+	Lock       string  `json:"lock"`
+	Samples    uint64  `json:"samples"`
+	Contended  uint64  `json:"contended"`
+	TotalWait  float64 `json:"total_wait_ms"`
+	MeanWait   float64 `json:"mean_wait_ms"`
+	MaxWait    float64 `json:"max_wait_ms"`
+	SampleRate int64   `json:"sample_rate"`
+}
+
+// lockStats accumulates the samples of one lock.
+type lockStats struct {
+	name      string
+	calls     atomic.Uint64
+	samples   atomic.Uint64
+	contended atomic.Uint64
+	waitNanos atomic.Int64
+	maxNanos  atomic.Int64
+}
+
+// The profiled locks. A read-write lock's shared acquisitions are kept
+// apart from its exclusive ones.
+var (
+	engineLock     = &lockStats{name: "engine.mu"}
+	engineReadLock = &lockStats{name: "engine.mu.read"}
+	writerLock     = &lockStats{name: "engine.writeMu"}
+	memtableLock   = &lockStats{name: "memtable.mu"}
+	memtableRLock  = &lockStats{name: "memtable.mu.read"}
+	walLock        = &lockStats{name: "wal.mu"}
+
+	profiledLocks = []*lockStats{engineLock, engineReadLock, writerLock, memtableLock, memtableRLock, walLock}
+)
+
+// LockProfile returns what sampling has recorded for each profiled lock
+// since the process started.
+func LockProfile() []LockStats {
+	rate := lockProfileRate.Load()
+	profile := make([]LockStats, 0, len(profiledLocks))
+	for _, s := range profiledLocks {
+		st := LockStats{
+			Lock:       s.name,
+			Samples:    s.samples.Load(),
+			Contended:  s.contended.Load(),
+			TotalWait:  millis(s.waitNanos.Load()),
+			MaxWait:    millis(s.maxNanos.Load()),
+			SampleRate: rate,
+		}
+		if st.Samples > 0 {
+			st.MeanWait = st.TotalWait / float64(st.Samples)
+		}
+		profile = append(profile, st)
+	}
+	return profile
+}
+
+func millis(nanos int64) float64 {
+	return float64(nanos) / float64(time.Millisecond)
+}
+
+// sample reports whether this acquisition is to be timed.
+func (s *lockStats) sample() bool {
+	rate := lockProfileRate.Load()
+	return s != nil && rate > 0 && s.calls.Add(1)%uint64(rate) == 0
+}
+
+// record adds a sampled acquisition that waited wait, contended if the
+// lock was held when it was requested.
+func (s *lockStats) record(wait time.Duration, contended bool) {
+	s.samples.Add(1)
+	if contended {
+		s.contended.Add(1)
+	}
+	s.waitNanos.Add(int64(wait))
+	for {
+		old := s.maxNanos.Load()
+		if int64(wait) <= old || s.maxNanos.CompareAndSwap(old, int64(wait)) {
+			break
+		}
+	}
+	lockWait.With(s.name).Observe(wait.Seconds())
+}
+
+// profiledMutex is a sync.Mutex whose sampled acquisitions are timed into
+// stats. A nil stats profiles nothing. An uncontended acquisition, which
+// TryLock takes at once, counts as waiting 0.
+type profiledMutex struct {
+	sync.Mutex
+	stats *lockStats
+}
+
+func (m *profiledMutex) Lock() {
+	if !m.stats.sample() {
+		m.Mutex.Lock()
+		return
+	}
+	if m.Mutex.TryLock() {
+		m.stats.record(0, false)
+		return
+	}
+	start := time.Now()
+	m.Mutex.Lock()
+	m.stats.record(time.Since(start), true)
+}
+
+// profiledRWMutex is a sync.RWMutex whose sampled acquisitions are timed
+// into stats, and shared ones into readStats, as for profiledMutex.
+type profiledRWMutex struct {
+	sync.RWMutex
+	stats, readStats *lockStats
+}
+
+func (m *profiledRWMutex) Lock() {
+	if !m.stats.sample() {
+		m.RWMutex.Lock()
+		return
+	}
+	if m.RWMutex.TryLock() {
+		m.stats.record(0, false)
+		return
+	}
+	start := time.Now()
+	m.RWMutex.Lock()
+	m.stats.record(time.Since(start), true)
+}
+
+func (m *profiledRWMutex) RLock() {
+	if !m.readStats.sample() {
+		m.RWMutex.RLock()
+		return
+	}
+	if m.RWMutex.TryRLock() {
+		m.readStats.record(0, false)
+		return
+	}
+	start := time.Now()
+	m.RWMutex.RLock()
+	m.readStats.record(time.Since(start), true)
+}
@@ -0,0 +1,12 @@
+package storage
+
+import "github.com/manjeet13/logbase/internal/logging"
+
+// Subsystem loggers; levels are set through the logging package.
+var (
+	walLog        = logging.For("wal")
+	compactionLog = logging.For("compaction")
+	cacheLog      = logging.For("cache")
+	transformLog  = logging.For("transform")
+	relocateLog   = logging.For("relocate")
+)
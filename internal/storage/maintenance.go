@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrMaintenance is returned for writes while the engine is read-only for
+// maintenance; see SetReadOnly.
+var ErrMaintenance = errors.New("storage is read-only for maintenance")
+
+// ReadOnlyMode describes why and since when writes are rejected.
+type ReadOnlyMode struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// SetReadOnly rejects every write with ErrMaintenance and reason until
+// ClearReadOnly, while reads carry on, for backups, migrations or looking
+// into suspected corruption. Unlike the degraded read-only state it is
+// chosen by an operator and says nothing about the disk. Flushes and
+// compactions of writes made before it still run. Setting it again
+// replaces the reason but keeps the time it started.
+func (e *Engine) SetReadOnly(reason string) {
+	mode := &ReadOnlyMode{Reason: reason, Since: clock().UTC()}
+	if old := e.maintenance.Load(); old != nil {
+		mode.Since = old.Since
+	}
+	e.maintenance.Store(mode)
+}
+
+// ClearReadOnly accepts writes again after SetReadOnly.
+func (e *Engine) ClearReadOnly() {
+	e.maintenance.Store(nil)
+}
+
+// ReadOnly reports whether writes are rejected for maintenance, and why.
+func (e *Engine) ReadOnly() (ReadOnlyMode, bool) {
+	if mode := e.maintenance.Load(); mode != nil {
+		return *mode, true
+	}
+	return ReadOnlyMode{}, false
+}
+
+func (e *Engine) checkMaintenance() error {
+	if mode := e.maintenance.Load(); mode != nil {
+		return fmt.Errorf("%w: %s", ErrMaintenance, mode.Reason)
+	}
+	return nil
+}
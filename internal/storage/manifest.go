@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Data directory format versions. Version 1 directories predate the
+// MANIFEST; version 2 adds WAL segment headers and SSTable footers.
+const (
+	dataFormatV1      = 1
+	dataFormatV2      = 2
+	DataFormatVersion = dataFormatV2
+)
+
+const manifestName = "MANIFEST"
+
+// Optional features a data directory may depend on. A binary refuses to
+// open a directory that uses a feature it does not support.
+const (
+	FeatureBloomFilter = "filter:bloom"
+	// FeatureIndexBlock marks tables in format version 3, which store
+	// their sparse index after the data.
+	FeatureIndexBlock = "sstable:index-block"
+	// FeatureWALChecksum marks WAL segments in format version 3, whose
+	// records carry a length and CRC32.
+	FeatureWALChecksum = "wal:crc32"
+	// FeatureSeqNo marks tables and WAL segments in format version 4,
+	// whose records carry their sequence numbers.
+	FeatureSeqNo = "record:seqno"
+	// FeatureWALBatch marks WAL segments in format version 5, which may
+	// hold batch records.
+	FeatureWALBatch = "wal:batch"
+	// FeatureTombstoneFlag marks tables in format version 5, whose records
+	// carry a tombstone flag, so they may hold empty values.
+	FeatureTombstoneFlag = "sstable:tombstone-flag"
+	// FeatureBlocks marks tables in format version 6, whose records are
+	// stored in checksummed, optionally compressed blocks.
+	FeatureBlocks = "sstable:blocks"
+	// FeatureEmbeddedBloom marks tables in format version 7, which hold
+	// their bloom filter instead of a .bloom file next to them.
+	FeatureEmbeddedBloom = "sstable:embedded-bloom"
+	// FeatureTableList marks a MANIFEST that lists the live tables, which
+	// are then the only ones loaded.
+	FeatureTableList = "manifest:tables"
+)
+
+var supportedFeatures = map[string]bool{
+	FeatureBloomFilter:   true,
+	FeatureIndexBlock:    true,
+	FeatureWALChecksum:   true,
+	FeatureSeqNo:         true,
+	FeatureWALBatch:      true,
+	FeatureTombstoneFlag: true,
+	FeatureBlocks:        true,
+	FeatureEmbeddedBloom: true,
+	FeatureTableList:     true,
+}
+
+var ErrUnsupportedFeatures = errors.New("data directory uses unsupported features")
+
+// Manifest is the data directory's metadata record.
+type Manifest struct {
+	FormatVersion int      `json:"format_version"`
+	Features      []string `json:"features,omitempty"`
+	// NextFile is the first table file number not yet reserved.
+	NextFile uint64 `json:"next_file,omitempty"`
+	// Epoch counts promotions; only the engine holding the newest epoch
+	// may write to the directory. See Engine.Promote.
+	Epoch uint64 `json:"epoch,omitempty"`
+	// LastSeq is at least the sequence number of the newest flushed
+	// write, so sequence numbers keep increasing once the WAL holding
+	// them is truncated.
+	LastSeq uint64 `json:"last_seq,omitempty"`
+	// Tables lists the live tables, oldest to newest, once the directory
+	// has FeatureTableList. Flushes and compactions update it before
+	// tables are used or removed, so files it does not name are leftovers.
+	Tables []ManifestTable `json:"tables,omitempty"`
+}
+
+// ManifestTable is one live table in the MANIFEST.
+type ManifestTable struct {
+	// File is the table's name within the data directory.
+	File string `json:"file"`
+	// Level is 0 for a flushed table and 1 for one written by a merge; a
+	// rewrite keeps the level of the table it replaces.
+	Level int `json:"level"`
+	// MaxSeq is the newest sequence number in the table, or 0 if it was
+	// written before tables were listed.
+	MaxSeq uint64 `json:"max_seq,omitempty"`
+}
+
+func (m *Manifest) hasFeature(name string) bool {
+	return slices.Contains(m.Features, name)
+}
+
+// checkFeatures fails with every feature the manifest needs that this
+// binary lacks.
+func checkFeatures(m *Manifest) error {
+	var missing []string
+	for _, f := range m.Features {
+		if !supportedFeatures[f] {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFeatures, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// readManifest loads dir's MANIFEST, returning nil if it does not exist.
+func readManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// writeManifest replaces dir's MANIFEST atomically.
+func writeManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, manifestName), data)
+}
+
+// writeFileAtomic writes data to a temporary file, syncs it and renames it
+// over path, so readers see either the old or the new contents. The
+// directory is synced too, or a power loss could undo the rename.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir syncs dir, making the files created in or renamed into it
+// durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// requireFeature records that the directory now depends on feature. It must
+// be called before the first file using the feature is written.
+func (e *Engine) requireFeature(feature string) error {
+	e.manifestMu.Lock()
+	defer e.manifestMu.Unlock()
+
+	if e.manifest.hasFeature(feature) {
+		return nil
+	}
+
+	next := *e.manifest
+	next.Features = append(slices.Clone(e.manifest.Features), feature)
+	return e.commitManifest(&next)
+}
+
+// commitManifest writes next as the MANIFEST and makes it current, unless
+// the engine has been fenced. Callers hold manifestMu.
+func (e *Engine) commitManifest(next *Manifest) error {
+	if err := e.checkEpochLocked(); err != nil {
+		return err
+	}
+	if err := writeManifest(e.DataDir(), next); err != nil {
+		return err
+	}
+	e.manifest = next
+	return nil
+}
+
+// tableRecords lists tables as the MANIFEST does.
+func tableRecords(tables []*SSTable) []ManifestTable {
+	records := make([]ManifestTable, len(tables))
+	for i, t := range tables {
+		records[i] = ManifestTable{File: filepath.Base(t.Path), Level: t.level, MaxSeq: t.maxSeq}
+	}
+	return records
+}
+
+// listedTables returns the tables m lists, and whether it lists them at
+// all.
+func (m *Manifest) listedTables() ([]ManifestTable, bool) {
+	if m == nil || !m.hasFeature(FeatureTableList) {
+		return nil, false
+	}
+	return m.Tables, true
+}
+
+// recordTables makes the current tables the MANIFEST's table list. It
+// starts the list for a directory whose MANIFEST has none.
+func (e *Engine) recordTables() error {
+	e.manifestMu.Lock()
+	defer e.manifestMu.Unlock()
+
+	next := *e.manifest
+	next.Tables = tableRecords(e.tables())
+	if !next.hasFeature(FeatureTableList) {
+		next.Features = append(slices.Clone(next.Features), FeatureTableList)
+	}
+	return e.commitManifest(&next)
+}
+
+// replaceTables swaps old for added in the MANIFEST's table list, added
+// taking the place of the first of old; with no old, added go last. It is
+// called once added are written and before the engine uses them, so after
+// a crash the MANIFEST names either old or added, never both.
+func (e *Engine) replaceTables(old, added []*SSTable) error {
+	e.manifestMu.Lock()
+	defer e.manifestMu.Unlock()
+
+	removed := make(map[string]bool, len(old))
+	for _, t := range old {
+		removed[filepath.Base(t.Path)] = true
+	}
+
+	next := *e.manifest
+	next.Tables = make([]ManifestTable, 0, len(e.manifest.Tables)+len(added))
+	placed := false
+	for _, t := range e.manifest.Tables {
+		if !removed[t.File] {
+			next.Tables = append(next.Tables, t)
+			continue
+		}
+		if !placed {
+			next.Tables = append(next.Tables, tableRecords(added)...)
+			placed = true
+		}
+	}
+	if !placed {
+		next.Tables = append(next.Tables, tableRecords(added)...)
+	}
+	return e.commitManifest(&next)
+}
+
+// raiseLastSeq records in the MANIFEST that seq has been used.
+func (e *Engine) raiseLastSeq(seq uint64) error {
+	e.manifestMu.Lock()
+	defer e.manifestMu.Unlock()
+
+	if seq <= e.manifest.LastSeq {
+		return nil
+	}
+
+	next := *e.manifest
+	next.LastSeq = seq
+	return e.commitManifest(&next)
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+// memtableFilter puts a bloom filter in front of every memtable, so a
+// lookup of a key the memtable lacks skips its lock.
+var memtableFilter bool
+
+var memtableFilterSkips = metrics.NewCounter("logbase_memtable_filter_skips_total",
+	"MemTable lookups answered by the memtable's filter without taking its lock.")
+
+// memFilterHashes is the number of bits set per key.
+const memFilterHashes = 3
+
+// memFilter is a bloom filter over a memtable's keys that readers check
+// without the memtable's lock. Bits are only ever set, and a key's bits are
+// set before its version is inserted, so a reader that can see the version
+// also sees the bits.
+type memFilter struct {
+	words []atomic.Uint64
+}
+
+// newMemFilter sizes a filter for a memtable of up to bytes, at about
+// four bits per byte: a memtable entry takes at least a few dozen bytes,
+// so that leaves over a hundred bits per key.
+func newMemFilter(bytes int) *memFilter {
+	return &memFilter{words: make([]atomic.Uint64, max(bytes/16, 64))}
+}
+
+func (f *memFilter) add(key []byte) {
+	h1, h2 := memFilterHash(key)
+	n := uint64(len(f.words)) * 64
+	for i := range uint64(memFilterHashes) {
+		bit := (h1 + i*h2) % n
+		f.words[bit/64].Or(1 << (bit % 64))
+	}
+}
+
+func (f *memFilter) mightContain(key []byte) bool {
+	h1, h2 := memFilterHash(key)
+	n := uint64(len(f.words)) * 64
+	for i := range uint64(memFilterHashes) {
+		bit := (h1 + i*h2) % n
+		if f.words[bit/64].Load()&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// memFilterHash derives the two hashes that double hashing combines into
+// memFilterHashes bit positions.
+func memFilterHash(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return sum, sum>>32 | 1
+}
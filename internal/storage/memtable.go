@@ -1,48 +1,82 @@
 package storage
 
-import "sync"
-
+// MemTable keeps every version written since the last flush so readers can
+// see the table as of a sequence number. Keys are kept in order in a skip
+// list; versions of a key are kept in ascending sequence order.
 type MemTable struct {
-	mu    sync.RWMutex
-	data  map[string][]byte
+	mu    profiledRWMutex
+	data  *skipList
 	bytes int
+	// filter, if set, rules out absent keys without taking mu; see
+	// memtableFilter.
+	filter *memFilter
+}
+
+type version struct {
+	seq     uint64
+	entry   Entry
+	deleted bool
 }
 
 func NewMemTable() *MemTable {
-	return &MemTable{
-		data: make(map[string][]byte),
+	m := &MemTable{
+		mu:   profiledRWMutex{stats: memtableLock, readStats: memtableRLock},
+		data: newSkipList(),
 	}
+	if memtableFilter {
+		m.filter = newMemFilter(MemTableFlushThreshold)
+	}
+	return m
 }
 
-func (m *MemTable) Put(key, value []byte) {
+func (m *MemTable) PutEntry(key []byte, seq uint64, e Entry) {
+	m.add(key, version{seq: seq, entry: e})
+}
+
+// Delete records a tombstone that masks older versions of key.
+func (m *MemTable) Delete(key []byte, seq uint64) {
+	m.add(key, version{seq: seq, deleted: true})
+}
+
+func (m *MemTable) add(key []byte, v version) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	k := string(key)
-	if old, ok := m.data[k]; ok {
-		m.bytes -= len(old)
+	if m.filter != nil {
+		m.filter.add(key)
 	}
-
-	m.data[k] = value
-	m.bytes += len(k) + len(value)
+	node := m.data.getOrInsert(string(key))
+	v.entry.Seq, v.entry.Deleted = v.seq, v.deleted
+	node.versions = append(node.versions, v)
+	m.bytes += len(key) + v.entry.size()
 }
 
-func (m *MemTable) Get(key []byte) ([]byte, bool) {
+// GetAt returns the newest version of key with a sequence number at or below
+// snapshot. found reports whether the memtable has such a version at all;
+// deleted reports whether that version is a tombstone.
+func (m *MemTable) GetAt(key []byte, snapshot uint64) (e Entry, deleted, found bool) {
+	if m.filter != nil && !m.filter.mightContain(key) {
+		memtableFilterSkips.Inc()
+		return Entry{}, false, false
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	val, ok := m.data[string(key)]
-	return val, ok
-}
 
-func (m *MemTable) Delete(key []byte) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	node := m.data.get(string(key))
+	if node == nil {
+		return Entry{}, false, false
+	}
+	v, ok := visible(node.versions, snapshot)
+	return v.entry, v.deleted, ok
+}
 
-	k := string(key)
-	if old, ok := m.data[k]; ok {
-		m.bytes -= len(old)
-		delete(m.data, k)
+func visible(versions []version, snapshot uint64) (version, bool) {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].seq <= snapshot {
+			return versions[i], true
+		}
 	}
+	return version{}, false
 }
 
 func (m *MemTable) Size() int {
@@ -51,28 +85,71 @@ func (m *MemTable) Size() int {
 	return m.bytes
 }
 
-func (m *MemTable) Snapshot() map[string][]byte {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// keyedEntry is an entry together with its key, for lists in key order.
+type keyedEntry struct {
+	key   string
+	entry Entry
+}
 
-	snap := make(map[string][]byte, len(m.data))
-	for k, v := range m.data {
-		snap[k] = v
+// Snapshot returns the newest version of every key for flushing, tombstones
+// included. Entries carry their sequence numbers.
+func (m *MemTable) Snapshot() map[string]Entry {
+	snap := make(map[string]Entry)
+	for _, e := range m.sorted() {
+		snap[e.key] = e.entry
 	}
 	return snap
 }
 
-func (m *MemTable) Range(start, end []byte) map[string][]byte {
+// sorted is Snapshot as a list in key order, as a flush writes it.
+func (m *MemTable) sorted() []keyedEntry {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	entries := make([]keyedEntry, 0, m.data.len)
+	for n := m.data.first(); n != nil; n = n.next[0] {
+		entries = append(entries, keyedEntry{n.key, n.versions[len(n.versions)-1].entry})
+	}
+	return entries
+}
+
+// RangeAt returns the live keys in [start, end] as of snapshot.
+func (m *MemTable) RangeAt(start, end []byte, snapshot uint64) map[string][]byte {
 	result := make(map[string][]byte)
-	s := string(start)
-	e := string(end)
+	for k, e := range m.RangeEntriesAt(start, end, snapshot) {
+		if !e.Deleted {
+			result[k] = e.Value
+		}
+	}
+	return result
+}
 
-	for k, v := range m.data {
-		if k >= s && k <= e {
-			result[k] = v
+// RangeEntriesAt is RangeAt including metadata and tombstones, which can
+// mask older tables.
+func (m *MemTable) RangeEntriesAt(start, end []byte, snapshot uint64) map[string]Entry {
+	result := make(map[string]Entry)
+	for _, e := range m.entriesAt(closedRange(start, end), snapshot) {
+		result[e.key] = e.entry
+	}
+	return result
+}
+
+// entriesAt returns the keys within bounds as of snapshot, as
+// RangeEntriesAt, in key order. Only keys from the lower bound on are
+// visited.
+func (m *MemTable) entriesAt(bounds IterOptions, snapshot uint64) []keyedEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var result []keyedEntry
+	bounds = bounds.normalize()
+
+	for n := m.data.seek(string(bounds.LowerBound)); n != nil && !bounds.past(n.key); n = n.next[0] {
+		if !bounds.contains(n.key) {
+			continue
+		}
+		if v, ok := visible(n.versions, snapshot); ok {
+			result = append(result, keyedEntry{n.key, v.entry})
 		}
 	}
 	return result
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+)
+
+// iterator walks entries in ascending key order. Tombstones are entries
+// marked Deleted.
+type iterator interface {
+	Next() bool
+	Key() []byte
+	Entry() Entry
+	Err() error
+	Close() error
+}
+
+// sliceIterator iterates over entries collected in memory, in key order.
+type sliceIterator struct {
+	entries []keyedEntry
+	pos     int
+}
+
+func newSliceIterator(entries []keyedEntry) *sliceIterator {
+	return &sliceIterator{entries: entries, pos: -1}
+}
+
+func (it *sliceIterator) Next() bool {
+	it.pos++
+	return it.pos < len(it.entries)
+}
+
+func (it *sliceIterator) Key() []byte  { return []byte(it.entries[it.pos].key) }
+func (it *sliceIterator) Entry() Entry { return it.entries[it.pos].entry }
+func (it *sliceIterator) Err() error   { return nil }
+func (it *sliceIterator) Close() error { return nil }
+
+// mergeSource is one merge input. When several sources hold the same key,
+// the version with the highest sequence number wins. rank orders sources by
+// recency, a lower rank being newer, and breaks the tie for versions from
+// tables older than version 4, which read with sequence number zero.
+type mergeSource struct {
+	it   iterator
+	rank int
+	// seq is the sequence number of the current entry.
+	seq uint64
+}
+
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if c := bytes.Compare(h[i].it.Key(), h[j].it.Key()); c != 0 {
+		return c < 0
+	}
+	if h[i].seq != h[j].seq {
+		return h[i].seq > h[j].seq
+	}
+	return h[i].rank < h[j].rank
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// mergingIterator merges sorted sources, newest first, into one sorted
+// stream holding only the newest version of each key: the one with the
+// highest sequence number. Every write since tables gained sequence numbers
+// has a higher one than any entry read from an older table, and among those
+// a source's position stands in: the memtable outranks every table and newer
+// tables outrank older ones.
+type mergingIterator struct {
+	sources []iterator
+	heap    mergeHeap
+	// hideTombstones drops keys whose newest version is a delete.
+	hideTombstones bool
+	// hidePrefix, if set, drops keys starting with it.
+	hidePrefix []byte
+
+	key   []byte
+	entry Entry
+	err   error
+}
+
+// newMergingIterator merges sources ordered newest first.
+func newMergingIterator(sources []iterator, hideTombstones bool) *mergingIterator {
+	m := &mergingIterator{sources: sources, hideTombstones: hideTombstones}
+	for rank, it := range sources {
+		m.advance(&mergeSource{it: it, rank: rank})
+	}
+	heap.Init(&m.heap)
+	return m
+}
+
+// advance moves src to its next entry, keeping it in the merge if it has
+// one. The caller restores the heap order.
+func (m *mergingIterator) advance(src *mergeSource) bool {
+	if src.it.Next() {
+		src.seq = src.it.Entry().Seq
+		m.heap = append(m.heap, src)
+		return true
+	}
+	if err := src.it.Err(); err != nil && m.err == nil {
+		m.err = err
+	}
+	return false
+}
+
+func (m *mergingIterator) Next() bool {
+	for m.err == nil && len(m.heap) > 0 {
+		// The smallest key's newest version is on top
+		top := heap.Pop(&m.heap).(*mergeSource)
+		m.key = bytes.Clone(top.it.Key())
+		m.entry = top.it.Entry()
+		if m.advance(top) {
+			heap.Fix(&m.heap, len(m.heap)-1)
+		}
+
+		// Older versions of the same key are shadowed
+		for len(m.heap) > 0 && bytes.Equal(m.heap[0].it.Key(), m.key) {
+			older := heap.Pop(&m.heap).(*mergeSource)
+			if m.advance(older) {
+				heap.Fix(&m.heap, len(m.heap)-1)
+			}
+		}
+
+		if m.hideTombstones && m.entry.Deleted {
+			continue
+		}
+		if m.hidePrefix != nil && bytes.HasPrefix(m.key, m.hidePrefix) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (m *mergingIterator) Key() []byte  { return m.key }
+func (m *mergingIterator) Entry() Entry { return m.entry }
+func (m *mergingIterator) Err() error   { return m.err }
+
+// Close closes every source.
+func (m *mergingIterator) Close() error {
+	return closeIterators(m.sources)
+}
+
+func closeIterators(its []iterator) error {
+	var errs []error
+	for _, it := range its {
+		errs = append(errs, it.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// MaxMetadataSize bounds the encoded metadata stored with a single value.
+const MaxMetadataSize = 4096
+
+var (
+	ErrMetadataTooLarge = errors.New("metadata too large")
+	errCorruptMetadata  = errors.New("corrupt metadata")
+)
+
+// Entry is a stored value together with its encoded metadata, if any.
+type Entry struct {
+	Value []byte
+	Meta  []byte
+	// Seq is the sequence number of the write that stored it, or 0 for
+	// data written before sequence numbers were kept on disk.
+	Seq uint64
+	// Deleted marks a tombstone, which has no value. An empty Value is
+	// ordinary data.
+	Deleted bool
+}
+
+func (e Entry) size() int {
+	return len(e.Value) + len(e.Meta)
+}
+
+// Metadata is small descriptive data kept alongside a value: a content type
+// and arbitrary user-defined headers.
+type Metadata struct {
+	ContentType string
+	Headers     map[string]string
+}
+
+func (m Metadata) IsEmpty() bool {
+	return m.ContentType == "" && len(m.Headers) == 0
+}
+
+// Encode serializes the metadata as length-prefixed strings:
+// content type, header count, then header name/value pairs in sorted order.
+func (m Metadata) Encode() ([]byte, error) {
+	if m.IsEmpty() {
+		return nil, nil
+	}
+
+	total := len(m.ContentType)
+	names := make([]string, 0, len(m.Headers))
+	for name, value := range m.Headers {
+		names = append(names, name)
+		total += len(name) + len(value)
+	}
+	if total > MaxMetadataSize {
+		return nil, ErrMetadataTooLarge
+	}
+	sort.Strings(names)
+
+	buf := appendString(nil, m.ContentType)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(names)))
+	for _, name := range names {
+		buf = appendString(buf, name)
+		buf = appendString(buf, m.Headers[name])
+	}
+
+	if len(buf) > MaxMetadataSize {
+		return nil, ErrMetadataTooLarge
+	}
+	return buf, nil
+}
+
+func DecodeMetadata(b []byte) (Metadata, error) {
+	var m Metadata
+	if len(b) == 0 {
+		return m, nil
+	}
+
+	var ok bool
+	if m.ContentType, b, ok = readString(b); !ok || len(b) < 2 {
+		return Metadata{}, errCorruptMetadata
+	}
+
+	count := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if count > 0 {
+		m.Headers = make(map[string]string, count)
+	}
+	for i := 0; i < count; i++ {
+		var name, value string
+		if name, b, ok = readString(b); !ok {
+			return Metadata{}, errCorruptMetadata
+		}
+		if value, b, ok = readString(b); !ok {
+			return Metadata{}, errCorruptMetadata
+		}
+		m.Headers[name] = value
+	}
+
+	return m, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func readString(b []byte) (string, []byte, bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < n {
+		return "", nil, false
+	}
+	return string(b[:n]), b[n:], true
+}
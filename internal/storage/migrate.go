@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var ErrFormatTooNew = errors.New("data directory was written by a newer logbase")
+
+// migration upgrades a data directory from version to-1 to version to.
+type migration struct {
+	to   int
+	desc string
+	run  func(dataDir string) error
+}
+
+// migrations are applied in order; each must be safe to re-run if a crash
+// interrupts it before the MANIFEST records the new version.
+var migrations = []migration{
+	{to: dataFormatV2, desc: "add WAL segment headers and SSTable footers", run: migrateV1ToV2},
+}
+
+// Upgrade brings dataDir to DataFormatVersion by running every pending
+// migration, recording progress in the MANIFEST after each step. It returns
+// the version the directory was at before upgrading.
+func Upgrade(dataDir string) (int, error) {
+	m, err := readManifest(dataDir)
+	if err != nil {
+		return 0, err
+	}
+
+	version := DataFormatVersion
+	if m != nil {
+		version = m.FormatVersion
+	} else if hasLegacyData(dataDir) {
+		version = dataFormatV1
+	}
+	from := version
+
+	if version > DataFormatVersion {
+		return from, fmt.Errorf("%w: format version %d, this binary supports %d",
+			ErrFormatTooNew, version, DataFormatVersion)
+	}
+	if m != nil {
+		if err := checkFeatures(m); err != nil {
+			return from, err
+		}
+	}
+
+	for _, mig := range migrations {
+		if mig.to <= version {
+			continue
+		}
+		if err := mig.run(dataDir); err != nil {
+			return from, fmt.Errorf("upgrade to format %d (%s): %w", mig.to, mig.desc, err)
+		}
+		version = mig.to
+		if m == nil {
+			m = &Manifest{}
+		}
+		m.FormatVersion = version
+		// Migrations rewrite tables in the current table format
+		for _, f := range []string{FeatureIndexBlock, FeatureSeqNo, FeatureTombstoneFlag, FeatureBlocks, FeatureEmbeddedBloom} {
+			if !m.hasFeature(f) {
+				m.Features = append(m.Features, f)
+			}
+		}
+		if err := writeManifest(dataDir, m); err != nil {
+			return from, err
+		}
+	}
+
+	if m == nil {
+		if err := writeManifest(dataDir, &Manifest{FormatVersion: version}); err != nil {
+			return from, err
+		}
+	}
+
+	return from, nil
+}
+
+func hasLegacyData(dataDir string) bool {
+	tables, _ := filepath.Glob(filepath.Join(dataDir, "sst_*.dat"))
+	return len(tables) > 0 || len(segmentPaths(filepath.Join(dataDir, walDirName))) > 0
+}
+
+func migrateV1ToV2(dataDir string) error {
+	tables, _ := filepath.Glob(filepath.Join(dataDir, "sst_*.dat"))
+	for _, path := range tables {
+		if err := upgradeSSTable(path); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+
+	for _, path := range segmentPaths(filepath.Join(dataDir, walDirName)) {
+		if err := upgradeWALSegment(path); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+
+	return nil
+}
+
+// upgradeSSTable rewrites a footerless table in the current format, with
+// its bloom filter embedded.
+func upgradeSSTable(path string) error {
+	table := &SSTable{Path: path}
+	file, section, err := table.open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if table.version != sstableV1 {
+		return nil
+	}
+
+	data := make(map[string]Entry)
+	reader := bufio.NewReader(ioCompaction.reader(section))
+	for {
+		k, e, err := readEntry(reader, table.version)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		data[string(k)] = e
+	}
+
+	if _, err := WriteSSTable(path+".tmp", data); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// upgradeWALSegment prepends a segment header to a headerless segment. The
+// header says version 2, the first with headers, since the records after
+// it are left unframed.
+func upgradeWALSegment(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	version, err := readWALHeader(reader)
+	if err != nil || version != walV1 {
+		return err
+	}
+
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+
+	if err := writeWALHeader(tmp, walV2); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, reader); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
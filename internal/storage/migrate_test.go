@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpgradeV1(t *testing.T) {
+	dir := t.TempDir()
+
+	// A version 1 directory: footerless tables, headerless segments and no
+	// MANIFEST. Its entries have neither sequence numbers nor metadata.
+	var entries []keyedEntry
+	for _, e := range tableTestEntries(sstableV1) {
+		entries = append(entries, keyedEntry{e.key, wantEntry(e.entry, sstableV1)})
+	}
+	tablePath := filepath.Join(dir, "sst_000001.dat")
+	writeLegacyTable(t, tablePath, sstableV1, entries)
+	segment := []WALRecord{
+		{Type: PutRecord, Key: []byte("key0000"), Value: []byte("from the wal")},
+		{Type: DeleteRecord, Key: []byte("key0001")},
+		{Type: PutRecord, Key: []byte("new"), Value: []byte("value")},
+	}
+	writeLegacySegment(t, filepath.Join(dir, walDirName, "wal_000000.log"), walV1, segment)
+
+	from, err := Upgrade(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != dataFormatV1 {
+		t.Errorf("upgraded from format %d, want %d", from, dataFormatV1)
+	}
+	m, err := readManifest(dir)
+	if err != nil || m == nil {
+		t.Fatalf("reading the MANIFEST: %v, %v", m, err)
+	}
+	if m.FormatVersion != DataFormatVersion || !m.hasFeature(FeatureEmbeddedBloom) {
+		t.Errorf("MANIFEST has format %d, features %v", m.FormatVersion, m.Features)
+	}
+
+	// The table is rewritten in the current format, and the segment
+	// replays as it did before
+	checkTable(t, openTestTable(t, tablePath), sstableVersion, entries)
+	records, _, err := readSegment(filepath.Join(dir, walDirName, "wal_000000.log"), ioWAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, records, segment)
+
+	// Upgrading again is a no-op
+	if from, err := Upgrade(dir); err != nil || from != DataFormatVersion {
+		t.Errorf("second Upgrade = %d, %v", from, err)
+	}
+
+	engine, err := NewEngine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	want := map[string][]byte{
+		"key0000": []byte("from the wal"),
+		"key0001": nil,
+		"key0002": entries[2].entry.Value,
+		"key0007": nil,
+		"new":     []byte("value"),
+	}
+	for key, value := range want {
+		got, ok := engine.Get([]byte(key))
+		if ok != (value != nil) || !bytes.Equal(got, value) {
+			t.Errorf("Get(%q) = %q, %v; want %q", key, got, ok, value)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// prefixWALPrefix starts the names of prefix WAL directories, which sit in
+// the engine's WAL directory next to its own segments.
+const prefixWALPrefix = "prefix_"
+
+// prefixWAL logs the writes a prefix sync policy routes to it. It has its
+// own segments and group commit, so its fsyncs neither wait for nor cover
+// writes logged elsewhere.
+type prefixWAL struct {
+	wal   *WAL
+	group groupCommit
+}
+
+// writeRoute is where a write is logged and how durable it is made.
+type writeRoute struct {
+	// prefix names the prefix WAL that logs the write; "" is the engine's
+	// own WAL.
+	prefix string
+	// durability is nil for the engine's default, or a running
+	// experiment's.
+	durability *Durability
+}
+
+// prefixWALDir is the directory, under walDir, of the WAL for writes
+// routed to prefix.
+func prefixWALDir(walDir, prefix string) string {
+	return filepath.Join(walDir, prefixWALPrefix+hex.EncodeToString([]byte(prefix)))
+}
+
+// walDirs lists walDir and the prefix WAL directories in it.
+func walDirs(walDir string) []string {
+	dirs, _ := filepath.Glob(filepath.Join(walDir, prefixWALPrefix+"*"))
+	return append([]string{walDir}, dirs...)
+}
+
+// allSegmentPaths lists the segments of walDir and of the prefix WALs in
+// it, each WAL's in segment order.
+func allSegmentPaths(walDir string) []string {
+	var paths []string
+	for _, dir := range walDirs(walDir) {
+		paths = append(paths, segmentPaths(dir)...)
+	}
+	return paths
+}
+
+// copySegments copies the segments of the WAL directory src, prefix WALs
+// included, to the same places under dst.
+func copySegments(src, dst string) error {
+	for _, path := range allSegmentPaths(src) {
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(path, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSegments removes the segments of the WAL directory dir and its
+// prefix WALs, and then the directories, if that leaves them empty.
+func removeSegments(dir string) {
+	dirs := walDirs(dir)
+	for _, path := range allSegmentPaths(dir) {
+		os.Remove(path)
+	}
+	for _, d := range slices.Backward(dirs) {
+		os.Remove(d)
+	}
+}
+
+// openPrefixWALs opens every prefix WAL in walDir, whether or not a
+// policy still routes writes to it, so what it logged is replayed and,
+// once flushed, truncated.
+func openPrefixWALs(walDir string, faults *FaultInjector) (map[string]*prefixWAL, error) {
+	wals := make(map[string]*prefixWAL)
+	for _, dir := range walDirs(walDir)[1:] {
+		prefix, err := hex.DecodeString(strings.TrimPrefix(filepath.Base(dir), prefixWALPrefix))
+		if err != nil {
+			walLog.Warnf("ignoring %s: not a prefix WAL", filepath.Base(dir))
+			continue
+		}
+		wal, err := OpenWAL(dir)
+		if err != nil {
+			closePrefixWALs(wals)
+			return nil, err
+		}
+		wal.faults = faults
+		wals[string(prefix)] = &prefixWAL{wal: wal}
+	}
+	return wals, nil
+}
+
+// closePrefixWALs closes wals and returns the first error.
+func closePrefixWALs(wals map[string]*prefixWAL) error {
+	var first error
+	for _, p := range wals {
+		if err := p.wal.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// replayWALs replays wal and the prefix WALs and returns their records in
+// sequence order, as they were applied.
+func replayWALs(wal *WAL, prefixes map[string]*prefixWAL) ([]WALRecord, error) {
+	records, err := wal.Replay()
+	if err != nil || len(prefixes) == 0 {
+		return records, err
+	}
+	for _, p := range prefixes {
+		logged, err := p.wal.Replay()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, logged...)
+	}
+	sortBySeq(records)
+	return records, nil
+}
+
+// sortBySeq puts records from several WALs back in the order they were
+// applied. Records logged without a sequence number predate prefix WALs,
+// so they stay first, in their own order.
+func sortBySeq(records []WALRecord) {
+	slices.SortStableFunc(records, func(a, b WALRecord) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+}
+
+// walFor returns the WAL that logs writes on route, opening a prefix WAL
+// the first time a write is routed to it. Callers hold writeMu.
+func (e *Engine) walFor(route writeRoute) (*WAL, error) {
+	if route.prefix == "" {
+		return e.wal, nil
+	}
+	if p, ok := e.prefixWALs[route.prefix]; ok {
+		return p.wal, nil
+	}
+
+	wal, err := OpenWAL(prefixWALDir(filepath.Join(e.DataDir(), walDirName), route.prefix))
+	if err != nil {
+		return nil, err
+	}
+	wal.faults = e.faults
+	walLog.Infof("opened the WAL for prefix %q", route.prefix)
+
+	e.mu.Lock()
+	if e.prefixWALs == nil {
+		e.prefixWALs = make(map[string]*prefixWAL)
+	}
+	e.prefixWALs[route.prefix] = &prefixWAL{wal: wal}
+	e.mu.Unlock()
+	return wal, nil
+}
+
+// syncTarget returns the WAL writes on route were logged to and the group
+// commit that syncs it.
+func (e *Engine) syncTarget(route writeRoute) (*WAL, *groupCommit) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if p, ok := e.prefixWALs[route.prefix]; ok && route.prefix != "" {
+		return p.wal, &p.group
+	}
+	return e.wal, &e.groupCommit
+}
+
+// allWALs returns the engine's WAL followed by its prefix WALs.
+func (e *Engine) allWALs() []*WAL {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	wals := []*WAL{e.wal}
+	for _, p := range e.prefixWALs {
+		wals = append(wals, p.wal)
+	}
+	return wals
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// loggedKeys returns the keys logged to the WAL in dir, in log order.
+func loggedKeys(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var keys []string
+	for _, path := range segmentPaths(dir) {
+		records, _, err := readSegment(path, ioWAL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range records {
+			keys = append(keys, string(r.Key))
+		}
+	}
+	return keys
+}
+
+func TestPrefixWALs(t *testing.T) {
+	e := openTestEngine(t)
+	e.SetPrefixSyncPolicies([]PrefixSyncPolicy{
+		{Prefix: "crit/", Durability: Durability{Sync: true}},
+		{Prefix: "bulk/"},
+	})
+
+	// The batch is logged whole to the stricter policy's WAL, between the
+	// two puts of plain logged to the engine's
+	var batch Batch
+	batch.Put([]byte("crit/k"), []byte("x"))
+	batch.Put([]byte("plain"), []byte("2"))
+	writes := []func() error{
+		func() error { return e.Put([]byte("plain"), []byte("1")) },
+		func() error { return e.Write(&batch) },
+		func() error { return e.Put([]byte("plain"), []byte("3")) },
+		func() error { return e.Put([]byte("bulk/b"), []byte("y")) },
+	}
+	for _, write := range writes {
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	walDir := filepath.Join(e.DataDir(), walDirName)
+	want := map[string][]string{
+		walDir:                        {"plain", "plain"},
+		prefixWALDir(walDir, "crit/"): {"crit/k", "plain"},
+		prefixWALDir(walDir, "bulk/"): {"bulk/b"},
+	}
+	for dir, keys := range want {
+		if got := loggedKeys(t, dir); !slices.Equal(got, keys) {
+			t.Errorf("%s logged %q, want %q", filepath.Base(dir), got, keys)
+		}
+	}
+	if v := e.Version(); len(v.PrefixWALs) != 2 {
+		t.Errorf("Version lists prefix WALs %v", v.PrefixWALs)
+	}
+
+	// A clone holds the writes only in its WALs, so opening it replays
+	// them, merged back into the order they were applied in
+	dir := filepath.Join(t.TempDir(), "clone")
+	if err := e.Clone(dir); err != nil {
+		t.Fatal(err)
+	}
+	clone, err := NewEngine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+	for key, value := range map[string]string{"plain": "3", "crit/k": "x", "bulk/b": "y"} {
+		if got, ok := clone.Get([]byte(key)); !ok || !bytes.Equal(got, []byte(value)) {
+			t.Errorf("clone Get(%q) = %q, %v; want %q", key, got, ok, value)
+		}
+	}
+}
+
+func TestUntilGap(t *testing.T) {
+	var records []WALRecord
+	for _, seq := range []uint64{0, 3, 5, 6, 8, 9} {
+		records = append(records, WALRecord{Seq: seq})
+	}
+	if got := untilGap(records, 4); len(got) != 4 {
+		t.Errorf("untilGap kept %d records, want 4", len(got))
+	}
+}
+
+func TestGroupCommitShorterWindow(t *testing.T) {
+	e := openTestEngine(t)
+
+	// The first synced write leads a group it would hold open for an
+	// hour, unless a write that cannot wait joins it
+	slow := make(chan error, 1)
+	go func() {
+		_, err := e.PutWithOptions([]byte("slow"), []byte("v"), WriteOptions{
+			Durability: &Durability{Sync: true, GroupWindow: time.Hour},
+		})
+		slow <- err
+	}()
+	for {
+		e.groupCommit.mu.Lock()
+		led := e.groupCommit.current != nil
+		e.groupCommit.mu.Unlock()
+		if led {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	fast := make(chan error, 1)
+	go func() {
+		_, err := e.PutWithOptions([]byte("fast"), []byte("v"), WriteOptions{
+			Durability: &Durability{Sync: true},
+		})
+		fast <- err
+	}()
+	for _, c := range []chan error{fast, slow} {
+		select {
+		case err := <-c:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("a write with no group commit window waited for another's")
+		}
+	}
+}
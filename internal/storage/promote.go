@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrFenced is returned by an engine whose data directory has been taken
+// over by a newer primary (see Promote). It never accepts writes again.
+var ErrFenced = errors.New("fenced: a newer primary owns the data directory")
+
+// Promote turns a secondary into the primary for its data directory, for
+// failover when the old primary is gone or must be retired. It bumps the
+// MANIFEST epoch first, which fences the old primary: an engine refuses to
+// write files once the directory's epoch is newer than the one it holds.
+// It then catches up on the old primary's WAL, opens its own, and flushes
+// what it replayed, so the old primary's segments (which may end in a
+// partial record) are no longer needed.
+func (e *Engine) Promote() error {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if !e.secondary {
+		return ErrNotSecondary
+	}
+
+	m, err := readManifest(e.DataDir())
+	if err != nil {
+		return err
+	}
+	next := *m
+	next.Epoch++
+	e.manifestMu.Lock()
+	err = writeManifest(e.DataDir(), &next)
+	if err == nil {
+		e.manifest = &next
+	}
+	e.manifestMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := e.catchUp(true); err != nil {
+		return err
+	}
+	// The old primary may have flushed writes this engine never saw
+	if next.LastSeq > e.lastSeq {
+		e.lastSeq = next.LastSeq
+		e.publish()
+	}
+
+	if err := e.requireFeature(FeatureWALChecksum); err != nil {
+		return err
+	}
+	if err := e.requireFeature(FeatureSeqNo); err != nil {
+		return err
+	}
+	if err := e.requireFeature(FeatureWALBatch); err != nil {
+		return err
+	}
+	if err := e.requireFeature(FeatureTombstoneFlag); err != nil {
+		return err
+	}
+	if err := e.requireFeature(FeatureBlocks); err != nil {
+		return err
+	}
+	if err := e.requireFeature(FeatureEmbeddedBloom); err != nil {
+		return err
+	}
+	wal, err := OpenWAL(filepath.Join(e.DataDir(), walDirName))
+	if err != nil {
+		return err
+	}
+	prefixWALs, err := openPrefixWALs(filepath.Join(e.DataDir(), walDirName), e.faults)
+	if err != nil {
+		wal.Close()
+		return err
+	}
+	files, _ := filepath.Glob(filepath.Join(e.DataDir(), "sst_*.dat"))
+	e.initFileNumbers(files)
+	e.mu.Lock()
+	e.wal = wal
+	e.prefixWALs = prefixWALs
+	e.mu.Unlock()
+	e.transferLimiter = newRateLimiter(transferBytesPerSec)
+	e.secondary = false
+
+	if err := e.flushMemTable(); err != nil {
+		return err
+	}
+	for _, wal := range e.allWALs() {
+		wal.Truncate(wal.segment)
+	}
+	e.saveIndexCache()
+
+	// The old primary's tables become this engine's to list
+	return e.recordTables()
+}
+
+// checkEpoch fails with ErrFenced if another engine has promoted itself
+// since this one took its epoch. It re-reads the MANIFEST, so it is meant
+// for rare events such as flushes and MANIFEST updates.
+func (e *Engine) checkEpoch() error {
+	e.manifestMu.Lock()
+	defer e.manifestMu.Unlock()
+	return e.checkEpochLocked()
+}
+
+// checkEpochLocked is checkEpoch for callers holding manifestMu.
+func (e *Engine) checkEpochLocked() error {
+	if e.fenced.Load() {
+		return ErrFenced
+	}
+
+	m, err := readManifest(e.DataDir())
+	if err != nil || m == nil {
+		return err
+	}
+	if e.manifest != nil && m.Epoch > e.manifest.Epoch {
+		e.fence(m.Epoch)
+		return ErrFenced
+	}
+	return nil
+}
+
+// checkFence is the epoch check made before every WAL append. It stats the
+// MANIFEST and re-reads it only if the file has changed since the last
+// check, so the common case costs one stat. Callers hold writeMu.
+func (e *Engine) checkFence() error {
+	if e.fenced.Load() {
+		return ErrFenced
+	}
+
+	info, err := os.Stat(filepath.Join(e.DataDir(), manifestName))
+	if err != nil {
+		return err
+	}
+
+	e.manifestMu.Lock()
+	defer e.manifestMu.Unlock()
+
+	if seen := e.manifestSeen; seen != nil && os.SameFile(info, seen) &&
+		info.ModTime().Equal(seen.ModTime()) && info.Size() == seen.Size() {
+		return nil
+	}
+	if err := e.checkEpochLocked(); err != nil {
+		return err
+	}
+	e.manifestSeen = info
+	return nil
+}
+
+// fence stops the engine accepting writes for good.
+func (e *Engine) fence(epoch uint64) {
+	if e.fenced.CompareAndSwap(false, true) {
+		walLog.Errorf("fenced: data directory is at epoch %d, this engine holds %d", epoch, e.manifest.Epoch)
+	}
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// quotaBytes caps the data the engine holds (0 = unlimited). Once usage
+// crosses quotaWarnPercent of it a warning is raised, before writes start
+// failing at 100%.
+var (
+	quotaBytes       int64
+	quotaWarnPercent = 80
+)
+
+var (
+	quotaUsed = metrics.NewGauge("logbase_storage_quota_used_bytes",
+		"Bytes counted against the storage quota.")
+	quotaLimit = metrics.NewGauge("logbase_storage_quota_limit_bytes",
+		"Storage quota in bytes (0 = unlimited).")
+	quotaWarnings = metrics.NewCounter("logbase_storage_quota_warnings_total",
+		"Times usage crossed the soft quota threshold.")
+	quotaRejected = metrics.NewCounter("logbase_storage_quota_rejected_total",
+		"Writes rejected by the hard quota.")
+)
+
+// QuotaUsage describes the engine's usage against its quota.
+type QuotaUsage struct {
+	UsedBytes  int64 `json:"used_bytes"`
+	LimitBytes int64 `json:"limit_bytes"`
+}
+
+// SetQuotaWarning installs a hook that is called once each time usage
+// crosses the soft quota threshold. It runs on the write path and must not
+// block.
+func (e *Engine) SetQuotaWarning(fn func(QuotaUsage)) {
+	e.quotaWarn = fn
+}
+
+// usedBytes approximates the engine's footprint: table data plus the
+// memtables. Callers hold writeMu.
+func (e *Engine) usedBytes() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	used := int64(e.memtable.Size())
+	for _, f := range e.immutable {
+		used += int64(f.mem.Size())
+	}
+	for _, t := range e.sstables {
+		used += t.dataSize
+	}
+	return used
+}
+
+// checkQuota fails a write of n bytes that would take usage past the hard
+// quota, and raises the soft warning when usage first crosses its
+// threshold. Callers hold writeMu.
+func (e *Engine) checkQuota(n int) error {
+	if quotaBytes <= 0 {
+		return nil
+	}
+
+	used := e.usedBytes()
+	after := used + int64(n)
+
+	if after*100 < quotaBytes*int64(quotaWarnPercent) {
+		e.quotaWarned = false
+	} else if !e.quotaWarned {
+		e.quotaWarned = true
+		quotaWarnings.Inc()
+		if e.quotaWarn != nil {
+			e.quotaWarn(QuotaUsage{UsedBytes: after, LimitBytes: quotaBytes})
+		}
+	}
+
+	if after > quotaBytes {
+		quotaUsed.Set(used)
+		quotaRejected.Inc()
+		return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, used, quotaBytes)
+	}
+	quotaUsed.Set(after)
+	return nil
+}
+
+// recordsSize is the number of bytes records add; deletes are free so a
+// full engine can always shrink.
+func recordsSize(records []WALRecord) int {
+	n := 0
+	for _, r := range records {
+		if r.Type != DeleteRecord {
+			n += len(r.Key) + len(r.Value) + len(r.Meta)
+		}
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RangeOptions tunes a range read.
+type RangeOptions struct {
+	// KeysOnly skips over values on disk; the result maps each live key
+	// to nil.
+	KeysOnly bool
+}
+
+// Readahead bounds for table scans. Small ranges use a small buffer so
+// point-like scans don't over-read; large ones read in bigger chunks.
+const (
+	minReadAhead = 4 * 1024
+	maxReadAhead = 1024 * 1024
+)
+
+// RangePlan records how a range read will use each table.
+type RangePlan struct {
+	KeysOnly bool        `json:"keys_only"`
+	Tables   []TablePlan `json:"tables"`
+}
+
+// TablePlan is the plan for one table, newest first.
+type TablePlan struct {
+	Table string `json:"table"`
+	// Skip is set when the table's key range does not overlap the read.
+	Skip bool `json:"skip"`
+	// EstBytes estimates how much of the table the scan reads.
+	EstBytes  int64 `json:"est_bytes,omitempty"`
+	ReadAhead int   `json:"readahead,omitempty"`
+
+	table *SSTable
+}
+
+// String is a compact form of the plan for debug headers.
+func (p RangePlan) String() string {
+	var scanned, skipped []string
+	for _, t := range p.Tables {
+		if t.Skip {
+			skipped = append(skipped, t.Table)
+		} else {
+			scanned = append(scanned, fmt.Sprintf("%s(est=%d,ra=%d)", t.Table, t.EstBytes, t.ReadAhead))
+		}
+	}
+	return fmt.Sprintf("keys_only=%t scan=[%s] skip=[%s]",
+		p.KeysOnly, strings.Join(scanned, " "), strings.Join(skipped, " "))
+}
+
+// planRange decides, from table metadata alone, which tables a read within
+// bounds must scan and how much readahead each scan gets.
+func planRange(tables []*SSTable, bounds IterOptions, opts RangeOptions) RangePlan {
+	plan := RangePlan{KeysOnly: opts.KeysOnly}
+	bounds = bounds.normalize()
+
+	for i := len(tables) - 1; i >= 0; i-- {
+		t := tables[i]
+		tp := TablePlan{Table: filepath.Base(t.Path), table: t}
+
+		if t.entries > 0 && (bounds.before(t.maxKey) || bounds.past(t.minKey)) {
+			tp.Skip = true
+		} else {
+			tp.EstBytes = max(t.scanEstimate(bounds.UpperBound)-t.seekOffset(bounds.LowerBound), 0)
+			tp.ReadAhead = int(min(max(tp.EstBytes/8, minReadAhead), maxReadAhead))
+		}
+		plan.Tables = append(plan.Tables, tp)
+	}
+	return plan
+}
+
+// scanEstimate estimates the bytes read to scan the table up to the
+// exclusive upper bound (nil for the whole table), using the sparse index
+// when it has one.
+func (s *SSTable) scanEstimate(upper []byte) int64 {
+	if upper == nil {
+		return s.dataSize
+	}
+	i := sort.Search(len(s.Index), func(i int) bool { return s.Index[i].Key >= string(upper) })
+	if i < len(s.Index) {
+		return s.Index[i].Offset
+	}
+	return s.dataSize
+}
+
+// PlanRange returns the plan a range read would use now.
+func (e *Engine) PlanRange(start, end []byte, opts RangeOptions) RangePlan {
+	return planRange(e.view().tables, closedRange(start, end), opts)
+}
+
+// ReadKeyRangeWithOptions is ReadKeyRange with options; it also returns
+// the plan it executed.
+func (e *Engine) ReadKeyRangeWithOptions(start, end []byte, opts RangeOptions) (map[string][]byte, RangePlan, error) {
+	return e.readRangeIn(e.view(), closedRange(start, end), opts)
+}
+
+// ReadBounds is ReadKeyRangeWithOptions for keys within bounds, such as
+// every key with a prefix. Tables whose key ranges fall outside the bounds
+// are skipped and scans stop at the upper bound.
+func (e *Engine) ReadBounds(bounds IterOptions, opts RangeOptions) (map[string][]byte, RangePlan, error) {
+	return e.readRangeIn(e.view(), bounds, opts)
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket over bytes read. A nil limiter is unlimited.
+type rateLimiter struct {
+	mu       sync.Mutex
+	rate     float64 // bytes per second
+	burst    float64
+	tokens   float64
+	lastFill time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSec)
+	return &rateLimiter{rate: rate, burst: rate, tokens: rate, lastFill: time.Now()}
+}
+
+// wait blocks until n bytes' worth of tokens have been taken.
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.lastFill).Seconds()*l.rate)
+	l.lastFill = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / l.rate * float64(time.Second)))
+	}
+}
+
+// reader wraps r so every read is charged against the limiter.
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &throttledReader{r: r, limiter: l}
+}
+
+type throttledReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.limiter.wait(n)
+	return n, err
+}
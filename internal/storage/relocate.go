@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrRelocateTarget is returned by Relocate when the target directory
+// holds files already.
+var ErrRelocateTarget = errors.New("relocation target is not empty")
+
+// DataDir returns the engine's data directory.
+func (e *Engine) DataDir() string {
+	return *e.dataDir.Load()
+}
+
+// Relocate moves the data directory to dir, typically on another disk,
+// without closing the engine. dir must not exist or be empty. Tables and
+// checkpoints are copied first while reads and writes go on; then writers
+// are paused while the tables flushed meanwhile, the WAL and the small
+// state files are copied, and the engine switches to dir. The old
+// directory is emptied as its tables are released, but left in place.
+// Compaction is held off throughout. If the move fails, dir is removed
+// and the engine carries on where it was.
+func (e *Engine) Relocate(dir string) error {
+	if e.secondary {
+		return ErrSecondary
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%w: %s", ErrRelocateTarget, dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, walDirName), 0755); err != nil {
+		return err
+	}
+
+	paused := e.compactionPaused.Swap(true)
+	defer func() {
+		e.compactionPaused.Store(paused)
+		e.requestCompaction()
+	}()
+	if err := e.WaitForCompactions(); err != nil {
+		compactionLog.Warnf("relocating after a failed compaction: %v", err)
+	}
+
+	if err := e.relocate(dir); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func (e *Engine) relocate(dir string) error {
+	old := e.DataDir()
+	copied := make(map[string]bool)
+
+	// Bulk copy while serving; pinned, the tables stay put
+	tables := e.tables()
+	pinTables(tables)
+	err := copyTables(tables, dir, copied)
+	unpinTables(tables)
+	if err != nil {
+		return err
+	}
+	if err := copyCheckpoints(old, dir, copied); err != nil {
+		return err
+	}
+	relocateLog.Infof("relocating to %s: copied %d tables, catching up", dir, len(tables))
+
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	if e.DataDir() != old {
+		return fmt.Errorf("relocated to %s meanwhile", e.DataDir())
+	}
+
+	// Nothing is flushed or compacted while writers are paused, so the
+	// table set and WAL are final
+	if err := e.waitForFlushes(); err != nil {
+		return err
+	}
+	tables = e.tables()
+	if err := copyTables(tables, dir, copied); err != nil {
+		return err
+	}
+	if err := copyCheckpoints(old, dir, copied); err != nil {
+		return err
+	}
+	for _, wal := range e.allWALs() {
+		if err := wal.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := copySegments(filepath.Join(old, walDirName), filepath.Join(dir, walDirName)); err != nil {
+		return err
+	}
+	for _, name := range []string{accessName, statsName, manifestName} {
+		err := copyFile(filepath.Join(old, name), filepath.Join(dir, name))
+		if err != nil && !(errors.Is(err, os.ErrNotExist) && name != manifestName) {
+			return err
+		}
+	}
+	wal, err := OpenWAL(filepath.Join(dir, walDirName))
+	if err != nil {
+		return err
+	}
+	wal.faults = e.faults
+	prefixWALs, err := openPrefixWALs(filepath.Join(dir, walDirName), e.faults)
+	if err != nil {
+		wal.Close()
+		return err
+	}
+
+	moved := make([]*SSTable, len(tables))
+	for i, t := range tables {
+		moved[i] = t.relocated(filepath.Join(dir, filepath.Base(t.Path)))
+	}
+
+	e.mu.Lock()
+	oldWAL, oldPrefixWALs := e.wal, e.prefixWALs
+	e.wal, e.prefixWALs = wal, prefixWALs
+	e.sstables = moved
+	e.tablesGen++
+	e.hot.restamp(e.tablesGen)
+	e.dataDir.Store(&dir)
+	e.mu.Unlock()
+	relocateLog.Infof("relocated from %s to %s", old, dir)
+
+	// Empty the old directory. Tables still pinned by snapshots are
+	// removed when they are released.
+	oldWAL.Close()
+	closePrefixWALs(oldPrefixWALs)
+	for _, t := range tables {
+		t.moved.Store(true)
+		t.obsolete.Store(true)
+		if t.refs.Load() == 0 {
+			t.remove()
+		}
+	}
+	removeSegments(filepath.Join(old, walDirName))
+	for _, name := range []string{accessName, statsName, indexCacheName, manifestName} {
+		os.Remove(filepath.Join(old, name))
+	}
+	os.RemoveAll(filepath.Join(old, checkpointDirName))
+
+	e.saveIndexCache()
+	return nil
+}
+
+// relocated returns a copy of the table at path, sharing its index and
+// bloom filter.
+func (s *SSTable) relocated(path string) *SSTable {
+	t := &SSTable{
+		Path:          path,
+		Index:         s.Index,
+		Bloom:         s.Bloom,
+		version:       s.version,
+		dataSize:      s.dataSize,
+		indexSize:     s.indexSize,
+		indexCRC:      s.indexCRC,
+		bloomSize:     s.bloomSize,
+		entries:       s.entries,
+		minKey:        s.minKey,
+		maxKey:        s.maxKey,
+		indexInterval: s.indexInterval,
+		level:         s.level,
+		maxSeq:        s.maxSeq,
+	}
+	t.garbage.Store(s.garbage.Load())
+	t.cold.Store(s.cold.Load())
+	return t
+}
+
+// copyTables copies the tables' files into dir, skipping those in copied
+// and adding the rest. A table in the cold tier stays there: its symlink
+// is copied instead.
+func copyTables(tables []*SSTable, dir string, copied map[string]bool) error {
+	for _, t := range tables {
+		name := filepath.Base(t.Path)
+		if copied[name] {
+			continue
+		}
+		dst := filepath.Join(dir, name)
+		var err error
+		if target, linkErr := os.Readlink(t.Path); linkErr == nil {
+			err = os.Symlink(target, dst)
+		} else {
+			err = copyFile(t.Path, dst)
+		}
+		if err != nil {
+			return err
+		}
+		err = copyFile(t.Path+".bloom", dst+".bloom")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		copied[name] = true
+	}
+	return nil
+}
+
+// copyCheckpoints copies old's checkpoints into dir, skipping those in
+// copied and adding the rest.
+func copyCheckpoints(old, dir string, copied map[string]bool) error {
+	infos, err := ListCheckpoints(old)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		key := checkpointDirName + "/" + info.Name
+		if copied[key] {
+			continue
+		}
+		src := filepath.Join(old, checkpointDirName, info.Name)
+		dst := filepath.Join(dir, checkpointDirName, info.Name)
+		err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(src, path)
+			if d.IsDir() {
+				return os.MkdirAll(filepath.Join(dst, rel), 0755)
+			}
+			return copyFile(path, filepath.Join(dst, rel))
+		})
+		if err != nil {
+			return err
+		}
+		copied[key] = true
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// garbageRewriteRatio is the estimated fraction of dead entries at which a
+// table is rewritten on its own rather than waiting for a full compaction.
+var garbageRewriteRatio = 0.5
+
+func (s *SSTable) garbageRatio() float64 {
+	if s.entries == 0 {
+		return 0
+	}
+	return float64(s.garbage.Load()) / float64(s.entries)
+}
+
+// trackGarbage updates the dead-entry estimates after table is flushed with
+// data: keys it overwrites become garbage in older tables, and its own
+// tombstones are garbage if no older table has the key. The estimates use
+// bloom filters only, so they are cheap but approximate; rewriteTable does
+// the exact check. older are the tables before table. It runs on the
+// flusher goroutine without writeMu; the counters are atomics, so
+// compaction and rewrites can read them meanwhile.
+func (e *Engine) trackGarbage(older []*SSTable, table *SSTable, data []keyedEntry) {
+	for _, t := range older {
+		if t.Bloom == nil {
+			continue
+		}
+		var n int64
+		for _, e := range data {
+			if t.Bloom.MightContain([]byte(e.key)) {
+				n++
+			}
+		}
+		t.garbage.Add(n)
+	}
+
+	for _, e := range data {
+		if e.entry.Deleted && !mightBeIn(older, []byte(e.key)) {
+			table.garbage.Add(1)
+		}
+	}
+}
+
+func mightBeIn(tables []*SSTable, key []byte) bool {
+	for _, t := range tables {
+		if t.Bloom == nil || t.Bloom.MightContain(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteTable rewrites the i'th table without its garbage: entries shadowed
+// by a newer table, and tombstones with nothing older left to mask. Unlike
+// compactAll it leaves every other table alone. It runs on the compactor
+// goroutine, so the i'th table stays put while flushes append newer ones.
+func (e *Engine) rewriteTable(i int) error {
+	if err := e.faults.inject(FaultCompaction); err != nil {
+		return err
+	}
+
+	current := e.tables()
+	table := current[i]
+	data, err := table.scanRange(IterOptions{}, rangeScan{io: ioCompaction})
+	if err != nil {
+		return err
+	}
+	total := len(data)
+
+	// Entries shadowed by newer tables
+	for _, t := range current[i+1:] {
+		e.yieldCompaction()
+
+		newer, err := t.scanRange(IterOptions{}, rangeScan{io: ioCompaction})
+		if err != nil {
+			return err
+		}
+		for k := range newer {
+			delete(data, k)
+		}
+	}
+
+	// Tombstones that no longer mask anything
+	older := current[:i]
+	for k, v := range data {
+		if !v.Deleted {
+			continue
+		}
+		masks, err := inAny(older, []byte(k))
+		if err != nil {
+			return err
+		}
+		if !masks {
+			delete(data, k)
+		}
+	}
+
+	if len(data) == total {
+		table.garbage.Store(0)
+		return nil
+	}
+
+	var replacement []*SSTable
+	if len(data) > 0 {
+		n, err := e.newFileNumber()
+		if err != nil {
+			return err
+		}
+		t, err := WriteSSTable(rewritePath(table.Path, n), data)
+		if err != nil {
+			return err
+		}
+		t.level = table.level
+		replacement = []*SSTable{t}
+	}
+	if err := e.replaceTables([]*SSTable{table}, replacement); err != nil {
+		for _, t := range replacement {
+			t.remove()
+		}
+		return err
+	}
+
+	e.mu.Lock()
+	tables := make([]*SSTable, 0, len(e.sstables))
+	tables = append(tables, e.sstables[:i]...)
+	tables = append(tables, replacement...)
+	tables = append(tables, e.sstables[i+1:]...)
+	e.sstables = tables
+	e.tablesGen++
+	e.hot.restamp(e.tablesGen)
+	e.mu.Unlock()
+
+	compactionLog.Infof("rewrote %s, keeping %d of %d entries", filepath.Base(table.Path), len(data), total)
+	table.obsolete.Store(true)
+	if table.refs.Load() == 0 {
+		table.remove()
+	}
+
+	return nil
+}
+
+// inAny reports whether any of tables holds key.
+func inAny(tables []*SSTable, key []byte) (bool, error) {
+	for _, t := range tables {
+		if t.Bloom != nil && !t.Bloom.MightContain(key) {
+			continue
+		}
+		_, ok, err := t.getEntry(key, rangeScan{io: ioCompaction})
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rewritePath names a rewritten table so it sorts in the same position as
+// the table it replaces: sst_000003.dat becomes sst_000003.r000012.dat.
+func rewritePath(path string, n uint64) string {
+	dir, name := filepath.Split(path)
+	stem, _, _ := strings.Cut(name, ".")
+	return filepath.Join(dir, fmt.Sprintf("%s.r%06d.dat", stem, n))
+}
@@ -0,0 +1,23 @@
+package storage
+
+import "testing"
+
+func TestRewriteKeepsHighKeys(t *testing.T) {
+	// The tombstone masks nothing, so the rewrite drops it and must keep
+	// every other key
+	e := openTestEngine(t)
+	putHighKeys(t, e, "1", "a")
+	if err := e.Delete([]byte("gone")); err != nil {
+		t.Fatal(err)
+	}
+	flushTestEngine(t, e)
+
+	table := e.tables()[0]
+	if err := e.rewriteTable(0); err != nil {
+		t.Fatal(err)
+	}
+	if e.tables()[0] == table {
+		t.Fatal("rewrite left the table in place")
+	}
+	checkHighKeys(t, e, "1", "a")
+}
@@ -0,0 +1,28 @@
+package storage
+
+import "math/rand/v2"
+
+// SampleKeys returns up to n live keys chosen uniformly at random using
+// reservoir sampling over the whole keyspace.
+func (e *Engine) SampleKeys(n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	all, _, err := e.ReadKeyRangeWithOptions([]byte(""), []byte("\xff"), RangeOptions{KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	sample := make([]string, 0, n)
+	seen := 0
+	for k := range all {
+		seen++
+		if len(sample) < n {
+			sample = append(sample, k)
+		} else if j := rand.IntN(seen); j < n {
+			sample[j] = k
+		}
+	}
+	return sample, nil
+}
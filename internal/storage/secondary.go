@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+var (
+	ErrSecondary    = errors.New("secondary instance is read-only")
+	ErrNotSecondary = errors.New("not a secondary instance")
+)
+
+// OpenSecondary opens a data directory that a primary engine, possibly in
+// another process, is writing to. The secondary never writes to the
+// directory; it serves reads from the state it last caught up to, and
+// TryCatchUp brings it forward.
+//
+// Tables the primary compacts away are deleted immediately, so reads on a
+// secondary that has fallen behind can fail with a not-exist error until
+// its next catch-up.
+func OpenSecondary(dataDir string) (*Engine, error) {
+	m, err := readManifest(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("%s: no %s; open it with a primary first", dataDir, manifestName)
+	}
+	if m.FormatVersion != DataFormatVersion {
+		return nil, fmt.Errorf("%w: format version %d, this binary supports %d",
+			ErrFormatTooNew, m.FormatVersion, DataFormatVersion)
+	}
+	if err := checkFeatures(m); err != nil {
+		return nil, err
+	}
+
+	engine := &Engine{
+		mu:        profiledRWMutex{stats: engineLock, readStats: engineReadLock},
+		writeMu:   profiledMutex{stats: writerLock},
+		memtable:  NewMemTable(),
+		manifest:  m,
+		locks:     newLockManager(),
+		tail:      tailLog{retention: defaultTailRetention},
+		lastSeq:   m.LastSeq,
+		secondary: true,
+
+		tableFiles: newTableFiles(maxOpenTables),
+	}
+	engine.dataDir.Store(&dataDir)
+	if err := engine.TryCatchUp(); err != nil {
+		return nil, err
+	}
+	// Idle until a promotion makes it the primary
+	engine.startCompactor()
+	engine.startFlusher()
+	return engine, nil
+}
+
+// TryCatchUp reloads the primary's MANIFEST and tables and replays its WAL
+// so the secondary reflects every write the primary has made durable, and
+// the primary's epoch. Reads in
+// progress keep the view they started with.
+func (e *Engine) TryCatchUp() error {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if !e.secondary {
+		return ErrNotSecondary
+	}
+	return e.catchUp(false)
+}
+
+// catchUp is TryCatchUp for callers holding writeMu. final is set by
+// Promote, once the old primary is fenced and logs nothing more.
+func (e *Engine) catchUp(final bool) error {
+	// Read the WAL before listing tables: a flush in between then shows
+	// up in both rather than in neither.
+	records, split, err := tailWALs(filepath.Join(e.DataDir(), walDirName))
+	if err != nil {
+		return err
+	}
+	m, err := readManifest(e.DataDir())
+	if err != nil {
+		return err
+	}
+	if split && !final && m != nil {
+		records = untilGap(records, m.LastSeq)
+	}
+
+	memtable := NewMemTable()
+	seq := e.lastSeq
+	for _, r := range records {
+		applied := replaySeq(r, seq)
+		switch r.Type {
+		case PutRecord, PutMetaRecord:
+			memtable.PutEntry(r.Key, applied, Entry{Value: r.Value, Meta: r.Meta})
+		default:
+			memtable.Delete(r.Key, applied)
+		}
+		seq = max(seq, applied)
+	}
+
+	known := make(map[string]*SSTable, len(e.sstables))
+	for _, t := range e.sstables {
+		known[t.Path] = t
+	}
+
+	live, _ := liveTables(e.DataDir(), m)
+
+	var cache map[string]cachedTable
+	if len(live) > len(known) {
+		cache = readIndexCache(e.DataDir())
+	}
+
+	tables := make([]*SSTable, 0, len(live))
+	for _, rec := range live {
+		f := filepath.Join(e.DataDir(), rec.File)
+		if t, ok := known[f]; ok {
+			tables = append(tables, t)
+			continue
+		}
+
+		table := &SSTable{Path: f, level: rec.Level, maxSeq: rec.MaxSeq}
+		table.detectTier()
+		if c, ok := cache[filepath.Base(f)]; table.loadFromCache(c, ok) {
+			tables = append(tables, table)
+			continue
+		}
+
+		if err := table.LoadIndex(); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// compacted away since the MANIFEST was read
+				continue
+			}
+			return err
+		}
+		table.Bloom, _, _ = table.readBloom()
+		tables = append(tables, table)
+	}
+
+	// The MANIFEST carries the primary's epoch, and sequence numbers it
+	// flushed whose records are gone from the WAL
+	if m != nil {
+		seq = max(seq, m.LastSeq)
+	}
+
+	e.mu.Lock()
+	changed := !slices.Equal(e.sstables, tables)
+	e.memtable = memtable
+	e.sstables = tables
+	if changed {
+		e.tablesGen++
+	}
+	if m != nil {
+		e.manifestMu.Lock()
+		e.manifest = m
+		e.manifestMu.Unlock()
+	}
+	e.lastSeq = seq
+	e.visibleSeq.Store(seq)
+	e.mu.Unlock()
+
+	if changed {
+		// The primary's flushes arrive as new tables, not snapshots
+		return e.refreshHot()
+	}
+	return nil
+}
+
+// tailWALs reads the primary's WAL and prefix WALs as tailWAL does, and
+// returns their records in sequence order. split reports whether there was
+// more than one WAL to read.
+func tailWALs(walDir string) (records []WALRecord, split bool, err error) {
+	dirs := walDirs(walDir)
+	for _, dir := range dirs {
+		logged, err := tailWAL(dir)
+		if err != nil {
+			return nil, false, err
+		}
+		records = append(records, logged...)
+	}
+	if len(dirs) > 1 {
+		sortBySeq(records)
+	}
+	return records, len(dirs) > 1, nil
+}
+
+// untilGap cuts records, in sequence order, at the first sequence number
+// past flushed that is missing. The primary's WALs are read one after
+// another while it appends to them, so a record can be read without one
+// logged before it to another WAL; the next catch-up reads both. Every
+// write past flushed is in some WAL, so a gap that stays is one the
+// primary lost in a crash, and holds the secondary back until the primary
+// flushes past it.
+func untilGap(records []WALRecord, flushed uint64) []WALRecord {
+	next := flushed + 1
+	for i, r := range records {
+		switch {
+		case r.Seq == 0 || r.Seq < next:
+		case r.Seq == next:
+			next++
+		default:
+			return records[:i]
+		}
+	}
+	return records
+}
+
+// tailWAL reads every complete record in a WAL that is still being
+// written. The newest segment may end in a partially written record, and
+// older segments may be truncated away while they are read.
+func tailWAL(dir string) ([]WALRecord, error) {
+	paths := segmentPaths(dir)
+	records := []WALRecord{}
+
+	for i, path := range paths {
+		segment, _, err := readSegment(path, ioReplication)
+		switch {
+		case err == nil:
+		case errors.Is(err, os.ErrNotExist):
+			continue
+		case errors.Is(err, io.ErrUnexpectedEOF) && i == len(paths)-1:
+		default:
+			return nil, fmt.Errorf("wal %s: %w", filepath.Base(path), err)
+		}
+		records = append(records, segment...)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestSecondaryCatchUpManifest(t *testing.T) {
+	dir := t.TempDir()
+	primary, err := NewEngine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	secondary, err := OpenSecondary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondary.Close()
+
+	// Another node promotes itself and flushes writes this one never saw
+	m, err := readManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := *m
+	next.Epoch++
+	next.LastSeq += 10
+	if err := writeManifest(dir, &next); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := secondary.TryCatchUp(); err != nil {
+		t.Fatal(err)
+	}
+	v := secondary.Version()
+	if v.Manifest.Epoch != next.Epoch {
+		t.Errorf("secondary is at epoch %d, want %d", v.Manifest.Epoch, next.Epoch)
+	}
+	if v.LastSeq != next.LastSeq || v.VisibleSeq != next.LastSeq {
+		t.Errorf("secondary is at sequence number %d (visible %d), want %d", v.LastSeq, v.VisibleSeq, next.LastSeq)
+	}
+	if got, ok := secondary.Get([]byte("key")); !ok || string(got) != "value" {
+		t.Errorf("Get(key) = %q, %v", got, ok)
+	}
+}
@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// simulating is the simulation in progress, if any. While it is set, the
+// engines it opens take their clock, the outcome of WAL writes and the
+// scheduling of flushes and compactions from it.
+var simulating atomic.Pointer[simulation]
+
+// clock is the engine's wall clock, which a simulation replaces.
+func clock() time.Time {
+	if s := simulating.Load(); s != nil {
+		return s.now
+	}
+	return time.Now()
+}
+
+// SimulationOptions configures Simulate.
+type SimulationOptions struct {
+	Seed  uint64
+	Steps int
+	// Dir is an empty directory for the simulated data directory.
+	Dir string
+	// SyncFailPercent is the chance that a WAL write fails, as a disk
+	// error would.
+	SyncFailPercent int
+	// Trace, if set, receives a line for every action.
+	Trace io.Writer
+}
+
+// SimulationReport summarizes a simulation that found nothing wrong.
+type SimulationReport struct {
+	Steps int            `json:"steps"`
+	Ops   map[string]int `json:"ops"`
+	// Digest is a hash of the trace. Runs with the same options have the
+	// same digest; a different one means something was not deterministic.
+	Digest string `json:"digest"`
+}
+
+// errSimulatedFault is the error of a WAL write the simulation fails.
+var errSimulatedFault = errors.New("simulated fault")
+
+// simKeys is the size of the simulated key space. It is small so writes
+// overwrite and delete each other's keys across tables.
+const simKeys = 32
+
+// simulation runs a primary and a secondary engine on one data directory
+// from a single goroutine. Every choice is drawn from one seeded source:
+// which action comes next, the clock, which WAL writes fail, and what else
+// happens at each point where compaction yields, which is where a
+// background compaction would race with writes, flushes and catch-ups.
+// The same seed replays the same run, so a failure is reproduced exactly.
+type simulation struct {
+	opts SimulationOptions
+	rng  *rand.Rand
+	now  time.Time
+
+	primary   *Engine
+	secondary *Engine
+	// model holds the values each key may have: one after a successful
+	// write, more after a failed one, which may or may not have landed.
+	// "" means absent.
+	model  map[string][]string
+	writes int
+
+	step       int
+	compacting bool
+	ops        map[string]int
+	digest     hash.Hash
+	// failure is the first check that failed.
+	failure error
+}
+
+// simAction is something the simulation can do. Actions marked
+// concurrent may also run while a compaction is in progress.
+type simAction struct {
+	name       string
+	weight     int
+	concurrent bool
+	run        func(*simulation) error
+}
+
+// simActions returns the actions and their weights. It is a function, not
+// a table, since the actions lead back to the simulation through yield.
+func simActions() []simAction {
+	return []simAction{
+		{"put", 40, true, (*simulation).put},
+		{"delete", 10, true, (*simulation).delete},
+		{"get", 15, true, (*simulation).get},
+		{"flush", 5, true, (*simulation).flush},
+		{"flush-frozen", 6, true, (*simulation).flushFrozen},
+		{"compact", 8, false, (*simulation).compact},
+		{"catch-up", 8, true, (*simulation).catchUp},
+		{"checkpoint", 2, true, (*simulation).checkpoint},
+		{"restart", 3, false, (*simulation).restart},
+		{"tick", 9, true, (*simulation).tick},
+	}
+}
+
+// Simulate runs a deterministic simulation of a primary engine and a
+// secondary following it, checking every read against a model of what was
+// written. It returns an error naming the seed and step of the first
+// check that fails. Engines must not be opened elsewhere in the process
+// while it runs.
+func Simulate(opts SimulationOptions) (*SimulationReport, error) {
+	if entries, err := os.ReadDir(opts.Dir); err != nil || len(entries) > 0 {
+		return nil, fmt.Errorf("simulation needs an empty directory: %s", opts.Dir)
+	}
+
+	s := &simulation{
+		opts:   opts,
+		rng:    rand.New(rand.NewPCG(opts.Seed, opts.Seed)),
+		now:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		model:  make(map[string][]string, simKeys),
+		ops:    make(map[string]int),
+		digest: sha256.New(),
+	}
+	for i := range simKeys {
+		s.model[simKey(i)] = []string{""}
+	}
+	if !simulating.CompareAndSwap(nil, s) {
+		return nil, errors.New("a simulation is already running")
+	}
+	defer simulating.Store(nil)
+
+	// Small tables, so a short run flushes and compacts often
+	for _, restore := range []func(){
+		setFor(&MemTableFlushThreshold, 512),
+		setFor(&compactionParallelism, 1),
+		setFor(&compactionTargetFileSize, 2048),
+		setFor(&indexInterval, 4),
+		setFor(&sstableBlockSize, 64),
+		setFor(&blockCompression, BlockCodecFlate),
+		setFor(&blockCache.capacity, 1024),
+		setFor(&maxOpenTables, 4),
+		setFor(&ioErrorThreshold, math.MaxInt),
+	} {
+		defer restore()
+	}
+
+	var err error
+	if s.primary, err = NewEngine(opts.Dir); err != nil {
+		return nil, err
+	}
+	if s.secondary, err = OpenSecondary(opts.Dir); err != nil {
+		return nil, err
+	}
+
+	for s.step = 1; s.step <= opts.Steps; s.step++ {
+		if err := s.pick(false).run(s); err != nil {
+			return nil, s.fail(err)
+		}
+		if s.failure != nil {
+			return nil, s.failure
+		}
+	}
+
+	s.record("close")
+	if err := s.primary.Close(); err != nil {
+		return nil, s.fail(err)
+	}
+	s.secondary.Close()
+
+	return &SimulationReport{
+		Steps:  opts.Steps,
+		Ops:    s.ops,
+		Digest: hex.EncodeToString(s.digest.Sum(nil)),
+	}, nil
+}
+
+// setFor sets *p to v and returns a function that restores it.
+func setFor[T any](p *T, v T) func() {
+	old := *p
+	*p = v
+	return func() { *p = old }
+}
+
+func simKey(i int) string {
+	return fmt.Sprintf("key-%02d", i)
+}
+
+// pick draws the next action by weight.
+func (s *simulation) pick(concurrent bool) simAction {
+	actions := simActions()
+	total := 0
+	for _, a := range actions {
+		if a.concurrent || !concurrent {
+			total += a.weight
+		}
+	}
+	n := s.rng.IntN(total)
+	for _, a := range actions {
+		if a.concurrent || !concurrent {
+			if n -= a.weight; n < 0 {
+				// Every action takes a moment
+				s.now = s.now.Add(time.Millisecond)
+				s.ops[a.name]++
+				return a
+			}
+		}
+	}
+	panic("unreachable")
+}
+
+// record adds a line to the trace.
+func (s *simulation) record(format string, args ...any) {
+	line := fmt.Sprintf("%d: %s\n", s.step, fmt.Sprintf(format, args...))
+	s.digest.Write([]byte(line))
+	if s.opts.Trace != nil {
+		io.WriteString(s.opts.Trace, line)
+	}
+}
+
+func (s *simulation) fail(err error) error {
+	return fmt.Errorf("simulation seed %d, step %d: %w", s.opts.Seed, s.step, err)
+}
+
+// yield is a compaction scheduling point. Half the time, a few other
+// actions run before the compaction goes on.
+func (s *simulation) yield() {
+	if !s.compacting || s.failure != nil || s.rng.IntN(2) == 0 {
+		return
+	}
+	for range 1 + s.rng.IntN(3) {
+		a := s.pick(true)
+		s.record("during compaction:")
+		if err := a.run(s); err != nil {
+			s.failure = s.fail(err)
+			return
+		}
+	}
+}
+
+// sync decides whether a WAL write succeeds.
+func (s *simulation) sync() error {
+	if s.opts.SyncFailPercent > 0 && s.rng.IntN(100) < s.opts.SyncFailPercent {
+		s.record("wal write fails")
+		return &fs.PathError{Op: "sync", Path: "simulation", Err: errSimulatedFault}
+	}
+	return nil
+}
+
+// wrote updates the model after a write of value to key.
+func (s *simulation) wrote(key, value string, err error) {
+	if err != nil {
+		s.model[key] = append(s.model[key], value)
+		return
+	}
+	s.model[key] = []string{value}
+}
+
+func (s *simulation) put() error {
+	key := simKey(s.rng.IntN(simKeys))
+	s.writes++
+	value := fmt.Sprintf("v%d", s.writes)
+	err := s.primary.Put([]byte(key), []byte(value))
+	s.record("put %s=%s: %v", key, value, err)
+	s.wrote(key, value, err)
+	return nil
+}
+
+func (s *simulation) delete() error {
+	key := simKey(s.rng.IntN(simKeys))
+	err := s.primary.Delete([]byte(key))
+	s.record("delete %s: %v", key, err)
+	s.wrote(key, "", err)
+	return nil
+}
+
+func (s *simulation) get() error {
+	return s.check("primary", s.primary, simKey(s.rng.IntN(simKeys)))
+}
+
+// check reads key from engine and compares it with the model.
+func (s *simulation) check(name string, engine *Engine, key string) error {
+	value, _ := engine.Get([]byte(key))
+	s.record("get %s from %s: %q", key, name, value)
+	if !slices.Contains(s.model[key], string(value)) {
+		return fmt.Errorf("%s: %s is %q, want one of %q", name, key, value, s.model[key])
+	}
+	return nil
+}
+
+// checkAll checks every key.
+func (s *simulation) checkAll(name string, engine *Engine) error {
+	for i := range simKeys {
+		if err := s.check(name, engine, simKey(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *simulation) flush() error {
+	s.primary.writeMu.Lock()
+	err := s.primary.breaker.recordWrite(s.primary.flushMemTable())
+	s.primary.writeMu.Unlock()
+	s.record("flush: %v (%d tables)", err, len(s.primary.tables()))
+	return nil
+}
+
+// flushFrozen writes the memtables frozen so far, as the flusher would in
+// the background.
+func (s *simulation) flushFrozen() error {
+	err := s.primary.flushRound()
+	s.record("flush frozen: %v (%d tables)", err, len(s.primary.tables()))
+	return nil
+}
+
+func (s *simulation) compact() error {
+	s.primary.requestCompaction()
+	s.compacting = true
+	_, err := s.primary.runQueuedCompaction()
+	s.compacting = false
+	s.record("compact: %v (%d tables)", err, len(s.primary.tables()))
+	return nil
+}
+
+// catchUp brings the secondary forward and checks that it has every write.
+func (s *simulation) catchUp() error {
+	if err := s.secondary.TryCatchUp(); err != nil {
+		return fmt.Errorf("secondary catch-up: %w", err)
+	}
+	s.record("catch-up (%d tables)", len(s.secondary.tables()))
+	return s.checkAll("secondary", s.secondary)
+}
+
+func (s *simulation) checkpoint() error {
+	info, err := s.primary.Checkpoint()
+	if err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	s.record("checkpoint %s (%d tables)", info.Name, info.Tables)
+	return s.primary.PruneCheckpoints(2)
+}
+
+// restart closes the primary, or abandons it as if the process crashed,
+// and opens the data directory again. Sequence numbers must carry on from
+// the last acknowledged write.
+func (s *simulation) restart() error {
+	lastSeq := s.primary.lastSeq
+	crash := s.rng.IntN(2) == 0
+	if crash {
+		s.record("crash")
+	} else {
+		err := s.primary.Close()
+		s.record("close: %v", err)
+	}
+
+	engine, err := NewEngine(s.opts.Dir)
+	if err != nil {
+		return fmt.Errorf("reopening: %w", err)
+	}
+	s.primary = engine
+	s.record("reopen (%d tables)", len(engine.tables()))
+	if engine.lastSeq < lastSeq {
+		return fmt.Errorf("reopened at sequence number %d, before %d", engine.lastSeq, lastSeq)
+	}
+	return s.checkAll("primary", engine)
+}
+
+func (s *simulation) tick() error {
+	s.now = s.now.Add(time.Duration(1+s.rng.IntN(5000)) * time.Millisecond)
+	s.record("clock %s", s.now.Format(time.RFC3339Nano))
+	return nil
+}
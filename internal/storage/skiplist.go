@@ -0,0 +1,86 @@
+package storage
+
+import "math/rand/v2"
+
+const skipListMaxLevel = 20
+
+// skipList keeps the memtable's keys in order, so scans start at their
+// lower bound and flushes walk keys without sorting them. It is not safe
+// for concurrent use; the memtable's lock guards it.
+type skipList struct {
+	head  skipNode
+	level int
+	len   int
+}
+
+type skipNode struct {
+	key      string
+	versions []version
+	next     []*skipNode
+}
+
+func newSkipList() *skipList {
+	return &skipList{head: skipNode{next: make([]*skipNode, skipListMaxLevel)}, level: 1}
+}
+
+// seek returns the first node whose key is at or above key, or nil.
+func (l *skipList) seek(key string) *skipNode {
+	n := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].key < key {
+			n = n.next[i]
+		}
+	}
+	return n.next[0]
+}
+
+// get returns the node for key, or nil.
+func (l *skipList) get(key string) *skipNode {
+	if n := l.seek(key); n != nil && n.key == key {
+		return n
+	}
+	return nil
+}
+
+// getOrInsert returns the node for key, inserting an empty one if needed.
+func (l *skipList) getOrInsert(key string) *skipNode {
+	var prev [skipListMaxLevel]*skipNode
+	n := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].key < key {
+			n = n.next[i]
+		}
+		prev[i] = n
+	}
+	if next := n.next[0]; next != nil && next.key == key {
+		return next
+	}
+
+	level := randomLevel()
+	for i := l.level; i < level; i++ {
+		prev[i] = &l.head
+	}
+	l.level = max(l.level, level)
+
+	node := &skipNode{key: key, next: make([]*skipNode, level)}
+	for i := range level {
+		node.next[i] = prev[i].next[i]
+		prev[i].next[i] = node
+	}
+	l.len++
+	return node
+}
+
+// first returns the node with the smallest key, or nil.
+func (l *skipList) first() *skipNode {
+	return l.head.next[0]
+}
+
+// randomLevel picks a node's height: each extra level with probability 1/4.
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Uint32()&3 == 0 {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSnapshotSkipsBlockCache(t *testing.T) {
+	withBlocks(t, 256, BlockCodecNone)
+	old := BlockCache().CapacityBytes
+	SetBlockCacheSize(1 << 20)
+	t.Cleanup(func() { SetBlockCacheSize(old) })
+
+	e := openTestEngine(t)
+	for i := range 100 {
+		if err := e.Put(fmt.Appendf(nil, "key%03d", i), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flushTestEngine(t, e)
+
+	snap := e.NewSnapshot(SnapshotOptions{})
+	defer snap.Release()
+	before := BlockCache()
+	for i := range 100 {
+		if _, ok := snap.Get(fmt.Appendf(nil, "key%03d", i)); !ok {
+			t.Fatalf("snapshot lost key%03d", i)
+		}
+	}
+	after := BlockCache()
+	if after.Blocks != before.Blocks || after.Hits != before.Hits || after.Misses != before.Misses {
+		t.Errorf("snapshot lookups touched the block cache: %+v, then %+v", before, after)
+	}
+
+	// The serving path still fills it
+	if _, ok := e.Get([]byte("key050")); !ok {
+		t.Fatal("lost key050")
+	}
+	if BlockCache().Blocks == after.Blocks {
+		t.Error("Get did not fill the block cache")
+	}
+}
@@ -2,17 +2,67 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
+	"sync"
+	"sync/atomic"
 )
 
 type SSTable struct {
 	Path  string
 	Index []IndexEntry
 	Bloom *BloomFilter
+
+	version  uint32
+	dataSize int64
+	// indexSize and indexCRC locate and check a version 3 index block,
+	// which starts at dataSize.
+	indexSize int64
+	indexCRC  uint32
+	// bloomSize is the size of a version 7 table's bloom filter block,
+	// which follows the index block.
+	bloomSize int64
+
+	// entries is the table's record count; garbage estimates how many of
+	// them are dead (see trackGarbage).
+	entries int64
+	garbage atomic.Int64
+
+	// minKey and maxKey bound the table's keys.
+	minKey, maxKey string
+	// indexInterval is the number of records between Index entries; 0 in
+	// a block table, which has an entry per block.
+	indexInterval int
+	// level and maxSeq are recorded for the table in the MANIFEST.
+	level  int
+	maxSeq uint64
+
+	bloomChecks         atomic.Uint64
+	bloomNegatives      atomic.Uint64
+	bloomFalsePositives atomic.Uint64
+
+	// refs counts snapshots using the table; an obsolete table's files are
+	// removed once the last one releases it.
+	refs     atomic.Int32
+	obsolete atomic.Bool
+	removed  atomic.Bool
+
+	// handle is the table's file while tableFiles keeps it open.
+	handle atomic.Pointer[tableFile]
+
+	// cold is set once the table's file lives in the cold tier.
+	cold atomic.Bool
+	// moved is set once the table was copied to a new data directory by
+	// Relocate; the copy then owns the table's cold tier file, if any.
+	moved atomic.Bool
 }
 
 type IndexEntry struct {
@@ -20,104 +70,442 @@ type IndexEntry struct {
 	Offset int64
 }
 
+// IndexInterval is the default number of records between sparse index
+// entries.
 const IndexInterval = 128
 
-func WriteSSTable(path string, data map[string][]byte) (*SSTable, error) {
-	file, err := os.Create(path)
-	if err != nil {
-		return nil, err
+// indexInterval is the configured base interval. With adaptiveIndex, tables
+// with keys longer than indexKeyBytes on average get a proportionally
+// sparser index, keeping index memory roughly in line with data size.
+var (
+	indexInterval = IndexInterval
+	adaptiveIndex = true
+)
+
+const indexKeyBytes = 16
+
+// chooseIndexInterval picks the index interval for a table holding keys
+// keys totalling keyBytes.
+func chooseIndexInterval(keys, keyBytes int64) int {
+	interval := max(indexInterval, 1)
+	if adaptiveIndex && keys > 0 {
+		if avg := keyBytes / keys; avg > indexKeyBytes {
+			interval *= int((avg + indexKeyBytes - 1) / indexKeyBytes)
+		}
 	}
-	defer file.Close()
+	return interval
+}
 
-	writer := bufio.NewWriter(file)
+// SSTable format versions. Version 1 files are a bare sequence of
+// key/value records; version 2 adds per-record metadata and a footer;
+// version 3 adds an index block (see indexblock.go); version 4 ends each
+// record with its sequence number; version 5 follows each record's key
+// with a flags byte marking tombstones; version 6 stores records in
+// compressed blocks (see block.go); version 7 embeds the bloom filter
+// after the index block. Before version 5 a tombstone is a record with an
+// empty value, so empty values cannot be stored.
+const (
+	sstableV1      uint32 = 1
+	sstableV2      uint32 = 2
+	sstableV3      uint32 = 3
+	sstableV4      uint32 = 4
+	sstableV5      uint32 = 5
+	sstableV6      uint32 = 6
+	sstableV7      uint32 = 7
+	sstableVersion        = sstableV7
+
+	sstableMagic      uint64 = 0x6c6f67626173655f // "logbase_"
+	sstableFooterSize        = 20                 // magic(8) + version(4) + dataSize(8)
+
+	// recordTombstone is the record flag marking a delete.
+	recordTombstone byte = 1 << 0
+)
 
-	bf := NewBloomFilter(1024, 3) // 1KB bloom, 3 hashes
+// WriteSSTable writes data as a table at path, with its bloom filter. Its
+// I/O is counted as compaction, which is what writes tables from maps.
+func WriteSSTable(path string, data map[string]Entry) (*SSTable, error) {
+	return writeSortedSSTable(path, sortEntries(data), ioCompaction)
+}
 
+// sortEntries lists data in key order.
+func sortEntries(data map[string]Entry) []keyedEntry {
 	keys := make([]string, 0, len(data))
 	for k := range data {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 
-	for _, k := range keys {
-		v := data[k]
-		bf.Add([]byte(k))
+	entries := make([]keyedEntry, len(keys))
+	for i, k := range keys {
+		entries[i] = keyedEntry{k, data[k]}
+	}
+	return entries
+}
 
-		binary.Write(writer, binary.BigEndian, uint32(len(k)))
-		writer.Write([]byte(k))
-		binary.Write(writer, binary.BigEndian, uint32(len(v)))
-		writer.Write(v)
+// writeSortedSSTable is WriteSSTable for entries already in key order,
+// counting its writes for sub.
+func writeSortedSSTable(path string, entries []keyedEntry, sub ioSubsystem) (*SSTable, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
 
-	writer.Flush()
+	writer := bufio.NewWriter(sub.writer(file))
+	blocks := &blockWriter{w: writer, codec: blockCompression}
 
-	bfPath := path + ".bloom"
-	if err := bf.Save(bfPath); err != nil {
+	bf := NewSizedBloomFilter(len(entries), bloomFPR)
+
+	var maxSeq uint64
+	for _, e := range entries {
+		bf.Add([]byte(e.key))
+		maxSeq = max(maxSeq, e.entry.Seq)
+		if err := blocks.add(e.key, e.entry); err != nil {
+			return nil, err
+		}
+	}
+	if err := blocks.finish(); err != nil {
 		return nil, err
 	}
+	dataSize := blocks.size
+
+	table := &SSTable{
+		Path:     path,
+		Index:    blocks.index,
+		Bloom:    bf,
+		version:  sstableVersion,
+		dataSize: dataSize,
+		entries:  int64(len(entries)),
+		maxSeq:   maxSeq,
+	}
+	if len(entries) > 0 {
+		table.minKey, table.maxKey = entries[0].key, entries[len(entries)-1].key
+	}
 
-	return &SSTable{
-		Path:  path,
-		Bloom: bf,
-	}, nil
+	block := table.encodeIndexBlock()
+	table.indexSize, table.indexCRC = int64(len(block)), crc32.ChecksumIEEE(block)
+	writer.Write(block)
+	filter := bf.encode()
+	table.bloomSize = int64(len(filter))
+	writer.Write(filter)
+	binary.Write(writer, binary.BigEndian, uint64(table.bloomSize))
+	binary.Write(writer, binary.BigEndian, uint64(table.indexSize))
+	binary.Write(writer, binary.BigEndian, table.indexCRC)
+	binary.Write(writer, binary.BigEndian, sstableMagic)
+	binary.Write(writer, binary.BigEndian, sstableVersion)
+	binary.Write(writer, binary.BigEndian, uint64(dataSize))
+
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		return nil, err
+	}
+	// The WAL behind the table is truncated once it is in the MANIFEST, so
+	// its directory entry must be durable by then
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	sub.synced()
+	return table, nil
 }
 
-// Get performs a point lookup in the SSTable.
-// This implementation performs a linear scan (v1).
-func (s *SSTable) Get(key []byte) ([]byte, bool, error) {
+// writeEntry appends a record in the current format.
+func writeEntry(w *bytes.Buffer, key []byte, e Entry) {
+	binary.Write(w, binary.BigEndian, uint32(len(key)))
+	w.Write(key)
+	var flags byte
+	if e.Deleted {
+		flags |= recordTombstone
+	}
+	w.WriteByte(flags)
+	binary.Write(w, binary.BigEndian, uint32(len(e.Value)))
+	w.Write(e.Value)
+	binary.Write(w, binary.BigEndian, uint32(len(e.Meta)))
+	w.Write(e.Meta)
+	binary.Write(w, binary.BigEndian, e.Seq)
+}
+
+// recordLen is the size of a record in the given format version.
+func recordLen(version uint32, key []byte, e Entry) int64 {
+	size := 4 + int64(len(key)) + 4 + int64(len(e.Value))
+	if version >= sstableV5 {
+		size++
+	}
+	if version >= sstableV2 {
+		size += 4 + int64(len(e.Meta))
+	}
+	if version >= sstableV4 {
+		size += 8
+	}
+	return size
+}
+
+// readEntry decodes one record written in the given format version.
+// io.EOF is returned only at a clean record boundary.
+func readEntry(r *bufio.Reader, version uint32) ([]byte, Entry, error) {
+	var keyLen uint32
+	if err := binary.Read(r, binary.BigEndian, &keyLen); err != nil {
+		return nil, Entry{}, err
+	}
+
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, Entry{}, err
+	}
+	flags, err := readFlags(r, version)
+	if err != nil {
+		return nil, Entry{}, err
+	}
+
+	value, err := readBlob(r)
+	if err != nil {
+		return nil, Entry{}, err
+	}
+
+	e := Entry{Value: value, Deleted: isTombstone(flags, version, len(value))}
+	if version >= sstableV2 {
+		if e.Meta, err = readBlob(r); err != nil {
+			return nil, Entry{}, err
+		}
+		if len(e.Meta) == 0 {
+			e.Meta = nil
+		}
+	}
+	if version >= sstableV4 {
+		if err := binary.Read(r, binary.BigEndian, &e.Seq); err != nil {
+			return nil, Entry{}, noEOF(err)
+		}
+	}
+
+	return key, e, nil
+}
+
+// readFlags reads a record's flags byte, which records have from version 5.
+func readFlags(r *bufio.Reader, version uint32) (byte, error) {
+	if version < sstableV5 {
+		return 0, nil
+	}
+	flags, err := r.ReadByte()
+	return flags, noEOF(err)
+}
+
+// isTombstone reports whether a record with the given flags and value
+// length is a delete.
+func isTombstone(flags byte, version uint32, valueLen int) bool {
+	if version < sstableV5 {
+		return valueLen == 0
+	}
+	return flags&recordTombstone != 0
+}
+
+func readBlob(r *bufio.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, noEOF(err)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, noEOF(err)
+	}
+	return b, nil
+}
+
+// noEOF reports a truncated record as an unexpected EOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// loadFooter reads the table's format version and the extent of its data
+// section. Files without a footer are treated as version 1.
+func (s *SSTable) loadFooter(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	s.version, s.dataSize = sstableV1, size
+	if size < sstableFooterSize {
+		return nil
+	}
+
+	footer := make([]byte, sstableFooterSize)
+	if _, err := file.ReadAt(footer, size-sstableFooterSize); err != nil {
+		return err
+	}
+
+	magic := binary.BigEndian.Uint64(footer[0:8])
+	version := binary.BigEndian.Uint32(footer[8:12])
+	dataSize := int64(binary.BigEndian.Uint64(footer[12:20]))
+	if magic != sstableMagic {
+		return nil
+	}
+	if version > sstableVersion {
+		return fmt.Errorf("sstable %s: unsupported format version %d", s.Path, version)
+	}
+
+	if version < sstableV3 {
+		if dataSize == size-sstableFooterSize {
+			s.version, s.dataSize = version, dataSize
+		}
+		return nil
+	}
+
+	if version >= sstableV7 {
+		return s.loadV7Footer(file, size, version, dataSize)
+	}
+	if size < sstableV3FooterSize {
+		return nil
+	}
+	ext := make([]byte, sstableV3FooterSize-sstableFooterSize)
+	if _, err := file.ReadAt(ext, size-sstableV3FooterSize); err != nil {
+		return err
+	}
+	indexSize := int64(binary.BigEndian.Uint64(ext[0:8]))
+	if dataSize < 0 || indexSize < 0 || dataSize+indexSize != size-sstableV3FooterSize {
+		return nil
+	}
+	s.version, s.dataSize = version, dataSize
+	s.indexSize, s.indexCRC = indexSize, binary.BigEndian.Uint32(ext[8:12])
+	return nil
+}
+
+// open opens the table file and returns a reader bounded to its data section.
+func (s *SSTable) open() (*os.File, *io.SectionReader, error) {
 	file, err := os.Open(s.Path)
 	if err != nil {
-		return nil, false, err
+		return nil, nil, err
 	}
-	defer file.Close()
+	if s.version == 0 {
+		if err := s.loadFooter(file); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+	return file, io.NewSectionReader(file, 0, s.dataSize), nil
+}
 
-	reader := bufio.NewReader(file)
+// Get performs a point lookup in the SSTable. It seeks to the sparse index
+// entry at or before key and scans at most one index interval from there.
+func (s *SSTable) Get(key []byte) ([]byte, bool, error) {
+	e, ok, err := s.GetEntry(key)
+	return e.Value, ok, err
+}
+
+func (s *SSTable) GetEntry(key []byte) (Entry, bool, error) {
+	return s.getEntry(key, rangeScan{})
+}
+
+// getEntry is GetEntry tuned by scan; only its limiter, files, io and
+// noCache apply.
+func (s *SSTable) getEntry(key []byte, scan rangeScan) (Entry, bool, error) {
 	target := string(key)
+	if s.entries > 0 && (target < s.minKey || target > s.maxKey) {
+		return Entry{}, false, nil
+	}
 
-	for {
-		var keyLen uint32
-		if err := binary.Read(reader, binary.BigEndian, &keyLen); err != nil {
+	var reader *bufio.Reader
+	if s.version >= sstableV6 {
+		// The key can only be in the block indexed at or before it
+		off := s.seekOffset(key)
+		var block []byte
+		ok := false
+		if !scan.noCache {
+			block, ok = blockCache.get(s, off)
+		}
+		if !ok {
+			file, _, err := s.openShared(scan.files)
+			if err != nil {
+				return Entry{}, false, err
+			}
+			block, err = s.readBlockAt(file.file, off, scan.limiter, scan.io)
+			file.release()
 			if err == io.EOF {
-				break
+				return Entry{}, false, nil
+			}
+			if err != nil {
+				return Entry{}, false, err
+			}
+			if !scan.noCache {
+				blockCache.put(s, off, block)
 			}
-			return nil, false, err
 		}
-
-		k := make([]byte, keyLen)
-		if _, err := io.ReadFull(reader, k); err != nil {
-			return nil, false, err
+		reader = bufio.NewReader(bytes.NewReader(block))
+	} else {
+		file, section, err := s.openShared(scan.files)
+		if err != nil {
+			return Entry{}, false, err
 		}
-
-		var valLen uint32
-		if err := binary.Read(reader, binary.BigEndian, &valLen); err != nil {
-			return nil, false, err
+		defer file.release()
+		if reader, err = s.recordReader(section, s.seekOffset(key), scan.limiter, 0, scan.io); err != nil {
+			return Entry{}, false, err
 		}
+	}
 
-		v := make([]byte, valLen)
-		if _, err := io.ReadFull(reader, v); err != nil {
-			return nil, false, err
+	// Keys are sorted, so the scan stops at the first key past the target,
+	// which is at the latest the next index entry.
+	for {
+		k, e, err := readEntry(reader, s.version)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return Entry{}, false, err
 		}
 
 		if string(k) == target {
-			return v, true, nil
+			return e, true, nil
+		}
+		if string(k) > target {
+			break
 		}
 	}
 
-	return nil, false, nil
+	return Entry{}, false, nil
 }
 
-func (s *SSTable) Range(start, end []byte) (map[string][]byte, error) {
-	file, err := os.Open(s.Path)
+// GetRange reads n bytes of key's value starting at off, skipping over other
+// values without reading them. A negative off selects the last -off bytes and
+// a negative n reads to the end of the value. The full value size is returned.
+// A tombstone is reported as not found.
+func (s *SSTable) GetRange(key []byte, off, n int64) ([]byte, int64, bool, error) {
+	val, size, deleted, ok, err := s.getRange(key, off, n)
+	return val, size, ok && !deleted, err
+}
+
+// getRange is GetRange that reports a tombstone for key as found and
+// deleted.
+func (s *SSTable) getRange(key []byte, off, n int64) (val []byte, size int64, deleted, ok bool, err error) {
+	if s.version >= sstableV6 {
+		// A block is read whole, so the value is in memory anyway
+		e, ok, err := s.getEntry(key, rangeScan{})
+		if err != nil || !ok {
+			return nil, 0, false, false, err
+		}
+		if e.Deleted {
+			return nil, 0, true, true, nil
+		}
+		size := int64(len(e.Value))
+		off, n = clampRange(off, n, size)
+		return e.Value[off : off+n], size, false, true, nil
+	}
+
+	file, section, err := s.open()
 	if err != nil {
-		return nil, err
+		return nil, 0, false, false, err
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	result := make(map[string][]byte)
-
-	sKey := string(start)
-	eKey := string(end)
+	pos := s.seekOffset(key)
+	if _, err := section.Seek(pos, io.SeekStart); err != nil {
+		return nil, 0, false, false, err
+	}
+	reader := bufio.NewReader(section)
+	target := string(key)
 
 	for {
 		var keyLen uint32
@@ -125,113 +513,468 @@ func (s *SSTable) Range(start, end []byte) (map[string][]byte, error) {
 			if err == io.EOF {
 				break
 			}
-			return nil, err
+			return nil, 0, false, false, err
 		}
 
 		k := make([]byte, keyLen)
 		if _, err := io.ReadFull(reader, k); err != nil {
-			return nil, err
+			return nil, 0, false, false, err
+		}
+		flags, err := readFlags(reader, s.version)
+		if err != nil {
+			return nil, 0, false, false, err
 		}
 
 		var valLen uint32
 		if err := binary.Read(reader, binary.BigEndian, &valLen); err != nil {
-			return nil, err
+			return nil, 0, false, false, err
 		}
-
-		v := make([]byte, valLen)
-		if _, err := io.ReadFull(reader, v); err != nil {
-			return nil, err
+		pos += 4 + int64(keyLen) + 4
+		if s.version >= sstableV5 {
+			pos++
 		}
 
 		keyStr := string(k)
-		if keyStr < sKey {
-			continue
+		if keyStr == target {
+			if isTombstone(flags, s.version, int(valLen)) {
+				return nil, 0, true, true, nil
+			}
+			size := int64(valLen)
+			off, n = clampRange(off, n, size)
+			buf := make([]byte, n)
+			if _, err := file.ReadAt(buf, pos+off); err != nil {
+				return nil, 0, false, false, err
+			}
+			return buf, size, false, true, nil
+		}
+		if keyStr > target {
+			break
 		}
-		if keyStr > eKey {
-			break // sorted order lets us stop early
+
+		// Large values are skipped with a seek rather than read through.
+		pos += int64(valLen)
+		if int(valLen) <= reader.Buffered() {
+			reader.Discard(int(valLen))
+		} else {
+			if _, err := section.Seek(pos, io.SeekStart); err != nil {
+				return nil, 0, false, false, err
+			}
+			reader.Reset(section)
 		}
 
-		result[keyStr] = v
+		if s.version >= sstableV2 {
+			meta, err := readBlob(reader)
+			if err != nil {
+				return nil, 0, false, false, err
+			}
+			pos += 4 + int64(len(meta))
+		}
+		if s.version >= sstableV4 {
+			if _, err := reader.Discard(8); err != nil {
+				return nil, 0, false, false, noEOF(err)
+			}
+			pos += 8
+		}
 	}
 
+	return nil, 0, false, false, nil
+}
+
+// clampRange resolves an (off, n) request against a value of the given size.
+func clampRange(off, n, size int64) (int64, int64) {
+	if off < 0 {
+		off += size
+		if off < 0 {
+			off = 0
+		}
+	}
+	if off > size {
+		off = size
+	}
+	if n < 0 || off+n > size {
+		n = size - off
+	}
+	return off, n
+}
+
+func (s *SSTable) Range(start, end []byte) (map[string][]byte, error) {
+	entries, err := s.RangeEntries(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]byte, len(entries))
+	for k, e := range entries {
+		result[k] = e.Value
+	}
 	return result, nil
 }
 
+func (s *SSTable) RangeEntries(start, end []byte) (map[string]Entry, error) {
+	return s.rangeEntries(start, end, nil)
+}
+
+func (s *SSTable) rangeEntries(start, end []byte, limiter *rateLimiter) (map[string]Entry, error) {
+	return s.scanRange(closedRange(start, end), rangeScan{limiter: limiter})
+}
+
+// rangeScan tunes a table scan; see planRange.
+type rangeScan struct {
+	limiter   *rateLimiter
+	readAhead int
+	// io is what the reads are counted as; the zero value is ioReads.
+	io ioSubsystem
+	// files, if set, keeps the table's file open for later reads.
+	files *tableFiles
+	// keysOnly skips values and metadata, leaving entries with only their
+	// sequence numbers and tombstone marks.
+	keysOnly bool
+	// noCache makes point lookups read past the block cache, neither
+	// using nor filling it.
+	noCache bool
+}
+
+// scanRange collects the table's entries within bounds.
+func (s *SSTable) scanRange(opts IterOptions, scan rangeScan) (map[string]Entry, error) {
+	it, err := s.newIterator(opts, scan)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	result := make(map[string]Entry)
+	for it.Next() {
+		result[string(it.Key())] = it.Entry()
+	}
+	return result, it.Err()
+}
+
+// readEntryKey is readEntry that discards the value and metadata.
+func readEntryKey(r *bufio.Reader, version uint32) ([]byte, Entry, error) {
+	var keyLen uint32
+	if err := binary.Read(r, binary.BigEndian, &keyLen); err != nil {
+		return nil, Entry{}, err
+	}
+
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, Entry{}, noEOF(err)
+	}
+	flags, err := readFlags(r, version)
+	if err != nil {
+		return nil, Entry{}, err
+	}
+
+	n, err := skipBlob(r)
+	if err != nil {
+		return nil, Entry{}, err
+	}
+	e := Entry{Deleted: isTombstone(flags, version, n)}
+	if version >= sstableV2 {
+		if _, err := skipBlob(r); err != nil {
+			return nil, Entry{}, err
+		}
+	}
+	if version >= sstableV4 {
+		if err := binary.Read(r, binary.BigEndian, &e.Seq); err != nil {
+			return nil, Entry{}, noEOF(err)
+		}
+	}
+	return key, e, nil
+}
+
+// skipBlob discards a length-prefixed blob and returns its length.
+func skipBlob(r *bufio.Reader) (int, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return 0, noEOF(err)
+	}
+	_, err := r.Discard(int(n))
+	return int(n), noEOF(err)
+}
+
 func (s *SSTable) LoadIndex() error {
-	file, err := os.Open(s.Path)
+	file, section, err := s.open()
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	var offset int64
+	if s.version >= sstableV3 {
+		err := s.loadIndexBlock(file)
+		if err == nil {
+			return nil
+		}
+		cacheLog.Warnf("%s: %v; rebuilding the index from the data", filepath.Base(s.Path), err)
+	}
+	if s.version >= sstableV6 {
+		s.loadBlockIndex(section)
+		return nil
+	}
+
+	reader := bufio.NewReader(section)
+
+	// Index at the base interval, then thin it once the key sizes are
+	// known, giving the index the writer built.
+	base := max(indexInterval, 1)
+	var offset, keyBytes int64
 	count := 0
 
 	for {
-		var keyLen uint32
-		if err := binary.Read(reader, binary.BigEndian, &keyLen); err != nil {
+		k, e, err := readEntry(reader, s.version)
+		if err != nil {
 			break
 		}
 
-		k := make([]byte, keyLen)
-		io.ReadFull(reader, k)
-
-		var valLen uint32
-		binary.Read(reader, binary.BigEndian, &valLen)
-		reader.Discard(int(valLen))
+		if count == 0 {
+			s.minKey = string(k)
+		}
+		s.maxKey = string(k)
+		keyBytes += int64(len(k))
 
-		if count%IndexInterval == 0 {
+		if count%base == 0 {
 			s.Index = append(s.Index, IndexEntry{
 				Key:    string(k),
 				Offset: offset,
 			})
 		}
 
-		offset += 4 + int64(keyLen) + 4 + int64(valLen)
+		offset += recordLen(s.version, k, e)
 		count++
 	}
+	s.entries = int64(count)
+
+	s.indexInterval = chooseIndexInterval(s.entries, keyBytes)
+	if step := s.indexInterval / base; step > 1 {
+		thinned := s.Index[:0]
+		for i := 0; i < len(s.Index); i += step {
+			thinned = append(thinned, s.Index[i])
+		}
+		s.Index = thinned
+	}
 	return nil
 }
 
+// remove deletes the table's files once.
+func (s *SSTable) remove() {
+	if s.removed.CompareAndSwap(false, true) {
+		s.dropHandle()
+		if s.moved.Load() {
+			os.Remove(s.Path)
+		} else {
+			removeTableFile(s.Path)
+		}
+		os.Remove(s.Path + ".bloom")
+		blockCache.drop(s)
+	}
+}
+
+// compactionParallelism bounds how many sub-compactions one compaction runs
+// at once.
+var compactionParallelism = 1
+
+// compactionTargetFileSize is the size at which compaction starts a new
+// output table, so later compactions of the same keys stay small.
+var compactionTargetFileSize int64 = 64 * 1024 * 1024
+
 func (e *Engine) compactAll() error {
-	merged := make(map[string][]byte)
+	if err := e.faults.inject(FaultCompaction); err != nil {
+		return err
+	}
 
+	current := e.tables()
+	inputs, moved := compactionInputs(current)
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	// Outputs take the newest input's number, so they sort before tables
+	// flushed while the merge runs; a fresh number keeps their names apart
+	newest := tableNumber(inputs[len(inputs)-1].Path)
+	nextPath := func() (string, error) {
+		n, err := e.newFileNumber()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(e.DataDir(), fmt.Sprintf("sst_compacted_%06d.c%06d.dat", newest, n)), nil
+	}
+
+	// Split the key space so sub-compactions can run in parallel, each
+	// writing its own output tables. Outputs cover disjoint key ranges.
+	bounds := splitPoints(inputs, compactionParallelism)
+	outputs := make([][]*SSTable, len(bounds)+1)
+	errs := make([]error, len(bounds)+1)
+
+	var wg sync.WaitGroup
+	for i := range outputs {
+		// The last sub-range has no upper bound, so it keeps every key
+		// past the final split point however large
+		var r IterOptions
+		if i > 0 {
+			r.LowerBound = []byte(bounds[i-1])
+		}
+		if i < len(bounds) {
+			r.UpperBound = []byte(bounds[i])
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outputs[i], errs[i] = e.compactRange(inputs, r, nextPath)
+		}()
+	}
+	wg.Wait()
+
+	tables := moved
+	for _, out := range outputs {
+		for _, t := range out {
+			t.level = 1
+		}
+		tables = append(tables, out...)
+	}
+	err := errors.Join(errs...)
+	if err == nil {
+		err = e.replaceTables(current, tables)
+	}
+	if err != nil {
+		for _, t := range tables[len(moved):] {
+			t.remove()
+		}
+		compactionLog.Errorf("merging %d tables: %v", len(inputs), err)
+		return err
+	}
+	compactionLog.Infof("merged %d tables into %d, moved %d", len(inputs), len(tables)-len(moved), len(moved))
+
+	e.mu.Lock()
+	// Keep the tables flushed since the merge started
+	e.sstables = append(tables, e.sstables[len(current):]...)
+	e.tablesGen++
+	e.hot.restamp(e.tablesGen)
+	e.mu.Unlock()
+
+	// Remove old SSTables, deferring any still pinned by a snapshot
+	for _, t := range inputs {
+		t.obsolete.Store(true)
+		if t.refs.Load() == 0 {
+			t.remove()
+		}
+	}
+
+	return nil
+}
+
+// compactRange merges inputs' keys within bounds into tables of about
+// compactionTargetFileSize each. On error it also returns the tables
+// already written so they can be removed.
+func (e *Engine) compactRange(inputs []*SSTable, bounds IterOptions, nextPath func() (string, error)) ([]*SSTable, error) {
 	// Newest → oldest
-	for i := len(e.sstables) - 1; i >= 0; i-- {
-		data, err := e.sstables[i].Range([]byte(""), []byte("\xff"))
+	sources := make([]iterator, 0, len(inputs))
+	for i := len(inputs) - 1; i >= 0; i-- {
+		e.yieldCompaction()
+
+		it, err := inputs[i].newIterator(bounds, rangeScan{io: ioCompaction})
+		if err != nil {
+			closeIterators(sources)
+			return nil, err
+		}
+		sources = append(sources, it)
+	}
+
+	// Tombstones are dropped: no older table outside the inputs overlaps
+	merged := newMergingIterator(sources, true)
+	defer merged.Close()
+
+	var tables []*SSTable
+	chunk := make(map[string]Entry)
+	var size int64
+	flush := func() error {
+		path, err := nextPath()
 		if err != nil {
 			return err
 		}
+		table, err := WriteSSTable(path, chunk)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, table)
+		chunk = make(map[string]Entry)
+		size = 0
+		return nil
+	}
 
-		for k, v := range data {
-			if _, exists := merged[k]; !exists {
-				merged[k] = v
+	for merged.Next() {
+		k, entry := string(merged.Key()), merged.Entry()
+		chunk[k] = entry
+		size += int64(len(k)) + int64(entry.size()) + 12
+
+		if size >= compactionTargetFileSize {
+			if err := flush(); err != nil {
+				return tables, err
 			}
 		}
 	}
-
-	// Remove tombstones
-	for k, v := range merged {
-		if len(v) == 0 {
-			delete(merged, k)
+	if err := merged.Err(); err != nil {
+		return tables, err
+	}
+	if len(chunk) > 0 {
+		if err := flush(); err != nil {
+			return tables, err
 		}
 	}
+	return tables, nil
+}
+
+// splitPoints picks up to n-1 keys from the inputs' sparse indexes that
+// divide their data into roughly equal parts.
+func splitPoints(inputs []*SSTable, n int) []string {
+	if n <= 1 {
+		return nil
+	}
 
-	// Write new SSTable
-	path := fmt.Sprintf("%s/sst_compacted_%06d.dat", e.dataDir, e.nextTable)
-	table, err := WriteSSTable(path, merged)
-	if err != nil {
-		return err
+	var keys []string
+	for _, t := range inputs {
+		for _, ie := range t.Index {
+			keys = append(keys, ie.Key)
+		}
 	}
+	sort.Strings(keys)
+	keys = slices.Compact(keys)
 
-	// Remove old SSTables
-	for _, t := range e.sstables {
-		os.Remove(t.Path)
+	var bounds []string
+	for i := 1; i < n; i++ {
+		k := keys[len(keys)*i/n:]
+		if len(k) == 0 || k[0] == "" {
+			continue
+		}
+		if len(bounds) == 0 || bounds[len(bounds)-1] < k[0] {
+			bounds = append(bounds, k[0])
+		}
 	}
+	return bounds
+}
 
-	e.sstables = []*SSTable{table}
-	e.nextTable++
+// compactionInputs splits tables into those that must be merged and those
+// whose key range overlaps no other table. The latter share no keys with
+// anything, so they are kept as they are instead of being rewritten; this
+// makes compaction nearly free for sequential ingest.
+func compactionInputs(tables []*SSTable) (inputs, moved []*SSTable) {
+	for i, t := range tables {
+		overlaps := t.entries == 0
+		for j, other := range tables {
+			if i == j || other.entries == 0 {
+				continue
+			}
+			if t.minKey <= other.maxKey && other.minKey <= t.maxKey {
+				overlaps = true
+				break
+			}
+		}
 
-	return nil
+		if overlaps {
+			inputs = append(inputs, t)
+		} else {
+			moved = append(moved, t)
+		}
+	}
+	return inputs, moved
 }
@@ -0,0 +1,381 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tableTestEntries returns entries, in key order, that a table of the
+// given version can hold: tombstones are empty values before version 5,
+// so only later versions get an empty value that is not a delete.
+func tableTestEntries(version uint32) []keyedEntry {
+	var entries []keyedEntry
+	for i := range 300 {
+		key := fmt.Sprintf("key%04d", i)
+		e := Entry{Value: []byte(strings.Repeat(key, 1+i%7)), Seq: uint64(i + 1)}
+		switch {
+		case i%50 == 7:
+			e = Entry{Deleted: true, Seq: uint64(i + 1)}
+		case i%50 == 9:
+			e.Meta = []byte("meta:" + key)
+		case i%50 == 11 && version >= sstableV5:
+			e.Value = []byte{}
+		}
+		entries = append(entries, keyedEntry{key, e})
+	}
+	return entries
+}
+
+// encodeLegacyEntry lays out a record as a table of the given version
+// stores it.
+func encodeLegacyEntry(key string, e Entry, version uint32) []byte {
+	b := appendBlob(nil, []byte(key))
+	if version >= sstableV5 {
+		var flags byte
+		if e.Deleted {
+			flags |= recordTombstone
+		}
+		b = append(b, flags)
+	}
+	if e.Deleted && version < sstableV5 {
+		b = appendBlob(b, nil)
+	} else {
+		b = appendBlob(b, e.Value)
+	}
+	if version >= sstableV2 {
+		b = appendBlob(b, e.Meta)
+	}
+	if version >= sstableV4 {
+		b = binary.BigEndian.AppendUint64(b, e.Seq)
+	}
+	return b
+}
+
+// writeLegacyTable writes entries to path as a table of the given version.
+// Version 7 is the current format, which WriteSSTable writes.
+func writeLegacyTable(t *testing.T, path string, version uint32, entries []keyedEntry) {
+	t.Helper()
+
+	if version == sstableVersion {
+		if _, err := writeSortedSSTable(path, entries, ioCompaction); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	var data bytes.Buffer
+	table := &SSTable{
+		entries:       int64(len(entries)),
+		indexInterval: 1,
+		minKey:        entries[0].key,
+		maxKey:        entries[len(entries)-1].key,
+	}
+	if version >= sstableV6 {
+		table.indexInterval = 0
+		w := bufio.NewWriter(&data)
+		blocks := &blockWriter{w: w, codec: blockCompression}
+		for _, e := range entries {
+			if err := blocks.add(e.key, e.entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := blocks.finish(); err != nil {
+			t.Fatal(err)
+		}
+		w.Flush()
+		table.Index = blocks.index
+	} else {
+		for _, e := range entries {
+			table.Index = append(table.Index, IndexEntry{Key: e.key, Offset: int64(data.Len())})
+			data.Write(encodeLegacyEntry(e.key, e.entry, version))
+		}
+	}
+
+	file := data.Bytes()
+	dataSize := uint64(len(file))
+	if version >= sstableV3 {
+		block := table.encodeIndexBlock()
+		file = append(file, block...)
+		file = binary.BigEndian.AppendUint64(file, uint64(len(block)))
+		file = binary.BigEndian.AppendUint32(file, crc32.ChecksumIEEE(block))
+	}
+	if version >= sstableV2 {
+		file = binary.BigEndian.AppendUint64(file, sstableMagic)
+		file = binary.BigEndian.AppendUint32(file, version)
+		file = binary.BigEndian.AppendUint64(file, dataSize)
+	}
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wantEntry returns e as a table of the given version reads it back.
+func wantEntry(e Entry, version uint32) Entry {
+	if version < sstableV4 {
+		e.Seq = 0
+	}
+	if version < sstableV2 {
+		e.Meta = nil
+	}
+	if e.Deleted || e.Value == nil {
+		e.Value = []byte{}
+	}
+	return e
+}
+
+func sameEntry(got, want Entry) bool {
+	return bytes.Equal(got.Value, want.Value) && bytes.Equal(got.Meta, want.Meta) &&
+		got.Deleted == want.Deleted && got.Seq == want.Seq
+}
+
+// openTestTable opens the table at path as the engine does.
+func openTestTable(t *testing.T, path string) *SSTable {
+	t.Helper()
+
+	table := &SSTable{Path: path}
+	if err := table.LoadIndex(); err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+// checkTable reads entries back from table by point lookups and by scans.
+func checkTable(t *testing.T, table *SSTable, version uint32, entries []keyedEntry) {
+	t.Helper()
+
+	if table.version != version {
+		t.Fatalf("table read as version %d, want %d", table.version, version)
+	}
+	if table.entries != int64(len(entries)) {
+		t.Errorf("table has %d entries, want %d", table.entries, len(entries))
+	}
+
+	for _, e := range entries {
+		got, ok, err := table.GetEntry([]byte(e.key))
+		if err != nil || !ok {
+			t.Fatalf("GetEntry(%q) = %v, %v", e.key, ok, err)
+		}
+		if want := wantEntry(e.entry, version); !sameEntry(got, want) {
+			t.Errorf("GetEntry(%q) = %+v, want %+v", e.key, got, want)
+		}
+	}
+	for _, key := range []string{"", "key0000a", "key9999"} {
+		if _, ok, err := table.GetEntry([]byte(key)); ok || err != nil {
+			t.Errorf("GetEntry(%q) = %v, %v; want not found", key, ok, err)
+		}
+	}
+
+	all, err := table.scanRange(IterOptions{}, rangeScan{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(entries) {
+		t.Errorf("scan returned %d entries, want %d", len(all), len(entries))
+	}
+	for _, e := range entries {
+		if want := wantEntry(e.entry, version); !sameEntry(all[e.key], want) {
+			t.Errorf("scan: %q = %+v, want %+v", e.key, all[e.key], want)
+		}
+	}
+
+	keys, err := table.scanRange(closedRange([]byte("key0100"), []byte("key0199")), rangeScan{keysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 100 {
+		t.Errorf("keys-only scan returned %d entries, want 100", len(keys))
+	}
+	for _, e := range entries[100:200] {
+		want := wantEntry(e.entry, version)
+		if got := keys[e.key]; got.Deleted != want.Deleted || got.Seq != want.Seq {
+			t.Errorf("keys-only scan: %q = %+v, want %+v", e.key, got, want)
+		}
+	}
+}
+
+// withBlocks sets the block size and codec of tables written during the
+// test.
+func withBlocks(t *testing.T, size int, codec byte) {
+	oldSize, oldCodec := sstableBlockSize, blockCompression
+	sstableBlockSize, blockCompression = size, codec
+	t.Cleanup(func() { sstableBlockSize, blockCompression = oldSize, oldCodec })
+}
+
+func TestSSTableVersions(t *testing.T) {
+	codecs := map[string]byte{"none": BlockCodecNone, "flate": BlockCodecFlate}
+	for version := sstableV1; version <= sstableVersion; version++ {
+		for name, codec := range codecs {
+			if version < sstableV6 && codec != BlockCodecNone {
+				continue
+			}
+			t.Run(fmt.Sprintf("v%d/%s", version, name), func(t *testing.T) {
+				withBlocks(t, 512, codec)
+				entries := tableTestEntries(version)
+				path := filepath.Join(t.TempDir(), "sst_000001.dat")
+				writeLegacyTable(t, path, version, entries)
+
+				table := openTestTable(t, path)
+				checkTable(t, table, version, entries)
+				if version >= sstableV6 && len(table.Index) < 2 {
+					t.Errorf("table has %d blocks, want several", len(table.Index))
+				}
+			})
+		}
+	}
+}
+
+func TestSSTableEmbeddedBloom(t *testing.T) {
+	entries := tableTestEntries(sstableVersion)
+	path := filepath.Join(t.TempDir(), "sst_000001.dat")
+	writeLegacyTable(t, path, sstableVersion, entries)
+
+	table := openTestTable(t, path)
+	bloom, legacy, err := table.readBloom()
+	if err != nil || legacy {
+		t.Fatalf("readBloom = %v, %v", legacy, err)
+	}
+	for _, e := range entries {
+		if !bloom.MightContain([]byte(e.key)) {
+			t.Errorf("bloom filter is missing %q", e.key)
+		}
+	}
+	if _, err := os.Stat(path + ".bloom"); !os.IsNotExist(err) {
+		t.Errorf("version %d table has a .bloom file: %v", sstableVersion, err)
+	}
+}
+
+func TestSSTableDamagedIndexBlock(t *testing.T) {
+	withBlocks(t, 512, BlockCodecFlate)
+	entries := tableTestEntries(sstableVersion)
+	path := filepath.Join(t.TempDir(), "sst_000001.dat")
+	writeLegacyTable(t, path, sstableVersion, entries)
+
+	// Damage the index block; the index is rebuilt from the blocks
+	table := openTestTable(t, path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[table.dataSize+table.indexSize/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTable(t, openTestTable(t, path), sstableVersion, entries)
+}
+
+func TestSSTableDamagedBlock(t *testing.T) {
+	withBlocks(t, 512, BlockCodecNone)
+	entries := tableTestEntries(sstableVersion)
+	path := filepath.Join(t.TempDir(), "sst_000001.dat")
+	writeLegacyTable(t, path, sstableVersion, entries)
+
+	table := openTestTable(t, path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[table.Index[1].Offset+blockHeaderSize] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	table = openTestTable(t, path)
+	if _, _, err := table.GetEntry([]byte(table.Index[1].Key)); err == nil {
+		t.Error("GetEntry in a damaged block succeeded")
+	}
+	if _, err := table.scanRange(IterOptions{}, rangeScan{}); err == nil {
+		t.Error("scan over a damaged block succeeded")
+	}
+	if _, _, err := table.GetEntry([]byte(table.Index[0].Key)); err != nil {
+		t.Errorf("GetEntry in an undamaged block: %v", err)
+	}
+}
+
+// highKeys sort after "\xff", so no closed range up to it covers them.
+var highKeys = []string{"\xff", "\xff\x00", "\xff\x01", "\xff\xff\xff"}
+
+// openTestEngine returns an engine in a fresh directory that flushes only
+// when told to, with compaction paused.
+func openTestEngine(t *testing.T) *Engine {
+	t.Helper()
+
+	old := MemTableFlushThreshold
+	MemTableFlushThreshold = 1 << 20
+	t.Cleanup(func() { MemTableFlushThreshold = old })
+
+	e, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	e.PauseCompaction(true)
+	return e
+}
+
+// flushTestEngine writes the engine's memtable to a table.
+func flushTestEngine(t *testing.T, e *Engine) {
+	t.Helper()
+
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	if err := e.flushMemTable(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// putHighKeys puts every one of keys and highKeys, with values ending in
+// suffix.
+func putHighKeys(t *testing.T, e *Engine, suffix string, keys ...string) {
+	t.Helper()
+
+	for _, k := range append(keys, highKeys...) {
+		if err := e.Put([]byte(k), []byte(k+suffix)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkHighKeys checks that the engine serves the value ending in suffix
+// for every one of keys and highKeys.
+func checkHighKeys(t *testing.T, e *Engine, suffix string, keys ...string) {
+	t.Helper()
+
+	for _, k := range append(keys, highKeys...) {
+		if got, ok := e.Get([]byte(k)); !ok || string(got) != k+suffix {
+			t.Errorf("Get(%q) = %q, %v; want %q", k, got, ok, k+suffix)
+		}
+	}
+}
+
+func TestCompactKeepsHighKeys(t *testing.T) {
+	for _, parallelism := range []int{1, 3} {
+		t.Run(fmt.Sprint(parallelism), func(t *testing.T) {
+			old := compactionParallelism
+			compactionParallelism = parallelism
+			t.Cleanup(func() { compactionParallelism = old })
+
+			// Two overlapping tables, the newer one's values ending in "2"
+			e := openTestEngine(t)
+			putHighKeys(t, e, "1", "a", "m", "z")
+			flushTestEngine(t, e)
+			putHighKeys(t, e, "2", "a", "m", "z")
+			flushTestEngine(t, e)
+
+			if err := e.compactAll(); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(e.tables()); n != 1 {
+				t.Fatalf("compaction left %d tables", n)
+			}
+			checkHighKeys(t, e, "2", "a", "m", "z")
+		})
+	}
+}
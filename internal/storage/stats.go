@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const statsName = "STATS"
+
+// Prefix buckets group keys by the part before the first ':' or '/'.
+const (
+	maxStatsPrefixes = 1000
+	noPrefixBucket   = "(none)"
+	otherBucket      = "(other)"
+)
+
+// Stats summarise the data set. They are recomputed by RefreshStats and
+// persisted next to the MANIFEST so they survive restarts.
+type Stats struct {
+	UpdatedAt    time.Time        `json:"updated_at"`
+	Keys         int64            `json:"keys"`
+	AvgValueSize float64          `json:"avg_value_size"`
+	Prefixes     map[string]int64 `json:"prefixes"`
+	// WritesPerSec is the write rate since the previous refresh by this
+	// process; it is zero after the first refresh.
+	WritesPerSec float64 `json:"writes_per_sec"`
+}
+
+type statsState struct {
+	mu      sync.Mutex
+	current *Stats
+	// seq is the visible sequence number at the last refresh.
+	seq uint64
+}
+
+// Stats returns the most recent statistics, or nil if none were computed.
+func (e *Engine) Stats() *Stats {
+	e.stats.mu.Lock()
+	defer e.stats.mu.Unlock()
+	return e.stats.current
+}
+
+// RefreshStats scans the data set, recomputes the statistics and persists
+// them.
+func (e *Engine) RefreshStats() (*Stats, error) {
+	e.stats.mu.Lock()
+	defer e.stats.mu.Unlock()
+
+	seq := e.visibleSeq.Load()
+	all, err := e.ReadKeyRange([]byte(""), []byte("\xff"))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stats{
+		UpdatedAt: clock().UTC(),
+		Keys:      int64(len(all)),
+		Prefixes:  make(map[string]int64),
+	}
+
+	var valueBytes int64
+	for k, v := range all {
+		valueBytes += int64(len(v))
+
+		bucket := KeyPrefix(k)
+		if _, ok := s.Prefixes[bucket]; !ok && len(s.Prefixes) >= maxStatsPrefixes {
+			bucket = otherBucket
+		}
+		s.Prefixes[bucket]++
+	}
+	if s.Keys > 0 {
+		s.AvgValueSize = float64(valueBytes) / float64(s.Keys)
+	}
+
+	if prev := e.stats.current; prev != nil && e.stats.seq > 0 && seq >= e.stats.seq {
+		if elapsed := s.UpdatedAt.Sub(prev.UpdatedAt).Seconds(); elapsed > 0 {
+			s.WritesPerSec = float64(seq-e.stats.seq) / elapsed
+		}
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if !e.secondary {
+		if err := writeFileAtomic(filepath.Join(e.DataDir(), statsName), data); err != nil {
+			return nil, err
+		}
+	}
+
+	e.stats.current = s
+	e.stats.seq = seq
+	return s, nil
+}
+
+// KeyPrefix returns the bucket a key is counted under: the part before the
+// first ':' or '/', or "(none)".
+func KeyPrefix(key string) string {
+	i := strings.IndexAny(key, ":/")
+	if i <= 0 {
+		return noPrefixBucket
+	}
+	return key[:i]
+}
+
+// loadStats reads the persisted statistics, if any.
+func loadStats(dataDir string) (*Stats, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, statsName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s Stats
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"container/list"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+// maxOpenTables bounds how many table files an engine keeps open between
+// reads; 0 opens a table's file for every read.
+var maxOpenTables = 256
+
+var (
+	tableFileOpens = metrics.NewCounter("logbase_table_file_opens_total",
+		"SSTable files opened for reads.")
+	tableFileReuses = metrics.NewCounter("logbase_table_file_reuses_total",
+		"SSTable reads served by a file already open.")
+	tableFilesOpen = metrics.NewGauge("logbase_table_files_open",
+		"SSTable files held open between reads.")
+)
+
+// tableFile is an open table file that concurrent reads share; they only
+// use ReadAt, through section readers. refs counts the reads using it,
+// plus one while it is the table's cached handle, and the last release
+// closes it.
+type tableFile struct {
+	file *os.File
+	refs atomic.Int32
+}
+
+// tryRef takes a reference unless the file is already closed.
+func (f *tableFile) tryRef() bool {
+	for {
+		n := f.refs.Load()
+		if n <= 0 {
+			return false
+		}
+		if f.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+func (f *tableFile) release() error {
+	if f.refs.Add(-1) == 0 {
+		return f.file.Close()
+	}
+	return nil
+}
+
+// tableFiles is an engine's bound on cached table handles: the tables
+// whose files are held open, least recently read first out. A table that
+// drops its handle on its own, when its file is removed, stays listed
+// until it reaches the end.
+type tableFiles struct {
+	mu       sync.Mutex
+	capacity int
+	order    list.List
+	elems    map[*SSTable]*list.Element
+}
+
+func newTableFiles(capacity int) *tableFiles {
+	if capacity <= 0 {
+		return nil
+	}
+	return &tableFiles{capacity: capacity, elems: make(map[*SSTable]*list.Element)}
+}
+
+// add records that s holds its file open, closing the least recently read
+// table's file if that is one too many.
+func (f *tableFiles) add(s *SSTable) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if el, ok := f.elems[s]; ok {
+		f.order.MoveToFront(el)
+		return
+	}
+	f.elems[s] = f.order.PushFront(s)
+	for f.order.Len() > f.capacity {
+		f.evict(f.order.Back())
+	}
+}
+
+func (f *tableFiles) touch(s *SSTable) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if el, ok := f.elems[s]; ok {
+		f.order.MoveToFront(el)
+	}
+}
+
+// closeAll closes every cached handle, when the engine closes.
+func (f *tableFiles) closeAll() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for f.order.Len() > 0 {
+		f.evict(f.order.Back())
+	}
+}
+
+// evict drops the table at el. f.mu must be held.
+func (f *tableFiles) evict(el *list.Element) {
+	s := f.order.Remove(el).(*SSTable)
+	delete(f.elems, s)
+	s.dropHandle()
+}
+
+// openShared is open through files: it reuses the table's cached handle
+// if it has one and otherwise opens the file and caches it. A nil files
+// opens the file for this read only. Release the handle when done.
+func (s *SSTable) openShared(files *tableFiles) (*tableFile, *io.SectionReader, error) {
+	if files != nil {
+		if h := s.handle.Load(); h != nil && h.tryRef() {
+			tableFileReuses.Inc()
+			files.touch(s)
+			return h, io.NewSectionReader(h.file, 0, s.dataSize), nil
+		}
+	}
+
+	file, section, err := s.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	tableFileOpens.Inc()
+	h := &tableFile{file: file}
+	h.refs.Store(1)
+	if files == nil || s.removed.Load() {
+		return h, section, nil
+	}
+
+	h.refs.Store(2)
+	if !s.handle.CompareAndSwap(nil, h) {
+		h.refs.Store(1)
+		return h, section, nil
+	}
+	tableFilesOpen.Add(1)
+	files.add(s)
+	// A table removed meanwhile must not keep its deleted file open
+	if s.removed.Load() {
+		s.dropHandle()
+	}
+	return h, section, nil
+}
+
+// dropHandle closes the table's cached handle once the reads using it are
+// done.
+func (s *SSTable) dropHandle() {
+	if h := s.handle.Swap(nil); h != nil {
+		tableFilesOpen.Add(-1)
+		h.release()
+	}
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+var (
+	// ErrTailTruncated means records a tail asked for are no longer kept.
+	ErrTailTruncated = errors.New("wal tail: records are no longer retained")
+	// ErrTailLagging ends a tail whose reader fell too far behind.
+	ErrTailLagging = errors.New("wal tail: reader fell behind")
+)
+
+// defaultTailRetention is how many recent records an engine keeps for
+// tails to resume from; see SetTailRetention.
+const defaultTailRetention = 4096
+
+// tailBuffer is how many records a tail holds for its reader, beyond the
+// ones it resumed with, before it is ended with ErrTailLagging.
+const tailBuffer = 1024
+
+// Tail is a subscription to committed writes; see Engine.TailWAL.
+type Tail struct {
+	// C delivers committed records, with their sequence numbers, in
+	// sequence order. It is closed when the tail ends; Err then says why.
+	C <-chan WALRecord
+
+	c    chan WALRecord
+	log  *tailLog
+	err  error
+	done bool
+}
+
+// tailLog keeps the most recent committed records and feeds them to tails.
+type tailLog struct {
+	mu        sync.Mutex
+	recent    []WALRecord
+	retention int
+	tails     map[*Tail]struct{}
+}
+
+// TailWAL subscribes to writes committed after sequence number after, so
+// an embedder can keep its own index or cache in step with the engine.
+// Records still retained from before the call are delivered first, then
+// new ones as they are published to readers. A reader that stops keeping
+// up is ended with ErrTailLagging and may resume from the last sequence
+// number it saw; resuming from a point no longer retained fails with
+// ErrTailTruncated.
+//
+// Writes to the expiry index are internal and left out, so the sequence
+// numbers delivered can skip. Sequence numbers carry over when the data
+// directory is reopened, but retained records do not, so a tail cannot be
+// resumed across a restart.
+func (e *Engine) TailWAL(after uint64) (*Tail, error) {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if e.secondary {
+		return nil, ErrSecondary
+	}
+	if after > e.lastSeq {
+		return nil, fmt.Errorf("wal tail: sequence number %d is ahead of the last write (%d)", after, e.lastSeq)
+	}
+
+	l := &e.tail
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	backlog := l.recent
+	for len(backlog) > 0 && backlog[0].Seq <= after {
+		backlog = backlog[1:]
+	}
+	if after < e.lastSeq && (len(backlog) == 0 || backlog[0].Seq != after+1) {
+		return nil, fmt.Errorf("%w: wanted %d", ErrTailTruncated, after+1)
+	}
+
+	c := make(chan WALRecord, len(backlog)+tailBuffer)
+	for _, r := range backlog {
+		if !isExpiryIndex(r.Key) {
+			c <- r
+		}
+	}
+	t := &Tail{C: c, c: c, log: l}
+	if l.tails == nil {
+		l.tails = make(map[*Tail]struct{})
+	}
+	l.tails[t] = struct{}{}
+	return t, nil
+}
+
+// SetTailRetention sets how many recent records are kept for tails to
+// resume from; 0 keeps none. The default is 4096.
+func (e *Engine) SetTailRetention(records int) {
+	l := &e.tail
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.retention = max(records, 0)
+	if len(l.recent) > l.retention {
+		l.recent = slices.Clone(l.recent[len(l.recent)-l.retention:])
+	}
+}
+
+// Err returns why the tail ended: nil once it was closed or its engine
+// was, ErrTailLagging if its reader fell behind.
+func (t *Tail) Err() error {
+	t.log.mu.Lock()
+	defer t.log.mu.Unlock()
+	return t.err
+}
+
+// Close ends the tail and closes C.
+func (t *Tail) Close() {
+	t.log.mu.Lock()
+	defer t.log.mu.Unlock()
+	t.log.end(t, nil)
+}
+
+// publish keeps records and hands them to every tail. Callers hold
+// writeMu, so records arrive in sequence order.
+func (l *tailLog) publish(records ...WALRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.retention > 0 {
+		l.recent = append(l.recent, records...)
+		if len(l.recent) > l.retention {
+			// The dropped front is reclaimed when append next reallocates
+			l.recent = l.recent[len(l.recent)-l.retention:]
+		}
+	}
+
+	for t := range l.tails {
+		for _, r := range records {
+			if isExpiryIndex(r.Key) {
+				continue
+			}
+			select {
+			case t.c <- r:
+				continue
+			default:
+				l.end(t, ErrTailLagging)
+			}
+			break
+		}
+	}
+}
+
+// end closes t with err. Callers hold l.mu.
+func (l *tailLog) end(t *Tail, err error) {
+	if t.done {
+		return
+	}
+	t.done, t.err = true, err
+	delete(l.tails, t)
+	close(t.c)
+}
+
+// closeAll ends every tail, when the engine closes.
+func (l *tailLog) closeAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for t := range l.tails {
+		l.end(t, nil)
+	}
+}
@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+var tierReads = metrics.NewCounterVec("logbase_tier_reads_total",
+	"Point lookups served from an SSTable, by the table's storage tier.", "tier")
+
+// TierOptions configure cold tiering; see EnableTiering.
+type TierOptions struct {
+	// Dir is the cold tier, typically slower and cheaper storage. It must
+	// not be shared with another data directory.
+	Dir string
+	// MinAge is how long a key must go untouched to count as cold.
+	MinAge time.Duration
+	// ColdFraction is the fraction of a table's keys that must be cold for
+	// the table to move, in (0, 1].
+	ColdFraction float64
+}
+
+// EnableTiering lets TierCold move tables dominated by cold keys into
+// opts.Dir. A moved table's file in the data directory is replaced by a
+// symlink into the cold tier, so reads reach it transparently, at the cold
+// tier's latency, and it leaves the cold tier again only when compaction
+// replaces it. Coldness comes from access tracking, which must be enabled
+// first.
+func (e *Engine) EnableTiering(opts TierOptions) error {
+	if e.access.Load() == nil {
+		return ErrAccessTracking
+	}
+	if opts.ColdFraction <= 0 || opts.ColdFraction > 1 {
+		opts.ColdFraction = 1
+	}
+	dir, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	opts.Dir = dir
+
+	e.tiering.Store(&opts)
+	return nil
+}
+
+// TierCold moves every table whose share of cold keys has reached the
+// configured fraction into the cold tier. It runs on the compaction
+// goroutine, so no table is merged away while it is being moved, and waits
+// for it to finish. It does nothing unless tiering is enabled, or while
+// compaction is paused.
+func (e *Engine) TierCold() error {
+	if e.tiering.Load() == nil || e.secondary {
+		return nil
+	}
+	e.tierRequested.Store(true)
+	e.requestCompaction()
+	return e.WaitForCompactions()
+}
+
+// tierCold does the work of TierCold on the compaction goroutine.
+func (e *Engine) tierCold() error {
+	opts, t := e.tiering.Load(), e.access.Load()
+	if opts == nil || t == nil {
+		return nil
+	}
+	cutoff := clock().Add(-opts.MinAge).Unix()
+
+	moved := 0
+	for _, table := range e.tables() {
+		if table.cold.Load() {
+			continue
+		}
+		e.yieldCompaction()
+
+		fraction, err := table.coldFraction(t, cutoff)
+		if err != nil {
+			return err
+		}
+		if fraction < opts.ColdFraction {
+			continue
+		}
+		if err := table.moveToCold(opts.Dir); err != nil {
+			return err
+		}
+		compactionLog.Infof("moved %s to the cold tier, %.0f%% of its keys cold", filepath.Base(table.Path), fraction*100)
+		moved++
+	}
+
+	if moved > 0 {
+		e.saveIndexCache()
+	}
+	return nil
+}
+
+// coldFraction is the fraction of the table's records whose key was last
+// touched at or before cutoff, in Unix seconds.
+func (s *SSTable) coldFraction(t *accessTracker, cutoff int64) (float64, error) {
+	it, err := s.newIterator(IterOptions{}, rangeScan{keysOnly: true, io: ioCompaction})
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+
+	var total, cold int
+	t.mu.Lock()
+	for it.Next() {
+		last := t.since
+		if times := t.keys[string(it.Key())]; times != nil {
+			last = max(last, times.Read, times.Write)
+		}
+		total++
+		if last <= cutoff {
+			cold++
+		}
+	}
+	t.mu.Unlock()
+
+	if err := it.Err(); err != nil || total == 0 {
+		return 0, err
+	}
+	return float64(cold) / float64(total), nil
+}
+
+// moveToCold copies the table's file into dir and swaps the file in the
+// data directory for a symlink to the copy. The bloom sidecar stays put;
+// it is only read when the table is loaded. Readers holding the old file
+// open finish on it.
+func (s *SSTable) moveToCold(dir string) error {
+	target := filepath.Join(dir, filepath.Base(s.Path))
+	if err := copyFile(s.Path, target+".tmp"); err != nil {
+		os.Remove(target + ".tmp")
+		return err
+	}
+	if err := os.Rename(target+".tmp", target); err != nil {
+		os.Remove(target + ".tmp")
+		return err
+	}
+
+	link := s.Path + ".link.tmp"
+	if err := os.Symlink(target, link); err != nil {
+		os.Remove(target)
+		return err
+	}
+	if err := os.Rename(link, s.Path); err != nil {
+		os.Remove(link)
+		os.Remove(target)
+		return err
+	}
+	s.cold.Store(true)
+	// Later reads open the copy, so the hot file's space is freed
+	s.dropHandle()
+	return nil
+}
+
+// detectTier notes whether the table's file lives in the cold tier.
+func (s *SSTable) detectTier() {
+	info, err := os.Lstat(s.Path)
+	s.cold.Store(err == nil && info.Mode()&os.ModeSymlink != 0)
+}
+
+// countTierRead counts a point lookup served from the table.
+func (s *SSTable) countTierRead() {
+	if s.cold.Load() {
+		tierReads.With("cold").Inc()
+	} else {
+		tierReads.With("hot").Inc()
+	}
+}
+
+// removeTableFile removes a table file and, if it is a symlink into the
+// cold tier, the file it points to.
+func removeTableFile(path string) error {
+	if target, err := os.Readlink(path); err == nil {
+		if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// linkTableFile hard-links a table file into a checkpoint. A table in the
+// cold tier is copied instead: the link would capture the symlink, whose
+// target is deleted once compaction replaces the table.
+func linkTableFile(path, dst string) error {
+	if err := os.Link(path, dst); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dst)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return err
+	}
+	if err := os.Remove(dst); err != nil {
+		return err
+	}
+	return copyFile(path, dst)
+}
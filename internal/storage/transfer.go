@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxChunkBytes caps one ReadCheckpointChunk call.
+const MaxChunkBytes = 4 << 20
+
+// transferBytesPerSec caps how fast checkpoint files are served to other
+// nodes, across all transfers; 0 means unlimited.
+var transferBytesPerSec int64
+
+var ErrNoCheckpointFile = errors.New("no such checkpoint file")
+
+// CheckpointFile is one file of a checkpoint, as a replica bootstrapping
+// from it must fetch it. Name is relative to the checkpoint directory and
+// uses forward slashes.
+type CheckpointFile struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+	CRC32 uint32 `json:"crc32"`
+}
+
+// CheckpointFiles lists the named checkpoint's files with their sizes and
+// CRC32s. MANIFEST is listed last; fetching it last means a partially
+// fetched data directory never looks complete.
+func (e *Engine) CheckpointFiles(name string) ([]CheckpointFile, error) {
+	dir, err := e.checkpointDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "sst_*"))
+	paths = append(paths, allSegmentPaths(filepath.Join(dir, walDirName))...)
+	paths = append(paths, filepath.Join(dir, manifestName))
+
+	files := make([]CheckpointFile, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		hash := crc32.NewIEEE()
+		n, err := io.Copy(hash, ioReplication.reader(f))
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, CheckpointFile{Name: filepath.ToSlash(rel), Bytes: n, CRC32: hash.Sum32()})
+	}
+	return files, nil
+}
+
+// ReadCheckpointChunk reads up to length bytes of a checkpoint file from
+// offset, throttled by the engine's transfer rate limit. A chunk shorter
+// than length means the end of the file was reached.
+func (e *Engine) ReadCheckpointChunk(name, file string, offset int64, length int) ([]byte, error) {
+	dir, err := e.checkpointDir(name)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsLocal(filepath.FromSlash(file)) {
+		return nil, fmt.Errorf("%w: %s", ErrNoCheckpointFile, file)
+	}
+	if offset < 0 || length <= 0 {
+		return nil, fmt.Errorf("bad chunk range: offset %d, length %d", offset, length)
+	}
+
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNoCheckpointFile, file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, min(length, MaxChunkBytes))
+	n, err := io.ReadFull(e.transferLimiter.reader(ioReplication.reader(io.NewSectionReader(f, offset, int64(len(buf))))), buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (e *Engine) checkpointDir(name string) (string, error) {
+	dir := filepath.Join(e.DataDir(), checkpointDirName, name)
+	if !strings.HasPrefix(name, "ckpt-") || filepath.Base(name) != name || strings.HasSuffix(name, ".tmp") {
+		return "", fmt.Errorf("%w: %s", ErrNoCheckpoint, name)
+	}
+	if _, err := os.Stat(filepath.Join(dir, manifestName)); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrNoCheckpoint, name)
+	}
+	return dir, nil
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ValueTransformer converts values on their way into and out of storage,
+// for client-managed encryption or to read a legacy value format. Encode
+// is applied to every value written and Decode to every value read, so
+// Decode must accept whatever Encode produces, and for a shim whatever
+// was stored before the transformer was installed. Both are passed the
+// key, for use as a nonce or associated data, and must be safe for
+// concurrent use. Metadata and keys are stored as they are.
+type ValueTransformer interface {
+	Encode(key, value []byte) ([]byte, error)
+	Decode(key, stored []byte) ([]byte, error)
+}
+
+// transformers is the compiled-in registry the server picks from by name.
+var transformers = struct {
+	sync.RWMutex
+	byName map[string]ValueTransformer
+}{byName: make(map[string]ValueTransformer)}
+
+// RegisterValueTransformer makes t available by name, to
+// LOGBASE_VALUE_TRANSFORMER and namespace attachments. It is meant to be
+// called from an init function compiled into the server; registering a
+// name twice panics.
+func RegisterValueTransformer(name string, t ValueTransformer) {
+	transformers.Lock()
+	defer transformers.Unlock()
+
+	if _, ok := transformers.byName[name]; ok {
+		panic("storage: value transformer registered twice: " + name)
+	}
+	transformers.byName[name] = t
+}
+
+// LookupValueTransformer returns the transformer registered as name.
+func LookupValueTransformer(name string) (ValueTransformer, error) {
+	transformers.RLock()
+	defer transformers.RUnlock()
+
+	t, ok := transformers.byName[name]
+	if !ok {
+		names := make([]string, 0, len(transformers.byName))
+		for n := range transformers.byName {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown value transformer %q (registered: %v)", name, names)
+	}
+	return t, nil
+}
+
+// SetValueTransformer installs t for every later read and write; nil
+// removes it. Values are stored as given until one is installed, so it
+// belongs right after the engine is opened, and a data directory must be
+// opened with the same transformer every time.
+func (e *Engine) SetValueTransformer(t ValueTransformer) {
+	if t == nil {
+		e.transformer.Store(nil)
+		return
+	}
+	e.transformer.Store(&t)
+}
+
+// encodeValue is value as it is stored.
+func (e *Engine) encodeValue(key, value []byte) ([]byte, error) {
+	t := e.transformer.Load()
+	if t == nil {
+		return value, nil
+	}
+	stored, err := (*t).Encode(key, value)
+	if err != nil {
+		return nil, fmt.Errorf("encoding value of %q: %w", key, err)
+	}
+	return stored, nil
+}
+
+// encodeRecords returns records with their values as stored; deletes are
+// left alone.
+func (e *Engine) encodeRecords(records []WALRecord) ([]WALRecord, error) {
+	if e.transformer.Load() == nil {
+		return records, nil
+	}
+
+	stored := make([]WALRecord, len(records))
+	for i, r := range records {
+		if r.Type != DeleteRecord {
+			value, err := e.encodeValue(r.Key, r.Value)
+			if err != nil {
+				return nil, err
+			}
+			r.Value = value
+		}
+		stored[i] = r
+	}
+	return stored, nil
+}
+
+// decodeEntry is entry as it was written.
+func (e *Engine) decodeEntry(key []byte, entry Entry) (Entry, error) {
+	t := e.transformer.Load()
+	if t == nil {
+		return entry, nil
+	}
+	value, err := (*t).Decode(key, entry.Value)
+	if err != nil {
+		return Entry{}, fmt.Errorf("decoding value of %q: %w", key, err)
+	}
+	entry.Value = value
+	return entry, nil
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/manjeet13/logbase/internal/metrics"
+)
+
+var expiredKeys = metrics.NewCounter("logbase_expired_keys_total",
+	"Keys deleted by PurgeExpired because their TTL ran out.")
+
+// expiryPrefix starts the keys of the expiry index. The index is kept in
+// the store itself, so it is logged, flushed, compacted and replicated with
+// the keys it points at. Range reads and WAL tails skip it, and writes of
+// keys starting with it fail with ErrReservedKey.
+const expiryPrefix = "\x00expiry/"
+
+// ErrReservedKey means a write named a key under expiryPrefix, which only
+// the expiry index may use.
+var ErrReservedKey = errors.New("key uses a reserved prefix")
+
+// isExpiryIndex reports whether key belongs to the expiry index.
+func isExpiryIndex(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(expiryPrefix))
+}
+
+// checkKeys fails with ErrReservedKey if any of keys is reserved. Every
+// write entry point checks its keys, so only the engine writes the index.
+func checkKeys(keys iter.Seq[[]byte]) error {
+	for key := range keys {
+		if isExpiryIndex(key) {
+			return fmt.Errorf("%w: %q", ErrReservedKey, key)
+		}
+	}
+	return nil
+}
+
+// expiryBatch caps the index entries PurgeExpired deletes in one batch.
+const expiryBatch = 1000
+
+// expiryKey is the index key for key expiring in the given Unix second.
+// Entries sort by that second, so the entries due are a prefix of the
+// index.
+func expiryKey(second int64, key []byte) []byte {
+	b := make([]byte, 0, len(expiryPrefix)+8+len(key))
+	b = append(b, expiryPrefix...)
+	b = binary.BigEndian.AppendUint64(b, uint64(second))
+	return append(b, key...)
+}
+
+// putExpiring is PutWithOptions for a put with a TTL. The put and its
+// index entry are written as one batch, the entry right after the put,
+// so the entry's sequence number, less one, names the write it expires.
+func (e *Engine) putExpiring(key, value []byte, meta []byte, opts WriteOptions, route writeRoute) (uint64, error) {
+	deadline := clock().Add(opts.TTL)
+	put := WALRecord{Type: PutRecord, Key: key, Value: value}
+	if meta != nil {
+		put.Type, put.Meta = PutMetaRecord, meta
+	}
+	index := WALRecord{
+		Type:  PutRecord,
+		Key:   expiryKey(deadline.Unix(), key),
+		Value: binary.BigEndian.AppendUint64(nil, uint64(deadline.UnixNano())),
+	}
+
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if err := e.checkSeq(key, opts.IfSeq); err != nil {
+		return 0, err
+	}
+	if err := e.applyRecordsLocked([]WALRecord{put, index}, route); err != nil {
+		return 0, err
+	}
+	return e.lastSeq - 1, nil
+}
+
+// dueExpiry is an index entry whose deadline has passed.
+type dueExpiry struct {
+	index []byte
+	key   []byte
+	// seq is the sequence number of the put the entry expires.
+	seq uint64
+}
+
+// PurgeExpired deletes the keys whose TTL has run out, along with their
+// index entries, and returns how many keys it deleted. It reads only the
+// index entries that are due, so its cost follows the number of expired
+// keys, not the size of the store. A key overwritten or deleted since its
+// TTL was set is left alone; only the stale entry goes. Until it runs, an
+// expired key is still readable. It does nothing on a secondary, or while
+// the engine is read-only: expiry pauses for maintenance, and expired keys
+// stay readable until it ends.
+func (e *Engine) PurgeExpired() (int, error) {
+	if e.secondary || e.maintenance.Load() != nil {
+		return 0, nil
+	}
+	now := clock()
+
+	purged := 0
+	for {
+		due, err := e.dueExpiries(now, expiryBatch)
+		if err != nil || len(due) == 0 {
+			return purged, err
+		}
+		n, err := e.purgeExpiries(due)
+		purged += n
+		expiredKeys.Add(uint64(n))
+		if err != nil || len(due) < expiryBatch {
+			return purged, err
+		}
+	}
+}
+
+// dueExpiries returns up to limit index entries whose deadline is at or
+// before now.
+func (e *Engine) dueExpiries(now time.Time, limit int) ([]dueExpiry, error) {
+	bounds := IterOptions{
+		LowerBound: []byte(expiryPrefix),
+		UpperBound: expiryKey(now.Unix()+1, nil),
+	}
+	rv := e.view()
+	merged, err := e.mergeRange(rv, bounds, planRange(rv.tables, bounds, RangeOptions{}), RangeOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer merged.Close()
+	// This is the one read of the index itself
+	merged.hidePrefix = nil
+
+	var due []dueExpiry
+	for len(due) < limit && merged.Next() {
+		index := merged.Key()
+		entry, err := e.decodeEntry(index, merged.Entry())
+		if err != nil {
+			return nil, err
+		}
+		// Entries in the current second may not be due yet
+		if len(entry.Value) == 8 && int64(binary.BigEndian.Uint64(entry.Value)) > now.UnixNano() {
+			continue
+		}
+		due = append(due, dueExpiry{
+			index: bytes.Clone(index),
+			key:   bytes.Clone(index[len(expiryPrefix)+8:]),
+			seq:   entry.Seq - 1,
+		})
+	}
+	return due, e.breaker.recordRead(merged.Err())
+}
+
+// purgeExpiries deletes the due entries, and each key still holding the
+// value its entry expires, as one batch. It returns the keys deleted.
+func (e *Engine) purgeExpiries(due []dueExpiry) (int, error) {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	var records []WALRecord
+	n := 0
+	for _, d := range due {
+		if entry, ok := e.getStoredIn(e.view(), d.key); ok && entry.Seq == d.seq {
+			records = append(records, WALRecord{Type: DeleteRecord, Key: d.key})
+			n++
+		}
+		records = append(records, WALRecord{Type: DeleteRecord, Key: d.index})
+	}
+	if err := e.applyRecordsLocked(records, writeRoute{}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReservedKeysRefused(t *testing.T) {
+	e := openTestEngine(t)
+	reserved := expiryKey(0, []byte("key"))
+
+	var batch Batch
+	batch.Put([]byte("ok"), []byte("value"))
+	batch.Delete(reserved)
+	txn := e.Begin()
+	if err := txn.Put(reserved, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Put([]byte("src/key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	writes := map[string]func() error{
+		"Put":         func() error { return e.Put(reserved, []byte("value")) },
+		"Delete":      func() error { return e.Delete(reserved) },
+		"BatchPut":    func() error { return e.BatchPut(map[string][]byte{string(reserved): nil}) },
+		"BatchDelete": func() error { return e.BatchDelete([][]byte{reserved}) },
+		"Write":       func() error { return e.Write(&batch) },
+		"Txn.Commit":  txn.Commit,
+		"CopyRange": func() error {
+			_, err := e.CopyRange([]byte("src/"), []byte("src/\xff"), CopyRangeOptions{SrcPrefix: []byte("src/"), DstPrefix: []byte(expiryPrefix)})
+			return err
+		},
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrReservedKey) {
+			t.Errorf("%s: %v, want %v", name, err, ErrReservedKey)
+		}
+	}
+	if _, ok := e.Get([]byte("ok")); ok {
+		t.Error("a refused batch was partly applied")
+	}
+}
+
+func TestForgedExpiryIgnored(t *testing.T) {
+	e := openTestEngine(t)
+	if err := e.Put([]byte("victim"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	// An entry naming victim, due a second ago, with the sequence number
+	// of victim's put
+	forged := expiryKey(clock().Unix()-1, []byte("victim"))
+	if err := e.Put(forged, []byte("deadline")); !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("forged entry: %v, want %v", err, ErrReservedKey)
+	}
+	if n, err := e.PurgeExpired(); err != nil || n != 0 {
+		t.Errorf("PurgeExpired = %d, %v; want 0", n, err)
+	}
+	if got, ok := e.Get([]byte("victim")); !ok || !bytes.Equal(got, []byte("value")) {
+		t.Errorf("Get(victim) = %q, %v", got, ok)
+	}
+}
+
+func TestExpiryIndexHidden(t *testing.T) {
+	e := openTestEngine(t)
+	tail, err := e.TailWAL(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tail.Close()
+
+	if _, err := e.PutWithOptions([]byte("key"), []byte("value"), WriteOptions{TTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Put([]byte("next"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bounds inside the prefix still leave the index out
+	got, _, err := e.ReadBounds(IterOptions{LowerBound: []byte(expiryPrefix)}, RangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range got {
+		if isExpiryIndex([]byte(key)) {
+			t.Errorf("range read returned index entry %q", key)
+		}
+	}
+
+	for _, want := range []string{"key", "next"} {
+		r := <-tail.C
+		if string(r.Key) != want {
+			t.Errorf("tail delivered %q, want %q", r.Key, want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultLockTimeout bounds how long a pessimistic transaction waits for a key.
+const DefaultLockTimeout = time.Second
+
+var (
+	ErrTxnDone     = errors.New("transaction already committed or rolled back")
+	ErrNoSavepoint = errors.New("no savepoint set")
+)
+
+// TxnOptions selects the transaction's concurrency control.
+type TxnOptions struct {
+	// Pessimistic transactions take an exclusive lock on every key they
+	// write or read with GetForUpdate, holding it until Commit or Rollback.
+	// Locks only coordinate pessimistic transactions with each other.
+	Pessimistic bool
+	// LockTimeout bounds each lock wait; DefaultLockTimeout when zero.
+	LockTimeout time.Duration
+}
+
+// Txn buffers writes and applies them atomically on Commit. Reads see the
+// transaction's own writes layered over the engine's current state. Without
+// the Pessimistic option there is no conflict detection: the last committer
+// wins.
+type Txn struct {
+	engine *Engine
+	id     uint64
+	opts   TxnOptions
+	writes []WALRecord
+	// savepoints holds len(writes) at each SetSavepoint call.
+	savepoints []int
+	locked     []string
+	done       bool
+}
+
+func (e *Engine) Begin() *Txn {
+	return e.BeginWithOptions(TxnOptions{})
+}
+
+func (e *Engine) BeginWithOptions(opts TxnOptions) *Txn {
+	if opts.LockTimeout <= 0 {
+		opts.LockTimeout = DefaultLockTimeout
+	}
+	return &Txn{engine: e, id: e.txnIDs.Add(1), opts: opts}
+}
+
+func (t *Txn) Put(key, value []byte) error {
+	return t.buffer(WALRecord{Type: PutRecord, Key: key, Value: value})
+}
+
+func (t *Txn) Delete(key []byte) error {
+	return t.buffer(WALRecord{Type: DeleteRecord, Key: key})
+}
+
+func (t *Txn) buffer(r WALRecord) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if err := t.lock(r.Key); err != nil {
+		return err
+	}
+	t.writes = append(t.writes, r)
+	return nil
+}
+
+// GetForUpdate reads key after locking it, so no other pessimistic
+// transaction can change it before this one finishes.
+func (t *Txn) GetForUpdate(key []byte) ([]byte, bool, error) {
+	if t.done {
+		return nil, false, ErrTxnDone
+	}
+	if err := t.lock(key); err != nil {
+		return nil, false, err
+	}
+	val, ok := t.Get(key)
+	return val, ok, nil
+}
+
+func (t *Txn) lock(key []byte) error {
+	if !t.opts.Pessimistic {
+		return nil
+	}
+	k := string(key)
+	for _, held := range t.locked {
+		if held == k {
+			return nil
+		}
+	}
+	if err := t.engine.locks.lock(t.id, k, t.opts.LockTimeout); err != nil {
+		return err
+	}
+	t.locked = append(t.locked, k)
+	return nil
+}
+
+func (t *Txn) unlock() {
+	if len(t.locked) > 0 {
+		t.engine.locks.unlockAll(t.id, t.locked)
+		t.locked = nil
+	}
+}
+
+func (t *Txn) Get(key []byte) ([]byte, bool) {
+	for i := len(t.writes) - 1; i >= 0; i-- {
+		if w := t.writes[i]; string(w.Key) == string(key) {
+			return w.Value, w.Type != DeleteRecord
+		}
+	}
+	return t.engine.Get(key)
+}
+
+// SetSavepoint marks the current state of the write buffer.
+func (t *Txn) SetSavepoint() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.savepoints = append(t.savepoints, len(t.writes))
+	return nil
+}
+
+// RollbackToSavepoint discards every write made since the most recent
+// savepoint and removes that savepoint.
+func (t *Txn) RollbackToSavepoint() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if len(t.savepoints) == 0 {
+		return ErrNoSavepoint
+	}
+
+	last := len(t.savepoints) - 1
+	t.writes = t.writes[:t.savepoints[last]]
+	t.savepoints = t.savepoints[:last]
+	return nil
+}
+
+// PopSavepoint removes the most recent savepoint without undoing any writes.
+func (t *Txn) PopSavepoint() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if len(t.savepoints) == 0 {
+		return ErrNoSavepoint
+	}
+	t.savepoints = t.savepoints[:len(t.savepoints)-1]
+	return nil
+}
+
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	defer t.unlock()
+
+	if len(t.writes) == 0 {
+		return nil
+	}
+	return t.engine.applyRecords(t.writes)
+}
+
+// Rollback discards the transaction and releases its locks.
+func (t *Txn) Rollback() {
+	t.done = true
+	t.unlock()
+	t.writes = nil
+	t.savepoints = nil
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Version describes the engine's current on-disk state.
+type Version struct {
+	Manifest Manifest `json:"manifest"`
+	// LastSeq is the newest sequence number assigned; VisibleSeq the
+	// newest readers may observe.
+	LastSeq    uint64 `json:"last_seq"`
+	VisibleSeq uint64 `json:"visible_seq"`
+
+	MemTableBytes int `json:"memtable_bytes"`
+	// FrozenMemTableBytes are in full memtables still being flushed.
+	FrozenMemTableBytes []int `json:"frozen_memtable_bytes,omitempty"`
+	// Tables are ordered oldest to newest; newer tables shadow older ones.
+	Tables []TableInfo `json:"tables"`
+	WAL    WALInfo     `json:"wal"`
+	// PrefixWALs are the WALs of prefix sync policies, by prefix.
+	PrefixWALs map[string]WALInfo `json:"prefix_wals,omitempty"`
+}
+
+// TableInfo describes one SSTable.
+type TableInfo struct {
+	// Path is the table's file; File is its name within the data directory.
+	Path    string `json:"-"`
+	File    string `json:"file"`
+	Format  uint32 `json:"format"`
+	Bytes   int64  `json:"bytes"`
+	Entries int64  `json:"entries"`
+	Garbage int64  `json:"garbage"`
+	MinKey  string `json:"min_key"`
+	MaxKey  string `json:"max_key"`
+	// IndexInterval is the number of records per sparse index entry.
+	IndexInterval int   `json:"index_interval"`
+	IndexEntries  int   `json:"index_entries"`
+	Bloom         bool  `json:"bloom"`
+	Snapshots     int32 `json:"snapshots"`
+	// Cold is set for a table moved to the cold tier; see EnableTiering.
+	Cold bool `json:"cold,omitempty"`
+}
+
+func tableInfo(t *SSTable) TableInfo {
+	return TableInfo{
+		Path:          t.Path,
+		File:          filepath.Base(t.Path),
+		Format:        t.version,
+		Bytes:         t.dataSize,
+		Entries:       t.entries,
+		Garbage:       t.garbage.Load(),
+		MinKey:        t.minKey,
+		MaxKey:        t.maxKey,
+		IndexInterval: t.indexInterval,
+		IndexEntries:  len(t.Index),
+		Bloom:         t.Bloom != nil,
+		Snapshots:     t.refs.Load(),
+		Cold:          t.cold.Load(),
+	}
+}
+
+// WALInfo gives the WAL's write position and its live segments.
+type WALInfo struct {
+	Segment  int           `json:"segment"`
+	Offset   int64         `json:"offset"`
+	Segments []SegmentInfo `json:"segments"`
+}
+
+// SegmentInfo describes one WAL segment file.
+type SegmentInfo struct {
+	ID    int    `json:"id"`
+	File  string `json:"file"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Version reports the engine's current version set. Writers are paused
+// while it is collected so the tables, sequence numbers and WAL agree.
+func (e *Engine) Version() Version {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	e.manifestMu.Lock()
+	v := Version{
+		LastSeq:       e.lastSeq,
+		VisibleSeq:    e.visibleSeq.Load(),
+		MemTableBytes: e.memtable.Size(),
+		Tables:        make([]TableInfo, 0, len(e.sstables)),
+	}
+	for _, f := range e.immutable {
+		v.FrozenMemTableBytes = append(v.FrozenMemTableBytes, f.mem.Size())
+	}
+	if e.manifest != nil {
+		v.Manifest = *e.manifest
+	}
+	e.manifestMu.Unlock()
+
+	for _, t := range e.sstables {
+		v.Tables = append(v.Tables, tableInfo(t))
+	}
+
+	walDir := filepath.Join(e.DataDir(), walDirName)
+	v.WAL = walInfo(e.wal, walDir)
+	for prefix, p := range e.prefixWALs {
+		if v.PrefixWALs == nil {
+			v.PrefixWALs = make(map[string]WALInfo)
+		}
+		v.PrefixWALs[prefix] = walInfo(p.wal, prefixWALDir(walDir, prefix))
+	}
+
+	return v
+}
+
+// walInfo describes wal, which logs to dir; a secondary has no wal.
+func walInfo(wal *WAL, dir string) WALInfo {
+	var info WALInfo
+	if wal != nil {
+		info.Segment, info.Offset = wal.position()
+	}
+	info.Segments = []SegmentInfo{}
+	for _, path := range segmentPaths(dir) {
+		seg := SegmentInfo{ID: extractID(path), File: filepath.Base(path)}
+		if stat, err := os.Stat(path); err == nil {
+			seg.Bytes = stat.Size()
+		}
+		info.Segments = append(info.Segments, seg)
+	}
+	return info
+}
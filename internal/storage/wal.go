@@ -2,46 +2,82 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
 )
 
+// WAL segment format versions. Version 1 segments are bare records;
+// version 2 segments start with a magic/version header; version 3 frames
+// every record with its length and a CRC32 of its contents; version 4
+// records carry their sequence number; version 5 segments may hold batch
+// records.
 const (
-	walPut byte = iota + 1
-	walDelete
+	walV1      uint32 = 1
+	walV2      uint32 = 2
+	walV3      uint32 = 3
+	walV4      uint32 = 4
+	walV5      uint32 = 5
+	walVersion        = walV5
+
+	walMagic      = "LBWALSEG"
+	walHeaderSize = len(walMagic) + 4
+
+	// walFrameSize is the length and CRC32 ahead of a version 3 or later
+	// record.
+	walFrameSize = 8
+	// maxWALRecord bounds a record's framed length, so a damaged length
+	// is not taken for a huge record.
+	maxWALRecord = 1 << 30
 )
 
+// errWALCorrupt marks a record whose checksum or contents are wrong.
+var errWALCorrupt = errors.New("corrupt record")
+
 type RecordType byte
 
 const (
 	PutRecord    RecordType = 1
 	DeleteRecord RecordType = 2
+	// PutMetaRecord is a put whose value carries encoded metadata.
+	PutMetaRecord RecordType = 3
+	// BatchRecord frames several records in one checksummed frame, so
+	// they are replayed all or none. Replay returns the records it holds,
+	// never the batch record itself.
+	BatchRecord RecordType = 4
 )
 
 type WALRecord struct {
-	Type  RecordType
+	Type RecordType
+	// Seq is the sequence number the write was applied at, or 0 in
+	// segments older than version 4.
+	Seq   uint64
 	Key   []byte
 	Value []byte
+	Meta  []byte
 }
 
 type WAL struct {
-	mu      sync.Mutex
+	mu      profiledMutex
 	dir     string
 	file    *os.File
 	writer  *bufio.Writer
 	segment int
+	faults  *FaultInjector
 }
 
 func OpenWAL(dir string) (*WAL, error) {
 	os.MkdirAll(dir, 0755)
 
-	wal := &WAL{dir: dir}
+	wal := &WAL{mu: profiledMutex{stats: walLock}, dir: dir}
 	wal.segment = wal.nextSegmentID()
 	err := wal.openSegment(wal.segment)
 	return wal, err
@@ -53,116 +89,450 @@ func (w *WAL) openSegment(id int) error {
 	if err != nil {
 		return err
 	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size() == 0 {
+		if err := writeWALHeader(file, walVersion); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
 	w.file = file
-	w.writer = bufio.NewWriter(file)
+	w.writer = bufio.NewWriter(ioWAL.writer(file))
 	w.segment = id
 	return nil
 }
 
-func (w *WAL) AppendPut(key, value []byte) error {
-	if err := w.appendRecord(PutRecord, key, value); err != nil {
+func (w *WAL) AppendPut(seq uint64, key, value []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.appendRecord(WALRecord{Type: PutRecord, Seq: seq, Key: key, Value: value}); err != nil {
 		return err
 	}
-	return w.writer.Flush()
+	return w.sync()
 }
 
-func (w *WAL) AppendDelete(key []byte) error {
-	if err := w.appendRecord(DeleteRecord, key, nil); err != nil {
+func (w *WAL) AppendPutMeta(seq uint64, key, value, meta []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.appendRecord(WALRecord{Type: PutMetaRecord, Seq: seq, Key: key, Value: value, Meta: meta}); err != nil {
 		return err
 	}
-	return w.writer.Flush()
+	return w.sync()
 }
 
-func (w *WAL) appendRecord(rt RecordType, key, value []byte) error {
-	if err := binary.Write(w.writer, binary.BigEndian, rt); err != nil {
+func (w *WAL) AppendDelete(seq uint64, key []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.appendRecord(WALRecord{Type: DeleteRecord, Seq: seq, Key: key}); err != nil {
 		return err
 	}
+	return w.sync()
+}
+
+// appendRecord buffers r behind its length and CRC32.
+func (w *WAL) appendRecord(r WALRecord) error {
+	return w.appendFrame(encodeRecord(r))
+}
 
-	if err := binary.Write(w.writer, binary.BigEndian, uint32(len(key))); err != nil {
+// appendFrame buffers an encoded record behind its length and CRC32.
+func (w *WAL) appendFrame(payload []byte) error {
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, walFrameSize), uint32(len(payload)))
+	frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(payload))
+
+	if _, err := w.writer.Write(frame); err != nil {
 		return err
 	}
-	if _, err := w.writer.Write(key); err != nil {
-		return err
+	_, err := w.writer.Write(payload)
+	return err
+}
+
+// encodeRecord lays out a record: its type and sequence number, then key
+// and value (and the metadata of a PutMetaRecord), each behind a 4-byte
+// length.
+func encodeRecord(r WALRecord) []byte {
+	b := make([]byte, 0, recordSize(r, walVersion))
+	b = append(b, byte(r.Type))
+	b = binary.BigEndian.AppendUint64(b, r.Seq)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(r.Key)))
+	b = append(b, r.Key...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(r.Value)))
+	b = append(b, r.Value...)
+	if r.Type == PutMetaRecord {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(r.Meta)))
+		b = append(b, r.Meta...)
 	}
+	return b
+}
 
-	if err := binary.Write(w.writer, binary.BigEndian, uint32(len(value))); err != nil {
-		return err
+// recordSize is the length of r's encoding in the given segment version.
+func recordSize(r WALRecord, version uint32) int64 {
+	size := 1 + 4 + len(r.Key) + 4 + len(r.Value)
+	if r.Type == PutMetaRecord {
+		size += 4 + len(r.Meta)
 	}
-	if _, err := w.writer.Write(value); err != nil {
-		return err
+	if version >= walV4 {
+		size += 8
 	}
+	return int64(size)
+}
 
-	return nil
+// sync pushes buffered records to the segment file.
+func (w *WAL) sync() error {
+	err := w.faults.inject(FaultWALSync)
+	if s := simulating.Load(); s != nil && err == nil {
+		err = s.sync()
+	}
+	if err == nil {
+		err = w.writer.Flush()
+	}
+	if err != nil {
+		walLog.Errorf("segment %d: %v", w.segment, err)
+	}
+	return err
+}
+
+// Sync pushes buffered records to the segment file and fsyncs it.
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.sync(); err != nil {
+		return err
+	}
+	ioWAL.synced()
+	return w.file.Sync()
 }
 
-func (w *WAL) AppendBatch(entries map[string][]byte) error {
+// AppendRecords writes records in order with a single flush. More than
+// one record are written as a batch record, so a crash part way through
+// the write loses all of them, never some.
+func (w *WAL) AppendRecords(records []WALRecord) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	for k, v := range entries {
-		w.writer.WriteByte(walPut)
+	var err error
+	if len(records) == 1 {
+		err = w.appendRecord(records[0])
+	} else if len(records) > 1 {
+		err = w.appendFrame(encodeBatch(records))
+	}
+	if err != nil {
+		return err
+	}
 
-		binary.Write(w.writer, binary.BigEndian, uint32(len(k)))
-		w.writer.Write([]byte(k))
+	return w.sync()
+}
 
-		binary.Write(w.writer, binary.BigEndian, uint32(len(v)))
-		w.writer.Write(v)
+// encodeBatch lays out a batch record: its type and record count, then
+// each record's encoding behind a 4-byte length.
+func encodeBatch(records []WALRecord) []byte {
+	size := 1 + 4
+	for _, r := range records {
+		size += 4 + int(recordSize(r, walVersion))
 	}
 
-	// 🔑 Single flush for the whole batch
-	return w.writer.Flush()
+	b := make([]byte, 0, size)
+	b = append(b, byte(BatchRecord))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(records)))
+	for _, r := range records {
+		payload := encodeRecord(r)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+		b = append(b, payload...)
+	}
+	return b
 }
 
-func (w *WAL) Replay() ([]WALRecord, error) {
-	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
-		return nil, err
+// decodeBatch reads the records of the batch record encodeBatch lays out.
+func decodeBatch(payload []byte, version uint32) ([]WALRecord, error) {
+	reader := bufio.NewReader(bytes.NewReader(payload[1:]))
+	var n uint32
+	if err := binary.Read(reader, binary.BigEndian, &n); err != nil {
+		return nil, noEOF(err)
+	}
+
+	records := make([]WALRecord, 0, min(n, 1024))
+	for range n {
+		encoded, err := readBlob(reader)
+		if err != nil {
+			return nil, err
+		}
+		r, err := decodeRecord(bufio.NewReader(bytes.NewReader(encoded)), version)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if r.Type == BatchRecord {
+			return nil, errors.New("nested batch record")
+		}
+		records = append(records, r)
 	}
+	return records, nil
+}
 
-	reader := bufio.NewReader(w.file)
+// Replay reads every live segment in order and returns their records. It
+// stops at the first torn or corrupt record, since nothing after it can be
+// trusted to follow it: the damaged segment is cut back to its good records
+// and, unless the damage is just the torn end of the log a crash leaves,
+// the rest of the log is set aside as *.corrupt. Either way the log on
+// disk then matches what was replayed.
+func (w *WAL) Replay() ([]WALRecord, error) {
 	records := []WALRecord{}
 
+	paths := segmentPaths(w.dir)
+	for i, path := range paths {
+		segment, valid, err := readSegment(path, ioWAL)
+		records = append(records, segment...)
+		if isWALDamage(err) {
+			walLog.Warnf("%s: %v at offset %d; replay stops after %d records", filepath.Base(path), err, valid, len(records))
+			return records, w.setAside(paths[i:], valid, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("wal %s: %w", filepath.Base(path), err)
+		}
+		walLog.Debugf("replayed %d records from %s", len(segment), filepath.Base(path))
+	}
+
+	return records, nil
+}
+
+// isWALDamage reports whether err is a torn or corrupt record, as opposed
+// to a segment that cannot be read at all.
+func isWALDamage(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errWALCorrupt)
+}
+
+// setAside keeps the first valid bytes of the damaged segment paths[0].
+// A copy of the whole segment and every later segment but the one being
+// written are moved to *.corrupt for inspection, unless the damage is a
+// torn final record.
+func (w *WAL) setAside(paths []string, valid int64, damage error) error {
+	damaged := paths[0]
+	var later []string
+	for _, path := range paths[1:] {
+		if extractID(path) != w.segment {
+			later = append(later, path)
+		}
+	}
+
+	if !errors.Is(damage, io.ErrUnexpectedEOF) || len(later) > 0 {
+		if err := copyFile(damaged, damaged+".corrupt"); err != nil {
+			return err
+		}
+	}
+	if err := os.Truncate(damaged, valid); err != nil {
+		return err
+	}
+
+	for _, path := range later {
+		walLog.Warnf("%s: set aside after damage in %s", filepath.Base(path), filepath.Base(damaged))
+		if err := os.Rename(path, path+".corrupt"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSegment returns a segment's records and the length of the prefix
+// they were read from, counting the reads for sub. On an error, the
+// records before it are returned.
+func readSegment(path string, sub ioSubsystem) ([]WALRecord, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(sub.reader(file))
+	version, err := readWALHeader(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var valid int64
+	if version >= walV2 {
+		valid = int64(walHeaderSize)
+	}
+
+	records := []WALRecord{}
 	for {
-		var rt RecordType
-		if err := binary.Read(reader, binary.BigEndian, &rt); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
+		rs, size, err := readRecord(reader, version)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return records, valid, err
 		}
+		records = append(records, rs...)
+		valid += size
+	}
 
-		var keyLen uint32
-		if err := binary.Read(reader, binary.BigEndian, &keyLen); err != nil {
-			return nil, err
+	return records, valid, nil
+}
+
+// readRecord reads one record from a segment of the given version and
+// returns it, or the records of a batch record, with its size on disk. A
+// record cut off part way through is reported as io.ErrUnexpectedEOF, and
+// one that fails its checksum as errWALCorrupt.
+func readRecord(reader *bufio.Reader, version uint32) ([]WALRecord, int64, error) {
+	if version < walV3 {
+		r, err := decodeRecord(reader, version)
+		if err != nil {
+			return nil, 0, err
 		}
+		return []WALRecord{r}, recordSize(r, version), nil
+	}
 
-		key := make([]byte, keyLen)
-		if _, err := io.ReadFull(reader, key); err != nil {
-			return nil, err
+	var frame [walFrameSize]byte
+	if _, err := io.ReadFull(reader, frame[:1]); err != nil {
+		return nil, 0, err
+	}
+	if _, err := io.ReadFull(reader, frame[1:]); err != nil {
+		return nil, 0, noEOF(err)
+	}
+	n := binary.BigEndian.Uint32(frame[:4])
+	if n > maxWALRecord {
+		return nil, 0, fmt.Errorf("%w: length %d", errWALCorrupt, n)
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, noEOF(err)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(frame[4:]) {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", errWALCorrupt)
+	}
+
+	if version >= walV5 && n > 0 && RecordType(payload[0]) == BatchRecord {
+		records, err := decodeBatch(payload, version)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", errWALCorrupt, err)
 		}
+		return records, walFrameSize + int64(n), nil
+	}
 
-		var valLen uint32
-		if err := binary.Read(reader, binary.BigEndian, &valLen); err != nil {
-			return nil, err
+	r, err := decodeRecord(bufio.NewReader(bytes.NewReader(payload)), version)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errWALCorrupt, err)
+	}
+	return []WALRecord{r}, walFrameSize + int64(n), nil
+}
+
+// decodeRecord reads the record encodeRecord lays out. Records in
+// segments older than version 4 have no sequence number.
+func decodeRecord(reader *bufio.Reader, version uint32) (WALRecord, error) {
+	var rt RecordType
+	if err := binary.Read(reader, binary.BigEndian, &rt); err != nil {
+		return WALRecord{}, err
+	}
+
+	var seq uint64
+	if version >= walV4 {
+		if err := binary.Read(reader, binary.BigEndian, &seq); err != nil {
+			return WALRecord{}, noEOF(err)
 		}
+	}
 
-		value := make([]byte, valLen)
-		if _, err := io.ReadFull(reader, value); err != nil {
-			return nil, err
+	key, err := readBlob(reader)
+	if err != nil {
+		return WALRecord{}, err
+	}
+	value, err := readBlob(reader)
+	if err != nil {
+		return WALRecord{}, err
+	}
+
+	var meta []byte
+	if rt == PutMetaRecord {
+		if meta, err = readBlob(reader); err != nil {
+			return WALRecord{}, err
 		}
+	}
+
+	return WALRecord{
+		Type:  rt,
+		Seq:   seq,
+		Key:   key,
+		Value: value,
+		Meta:  meta,
+	}, nil
+}
+
+// readWALHeader consumes a segment header and returns the segment's format
+// version. Segments written before headers existed are version 1.
+func readWALHeader(r *bufio.Reader) (uint32, error) {
+	first, err := r.Peek(1)
+	if err == io.EOF {
+		return walVersion, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if first[0] != walMagic[0] {
+		return walV1, nil
+	}
 
-		records = append(records, WALRecord{
-			Type:  rt,
-			Key:   key,
-			Value: value,
-		})
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	if string(header[:len(walMagic)]) != walMagic {
+		return 0, errors.New("bad segment header")
 	}
 
-	return records, nil
+	version := binary.BigEndian.Uint32(header[len(walMagic):])
+	if version > walVersion {
+		return 0, fmt.Errorf("unsupported format version %d", version)
+	}
+	return version, nil
+}
+
+// writeWALHeader starts a segment in the given format version.
+func writeWALHeader(w io.Writer, version uint32) error {
+	header := binary.BigEndian.AppendUint32([]byte(walMagic), version)
+	_, err := w.Write(header)
+	return err
+}
+
+// segmentPaths lists the WAL segments in dir in segment order.
+func segmentPaths(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, "wal_*.log"))
+	sort.Slice(files, func(i, j int) bool {
+		return extractID(files[i]) < extractID(files[j])
+	})
+	return files
 }
 
+// position returns the segment being written and the offset of its end.
+func (w *WAL) position() (int, int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	offset := int64(w.writer.Buffered())
+	if info, err := w.file.Stat(); err == nil {
+		offset += info.Size()
+	}
+	return w.segment, offset
+}
+
+// Rotate starts a new segment. The old one is synced first, so a Sync
+// after the rotation still covers writes logged before it.
 func (w *WAL) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	w.writer.Flush()
+	w.file.Sync()
+	ioWAL.synced()
 	w.file.Close()
+	walLog.Debugf("rotating to segment %d", w.segment+1)
 	return w.openSegment(w.segment + 1)
 }
 
@@ -171,6 +541,7 @@ func (w *WAL) Truncate(before int) error {
 	for _, f := range files {
 		id := extractID(f)
 		if id < before {
+			walLog.Debugf("removing segment %d", id)
 			os.Remove(f)
 		}
 	}
@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// walTestRecords are logged by every segment version. Segments older
+// than version 4 read them back without their sequence numbers.
+var walTestRecords = []WALRecord{
+	{Type: PutRecord, Seq: 1, Key: []byte("a"), Value: []byte("apple")},
+	{Type: PutMetaRecord, Seq: 2, Key: []byte("b"), Value: []byte("banana"), Meta: []byte("meta")},
+	{Type: DeleteRecord, Seq: 3, Key: []byte("a")},
+	{Type: PutRecord, Seq: 4, Key: []byte(""), Value: []byte("empty key")},
+}
+
+// encodeLegacyRecord lays out r as a segment of the given version stores
+// it, before framing.
+func encodeLegacyRecord(r WALRecord, version uint32) []byte {
+	b := []byte{byte(r.Type)}
+	if version >= walV4 {
+		b = binary.BigEndian.AppendUint64(b, r.Seq)
+	}
+	b = appendBlob(b, r.Key)
+	b = appendBlob(b, r.Value)
+	if r.Type == PutMetaRecord {
+		b = appendBlob(b, r.Meta)
+	}
+	return b
+}
+
+// writeLegacySegment writes records to path as a segment of the given
+// version. A version 5 segment logs the last two of them as one batch.
+func writeLegacySegment(t *testing.T, path string, version uint32, records []WALRecord) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if version >= walV2 {
+		buf.WriteString(walMagic)
+		binary.Write(&buf, binary.BigEndian, version)
+	}
+
+	var payloads [][]byte
+	single := records
+	if version >= walV5 && len(records) > 1 {
+		single = records[:len(records)-2]
+	}
+	for _, r := range single {
+		payloads = append(payloads, encodeLegacyRecord(r, version))
+	}
+	if len(single) < len(records) {
+		payloads = append(payloads, encodeBatch(records[len(single):]))
+	}
+
+	for _, p := range payloads {
+		if version >= walV3 {
+			binary.Write(&buf, binary.BigEndian, uint32(len(p)))
+			binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(p))
+		}
+		buf.Write(p)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRecords(t *testing.T, got, want []WALRecord) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Type != w.Type || g.Seq != w.Seq || !bytes.Equal(g.Key, w.Key) ||
+			!bytes.Equal(g.Value, w.Value) || !bytes.Equal(g.Meta, w.Meta) {
+			t.Errorf("record %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+// withoutSeqs returns records as a segment older than version 4 reads
+// them back.
+func withoutSeqs(records []WALRecord) []WALRecord {
+	out := make([]WALRecord, len(records))
+	for i, r := range records {
+		r.Seq = 0
+		out[i] = r
+	}
+	return out
+}
+
+func TestReplaySegmentVersions(t *testing.T) {
+	for version := walV1; version <= walVersion; version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			dir := t.TempDir()
+			writeLegacySegment(t, filepath.Join(dir, "wal_000000.log"), version, walTestRecords)
+
+			wal, err := OpenWAL(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer wal.Close()
+
+			records, err := wal.Replay()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := walTestRecords
+			if version < walV4 {
+				want = withoutSeqs(want)
+			}
+			checkRecords(t, records, want)
+		})
+	}
+}
+
+func TestAppendReplay(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.AppendPut(1, []byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.AppendRecords(walTestRecords); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.AppendDelete(5, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wal, err = OpenWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	records, err := wal.Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []WALRecord{{Type: PutRecord, Seq: 1, Key: []byte("a"), Value: []byte("1")}}
+	want = append(want, walTestRecords...)
+	want = append(want, WALRecord{Type: DeleteRecord, Seq: 5, Key: []byte("b")})
+	checkRecords(t, records, want)
+}
+
+// writeSegments logs records one per write, starting a new segment before
+// each index in rotateAt, and returns the segment paths.
+func writeSegments(t *testing.T, dir string, records []WALRecord, rotateAt ...int) []string {
+	t.Helper()
+
+	wal, err := OpenWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range records {
+		for _, at := range rotateAt {
+			if i == at {
+				if err := wal.Rotate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := wal.AppendRecords([]WALRecord{r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return segmentPaths(dir)
+}
+
+func TestReplayTornTail(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeSegments(t, dir, walTestRecords)
+
+	info, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(paths[0], info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	wal, err := OpenWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	records, err := wal.Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, records, walTestRecords[:len(walTestRecords)-1])
+
+	// A torn final record is what a crash leaves, so it is cut off
+	// without setting anything aside
+	if corrupt, _ := filepath.Glob(filepath.Join(dir, "*.corrupt")); len(corrupt) > 0 {
+		t.Errorf("torn tail set aside %v", corrupt)
+	}
+	segment, valid, err := readSegment(paths[0], ioWAL)
+	if err != nil {
+		t.Fatalf("segment still damaged after replay: %v", err)
+	}
+	checkRecords(t, segment, walTestRecords[:len(walTestRecords)-1])
+	if info, _ := os.Stat(paths[0]); info.Size() != valid {
+		t.Errorf("segment is %d bytes, want %d", info.Size(), valid)
+	}
+}
+
+func TestReplayChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeSegments(t, dir, walTestRecords, 2)
+	if len(paths) != 2 {
+		t.Fatalf("got %d segments, want 2", len(paths))
+	}
+
+	// Flip the last byte of the second record, the first segment's last
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(paths[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wal, err := OpenWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	records, err := wal.Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, records, walTestRecords[:1])
+
+	// The damaged segment is kept whole for inspection and cut back to its
+	// good records, and the segment after it is set aside
+	if _, _, err := readSegment(paths[0]+".corrupt", ioWAL); !errors.Is(err, errWALCorrupt) {
+		t.Errorf("reading the set aside copy: %v, want %v", err, errWALCorrupt)
+	}
+	segment, _, err := readSegment(paths[0], ioWAL)
+	if err != nil {
+		t.Fatalf("segment still damaged after replay: %v", err)
+	}
+	checkRecords(t, segment, walTestRecords[:1])
+	if _, err := os.Stat(paths[1]); !os.IsNotExist(err) {
+		t.Errorf("later segment still live: %v", err)
+	}
+	if _, err := os.Stat(paths[1] + ".corrupt"); err != nil {
+		t.Errorf("later segment not set aside: %v", err)
+	}
+}